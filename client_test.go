@@ -1,17 +1,361 @@
 package fasthttp
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+func TestPipelineClientDoSerial(t *testing.T) {
+	testPipelineClientDoConcurrent(t, 1, 0)
+}
+
+func TestPipelineClientDoConcurrent(t *testing.T) {
+	testPipelineClientDoConcurrent(t, 10, 0)
+}
+
+func TestPipelineClientDoBatchDelayConcurrent(t *testing.T) {
+	testPipelineClientDoConcurrent(t, 10, 5*time.Millisecond)
+}
+
+func testPipelineClientDoConcurrent(t *testing.T, concurrency int, maxBatchDelay time.Duration) {
+	addr := "127.0.0.1:56789"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	c := &PipelineClient{
+		Addr:               addr,
+		MaxConns:           3,
+		MaxPendingRequests: 100,
+		MaxBatchDelay:      maxBatchDelay,
+		Logger:             &customLogger{},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			testPipelineClientDo(t, c, addr, i)
+		}(i)
+	}
+	wg.Wait()
+
+	if c.PendingRequests() != 0 {
+		t.Fatalf("unexpected number of pending requests: %d. Expecting zero", c.PendingRequests())
+	}
+}
+
+func testPipelineClientDo(t *testing.T, c *PipelineClient, addr string, worker int) {
+	var req Request
+	var resp Response
+	for i := 0; i < 100; i++ {
+		uri := fmt.Sprintf("http://%s/foo/%d/%d?bar=baz", addr, worker, i)
+		req.SetRequestURI(uri)
+		var err error
+		if i%2 == 0 {
+			err = c.DoTimeout(&req, &resp, time.Second)
+		} else {
+			err = c.Do(&req, &resp)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error on iteration %d: %s", i, err)
+		}
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+		}
+		if string(resp.Body()) != uri {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), uri)
+		}
+	}
+}
+
+func TestPipelineClientHeadRequests(t *testing.T) {
+	addr := "127.0.0.1:56790"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	c := &PipelineClient{
+		Addr: addr,
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var req Request
+			var resp Response
+			uri := fmt.Sprintf("http://%s/foo/%d", addr, i)
+			req.SetRequestURI(uri)
+			if i%2 == 0 {
+				req.Header.SetMethod("HEAD")
+			}
+			err := c.DoTimeout(&req, &resp, time.Second)
+			if err == nil {
+				body := string(resp.Body())
+				if i%2 == 0 && body != "" {
+					err = fmt.Errorf("unexpected non-empty body for HEAD request: %q", body)
+				}
+				if i%2 != 0 && body != uri {
+					err = fmt.Errorf("unexpected body %q. Expecting %q", body, uri)
+				}
+			}
+			errCh <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+func TestPipelineClientDoContext(t *testing.T) {
+	addr, stop := startSlowTestServer(t)
+	defer stop()
+
+	c := &PipelineClient{
+		Addr:   addr,
+		Logger: &customLogger{},
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/slow")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	startTime := time.Now()
+	if err := c.DoContext(ctx, &req, &resp); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+	if d := time.Since(startTime); d > 250*time.Millisecond {
+		t.Fatalf("too long request duration: %s", d)
+	}
+
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+
+	req.SetRequestURI("http://example.com/fast")
+	if err := c.DoContext(context.Background(), &req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+}
+
+func TestPipelineClientDoTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(100 * time.Millisecond)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c := &PipelineClient{
+		Addr:   ln.Addr().String(),
+		Logger: &customLogger{},
+	}
+	var req Request
+	req.SetRequestURI("http://foobar.com/baz")
+	for i := 0; i < 3; i++ {
+		if err = c.DoTimeout(&req, nil, 10*time.Millisecond); err != ErrTimeout {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+		}
+	}
+	if err = c.DoTimeout(&req, nil, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestPipelineClientOverflow(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+
+	// The server never responds, so all the requests remain pending.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	c := &PipelineClient{
+		Addr:               ln.Addr().String(),
+		MaxPendingRequests: 2,
+		Logger:             &customLogger{},
+	}
+
+	// Only a limited number of requests may be queued, so the client
+	// must return ErrPipelineOverflow for the remaining requests.
+	errCh := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func() {
+			var req Request
+			req.SetRequestURI("http://foobar.com/baz")
+			errCh <- c.Do(&req, nil)
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case err = <-errCh:
+		if err != ErrPipelineOverflow {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrPipelineOverflow)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	if c.PendingRequests() == 0 {
+		t.Fatalf("expecting non-zero pending requests")
+	}
+}
+
+func TestPipelineClientConnectionDied(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+
+	// The server reads the first request and closes the connection.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req Request
+			req.Read(bufio.NewReader(conn))
+			conn.Close()
+		}
+	}()
+
+	c := &PipelineClient{
+		Addr:   ln.Addr().String(),
+		Logger: &customLogger{},
+	}
+	var wg sync.WaitGroup
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var req Request
+			req.SetRequestURI("http://foobar.com/baz")
+			errCh <- c.DoTimeout(&req, nil, time.Second)
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err == nil || err == ErrTimeout {
+			t.Fatalf("unexpected error: %v. Expecting connection error", err)
+		}
+	}
+}
+
+func TestPipelineClientConnectionDiedWhileEnqueueing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+
+	// The server responds to the first request and closes the connection
+	// shortly after, so requests are enqueued while connections are dying.
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				var req Request
+				if err := req.Read(bufio.NewReader(conn)); err == nil {
+					conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+				}
+				time.Sleep(time.Millisecond)
+				conn.Close()
+			}()
+		}
+	}()
+
+	c := &PipelineClient{
+		Addr:   ln.Addr().String(),
+		Logger: &customLogger{},
+	}
+	for i := 0; i < 300; i++ {
+		doneCh := make(chan struct{})
+		go func() {
+			var wg sync.WaitGroup
+			for j := 0; j < 5; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var req Request
+					req.SetRequestURI("http://foobar.com/baz")
+					// Errors are expected, while Do mustn't hang.
+					c.Do(&req, nil)
+				}()
+			}
+			wg.Wait()
+			close(doneCh)
+		}()
+		select {
+		case <-doneCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout on iteration %d. PipelineClient.Do hangs on dying connections", i)
+		}
+	}
+}
+
+func TestPipelineClientDialError(t *testing.T) {
+	dialErr := errors.New("dial error")
+	c := &PipelineClient{
+		Addr: "foobar.com",
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, dialErr
+		},
+		Logger: &customLogger{},
+	}
+	var req Request
+	req.SetRequestURI("http://foobar.com/baz")
+	if err := c.Do(&req, nil); err != dialErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, dialErr)
+	}
+	if err := c.DoTimeout(&req, nil, time.Second); err != dialErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, dialErr)
+	}
+}
+
 func TestClientFollowRedirects(t *testing.T) {
 	addr := "127.0.0.1:55234"
 	s := &Server{
@@ -25,45 +369,672 @@ func TestClientFollowRedirects(t *testing.T) {
 			}
 		},
 	}
-	ln, err := net.Listen("tcp4", addr)
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	serverStopCh := make(chan struct{})
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		close(serverStopCh)
+	}()
+
+	uri := fmt.Sprintf("http://%s/foo", addr)
+	for i := 0; i < 10; i++ {
+		statusCode, body, err := GetTimeout(nil, uri, time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d", statusCode)
+		}
+		if string(body) != "/bar" {
+			t.Fatalf("unexpected response %q. Expecting %q", body, "/bar")
+		}
+	}
+
+	uri = fmt.Sprintf("http://%s/aaab/sss", addr)
+	for i := 0; i < 10; i++ {
+		statusCode, body, err := Get(nil, uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d", statusCode)
+		}
+		if string(body) != "/aaab/sss" {
+			t.Fatalf("unexpected response %q. Expecting %q", body, "/aaab/sss")
+		}
+	}
+}
+
+func startRedirectTestServer(t *testing.T) (string, func()) {
+	return startTestServer(t, &Server{
+		Handler: redirectTestHandler,
+	})
+}
+
+func redirectTestHandler(ctx *RequestCtx) {
+	path := string(ctx.Path())
+	switch {
+	case strings.HasSuffix(path, "/echo"):
+		h := &ctx.Request.Header
+		fmt.Fprintf(ctx, "method=%s, uri=%s, body=%s, auth=%s, cookie=%s",
+			h.Method(), h.RequestURI(), ctx.PostBody(), h.Peek("Authorization"), h.Cookie("foo"))
+	case path == "/loop":
+		ctx.Redirect("/loop", StatusFound)
+	case path == "/missing-location":
+		ctx.SetStatusCode(StatusFound)
+	case path == "/cross":
+		ctx.Redirect(string(ctx.QueryArgs().Peek("to")), StatusFound)
+	case strings.HasPrefix(path, "/a/"):
+		ctx.Redirect("../echo?relative", StatusTemporaryRedirect)
+	default:
+		statusCode, err := ParseUint([]byte(path[1:]))
+		if err != nil {
+			ctx.Error("unexpected path", StatusBadRequest)
+			return
+		}
+		ctx.Redirect("/echo?"+path[1:], statusCode)
+	}
+}
+
+func TestClientDoRedirects(t *testing.T) {
+	addr, stop := startRedirectTestServer(t)
+	defer stop()
+
+	var c Client
+	testClientDoRedirects(t, &c, "GET", "http://"+addr+"/301", "method=GET, uri=/echo?301, body=, auth=foo, cookie=bar")
+	testClientDoRedirects(t, &c, "POST", "http://"+addr+"/301", "method=GET, uri=/echo?301, body=, auth=foo, cookie=bar")
+	testClientDoRedirects(t, &c, "POST", "http://"+addr+"/302", "method=GET, uri=/echo?302, body=, auth=foo, cookie=bar")
+	testClientDoRedirects(t, &c, "PUT", "http://"+addr+"/303", "method=GET, uri=/echo?303, body=, auth=foo, cookie=bar")
+	testClientDoRedirects(t, &c, "POST", "http://"+addr+"/307", "method=POST, uri=/echo?307, body=request body, auth=foo, cookie=bar")
+	testClientDoRedirects(t, &c, "PUT", "http://"+addr+"/308", "method=PUT, uri=/echo?308, body=request body, auth=foo, cookie=bar")
+	testClientDoRedirects(t, &c, "POST", "http://"+addr+"/a/b/c", "method=POST, uri=/a/echo?relative, body=request body, auth=foo, cookie=bar")
+
+	// Credentials mustn't be sent to another host.
+	otherAddr, stopOther := startRedirectTestServer(t)
+	defer stopOther()
+	testClientDoRedirects(t, &c, "GET", "http://"+addr+"/cross?to=http://"+otherAddr+"/echo",
+		"method=GET, uri=/echo, body=, auth=, cookie=")
+	testClientDoRedirects(t, &c, "GET", "http://"+addr+"/cross?to=http://"+addr+"/echo",
+		"method=GET, uri=/echo, body=, auth=foo, cookie=bar")
+}
+
+func TestClientDoRedirectsSchemeDowngrade(t *testing.T) {
+	addr, stop := startRedirectTestServer(t)
+	defer stop()
+
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load certificate: %s", err)
+	}
+	ln, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	s := &Server{
+		Handler: redirectTestHandler,
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	// https and http requests to the same host are served by distinct servers.
+	c := &Client{
+		Dial: func(dialAddr string) (net.Conn, error) {
+			if strings.HasSuffix(dialAddr, ":443") {
+				return net.Dial("tcp4", ln.Addr().String())
+			}
+			return net.Dial("tcp4", addr)
+		},
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+
+	// Credentials mustn't be sent over unencrypted connection.
+	testClientDoRedirects(t, c, "GET", "https://example.com/cross?to=http://example.com/echo",
+		"method=GET, uri=/echo, body=, auth=, cookie=")
+	testClientDoRedirects(t, c, "GET", "https://example.com/cross?to=https://example.com/echo",
+		"method=GET, uri=/echo, body=, auth=foo, cookie=bar")
+	testClientDoRedirects(t, c, "GET", "http://example.com/cross?to=https://example.com/echo",
+		"method=GET, uri=/echo, body=, auth=foo, cookie=bar")
+}
+
+func testClientDoRedirects(t *testing.T, c *Client, method, url, expectedBody string) {
+	var req Request
+	var resp Response
+	req.Header.SetMethod(method)
+	req.SetRequestURI(url)
+	req.Header.Set("Authorization", "foo")
+	req.Header.SetCookie("foo", "bar")
+	if method != "GET" {
+		req.SetBodyString("request body")
+	}
+	if err := c.DoRedirects(&req, &resp, 5); err != nil {
+		t.Fatalf("unexpected error for %s %q: %s", method, url, err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d for %s %q. Expecting %d", resp.StatusCode(), method, url, StatusOK)
+	}
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q for %s %q. Expecting %q", resp.Body(), method, url, expectedBody)
+	}
+}
+
+func TestHostClientDoRedirectsErrors(t *testing.T) {
+	addr, stop := startRedirectTestServer(t)
+	defer stop()
+
+	c := &HostClient{
+		Addr: addr,
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/loop")
+	if err := c.DoRedirects(&req, &resp, 3); err != ErrTooManyRedirects {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyRedirects)
+	}
+	req.SetRequestURI("http://example.com/missing-location")
+	if err := c.DoRedirects(&req, &resp, 3); err != ErrMissingLocation {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrMissingLocation)
+	}
+
+	// Redirects aren't followed if maxRedirectsCount is 0.
+	req.SetRequestURI("http://example.com/302")
+	if err := c.DoRedirects(&req, &resp, 0); err != ErrTooManyRedirects {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyRedirects)
+	}
+
+	// Body stream cannot be re-sent to 307 location.
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://example.com/307")
+	req.SetBodyStream(bytes.NewBufferString("request body"), -1)
+	if err := c.DoRedirects(&req, &resp, 3); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusTemporaryRedirect {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusTemporaryRedirect)
+	}
+}
+
+func TestHostClientRedirectPolicy(t *testing.T) {
+	addr, stop := startRedirectTestServer(t)
+	defer stop()
+
+	var redirects []string
+	c := &HostClient{
+		Addr: addr,
+		RedirectPolicy: func(req *Request, resp *Response, redirectsCount int) bool {
+			redirects = append(redirects, fmt.Sprintf("%d %s", redirectsCount, req.URI().FullURI()))
+			return redirectsCount < 2
+		},
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/loop")
+	if err := c.DoRedirects(&req, nil, 10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.DoRedirects(&req, &resp, 10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusFound {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusFound)
+	}
+	expectedRedirects := "[1 http://example.com/loop 2 http://example.com/loop " +
+		"1 http://example.com/loop 2 http://example.com/loop]"
+	if fmt.Sprintf("%v", redirects) != expectedRedirects {
+		t.Fatalf("unexpected redirects %v. Expecting %s", redirects, expectedRedirects)
+	}
+
+	// The request mustn't be modified if the redirect is rejected.
+	c.RedirectPolicy = func(req *Request, resp *Response, redirectsCount int) bool {
+		if string(req.Header.Method()) != "GET" || len(req.Body()) > 0 {
+			t.Fatalf("unexpected redirect request: method=%s, body=%q", req.Header.Method(), req.Body())
+		}
+		return false
+	}
+	req.Reset()
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://example.com/302")
+	req.SetBodyString("request body")
+	if err := c.DoRedirects(&req, &resp, 10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusFound {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusFound)
+	}
+	if uri := req.URI().FullURI(); string(uri) != "http://example.com/302" {
+		t.Fatalf("unexpected request uri %q. Expecting %q", uri, "http://example.com/302")
+	}
+	if string(req.Header.Method()) != "POST" || string(req.Body()) != "request body" {
+		t.Fatalf("unexpected request: method=%s, body=%q. Expecting POST with body", req.Header.Method(), req.Body())
+	}
+}
+
+func TestHostClientMaxConnWaitTimeout(t *testing.T) {
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(10 * time.Millisecond)
+			ctx.SetBodyString("ok")
+		},
+	})
+	defer stop()
+
+	c := &HostClient{
+		Addr:               addr,
+		MaxConns:           1,
+		MaxConnWaitTimeout: 5 * time.Second,
+	}
+	var wg sync.WaitGroup
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statusCode, body, err := c.Get(nil, "http://example.com/")
+			if err == nil && (statusCode != StatusOK || string(body) != "ok") {
+				err = fmt.Errorf("unexpected response: %d %q", statusCode, body)
+			}
+			errCh <- err
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+func TestHostClientMaxConnWaitTimeoutError(t *testing.T) {
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(300 * time.Millisecond)
+		},
+	})
+	defer stop()
+
+	c := &HostClient{
+		Addr:               addr,
+		MaxConns:           1,
+		MaxConnWaitTimeout: 20 * time.Millisecond,
+	}
+	doneCh := make(chan error, 1)
+	go func() {
+		_, _, err := c.Get(nil, "http://example.com/")
+		doneCh <- err
+	}()
+	// Wait until the first request occupies the connection.
+	time.Sleep(100 * time.Millisecond)
+
+	if _, _, err := c.Get(nil, "http://example.com/"); err != ErrConnWaitTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrConnWaitTimeout)
+	}
+
+	// The wait is limited by the request timeout.
+	c.MaxConnWaitTimeout = 10 * time.Second
+	startTime := time.Now()
+	if _, _, err := c.GetTimeout(nil, "http://example.com/", 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+	if d := time.Since(startTime); d > time.Second {
+		t.Fatalf("too long wait for free connection: %s", d)
+	}
+
+	if err := <-doneCh; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestHostClientConnWaitersFIFO(t *testing.T) {
+	var c HostClient
+	w1 := acquireConnWaiter()
+	w2 := acquireConnWaiter()
+	w3 := acquireConnWaiter()
+	c.connsCount = 1
+	c.connsWait = append(c.connsWait, w1, w2, w3)
+
+	cc := &clientConn{}
+	c.releaseConn(cc)
+	if x := <-w1.ch; x != cc {
+		t.Fatalf("unexpected connection passed to the first waiter: %p. Expecting %p", x, cc)
+	}
+
+	if !c.removeConnWaiter(w2) {
+		t.Fatalf("cannot remove waiter")
+	}
+	if c.removeConnWaiter(w2) {
+		t.Fatalf("waiter mustn't be removed twice")
+	}
+
+	// Closed connection slot must be passed to the next waiter.
+	c.decConnsCount()
+	if x := <-w3.ch; x != nil {
+		t.Fatalf("unexpected connection passed to the waiter: %p. Expecting nil", x)
+	}
+	if c.connsCount != 1 {
+		t.Fatalf("unexpected connsCount %d. Expecting 1", c.connsCount)
+	}
+	if len(c.connsWait) != 0 {
+		t.Fatalf("unexpected waiters left: %d", len(c.connsWait))
+	}
+
+	c.releaseConn(cc)
+	if len(c.conns) != 1 {
+		t.Fatalf("the connection must be returned to the pool if there are no waiters")
+	}
+}
+
+func startSlowTestServer(t *testing.T) (string, func()) {
+	return startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/slow":
+				time.Sleep(300 * time.Millisecond)
+			}
+			ctx.SetBodyString("ok")
+		},
+	})
+}
+
+// startSlowBodyTestServer starts server, which sends response body
+// in chunks with delays.
+func startSlowBodyTestServer(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	ch := make(chan struct{})
+	go func() {
+		defer close(ch)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var req Request
+				if err := req.Read(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"))
+				for i := 0; i < 3; i++ {
+					if _, err := fmt.Fprintf(conn, "7\r\nline %d\n\r\n", i); err != nil {
+						return
+					}
+					time.Sleep(50 * time.Millisecond)
+				}
+				conn.Write([]byte("0\r\n\r\n"))
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() {
+		ln.Close()
+		<-ch
+	}
+}
+
+func TestHostClientDoDeadline(t *testing.T) {
+	addr, stop := startSlowTestServer(t)
+	defer stop()
+
+	c := &HostClient{
+		Addr: addr,
+	}
+	var req Request
+	var resp Response
+
+	req.SetRequestURI("http://example.com/slow")
+	startTime := time.Now()
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+	if d := time.Since(startTime); d > 250*time.Millisecond {
+		t.Fatalf("too long request duration: %s", d)
+	}
+	testHostClientNoConns(t, c)
+
+	// The connection deadline must be reset for the next request.
+	req.SetRequestURI("http://example.com/fast")
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+
+	// Past deadline.
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(-time.Second)); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+
+	// The deadline limits waiting for the response header only
+	// when the body is streamed.
+	slowBodyAddr, stopSlowBody := startSlowBodyTestServer(t)
+	defer stopSlowBody()
+	c = &HostClient{
+		Addr: slowBodyAddr,
+	}
+	resp.StreamBody = true
+	if err := c.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadAll(resp.BodyStream())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != "line 0\nline 1\nline 2\n" {
+		t.Fatalf("unexpected body %q", b)
+	}
+}
+
+func TestHostClientDoContext(t *testing.T) {
+	addr, stop := startSlowTestServer(t)
+	defer stop()
+
+	c := &HostClient{
+		Addr: addr,
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/slow")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	startTime := time.Now()
+	if err := c.DoContext(ctx, &req, &resp); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+	if d := time.Since(startTime); d > 250*time.Millisecond {
+		t.Fatalf("too long request duration: %s", d)
+	}
+	testHostClientNoConns(t, c)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.DoContext(ctx, &req, &resp); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+
+	req.SetRequestURI("http://example.com/fast")
+	if err := c.DoContext(context.Background(), &req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+
+	// Canceling the context aborts reading the body stream.
+	slowBodyAddr, stopSlowBody := startSlowBodyTestServer(t)
+	defer stopSlowBody()
+	c = &HostClient{
+		Addr: slowBodyAddr,
+	}
+	resp.StreamBody = true
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := c.DoContext(ctx, &req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(resp.BodyStream()); err == nil {
+		t.Fatalf("expecting error")
+	}
+	testHostClientNoConns(t, c)
+}
+
+func TestHostClientDoContextDial(t *testing.T) {
+	dialStartedCh := make(chan struct{})
+	var dialing int32
+	c := &HostClient{
+		Addr: "foobar.com",
+		Dial: func(addr string) (net.Conn, error) {
+			atomic.AddInt32(&dialing, 1)
+			close(dialStartedCh)
+			time.Sleep(100 * time.Millisecond)
+			atomic.AddInt32(&dialing, -1)
+			return &readTimeoutConn{t: time.Second}, nil
+		},
+	}
+	var req Request
+	req.SetRequestURI("http://foobar.com/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-dialStartedCh
+		cancel()
+	}()
+
+	// Custom Dial cannot be aborted, so the request waits for it
+	// and closes the connection established after the cancel.
+	if err := c.DoContext(ctx, &req, nil); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+	if n := atomic.LoadInt32(&dialing); n != 0 {
+		t.Fatalf("the dial mustn't run in background after the request returns")
+	}
+	testHostClientNoConns(t, c)
+}
+
+func TestTCPDialerDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	ctx, cancel := dialContext(zeroTime, nil)
+	conn, err := defaultDialer.dial(ctx, addr, nil)
+	cancel()
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
+	conn.Close()
 
-	serverStopCh := make(chan struct{})
+	done := make(chan struct{})
+	close(done)
+	ctx, cancel = dialContext(zeroTime, done)
+	if _, err = defaultDialer.dial(ctx, addr, nil); err == nil {
+		t.Fatalf("expecting error when dialing with closed done")
+	}
+	cancel()
+
+	ctx, cancel = dialContext(time.Now().Add(-time.Second), nil)
+	if _, err = defaultDialer.dial(ctx, addr, nil); err == nil {
+		t.Fatalf("expecting error when dialing after the deadline")
+	}
+	cancel()
+
+	// Dialing via HostClient must return ErrTimeout after the deadline.
+	c := &HostClient{
+		Addr: addr,
+	}
+	conn, err = c.dialHost(time.Now().Add(-time.Second), nil, nil)
+	if err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+}
+
+func TestHostClientDoTimeoutWaitFreeConn(t *testing.T) {
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			ctx.SetBodyString("ok")
+		},
+	})
+	defer stop()
+
+	c := &HostClient{
+		Addr:     addr,
+		MaxConns: 1,
+	}
+	slowCh := make(chan error, 1)
 	go func() {
-		if err := s.Serve(ln); err != nil {
-			t.Fatalf("unexpected error: %s", err)
-		}
-		close(serverStopCh)
+		_, _, err := c.Get(nil, "http://example.com/slow")
+		slowCh <- err
 	}()
+	time.Sleep(50 * time.Millisecond)
 
-	uri := fmt.Sprintf("http://%s/foo", addr)
-	for i := 0; i < 10; i++ {
-		statusCode, body, err := GetTimeout(nil, uri, time.Second)
-		if err != nil {
-			t.Fatalf("unexpected error: %s", err)
-		}
-		if statusCode != StatusOK {
-			t.Fatalf("unexpected status code: %d", statusCode)
-		}
-		if string(body) != "/bar" {
-			t.Fatalf("unexpected response %q. Expecting %q", body, "/bar")
-		}
+	// Do returns ErrNoFreeConns immediately.
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/")
+	if err := c.Do(&req, &resp); err != ErrNoFreeConns {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrNoFreeConns)
 	}
 
-	uri = fmt.Sprintf("http://%s/aaab/sss", addr)
-	for i := 0; i < 10; i++ {
-		statusCode, body, err := Get(nil, uri)
-		if err != nil {
-			t.Fatalf("unexpected error: %s", err)
-		}
-		if statusCode != StatusOK {
-			t.Fatalf("unexpected status code: %d", statusCode)
-		}
-		if string(body) != "/aaab/sss" {
-			t.Fatalf("unexpected response %q. Expecting %q", body, "/aaab/sss")
-		}
+	// DoTimeout waits for the free connection until the timeout.
+	if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+	if err := <-slowCh; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	go func() {
+		_, _, err := c.Get(nil, "http://example.com/slow")
+		slowCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := c.DoTimeout(&req, &resp, 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+	if err := <-slowCh; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func testHostClientNoConns(t *testing.T, c *HostClient) {
+	c.connsLock.Lock()
+	connsCount := c.connsCount
+	c.connsLock.Unlock()
+	if connsCount != 0 {
+		t.Fatalf("unexpected number of open connections: %d. Expecting 0", connsCount)
 	}
 }
 
@@ -214,13 +1185,41 @@ func testClientGetTimeoutError(t *testing.T, c *Client, n int) {
 type readTimeoutConn struct {
 	net.Conn
 	t time.Duration
+
+	lock         sync.Mutex
+	readDeadline time.Time
 }
 
 func (r *readTimeoutConn) Read(p []byte) (int, error) {
-	time.Sleep(r.t)
+	r.lock.Lock()
+	readDeadline := r.readDeadline
+	r.lock.Unlock()
+
+	t := r.t
+	if !readDeadline.IsZero() {
+		if d := -time.Since(readDeadline); d < t {
+			t = d
+		}
+	}
+	time.Sleep(t)
 	return 0, io.EOF
 }
 
+func (r *readTimeoutConn) SetDeadline(deadline time.Time) error {
+	return r.SetReadDeadline(deadline)
+}
+
+func (r *readTimeoutConn) SetReadDeadline(deadline time.Time) error {
+	r.lock.Lock()
+	r.readDeadline = deadline
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *readTimeoutConn) SetWriteDeadline(deadline time.Time) error {
+	return nil
+}
+
 func (r *readTimeoutConn) Write(p []byte) (int, error) {
 	return len(p), nil
 }
@@ -275,6 +1274,346 @@ func TestClientIdempotentRequest(t *testing.T) {
 	}
 }
 
+func TestClientBodyStreamNotRetried(t *testing.T) {
+	dialsCount := 0
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			dialsCount++
+			return &readErrorConn{}, nil
+		},
+	}
+
+	var req Request
+	req.Header.SetMethod("PUT")
+	req.SetRequestURI("http://foobar/a/b")
+	req.SetBodyStream(strings.NewReader("foobar"), -1)
+	if err := c.Do(&req, nil); err == nil {
+		t.Fatalf("expecting error")
+	}
+	if dialsCount != 1 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 1. Requests with body stream mustn't be retried", dialsCount)
+	}
+}
+
+func TestHostClientBodyStream(t *testing.T) {
+	addr := "127.0.0.1:56794"
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot listen %q: %s", addr, err)
+	}
+	s := &Server{
+		StreamRequestBody: true,
+		Handler: func(ctx *RequestCtx) {
+			n, err := io.Copy(ioutil.Discard, ctx.RequestBodyStream())
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			fmt.Fprintf(ctx, "%s %d", ctx.Request.Header.Peek("Transfer-Encoding"), n)
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	c := &HostClient{
+		Addr: addr,
+	}
+	body := createFixedBody(3 * 1024 * 1024)
+	for _, bodySize := range []int{len(body), -1} {
+		var req Request
+		var resp Response
+		req.Header.SetMethod("POST")
+		req.SetRequestURI("http://foobar.com/upload")
+		req.SetBodyStream(bytes.NewReader(body), bodySize)
+		if err := c.DoTimeout(&req, &resp, 5*time.Second); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expectedBody := fmt.Sprintf(" %d", len(body))
+		if bodySize < 0 {
+			expectedBody = "chunked" + expectedBody
+		}
+		if string(resp.Body()) != expectedBody {
+			t.Fatalf("unexpected response body %q. Expecting %q", resp.Body(), expectedBody)
+		}
+	}
+}
+
+func TestHostClientStreamBody(t *testing.T) {
+	addr := "127.0.0.1:56795"
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot listen %q: %s", addr, err)
+	}
+	body := createFixedBody(1024 * 1024)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/chunked":
+				ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+					for i := 0; i < 10; i++ {
+						fmt.Fprintf(w, "chunk %d\n", i)
+						w.Flush()
+					}
+				})
+			default:
+				ctx.Write(body)
+			}
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	// The only connection must be released after reading
+	// or closing each body stream. Otherwise ErrNoFreeConns is returned.
+	c := &HostClient{
+		Addr:     addr,
+		MaxConns: 1,
+	}
+	var req Request
+	var resp Response
+	for i := 0; i < 3; i++ {
+		req.SetRequestURI("http://foobar.com/big")
+		resp.StreamBody = true
+		if err := c.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		b, err := ioutil.ReadAll(resp.BodyStream())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(b, body) {
+			t.Fatalf("unexpected body with length %d. Expecting length %d", len(b), len(body))
+		}
+
+		req.SetRequestURI("http://foobar.com/chunked")
+		resp.StreamBody = true
+		if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		br := bufio.NewReader(resp.BodyStream())
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if line != "chunk 0\n" {
+			t.Fatalf("unexpected line %q. Expecting %q", line, "chunk 0\n")
+		}
+		// Close the body stream before reading the whole body.
+		if err := resp.CloseBodyStream(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := br.ReadString('\n'); err == nil {
+			t.Fatalf("expecting error when reading closed body stream")
+		}
+
+		resp.StreamBody = false
+		req.SetRequestURI("http://foobar.com/big")
+		if err := c.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(resp.Body(), body) {
+			t.Fatalf("unexpected body with length %d. Expecting length %d", len(resp.Body()), len(body))
+		}
+	}
+}
+
+func startDecompressTestServer(t *testing.T) (string, func()) {
+	return startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			ae := ctx.Request.Header.Peek("Accept-Encoding")
+			switch string(ctx.Path()) {
+			case "/big":
+				ctx.SetBody(createFixedBody(1024 * 1024))
+			case "/chunked":
+				ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+					for i := 0; i < 10; i++ {
+						fmt.Fprintf(w, "chunk %d\n", i)
+						w.Flush()
+					}
+				})
+			default:
+				fmt.Fprintf(ctx, "accept-encoding=%s", ae)
+			}
+			switch {
+			case bytes.Contains(ae, strGzip):
+				ctx.Response.gzipBody(CompressDefaultCompression)
+			case bytes.Contains(ae, strDeflate):
+				ctx.Response.deflateBody(CompressDefaultCompression)
+			}
+		},
+	})
+}
+
+func TestHostClientDecompressResponse(t *testing.T) {
+	addr, stop := startDecompressTestServer(t)
+	defer stop()
+
+	c := &HostClient{
+		Addr:               addr,
+		DecompressResponse: true,
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody := "accept-encoding=gzip, deflate"
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if resp.Header.ContentLength() != len(expectedBody) {
+		t.Fatalf("unexpected content length %d. Expecting %d", resp.Header.ContentLength(), len(expectedBody))
+	}
+	if ce := resp.Header.Peek("Content-Encoding"); len(ce) > 0 {
+		t.Fatalf("unexpected Content-Encoding %q", ce)
+	}
+	if string(resp.DecompressedEncoding()) != "gzip" {
+		t.Fatalf("unexpected decompressed encoding %q. Expecting %q", resp.DecompressedEncoding(), "gzip")
+	}
+	if ae := req.Header.Peek("Accept-Encoding"); len(ae) > 0 {
+		t.Fatalf("unexpected Accept-Encoding %q left in the request", ae)
+	}
+
+	// Deflate response.
+	req.Header.Set("Accept-Encoding", "deflate")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Header.Peek("Content-Encoding")) != "deflate" {
+		t.Fatalf("unexpected Content-Encoding %q. Expecting %q", resp.Header.Peek("Content-Encoding"), "deflate")
+	}
+	if len(resp.DecompressedEncoding()) > 0 {
+		t.Fatalf("unexpected decompressed encoding %q", resp.DecompressedEncoding())
+	}
+	body, err := resp.BodyInflate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "accept-encoding=deflate" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "accept-encoding=deflate")
+	}
+
+	// Responses aren't decompressed by default.
+	c = &HostClient{
+		Addr: addr,
+	}
+	req.Header.Del("Accept-Encoding")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "accept-encoding=" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "accept-encoding=")
+	}
+}
+
+func TestHostClientDecompressResponseBodyTooLarge(t *testing.T) {
+	addr, stop := startDecompressTestServer(t)
+	defer stop()
+
+	c := &HostClient{
+		Addr:                addr,
+		DecompressResponse:  true,
+		MaxResponseBodySize: 64 * 1024,
+	}
+	// The compressed body is smaller than the limit,
+	// while the decompressed body exceeds it.
+	_, _, err := c.Get(nil, "http://example.com/big")
+	if err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, ErrBodyTooLarge)
+	}
+
+	// Decompressed body stream is limited too.
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/big")
+	resp.StreamBody = true
+	if err = c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadAll(resp.BodyStream())
+	if err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, ErrBodyTooLarge)
+	}
+	if len(b) != c.MaxResponseBodySize {
+		t.Fatalf("unexpected body length %d. Expecting %d", len(b), c.MaxResponseBodySize)
+	}
+
+	c.MaxResponseBodySize = 2 * 1024 * 1024
+	statusCode, body, err := c.Get(nil, "http://example.com/big")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+	}
+	if !bytes.Equal(body, createFixedBody(1024*1024)) {
+		t.Fatalf("unexpected body with length %d. Expecting length %d", len(body), 1024*1024)
+	}
+}
+
+func TestHostClientDecompressResponseStreamBody(t *testing.T) {
+	addr, stop := startDecompressTestServer(t)
+	defer stop()
+
+	// The only connection must be released after reading
+	// or closing each decompressed body stream.
+	c := &HostClient{
+		Addr:               addr,
+		MaxConns:           1,
+		DecompressResponse: true,
+	}
+	var req Request
+	var resp Response
+	for i := 0; i < 3; i++ {
+		req.SetRequestURI("http://example.com/big")
+		resp.StreamBody = true
+		if err := c.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.DecompressedEncoding()) != "gzip" {
+			t.Fatalf("unexpected decompressed encoding %q. Expecting %q", resp.DecompressedEncoding(), "gzip")
+		}
+		b, err := ioutil.ReadAll(resp.BodyStream())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(b, createFixedBody(1024*1024)) {
+			t.Fatalf("unexpected body with length %d. Expecting length %d", len(b), 1024*1024)
+		}
+
+		req.SetRequestURI("http://example.com/chunked")
+		resp.StreamBody = true
+		if err := c.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		br := bufio.NewReader(resp.BodyStream())
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if line != "chunk 0\n" {
+			t.Fatalf("unexpected line %q. Expecting %q", line, "chunk 0\n")
+		}
+		if err := resp.CloseBodyStream(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
 type readErrorConn struct {
 	net.Conn
 }
@@ -0,0 +1,136 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ClientTrace contains hooks called by HostClient at various stages
+// of sending the request and reading the response.
+//
+// Set Request.Trace for tracing the request. Hooks may be used
+// for collecting latency breakdowns of client requests.
+//
+// Any hook may be nil. Hooks must return quickly, since they are called
+// synchronously while performing the request. Hooks may be called
+// multiple times per request if the request is retried or redirected.
+//
+// Dialing hooks may be called from a separate goroutine if the request
+// has a deadline, since dialing is performed in the background.
+// Such hooks may be called even after the request is finished
+// if it times out while dialing.
+//
+// It is safe sharing ClientTrace between concurrently running requests
+// if the hooks are safe for concurrent use.
+type ClientTrace struct {
+	// GotConn is called after the connection to the host is obtained.
+	//
+	// reused is true if the connection has been taken from the pool
+	// of idle connections. Otherwise the connection has been dialed.
+	GotConn func(reused bool)
+
+	// DNSStart is called before resolving the given host.
+	//
+	// DNSStart and DNSDone are called only by the default dialer used
+	// if HostClient.Dial isn't set. Resolved addresses are cached,
+	// so these hooks aren't called for cached addresses.
+	DNSStart func(host string)
+
+	// DNSDone is called after resolving the host passed to DNSStart.
+	DNSDone func(addrs []net.TCPAddr, err error)
+
+	// ConnectStart is called before dialing the given addr.
+	//
+	// addr is the resolved ip:port if the default dialer is used.
+	// Otherwise addr is passed to HostClient.Dial as is.
+	ConnectStart func(addr string)
+
+	// ConnectDone is called after dialing the addr passed to ConnectStart.
+	ConnectDone func(addr string, err error)
+
+	// TLSHandshakeStart is called before TLS handshake
+	// on the new connection.
+	TLSHandshakeStart func()
+
+	// TLSHandshakeDone is called after TLS handshake.
+	TLSHandshakeDone func(state tls.ConnectionState, err error)
+
+	// WroteRequest is called after writing the request
+	// including the body.
+	WroteRequest func(err error)
+
+	// GotFirstResponseByte is called when the first byte
+	// of the response header is available.
+	GotFirstResponseByte func()
+
+	// ResponseDone is called after reading the whole response
+	// or after the request failure.
+	//
+	// ResponseDone is called after reading the body stream until
+	// io.EOF or after closing the body stream if Response.StreamBody
+	// is set.
+	ResponseDone func(err error)
+}
+
+func (t *ClientTrace) gotConn(reused bool) {
+	if t != nil && t.GotConn != nil {
+		t.GotConn(reused)
+	}
+}
+
+func (t *ClientTrace) dnsStart(host string) {
+	if t != nil && t.DNSStart != nil {
+		t.DNSStart(host)
+	}
+}
+
+func (t *ClientTrace) dnsDone(addrs []net.TCPAddr, err error) {
+	if t != nil && t.DNSDone != nil {
+		t.DNSDone(addrs, err)
+	}
+}
+
+func (t *ClientTrace) connectStart(addr string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(addr)
+	}
+}
+
+func (t *ClientTrace) connectDone(addr string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(addr, err)
+	}
+}
+
+func (t *ClientTrace) wroteRequest(err error) {
+	if t != nil && t.WroteRequest != nil {
+		t.WroteRequest(err)
+	}
+}
+
+func (t *ClientTrace) responseDone(err error) {
+	if t != nil && t.ResponseDone != nil {
+		t.ResponseDone(err)
+	}
+}
+
+// tlsHandshake performs TLS handshake on the new connection,
+// so it may be traced. The handshake is performed on the first write
+// to the connection otherwise.
+func (t *ClientTrace) tlsHandshake(conn net.Conn) error {
+	if t == nil || (t.TLSHandshakeStart == nil && t.TLSHandshakeDone == nil) {
+		return nil
+	}
+	tc, ok := conn.(*tls.Conn)
+	if !ok || tc.ConnectionState().HandshakeComplete {
+		return nil
+	}
+	if t.TLSHandshakeStart != nil {
+		t.TLSHandshakeStart()
+	}
+	err := tc.Handshake()
+	if t.TLSHandshakeDone != nil {
+		t.TLSHandshakeDone(tc.ConnectionState(), err)
+	}
+	return err
+}
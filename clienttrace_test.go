@@ -0,0 +1,206 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testClientTraceEvents struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (e *testClientTraceEvents) add(format string, args ...interface{}) {
+	e.lock.Lock()
+	e.events = append(e.events, fmt.Sprintf(format, args...))
+	e.lock.Unlock()
+}
+
+func (e *testClientTraceEvents) reset() string {
+	e.lock.Lock()
+	s := strings.Join(e.events, ", ")
+	e.events = e.events[:0]
+	e.lock.Unlock()
+	return s
+}
+
+func newTestClientTrace(e *testClientTraceEvents) *ClientTrace {
+	return &ClientTrace{
+		GotConn: func(reused bool) {
+			e.add("GotConn(%v)", reused)
+		},
+		DNSStart: func(host string) {
+			e.add("DNSStart(%s)", host)
+		},
+		DNSDone: func(addrs []net.TCPAddr, err error) {
+			e.add("DNSDone(%d, %v)", len(addrs), err)
+		},
+		ConnectStart: func(addr string) {
+			e.add("ConnectStart")
+		},
+		ConnectDone: func(addr string, err error) {
+			e.add("ConnectDone(%v)", err)
+		},
+		TLSHandshakeStart: func() {
+			e.add("TLSHandshakeStart")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			e.add("TLSHandshakeDone(%v, %v)", state.HandshakeComplete, err)
+		},
+		WroteRequest: func(err error) {
+			e.add("WroteRequest(%v)", err)
+		},
+		GotFirstResponseByte: func() {
+			e.add("GotFirstResponseByte")
+		},
+		ResponseDone: func(err error) {
+			e.add("ResponseDone(%v)", err)
+		},
+	}
+}
+
+func TestClientTrace(t *testing.T) {
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("foobar")
+		},
+	})
+	defer stop()
+
+	// Use localhost, so the address is resolved by the default dialer.
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := &HostClient{
+		Addr: "localhost:" + port,
+	}
+
+	var e testClientTraceEvents
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://localhost/")
+	req.Trace = newTestClientTrace(&e)
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedEvents := "DNSStart(localhost), DNSDone(1, <nil>), ConnectStart, ConnectDone(<nil>), GotConn(false), " +
+		"WroteRequest(<nil>), GotFirstResponseByte, ResponseDone(<nil>)"
+	if events := e.reset(); events != expectedEvents {
+		t.Fatalf("unexpected events\n%s\nExpecting\n%s", events, expectedEvents)
+	}
+
+	// The connection is reused.
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedEvents = "GotConn(true), WroteRequest(<nil>), GotFirstResponseByte, ResponseDone(<nil>)"
+	if events := e.reset(); events != expectedEvents {
+		t.Fatalf("unexpected events\n%s\nExpecting\n%s", events, expectedEvents)
+	}
+
+	// ResponseDone is called after reading the body stream.
+	resp.StreamBody = true
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedEvents = "GotConn(true), WroteRequest(<nil>), GotFirstResponseByte"
+	if events := e.reset(); events != expectedEvents {
+		t.Fatalf("unexpected events\n%s\nExpecting\n%s", events, expectedEvents)
+	}
+	if _, err := ioutil.ReadAll(resp.BodyStream()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedEvents = "ResponseDone(<nil>)"
+	if events := e.reset(); events != expectedEvents {
+		t.Fatalf("unexpected events\n%s\nExpecting\n%s", events, expectedEvents)
+	}
+
+	// Tracing is disabled after Reset.
+	req.Reset()
+	if req.Trace != nil {
+		t.Fatalf("unexpected non-nil trace after Reset")
+	}
+}
+
+func TestClientTraceDialError(t *testing.T) {
+	dialErr := fmt.Errorf("cannot dial")
+	c := &HostClient{
+		Addr: "example.com:80",
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, dialErr
+		},
+	}
+
+	var e testClientTraceEvents
+	var req Request
+	req.SetRequestURI("http://example.com/")
+	req.Trace = newTestClientTrace(&e)
+	if err := c.DoTimeout(&req, nil, time.Second); err != dialErr {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, dialErr)
+	}
+	expectedEvents := "ConnectStart, ConnectDone(cannot dial), ResponseDone(cannot dial)"
+	if events := e.reset(); events != expectedEvents {
+		t.Fatalf("unexpected events\n%s\nExpecting\n%s", events, expectedEvents)
+	}
+}
+
+func TestClientTraceTLS(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load certificate: %s", err)
+	}
+	ln, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("foobar")
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	c := &HostClient{
+		Addr:  ln.Addr().String(),
+		IsTLS: true,
+		Dial: func(addr string) (net.Conn, error) {
+			return net.Dial("tcp4", addr)
+		},
+	}
+	var e testClientTraceEvents
+	var req Request
+	var resp Response
+	req.SetRequestURI("https://foobar.com/")
+	req.Trace = newTestClientTrace(&e)
+	for i := 0; i < 2; i++ {
+		if err := c.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.Body()) != "foobar" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobar")
+		}
+	}
+	expectedEvents := "ConnectStart, ConnectDone(<nil>), GotConn(false), TLSHandshakeStart, TLSHandshakeDone(true, <nil>), " +
+		"WroteRequest(<nil>), GotFirstResponseByte, ResponseDone(<nil>), " +
+		"GotConn(true), WroteRequest(<nil>), GotFirstResponseByte, ResponseDone(<nil>)"
+	if events := e.reset(); events != expectedEvents {
+		t.Fatalf("unexpected events\n%s\nExpecting\n%s", events, expectedEvents)
+	}
+}
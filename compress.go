@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
 	"io"
-	"os"
 	"sync"
 )
 
@@ -65,6 +65,66 @@ func resetFlateReader(zr io.ReadCloser, r io.Reader) error {
 
 var flateReaderPool sync.Pool
 
+// acquireInflateReader returns reader for 'Content-Encoding: deflate' data.
+//
+// RFC 7230 defines deflate coding as zlib stream, while some servers
+// send raw deflate data, so zlib header is detected automatically.
+func acquireInflateReader(r io.Reader) (io.ReadCloser, error) {
+	var hdr [2]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	r = io.MultiReader(bytes.NewReader(hdr[:n]), r)
+	if n == len(hdr) && isZlibHeader(hdr[0], hdr[1]) {
+		return acquireZlibReader(r)
+	}
+	return acquireFlateReader(r)
+}
+
+func releaseInflateReader(zr io.ReadCloser) {
+	if zlr, ok := zr.(*zlibReader); ok {
+		releaseZlibReader(zlr)
+	} else {
+		releaseFlateReader(zr)
+	}
+}
+
+// isZlibHeader returns true if cmf and flg are valid zlib header bytes
+// for deflate compression method. See RFC 1950 for details.
+func isZlibHeader(cmf, flg byte) bool {
+	return cmf&0x0f == 8 && cmf>>4 <= 7 && flg&0x20 == 0 && (uint(cmf)<<8|uint(flg))%31 == 0
+}
+
+// zlibReader distinguishes zlib readers from flate readers,
+// since both implement the same Reset method.
+type zlibReader struct {
+	io.ReadCloser
+}
+
+func acquireZlibReader(r io.Reader) (*zlibReader, error) {
+	v := zlibReaderPool.Get()
+	if v == nil {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &zlibReader{zr}, nil
+	}
+	zlr := v.(*zlibReader)
+	if err := zlr.ReadCloser.(zlib.Resetter).Reset(r, nil); err != nil {
+		return nil, err
+	}
+	return zlr, nil
+}
+
+func releaseZlibReader(zlr *zlibReader) {
+	zlr.Close()
+	zlibReaderPool.Put(zlr)
+}
+
+var zlibReaderPool sync.Pool
+
 func acquireGzipWriter(w io.Writer, level int) *gzipWriter {
 	p := gzipWriterPoolMap[level]
 	if p == nil {
@@ -151,7 +211,7 @@ var flateWriterPoolMap = func() map[int]*sync.Pool {
 	return m
 }()
 
-func isFileCompressible(f *os.File, minCompressRatio float64) bool {
+func isFileCompressible(f io.ReadSeeker, minCompressRatio float64) bool {
 	// Try compressing the first 4kb of of the file
 	// and see if it can be compressed by more than
 	// the given minCompressRatio.
@@ -61,3 +61,4 @@ func testFlateCompress(t *testing.T, s string) {
 	}
 	releaseFlateReader(zr)
 }
+
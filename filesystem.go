@@ -0,0 +1,299 @@
+package fasthttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// FileSystem provides access to files served by FS.
+//
+// See Dir, NewMemFS, NewZipFS and NewTarFS for FileSystem implementations.
+type FileSystem interface {
+	// Open opens the file or directory with the given name.
+	//
+	// name is slash-separated path relative to the filesystem root
+	// such as "/foo/bar.html". Empty name and "/" refer to the root
+	// directory.
+	//
+	// The returned error must satisfy os.IsNotExist if the file
+	// doesn't exist.
+	Open(name string) (File, error)
+}
+
+// File is a file returned by FileSystem.Open.
+//
+// The methods have the same semantics as the corresponding os.File methods.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+
+	// Readdir returns directory entries. It is called only
+	// for directories.
+	Readdir(count int) ([]os.FileInfo, error)
+
+	// Stat returns file info.
+	Stat() (os.FileInfo, error)
+}
+
+// Dir implements FileSystem for the directory on the local filesystem.
+//
+// Files are served from the current working directory if Dir is empty.
+//
+// Dir files are sent with sendfile, while compressed files are cached
+// in the directory. See FS.Compress for details.
+type Dir string
+
+// Open implements FileSystem.
+func (d Dir) Open(name string) (File, error) {
+	f, err := os.Open(d.osPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// osPath returns the path on the local filesystem for the given name.
+func (d Dir) osPath(name string) string {
+	dir := string(d)
+	if len(dir) == 0 {
+		dir = "."
+	}
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// NewMemFS returns FileSystem serving the given files from memory.
+//
+// files keys are slash-separated file paths relative to the filesystem
+// root, while values are file contents. Directories are created
+// automatically. modTime is used as the modification time
+// for all the files.
+//
+// It is forbidden modifying files contents after passing them
+// to NewMemFS.
+func NewMemFS(files map[string][]byte, modTime time.Time) FileSystem {
+	fs := newMemFS(modTime)
+	for name, data := range files {
+		fs.add(name, &memFileInfo{
+			size:    int64(len(data)),
+			mode:    0444,
+			modTime: modTime,
+			data:    data,
+		})
+	}
+	return fs
+}
+
+// NewZipFS returns FileSystem serving files from the given zip archive.
+//
+// Compressed archive files are decompressed into memory when opened.
+// FS caches opened files for FS.CacheDuration.
+//
+// r mustn't be closed while the returned FileSystem is in use.
+func NewZipFS(r *zip.Reader) FileSystem {
+	fs := newMemFS(time.Now())
+	for _, zf := range r.File {
+		zf := zf
+		fi := zf.FileInfo()
+		mfi := &memFileInfo{
+			size:    fi.Size(),
+			mode:    fi.Mode(),
+			modTime: fi.ModTime(),
+		}
+		if !fi.IsDir() {
+			mfi.open = func() ([]byte, error) {
+				zr, err := zf.Open()
+				if err != nil {
+					return nil, err
+				}
+				data, err := ioutil.ReadAll(zr)
+				zr.Close()
+				return data, err
+			}
+		}
+		fs.add(zf.Name, mfi)
+	}
+	return fs
+}
+
+// NewTarFS returns FileSystem serving files from the tar archive read from r.
+//
+// The whole archive is read into memory. Only regular files
+// and directories are served from the archive.
+func NewTarFS(r io.Reader) (FileSystem, error) {
+	fs := newMemFS(time.Now())
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fi := hdr.FileInfo()
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			continue
+		}
+		mfi := &memFileInfo{
+			size:    fi.Size(),
+			mode:    fi.Mode(),
+			modTime: fi.ModTime(),
+		}
+		if !fi.IsDir() {
+			if mfi.data, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		}
+		fs.add(hdr.Name, mfi)
+	}
+}
+
+// memFS is read-only in-memory FileSystem.
+type memFS struct {
+	modTime time.Time
+	files   map[string]*memFileInfo
+}
+
+func newMemFS(modTime time.Time) *memFS {
+	fs := &memFS{
+		modTime: modTime,
+		files:   make(map[string]*memFileInfo),
+	}
+	fs.files["/"] = &memFileInfo{
+		name:    "/",
+		mode:    os.ModeDir | 0555,
+		modTime: modTime,
+	}
+	return fs
+}
+
+// add adds the file with the given name to fs, creating missing
+// parent directories.
+func (fs *memFS) add(name string, fi *memFileInfo) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return
+	}
+	if old := fs.files[name]; old != nil {
+		if old.IsDir() && fi.IsDir() {
+			// The directory has been already created for its files.
+			old.mode = fi.mode
+			old.modTime = fi.modTime
+			return
+		}
+		// Replace the file with the same name.
+		fi.name = old.name
+		*old = *fi
+		return
+	}
+
+	dir, base := path.Split(name)
+	parent := fs.dir(path.Clean(dir))
+	fi.name = base
+	parent.children = append(parent.children, fi)
+	fs.files[name] = fi
+}
+
+// dir returns the directory with the given cleaned name, creating it
+// if it is missing.
+func (fs *memFS) dir(name string) *memFileInfo {
+	fi := fs.files[name]
+	if fi == nil {
+		fi = &memFileInfo{
+			mode:    os.ModeDir | 0555,
+			modTime: fs.modTime,
+		}
+		fs.add(name, fi)
+	}
+	return fi
+}
+
+func (fs *memFS) Open(name string) (File, error) {
+	fi := fs.files[path.Clean("/"+name)]
+	if fi == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	data := fi.data
+	if fi.open != nil {
+		var err error
+		if data, err = fi.open(); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	return &memFile{
+		Reader: bytes.NewReader(data),
+		fi:     fi,
+	}, nil
+}
+
+// memFileInfo describes memFS file or directory.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+
+	// data contains file contents. open returns file contents
+	// if it is set.
+	data []byte
+	open func() ([]byte, error)
+
+	children []*memFileInfo
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+var errNotDirectory = errors.New("not a directory")
+
+// memFile is a file opened via memFS.
+type memFile struct {
+	*bytes.Reader
+	fi *memFileInfo
+
+	readdirPos int
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.fi.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.fi.name, Err: errNotDirectory}
+	}
+	children := f.fi.children[f.readdirPos:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		if len(children) > count {
+			children = children[:count]
+		}
+	}
+	f.readdirPos += len(children)
+
+	fileinfos := make([]os.FileInfo, len(children))
+	for i, fi := range children {
+		fileinfos[i] = fi
+	}
+	return fileinfos, nil
+}
@@ -0,0 +1,228 @@
+package fasthttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDir(t *testing.T) {
+	expectedBody, err := ioutil.ReadFile("./fs.go")
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+	for _, name := range []string{"/fs.go", "fs.go", "/../fs.go", "/foo/../fs.go"} {
+		testFileSystemFile(t, Dir(""), name, expectedBody)
+		testFileSystemFile(t, Dir("./"), name, expectedBody)
+	}
+
+	if _, err := Dir(".").Open("/non-existing-file"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v. Expecting not exist error", err)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	modTime := time.Unix(1500000000, 0)
+	fs := NewMemFS(map[string][]byte{
+		"/foo.txt":         []byte("foo"),
+		"bar/baz.html":     []byte("<b>baz</b>"),
+		"/bar/qux/x.js":    []byte("var x"),
+		"/bar/qux/y.js":    []byte("var y"),
+		"/bar/qux/z/z.css": nil,
+	}, modTime)
+
+	testFileSystemFile(t, fs, "/foo.txt", []byte("foo"))
+	testFileSystemFile(t, fs, "foo.txt", []byte("foo"))
+	testFileSystemFile(t, fs, "/bar/baz.html", []byte("<b>baz</b>"))
+	testFileSystemFile(t, fs, "/bar/../bar/qux/x.js", []byte("var x"))
+	testFileSystemFile(t, fs, "/bar/qux/z/z.css", nil)
+
+	testFileSystemDir(t, fs, "", "bar, foo.txt")
+	testFileSystemDir(t, fs, "/", "bar, foo.txt")
+	testFileSystemDir(t, fs, "/bar", "baz.html, qux")
+	testFileSystemDir(t, fs, "/bar/qux/", "x.js, y.js, z")
+
+	for _, name := range []string{"/foo", "/bar/foo.txt", "/foo.txt/bar"} {
+		if _, err := fs.Open(name); !os.IsNotExist(err) {
+			t.Fatalf("unexpected error for %q: %v. Expecting not exist error", name, err)
+		}
+	}
+
+	f, err := fs.Open("/bar/qux")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !fi.IsDir() || fi.Name() != "qux" || !fi.ModTime().Equal(modTime) {
+		t.Fatalf("unexpected dir info: name=%q, isDir=%v, modTime=%s", fi.Name(), fi.IsDir(), fi.ModTime())
+	}
+	for i := 0; i < 3; i++ {
+		fis, err := f.Readdir(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(fis) != 1 {
+			t.Fatalf("unexpected number of entries: %d. Expecting 1", len(fis))
+		}
+	}
+	if _, err = f.Readdir(1); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, io.EOF)
+	}
+	f.Close()
+
+	f, err = fs.Open("/foo.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = f.Readdir(0); err == nil {
+		t.Fatalf("expecting error when reading file as directory")
+	}
+	f.Close()
+}
+
+func TestZipFS(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	testZipFSAddFile(t, zw, "dir/", nil, zip.Store)
+	testZipFSAddFile(t, zw, "dir/stored.txt", []byte("stored file"), zip.Store)
+	testZipFSAddFile(t, zw, "dir/deflated.txt", []byte(strings.Repeat("deflated file", 100)), zip.Deflate)
+	testZipFSAddFile(t, zw, "index.html", []byte("<html></html>"), zip.Deflate)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fs := NewZipFS(zr)
+
+	testFileSystemFile(t, fs, "/dir/stored.txt", []byte("stored file"))
+	testFileSystemFile(t, fs, "/dir/deflated.txt", []byte(strings.Repeat("deflated file", 100)))
+	testFileSystemFile(t, fs, "/index.html", []byte("<html></html>"))
+	testFileSystemDir(t, fs, "/", "dir, index.html")
+	testFileSystemDir(t, fs, "/dir", "deflated.txt, stored.txt")
+}
+
+func testZipFSAddFile(t *testing.T, zw *zip.Writer, name string, data []byte, method uint16) {
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: method,
+	})
+	if err != nil {
+		t.Fatalf("cannot create file %q: %s", name, err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("cannot write file %q: %s", name, err)
+	}
+}
+
+func TestTarFS(t *testing.T) {
+	modTime := time.Unix(1500000000, 0)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	testTarFSAddFile(t, tw, &tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}, nil)
+	testTarFSAddFile(t, tw, &tar.Header{Name: "dir/foo.txt", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modTime}, []byte("foo"))
+	testTarFSAddFile(t, tw, &tar.Header{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "foo.txt", ModTime: modTime}, nil)
+	testTarFSAddFile(t, tw, &tar.Header{Name: "./bar/baz.txt", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modTime}, []byte("baz"))
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	fs, err := NewTarFS(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testFileSystemFile(t, fs, "/dir/foo.txt", []byte("foo"))
+	testFileSystemFile(t, fs, "/bar/baz.txt", []byte("baz"))
+	testFileSystemDir(t, fs, "/", "bar, dir")
+	testFileSystemDir(t, fs, "/dir", "foo.txt")
+
+	if _, err = NewTarFS(strings.NewReader("invalid tar archive")); err == nil {
+		t.Fatalf("expecting error for invalid tar archive")
+	}
+}
+
+func testTarFSAddFile(t *testing.T, tw *tar.Writer, hdr *tar.Header, data []byte) {
+	hdr.Size = int64(len(data))
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("cannot write header for %q: %s", hdr.Name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatalf("cannot write file %q: %s", hdr.Name, err)
+	}
+}
+
+func testFileSystemFile(t *testing.T, fs FileSystem, name string, expectedBody []byte) {
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("cannot open %q: %s", name, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("cannot stat %q: %s", name, err)
+	}
+	if fi.IsDir() {
+		t.Fatalf("unexpected directory %q", name)
+	}
+	if fi.Size() != int64(len(expectedBody)) {
+		t.Fatalf("unexpected size for %q: %d. Expecting %d", name, fi.Size(), len(expectedBody))
+	}
+
+	body, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("cannot read %q: %s", name, err)
+	}
+	if !bytes.Equal(body, expectedBody) {
+		t.Fatalf("unexpected body for %q: %q. Expecting %q", name, body, expectedBody)
+	}
+
+	if len(expectedBody) > 2 {
+		buf := make([]byte, 2)
+		if _, err = f.ReadAt(buf, 1); err != nil {
+			t.Fatalf("cannot read %q at offset 1: %s", name, err)
+		}
+		if !bytes.Equal(buf, expectedBody[1:3]) {
+			t.Fatalf("unexpected data for %q at offset 1: %q. Expecting %q", name, buf, expectedBody[1:3])
+		}
+	}
+}
+
+func testFileSystemDir(t *testing.T, fs FileSystem, name, expectedEntries string) {
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("cannot open %q: %s", name, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("cannot stat %q: %s", name, err)
+	}
+	if !fi.IsDir() {
+		t.Fatalf("expecting directory %q", name)
+	}
+	fis, err := f.Readdir(0)
+	if err != nil {
+		t.Fatalf("cannot read directory %q: %s", name, err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	if entries := strings.Join(names, ", "); entries != expectedEntries {
+		t.Fatalf("unexpected entries in %q: %q. Expecting %q", name, entries, expectedEntries)
+	}
+}
@@ -0,0 +1,197 @@
+package fasthttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ForwardProxy implements forward http proxy.
+//
+// The proxy supports the following requests:
+//
+//     * Requests with absolute uri such as
+//       'GET http://example.com/foo HTTP/1.1'. Such requests are forwarded
+//       to the requested host via Client.
+//     * CONNECT requests such as 'CONNECT example.com:443 HTTP/1.1'.
+//       Such requests are turned into bidirectional TCP tunnels
+//       to the requested host via RequestCtx.Hijack.
+//
+// Other requests are rejected with 400 Bad Request.
+//
+// It is forbidden copying ForwardProxy instances. Create new instances
+// instead.
+//
+// It is safe calling ForwardProxy methods from concurrently running
+// goroutines.
+type ForwardProxy struct {
+	// Client for forwarding requests with absolute uri.
+	//
+	// Default client is used if not set.
+	Client *Client
+
+	// Callback for establishing CONNECT tunnels.
+	//
+	// Default TCPDialer is used if not set.
+	Dial DialFunc
+
+	// Maximum duration for establishing CONNECT tunnels.
+	//
+	// Custom Dial cannot be interrupted, so it must limit dialing
+	// duration itself. The connection established by Dial after
+	// the timeout is closed.
+	//
+	// DefaultForwardProxyDialTimeout is used by default.
+	DialTimeout time.Duration
+
+	// Allow is called for each request with the requested addr
+	// in the form host:port.
+	//
+	// The request is rejected if Allow returns false. 403 Forbidden
+	// response is sent unless Allow sets another status code. For instance,
+	// Allow may check Proxy-Authorization request header and set
+	// 407 Proxy Authentication Required status code on failure.
+	//
+	// All the requests are allowed if Allow isn't set.
+	Allow func(ctx *RequestCtx, addr string) bool
+
+	// Maximum duration for waiting for upstream response header
+	// for requests with absolute uri.
+	//
+	// By default the timeout is unlimited.
+	Timeout time.Duration
+}
+
+// DefaultForwardProxyDialTimeout is the default ForwardProxy.DialTimeout.
+const DefaultForwardProxyDialTimeout = 10 * time.Second
+
+// NewRequestHandler returns request handler for the forward proxy.
+func (p *ForwardProxy) NewRequestHandler() RequestHandler {
+	return p.handleRequest
+}
+
+func (p *ForwardProxy) handleRequest(ctx *RequestCtx) {
+	if ctx.Request.Header.IsConnect() {
+		p.handleConnect(ctx)
+		return
+	}
+
+	requestURI := ctx.Request.Header.RequestURI()
+	if !isAbsoluteURI(requestURI) {
+		ctx.Error("Absolute request uri is required", StatusBadRequest)
+		return
+	}
+	uri := ctx.URI()
+	scheme := uri.Scheme()
+	isTLS := bytes.Equal(scheme, strHTTPS)
+	if !isTLS && !bytes.Equal(scheme, strHTTP) {
+		ctx.Error("Unsupported request uri scheme", StatusBadRequest)
+		return
+	}
+	addr := addMissingPort(string(uri.Host()), isTLS)
+	if !p.allow(ctx, addr) {
+		return
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+
+	ctx.Request.Header.CopyTo(&req.Header)
+	h := &req.Header
+	removeHopHeaders(h.PeekBytes(strConnection), h.DelBytes)
+	h.connectionClose = false
+	// Host header is set from the request uri when sending the request.
+	h.SetHostBytes(nil)
+
+	c := p.Client
+	if c == nil {
+		c = &defaultClient
+	}
+	doProxyRequest(ctx, req, c, p.Timeout, addr)
+}
+
+func (p *ForwardProxy) handleConnect(ctx *RequestCtx) {
+	addr := string(ctx.URI().Host())
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		ctx.Error("CONNECT request uri must be in the form host:port", StatusBadRequest)
+		return
+	}
+	if !p.allow(ctx, addr) {
+		return
+	}
+
+	dialTimeout := p.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = DefaultForwardProxyDialTimeout
+	}
+	conn, err := dialAddr(addr, p.Dial, false, false, nil, time.Now().Add(dialTimeout), nil, nil)
+	if err != nil {
+		handleProxyError(ctx, addr, err)
+		return
+	}
+
+	// The connection is turned into a tunnel, so it mustn't be closed
+	// after sending the response.
+	ctx.Request.Header.connectionClose = false
+	ctx.Hijack(func(c net.Conn) {
+		pipeConns(c, conn, conn)
+	})
+}
+
+func (p *ForwardProxy) allow(ctx *RequestCtx, addr string) bool {
+	if p.Allow == nil || p.Allow(ctx, addr) {
+		return true
+	}
+	if statusCode := ctx.Response.StatusCode(); statusCode == 0 || statusCode == StatusOK {
+		// Allow didn't set custom status code.
+		ctx.Error(StatusMessage(StatusForbidden), StatusForbidden)
+	}
+	return false
+}
+
+// pipeConns copies data between c and upstream until both sides
+// finish sending. Data from upstream is read via r, which may buffer
+// upstream data.
+//
+// Write side of the peer is closed when one side finishes sending,
+// so half-closed connections work. pipeConns returns as soon as
+// either direction fails or if the peer doesn't support half-close.
+//
+// upstream is closed before returning.
+func pipeConns(c, upstream net.Conn, r io.Reader) {
+	defer upstream.Close()
+
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- pipeConn(upstream, c)
+	}()
+	go func() {
+		errCh <- pipeConn(c, r)
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			return
+		}
+	}
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+var errCloseWriteUnsupported = errors.New("connection doesn't support closing write side")
+
+// pipeConn copies data from src to dst until io.EOF and then closes
+// write side of dst.
+func pipeConn(dst net.Conn, src io.Reader) error {
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	cw, ok := dst.(closeWriter)
+	if !ok {
+		return errCloseWriteUnsupported
+	}
+	return cw.CloseWrite()
+}
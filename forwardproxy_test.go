@@ -0,0 +1,289 @@
+package fasthttp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardProxy(t *testing.T) {
+	upstreamAddr, stopUpstream := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			h := &ctx.Request.Header
+			fmt.Fprintf(ctx, "uri=%s, host=%s, auth=%s, body=%s", h.RequestURI(), h.Host(),
+				h.Peek("Proxy-Authorization"), ctx.PostBody())
+		},
+	})
+	defer stopUpstream()
+
+	var allowedAddr string
+	p := &ForwardProxy{
+		Allow: func(ctx *RequestCtx, addr string) bool {
+			allowedAddr = addr
+			if string(ctx.Request.Header.Peek("Proxy-Authorization")) != "Basic Zm9vOmJhcg==" {
+				ctx.Response.Header.Set("Proxy-Authenticate", `Basic realm="proxy"`)
+				ctx.SetStatusCode(StatusProxyAuthRequired)
+				return false
+			}
+			return true
+		},
+		Timeout: time.Second,
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	c := &HostClient{
+		Addr:  upstreamAddr,
+		Proxy: "http://foo:bar@" + proxyAddr,
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://" + upstreamAddr + "/foo?bar=baz")
+	req.Header.SetMethod("POST")
+	req.SetBodyString("hello")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	expectedBody := fmt.Sprintf("uri=/foo?bar=baz, host=%s, auth=, body=hello", upstreamAddr)
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if allowedAddr != upstreamAddr {
+		t.Fatalf("unexpected addr passed to Allow: %q. Expecting %q", allowedAddr, upstreamAddr)
+	}
+
+	c = &HostClient{
+		Addr:  upstreamAddr,
+		Proxy: "http://" + proxyAddr,
+	}
+	statusCode, _, err := c.Get(nil, "http://"+upstreamAddr+"/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusProxyAuthRequired {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusProxyAuthRequired)
+	}
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load certificate: %s", err)
+	}
+	ln, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "uri=%s, tls=%v", ctx.RequestURI(), ctx.IsTLS())
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+	upstreamAddr := ln.Addr().String()
+
+	p := &ForwardProxy{
+		Allow: func(ctx *RequestCtx, addr string) bool {
+			return addr == upstreamAddr
+		},
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	c := &HostClient{
+		Addr:  upstreamAddr,
+		IsTLS: true,
+		Proxy: "http://" + proxyAddr,
+	}
+	for i := 0; i < 3; i++ {
+		statusCode, body, err := c.Get(nil, fmt.Sprintf("https://%s/foo%d", upstreamAddr, i))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+		}
+		expectedBody := fmt.Sprintf("uri=/foo%d, tls=true", i)
+		if string(body) != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+		}
+	}
+
+	// 2xx response to CONNECT mustn't contain Content-Length and Transfer-Encoding.
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("cannot dial proxy: %s", err)
+	}
+	defer conn.Close()
+	if _, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", upstreamAddr, upstreamAddr); err != nil {
+		t.Fatalf("cannot write CONNECT request: %s", err)
+	}
+	var rawResp []byte
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("cannot read CONNECT response: %s", err)
+		}
+		if !strings.HasPrefix(line, "Date: ") {
+			rawResp = append(rawResp, line...)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+	expectedResp := "HTTP/1.1 200 OK\r\nServer: fasthttp\r\n\r\n"
+	if string(rawResp) != expectedResp {
+		t.Fatalf("unexpected CONNECT response %q. Expecting %q", rawResp, expectedResp)
+	}
+
+	// CONNECT to disallowed addr.
+	c = &HostClient{
+		Addr:  "example.com:443",
+		IsTLS: true,
+		Proxy: "http://" + proxyAddr,
+	}
+	if _, _, err = c.Get(nil, "https://example.com/"); err == nil {
+		t.Fatalf("expecting error")
+	}
+}
+
+func TestForwardProxyConnectHalfClose(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+
+	// The upstream responds after the client finishes sending.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, err := ioutil.ReadAll(conn)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(conn, "received %q", b)
+	}()
+	upstreamAddr := ln.Addr().String()
+
+	p := &ForwardProxy{}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	conn, err := net.Dial("tcp4", proxyAddr)
+	if err != nil {
+		t.Fatalf("cannot dial proxy: %s", err)
+	}
+	defer conn.Close()
+	if _, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", upstreamAddr, upstreamAddr); err != nil {
+		t.Fatalf("cannot write CONNECT request: %s", err)
+	}
+	br := bufio.NewReader(conn)
+	var resp Response
+	resp.SkipBody = true
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("cannot read CONNECT response: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	if _, err = conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("cannot write to the tunnel: %s", err)
+	}
+	if err = conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("cannot close write side of the tunnel: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("cannot read from the tunnel: %s", err)
+	}
+	if string(b) != `received "hello"` {
+		t.Fatalf("unexpected response from the tunnel %q. Expecting %q", b, `received "hello"`)
+	}
+}
+
+func TestForwardProxyConnectDialTimeout(t *testing.T) {
+	dialDoneCh := make(chan net.Conn, 1)
+	p := &ForwardProxy{
+		Dial: func(addr string) (net.Conn, error) {
+			time.Sleep(200 * time.Millisecond)
+			c1, c2 := net.Pipe()
+			dialDoneCh <- c2
+			return c1, nil
+		},
+		DialTimeout: 50 * time.Millisecond,
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	testForwardProxyRawRequest(t, proxyAddr, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n", StatusGatewayTimeout)
+
+	// The connection established after the timeout must be closed.
+	c := <-dialDoneCh
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+}
+
+func TestForwardProxyBadRequest(t *testing.T) {
+	p := &ForwardProxy{}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	testForwardProxyRawRequest(t, proxyAddr, "GET /foo HTTP/1.1\r\nHost: example.com\r\n\r\n", StatusBadRequest)
+	testForwardProxyRawRequest(t, proxyAddr, "GET ftp://example.com/foo HTTP/1.1\r\nHost: example.com\r\n\r\n", StatusBadRequest)
+	testForwardProxyRawRequest(t, proxyAddr, "CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n", StatusBadRequest)
+}
+
+func testForwardProxyRawRequest(t *testing.T, proxyAddr, request string, expectedStatusCode int) {
+	conn, err := net.Dial("tcp4", proxyAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(request)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var resp Response
+	if err = resp.Read(bufio.NewReader(conn)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code %d for %q. Expecting %d", resp.StatusCode(), request, expectedStatusCode)
+	}
+}
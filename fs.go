@@ -3,6 +3,8 @@ package fasthttp
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"html"
@@ -64,11 +66,21 @@ func NewPathPrefixStripper(prefixSize int) PathRewriteFunc {
 }
 
 // FS represents settings for request handler serving static files
-// from the local filesystem.
+// from the local filesystem or from the custom FileSystem.
 type FS struct {
 	// Path to the root directory to serve files from.
+	//
+	// Root is ignored if FileSystem is set.
 	Root string
 
+	// FileSystem to serve files from.
+	//
+	// It may be used for serving files from in-memory bundles
+	// or archives. See NewMemFS, NewZipFS and NewTarFS.
+	//
+	// Dir(Root) is used by default.
+	FileSystem FileSystem
+
 	// List of index file names to try opening during directory access.
 	//
 	// For example:
@@ -97,11 +109,81 @@ type FS struct {
 	// tries saving the resulting compressed file under the new file name.
 	// So it is advisable to give the server write access to Root
 	// and to all inner folders in order to minimze CPU usage when serving
-	// compressed responses.
+	// compressed responses. See also CompressedCacheDir.
+	//
+	// Compressed files are cached in memory for CacheDuration
+	// if neither CompressedCacheDir is set nor FileSystem is Dir.
 	//
 	// Transparent compression is disabled by default.
 	Compress bool
 
+	// Directory for saving compressed files if Compress is set.
+	//
+	// The directory mirrors the structure of the served directory tree,
+	// so Root may be read-only. Compressed files left by previous runs
+	// are re-used unless the original files have been modified.
+	//
+	// By default compressed files are saved next to the original files
+	// in Root.
+	CompressedCacheDir string
+
+	// Maximum total size in bytes of compressed files in CompressedCacheDir.
+	//
+	// Least recently opened compressed files are deleted from
+	// CompressedCacheDir when the limit is exceeded. Files cached
+	// by the handler aren't deleted until they expire, so the limit
+	// may be exceeded temporarily.
+	//
+	// By default the size is unlimited.
+	CompressedCacheDirMaxSize int64
+
+	// Maps content encodings to suffixes of precompressed files.
+	// For instance, {"br": ".br", "gzip": ".gz"}.
+	//
+	// Precompressed file such as 'app.js.br' is served instead of
+	// 'app.js' if the client accepts the corresponding encoding and
+	// the precompressed file isn't older than the original file.
+	// Encodings are tried in lexicographical order, so 'br' is preferred
+	// over 'gzip'. Original files without precompressed siblings
+	// are compressed according to Compress.
+	//
+	// Precompressed files are served regardless of Compress.
+	//
+	// By default precompressed files aren't served.
+	CompressedFileSuffixes map[string]string
+
+	// Enables byte range requests if set to true.
+	//
+	// 'Range' request header with a single or multiple byte ranges
+	// is honored for GET and HEAD requests. Multiple ranges are sent
+	// in multipart/byteranges response. 'If-Range' request header
+	// is supported too.
+	//
+	// Byte range requests are disabled by default.
+	AcceptByteRange bool
+
+	// Sends ETag response header if set to true.
+	//
+	// Weak ETag is derived from the file size and modification time.
+	// See ETagContentHash for strong ETags. Compressed and uncompressed
+	// files have distinct ETags.
+	//
+	// 'If-Match', 'If-None-Match', 'If-Unmodified-Since'
+	// and 'If-Modified-Since' request headers are evaluated according
+	// to https://tools.ietf.org/html/rfc7232#section-6 regardless
+	// of this setting.
+	//
+	// ETags aren't sent by default.
+	GenerateETag bool
+
+	// Derives strong ETag from the file contents hash if set to true.
+	//
+	// The hash is calculated once per cached file handle, so it may
+	// increase CPU usage for big files. See CacheDuration.
+	//
+	// This setting is ignored if GenerateETag isn't set.
+	ETagContentHash bool
+
 	// Path rewriting function.
 	//
 	// By default request path is not modified.
@@ -112,7 +194,23 @@ type FS struct {
 	// FSHandlerCacheDuration is used by default.
 	CacheDuration time.Duration
 
+	// Invalidates cached files as soon as they are changed under Root
+	// if set to true.
+	//
+	// Compressed files are re-created in the background for changed files
+	// if Compress is set.
+	//
+	// Changes are tracked via inotify on Linux, so it may be required
+	// increasing fs.inotify.max_user_watches sysctl for serving
+	// many directories. Cached files expire after CacheDuration
+	// on other platforms and if FileSystem isn't Dir.
+	//
+	// Changes aren't watched by default. Call Close for stopping
+	// watching changes.
+	WatchChanges bool
+
 	started bool
+	h       *fsHandler
 }
 
 // FS adds this suffix to the original file names when trying to store
@@ -135,7 +233,8 @@ const FSHandlerCacheDuration = 10 * time.Second
 //   * stripSlashes = 2, original path: "/foo/bar", result: ""
 //
 // The returned request handler automatically generates index pages
-// for directories without index.html.
+// for directories without index.html. Use FS for accepting byte range
+// requests and sending ETags.
 //
 // The returned handler caches requested file handles
 // for FSHandlerCacheDuration.
@@ -170,16 +269,9 @@ func (fs *FS) NewRequestHandler() RequestHandler {
 	}
 	fs.started = true
 
-	root := fs.Root
-
-	// strip trailing slashes from the root path
-	for len(root) > 0 && root[len(root)-1] == '/' {
-		root = root[:len(root)-1]
-	}
-
-	// serve files from the current working directory if root is empty
-	if len(root) == 0 {
-		root = "."
+	filesystem := fs.FileSystem
+	if filesystem == nil {
+		filesystem = Dir(fs.Root)
 	}
 
 	cacheDuration := fs.CacheDuration
@@ -187,15 +279,44 @@ func (fs *FS) NewRequestHandler() RequestHandler {
 		cacheDuration = FSHandlerCacheDuration
 	}
 
+	var compressedDir *fsCompressedDir
+	if fs.Compress {
+		if len(fs.CompressedCacheDir) > 0 {
+			compressedDir = newFSCompressedDir(fs.CompressedCacheDir, fs.CompressedCacheDirMaxSize)
+		} else if d, ok := filesystem.(Dir); ok {
+			compressedDir = newFSCompressedDir(string(d), 0)
+		}
+	}
+
+	compressedFileSuffixes := make(map[string]string, len(fs.CompressedFileSuffixes))
+	var precompressedEncodings []string
+	for encoding, suffix := range fs.CompressedFileSuffixes {
+		compressedFileSuffixes[encoding] = suffix
+		precompressedEncodings = append(precompressedEncodings, encoding)
+	}
+	if len(precompressedEncodings) > 63 {
+		panic("BUG: FS.CompressedFileSuffixes cannot contain more than 63 encodings")
+	}
+	sort.Strings(precompressedEncodings)
+
 	h := &fsHandler{
-		root:               root,
+		filesystem:         filesystem,
 		indexNames:         fs.IndexNames,
 		pathRewrite:        fs.PathRewrite,
 		generateIndexPages: fs.GenerateIndexPages,
 		compress:           fs.Compress,
+		compressedDir:      compressedDir,
+		acceptByteRange:    fs.AcceptByteRange,
+		generateETag:       fs.GenerateETag,
+		etagContentHash:    fs.ETagContentHash,
 		cacheDuration:      cacheDuration,
-		cache:              make(map[string]*fsFile),
-		compressedCache:    make(map[string]*fsFile),
+
+		compressedFileSuffixes: compressedFileSuffixes,
+		precompressedEncodings: precompressedEncodings,
+
+		cache:               make(map[string]*fsFile),
+		compressedCache:     make(map[string]*fsFile),
+		precompressedCaches: make(map[uint64]map[string]*fsFile),
 	}
 
 	go func() {
@@ -206,31 +327,89 @@ func (fs *FS) NewRequestHandler() RequestHandler {
 		}
 	}()
 
+	if fs.WatchChanges {
+		if d, ok := filesystem.(Dir); ok {
+			root := d.osPath("")
+			w, err := newFSWatcher(root, h.invalidateCache)
+			if err != nil {
+				defaultLogger.Printf("cannot watch changes in %q: %s. Cached files expire after %s", root, err, cacheDuration)
+			} else {
+				h.watcher = w
+				h.recompressCh = make(chan struct{}, 1)
+				h.recompressStopCh = make(chan struct{})
+				if fs.Compress {
+					go h.recompressWorker()
+				}
+			}
+		}
+	}
+	fs.h = h
+
 	return h.handleRequest
 }
 
+// Close stops watching changes enabled via WatchChanges.
+//
+// The request handler may be still used after Close. Cached files
+// expire after CacheDuration then.
+func (fs *FS) Close() error {
+	if fs.h != nil {
+		fs.h.stopWatching()
+	}
+	return nil
+}
+
 type fsHandler struct {
-	root               string
+	filesystem         FileSystem
 	indexNames         []string
 	pathRewrite        PathRewriteFunc
 	generateIndexPages bool
 	compress           bool
+	compressedDir      *fsCompressedDir
+	acceptByteRange    bool
+	generateETag       bool
+	etagContentHash    bool
 	cacheDuration      time.Duration
 
-	cache           map[string]*fsFile
-	compressedCache map[string]*fsFile
-	cacheLock       sync.Mutex
+	compressedFileSuffixes map[string]string
+	precompressedEncodings []string
+
+	cache               map[string]*fsFile
+	compressedCache     map[string]*fsFile
+	precompressedCaches map[uint64]map[string]*fsFile
+	cacheLock           sync.Mutex
+
+	// cacheGeneration is incremented on each cache invalidation.
+	cacheGeneration uint64
+
+	// staleFiles contains invalidated files, which must be released
+	// by cleanCache.
+	staleFiles []*fsFile
+
+	watcher *fsWatcher
+
+	// pathsToRecompress contains paths of invalidated compressed files,
+	// which are re-created one by one by recompressWorker.
+	pathsToRecompress map[string]struct{}
+	recompressCh      chan struct{}
+	recompressStopCh  chan struct{}
 
 	smallFileReaderPool sync.Pool
 }
 
 type fsFile struct {
-	h             *fsHandler
-	f             *os.File
-	dirIndex      []byte
-	contentType   string
-	contentLength int
-	compressed    bool
+	h               *fsHandler
+	f               File
+	filesystem      FileSystem
+	filePath        string
+	contentType     string
+	contentLength   int
+	contentEncoding []byte
+	etag            []byte
+
+	// data contains file contents if f is nil. For instance,
+	// automatically generated directory index.
+	data []byte
 
 	lastModified    time.Time
 	lastModifiedStr []byte
@@ -238,6 +417,10 @@ type fsFile struct {
 	t            time.Time
 	readersCount int
 
+	// compressedDirFile is set for files opened from h.compressedDir,
+	// so they aren't deleted until the file is released.
+	compressedDirFile *fsCompressedDirFile
+
 	bigFiles     []*bigFileReader
 	bigFilesLock sync.Mutex
 }
@@ -257,14 +440,16 @@ func (ff *fsFile) smallFileReader() io.Reader {
 	v := ff.h.smallFileReaderPool.Get()
 	if v == nil {
 		r := &fsSmallFileReader{
-			ff: ff,
+			ff:     ff,
+			endPos: ff.contentLength,
 		}
 		return r
 	}
 	r := v.(*fsSmallFileReader)
 	r.ff = ff
-	if r.offset > 0 {
-		panic("BUG: fsSmallFileReader with non-nil offset found in the pool")
+	r.endPos = ff.contentLength
+	if r.startPos > 0 {
+		panic("BUG: fsSmallFileReader with non-nil startPos found in the pool")
 	}
 	return r
 }
@@ -273,7 +458,7 @@ func (ff *fsFile) smallFileReader() io.Reader {
 const maxSmallFileSize = 2 * 4096
 
 func (ff *fsFile) isBig() bool {
-	return ff.contentLength > maxSmallFileSize && len(ff.dirIndex) == 0
+	return ff.contentLength > maxSmallFileSize && len(ff.data) == 0
 }
 
 func (ff *fsFile) bigFileReader() (io.Reader, error) {
@@ -281,7 +466,7 @@ func (ff *fsFile) bigFileReader() (io.Reader, error) {
 		panic("BUG: ff.f must be non-nil in bigFileReader")
 	}
 
-	var r io.Reader
+	var r *bigFileReader
 
 	ff.bigFilesLock.Lock()
 	n := len(ff.bigFiles)
@@ -291,18 +476,27 @@ func (ff *fsFile) bigFileReader() (io.Reader, error) {
 	}
 	ff.bigFilesLock.Unlock()
 
-	if r != nil {
-		return r, nil
+	if r == nil {
+		f, err := ff.filesystem.Open(ff.filePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open already opened file: %s", err)
+		}
+		r = &bigFileReader{
+			f:  f,
+			ff: ff,
+		}
 	}
+	r.lr.R = r.f
+	r.lr.N = int64(ff.contentLength)
+	return r, nil
+}
 
-	f, err := os.Open(ff.f.Name())
-	if err != nil {
-		return nil, fmt.Errorf("cannot open already opened file: %s", err)
+// readerAt returns random access reader for the file contents.
+func (ff *fsFile) readerAt() io.ReaderAt {
+	if ff.f != nil {
+		return ff.f
 	}
-	return &bigFileReader{
-		f:  f,
-		ff: ff,
-	}, nil
+	return bytes.NewReader(ff.data)
 }
 
 func (ff *fsFile) Release() {
@@ -317,6 +511,9 @@ func (ff *fsFile) Release() {
 			ff.bigFilesLock.Unlock()
 		}
 	}
+	if ff.compressedDirFile != nil {
+		ff.h.compressedDir.release(ff.compressedDirFile)
+	}
 }
 
 func (ff *fsFile) decReadersCount() {
@@ -331,22 +528,34 @@ func (ff *fsFile) decReadersCount() {
 // bigFileReader attempts to trigger sendfile
 // for sending big files over the wire.
 type bigFileReader struct {
-	f  *os.File
+	f  File
 	ff *fsFile
+
+	// lr limits reading to the byte range set via UpdateByteRange.
+	// sendfile is triggered for io.LimitedReader wrapping os.File.
+	lr io.LimitedReader
+}
+
+func (r *bigFileReader) UpdateByteRange(startPos, endPos int) error {
+	if _, err := r.f.Seek(int64(startPos), 0); err != nil {
+		return err
+	}
+	r.lr.N = int64(endPos - startPos + 1)
+	return nil
 }
 
 func (r *bigFileReader) Read(p []byte) (int, error) {
-	return r.f.Read(p)
+	return r.lr.Read(p)
 }
 
 func (r *bigFileReader) WriteTo(w io.Writer) (int64, error) {
 	if rf, ok := w.(io.ReaderFrom); ok {
 		// fast path. Senfile must be triggered
-		return rf.ReadFrom(r.f)
+		return rf.ReadFrom(&r.lr)
 	}
 
 	// slow path
-	return copyZeroAlloc(w, r.f)
+	return copyZeroAlloc(w, &r.lr)
 }
 
 func (r *bigFileReader) Close() error {
@@ -367,75 +576,128 @@ func (r *bigFileReader) Close() error {
 	return err
 }
 
+// fileRangeReader reads the byte range of f set via UpdateByteRange.
+type fileRangeReader struct {
+	f *os.File
+
+	// sendfile is triggered for io.LimitedReader wrapping os.File.
+	lr io.LimitedReader
+}
+
+func (r *fileRangeReader) UpdateByteRange(startPos, endPos int) error {
+	if _, err := r.f.Seek(int64(startPos), 0); err != nil {
+		return err
+	}
+	r.lr.R = r.f
+	r.lr.N = int64(endPos - startPos + 1)
+	return nil
+}
+
+func (r *fileRangeReader) Read(p []byte) (int, error) {
+	return r.lr.Read(p)
+}
+
+func (r *fileRangeReader) WriteTo(w io.Writer) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		// fast path. Senfile must be triggered
+		return rf.ReadFrom(&r.lr)
+	}
+
+	// slow path
+	return copyZeroAlloc(w, &r.lr)
+}
+
+func (r *fileRangeReader) Close() error {
+	return r.f.Close()
+}
+
 type fsSmallFileReader struct {
-	ff     *fsFile
-	offset int64
+	ff       *fsFile
+	startPos int
+	endPos   int
 }
 
 func (r *fsSmallFileReader) Close() error {
 	ff := r.ff
 	ff.decReadersCount()
 	r.ff = nil
-	r.offset = 0
+	r.startPos = 0
+	r.endPos = 0
 	ff.h.smallFileReaderPool.Put(r)
 	return nil
 }
 
+func (r *fsSmallFileReader) UpdateByteRange(startPos, endPos int) error {
+	r.startPos = startPos
+	r.endPos = endPos + 1
+	return nil
+}
+
 func (r *fsSmallFileReader) Read(p []byte) (int, error) {
-	ff := r.ff
+	tailLen := r.endPos - r.startPos
+	if tailLen <= 0 {
+		return 0, io.EOF
+	}
+	if len(p) > tailLen {
+		p = p[:tailLen]
+	}
 
+	ff := r.ff
 	if ff.f != nil {
-		n, err := ff.f.ReadAt(p, r.offset)
-		r.offset += int64(n)
+		n, err := ff.f.ReadAt(p, int64(r.startPos))
+		r.startPos += n
 		return n, err
 	}
 
-	if r.offset == int64(len(ff.dirIndex)) {
-		return 0, io.EOF
-	}
-	n := copy(p, ff.dirIndex[r.offset:])
-	r.offset += int64(n)
+	n := copy(p, ff.data[r.startPos:])
+	r.startPos += n
 	return n, nil
 }
 
 func (r *fsSmallFileReader) WriteTo(w io.Writer) (int64, error) {
-	if r.offset != 0 {
-		panic("BUG: non-zero offset! Read() mustn't be called before WriteTo()")
-	}
-
 	ff := r.ff
 
 	var n int
 	var err error
-	if ff.f != nil {
-		if rf, ok := w.(io.ReaderFrom); ok {
-			return rf.ReadFrom(r)
-		}
-
-		bufv := copyBufPool.Get()
-		buf := bufv.([]byte)
-		for err != nil {
-			n, err = ff.f.ReadAt(buf, r.offset)
-			nw, errw := w.Write(buf[:n])
-			r.offset += int64(nw)
-			if errw == nil && nw != n {
-				panic("BUG: Write(p) returned (n, nil), where n != len(p)")
-			}
-			if err == nil {
-				err = errw
-			}
-		}
-		copyBufPool.Put(bufv)
+	if ff.f == nil {
+		n, err = w.Write(ff.data[r.startPos:r.endPos])
+		r.startPos += n
+		return int64(n), err
+	}
 
-		if err == io.EOF {
-			err = nil
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+
+	curPos := r.startPos
+	bufv := copyBufPool.Get()
+	buf := bufv.([]byte)
+	for err == nil {
+		tailLen := r.endPos - curPos
+		if tailLen <= 0 {
+			break
+		}
+		if len(buf) > tailLen {
+			buf = buf[:tailLen]
+		}
+		n, err = ff.f.ReadAt(buf, int64(curPos))
+		nw, errw := w.Write(buf[:n])
+		curPos += nw
+		if errw == nil && nw != n {
+			panic("BUG: Write(p) returned (n, nil), where n != len(p)")
+		}
+		if err == nil {
+			err = errw
 		}
-		return r.offset, err
 	}
+	copyBufPool.Put(bufv)
 
-	n, err = w.Write(ff.dirIndex)
-	r.offset += int64(n)
-	return r.offset, err
+	if err == io.EOF {
+		err = nil
+	}
+	n = curPos - r.startPos
+	r.startPos = curPos
+	return int64(n), err
 }
 
 func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
@@ -443,6 +705,9 @@ func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
 
 	h.cacheLock.Lock()
 
+	pendingFiles = append(pendingFiles, h.staleFiles...)
+	h.staleFiles = nil
+
 	// Close files which couldn't be closed before due to non-zero
 	// readers count on the previous run.
 	var remainingFiles []*fsFile
@@ -457,6 +722,9 @@ func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
 
 	pendingFiles, filesToRelease = cleanCacheNolock(h.cache, pendingFiles, filesToRelease, h.cacheDuration)
 	pendingFiles, filesToRelease = cleanCacheNolock(h.compressedCache, pendingFiles, filesToRelease, h.cacheDuration)
+	for _, cache := range h.precompressedCaches {
+		pendingFiles, filesToRelease = cleanCacheNolock(cache, pendingFiles, filesToRelease, h.cacheDuration)
+	}
 
 	h.cacheLock.Unlock()
 
@@ -467,6 +735,175 @@ func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
 	return pendingFiles
 }
 
+// invalidateCache removes cached files affected by the change
+// of the file or directory with the given path.
+//
+// All the cached files are removed if the path is empty.
+func (h *fsHandler) invalidateCache(path string) {
+	if strings.HasSuffix(path, FSCompressedFileSuffix) || strings.HasSuffix(path, FSCompressedFileSuffix+".tmp") {
+		// Ignore compressed files saved by the handler.
+		return
+	}
+
+	var staleFiles, filesToRelease []*fsFile
+	mustRecompress := false
+	h.cacheLock.Lock()
+	h.cacheGeneration++
+	for k, ff := range h.compressedCache {
+		if fsCacheKeyAffected(k, path) {
+			delete(h.compressedCache, k)
+			staleFiles = append(staleFiles, ff)
+			if h.pathsToRecompress == nil {
+				h.pathsToRecompress = make(map[string]struct{})
+			}
+			h.pathsToRecompress[k] = struct{}{}
+			mustRecompress = true
+		}
+	}
+	staleFiles = invalidateCacheNolock(h.cache, path, staleFiles)
+	for _, cache := range h.precompressedCaches {
+		staleFiles = invalidateCacheNolock(cache, path, staleFiles)
+	}
+	for _, ff := range staleFiles {
+		if ff.readersCount > 0 {
+			// The file is closed by cleanCache after pending readers
+			// are finished.
+			h.staleFiles = append(h.staleFiles, ff)
+		} else {
+			filesToRelease = append(filesToRelease, ff)
+		}
+	}
+	h.cacheLock.Unlock()
+
+	for _, ff := range filesToRelease {
+		ff.Release()
+	}
+	if mustRecompress && h.recompressCh != nil {
+		select {
+		case h.recompressCh <- struct{}{}:
+		default:
+			// recompressWorker has been already notified.
+		}
+	}
+}
+
+func invalidateCacheNolock(cache map[string]*fsFile, path string, staleFiles []*fsFile) []*fsFile {
+	for k, ff := range cache {
+		if fsCacheKeyAffected(k, path) {
+			delete(cache, k)
+			staleFiles = append(staleFiles, ff)
+		}
+	}
+	return staleFiles
+}
+
+// fsCacheKeyAffected returns true if the cached file with the given key
+// may be affected by the change of the given path.
+func fsCacheKeyAffected(key, path string) bool {
+	if key == path || strings.HasPrefix(key, path+"/") {
+		// The file or its parent directory has been changed.
+		return true
+	}
+	if !strings.HasPrefix(path, key) {
+		return false
+	}
+	tail := path[len(key):]
+	switch tail[0] {
+	case '.':
+		// Precompressed sibling of the file has been changed.
+		return strings.IndexByte(tail, '/') < 0
+	case '/':
+		// The file in the directory has been changed, so directory
+		// index may be changed.
+		return strings.IndexByte(tail[1:], '/') < 0
+	}
+	return false
+}
+
+// recompressWorker re-creates compressed files from h.pathsToRecompress
+// until stopWatching is called.
+//
+// Files are re-created sequentially, so bulk changes don't result
+// in unbounded number of concurrent compressions.
+func (h *fsHandler) recompressWorker() {
+	for {
+		select {
+		case <-h.recompressCh:
+		case <-h.recompressStopCh:
+			return
+		}
+
+		h.cacheLock.Lock()
+		paths := h.pathsToRecompress
+		h.pathsToRecompress = nil
+		h.cacheLock.Unlock()
+
+		for path := range paths {
+			select {
+			case <-h.recompressStopCh:
+				return
+			default:
+			}
+			h.recompress(path)
+		}
+	}
+}
+
+// stopWatching stops watching changes and recompressWorker.
+func (h *fsHandler) stopWatching() {
+	h.cacheLock.Lock()
+	w := h.watcher
+	h.watcher = nil
+	h.cacheLock.Unlock()
+
+	if w != nil {
+		w.close()
+		close(h.recompressStopCh)
+	}
+}
+
+// recompress re-creates compressed file with the given path
+// and puts it into h.compressedCache.
+func (h *fsHandler) recompress(path string) {
+	h.cacheLock.Lock()
+	cacheGeneration := h.cacheGeneration
+	h.cacheLock.Unlock()
+
+	ff, err := h.openFSFile(path, 0, true)
+	if err != nil {
+		// The file may be deleted or it may be a directory.
+		return
+	}
+
+	h.cacheLock.Lock()
+	if _, ok := h.compressedCache[path]; !ok && cacheGeneration == h.cacheGeneration {
+		h.compressedCache[path] = ff
+		ff = nil
+	}
+	h.cacheLock.Unlock()
+
+	if ff != nil {
+		ff.Release()
+	}
+}
+
+// precompressedCache returns file cache for requests accepting encodings
+// from h.precompressedEncodings set in encodingsMask.
+//
+// h.cacheLock must be held.
+func (h *fsHandler) precompressedCache(encodingsMask uint64, mustCompress bool) map[string]*fsFile {
+	key := encodingsMask << 1
+	if mustCompress {
+		key |= 1
+	}
+	cache := h.precompressedCaches[key]
+	if cache == nil {
+		cache = make(map[string]*fsFile)
+		h.precompressedCaches[key] = cache
+	}
+	return cache
+}
+
 func cleanCacheNolock(cache map[string]*fsFile, pendingFiles, filesToRelease []*fsFile, cacheDuration time.Duration) ([]*fsFile, []*fsFile) {
 	t := time.Now()
 	for k, ff := range cache {
@@ -506,27 +943,37 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		mustCompress = true
 		fileCache = h.compressedCache
 	}
+	var encodingsMask uint64
+	for i, encoding := range h.precompressedEncodings {
+		if ctx.Request.Header.HasAcceptEncoding(encoding) {
+			encodingsMask |= 1 << uint(i)
+		}
+	}
 
 	h.cacheLock.Lock()
+	if encodingsMask != 0 {
+		fileCache = h.precompressedCache(encodingsMask, mustCompress)
+	}
 	ff, ok := fileCache[string(path)]
 	if ok {
 		ff.readersCount++
 	}
+	cacheGeneration := h.cacheGeneration
 	h.cacheLock.Unlock()
 
 	if !ok {
 		pathStr := string(path)
-		filePath := h.root + pathStr
+		filePath := pathStr
 		var err error
-		ff, err = h.openFSFile(filePath, mustCompress)
+		ff, err = h.openFSFile(filePath, encodingsMask, mustCompress)
 		if mustCompress && err == errNoCreatePermission {
 			ctx.Logger().Printf("insufficient permissions for saving compressed file for %q. Serving uncompressed file. "+
 				"Allow write access to the directory with this file in order to improve fasthttp performance", filePath)
 			mustCompress = false
-			ff, err = h.openFSFile(filePath, mustCompress)
+			ff, err = h.openFSFile(filePath, encodingsMask, mustCompress)
 		}
 		if err == errDirIndexRequired {
-			ff, err = h.openIndexFile(ctx, filePath, mustCompress)
+			ff, err = h.openIndexFile(ctx, filePath, encodingsMask, mustCompress)
 			if err != nil {
 				ctx.Logger().Printf("cannot open dir index %q: %s", filePath, err)
 				ctx.Error("Directory index is forbidden", StatusForbidden)
@@ -541,7 +988,13 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		h.cacheLock.Lock()
 		ff1, ok := fileCache[pathStr]
 		if !ok {
-			fileCache[pathStr] = ff
+			if cacheGeneration == h.cacheGeneration {
+				fileCache[pathStr] = ff
+			} else {
+				// The file might be changed while opening it,
+				// so do not cache it.
+				h.staleFiles = append(h.staleFiles, ff)
+			}
 			ff.readersCount++
 		} else {
 			ff1.readersCount++
@@ -557,12 +1010,31 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		}
 	}
 
-	if !ctx.IfModifiedSince(ff.lastModified) {
+	switch ctx.checkPreconditions(ff.etag, ff.lastModified) {
+	case StatusNotModified:
 		ff.decReadersCount()
 		ctx.NotModified()
+		if len(ff.etag) > 0 {
+			ctx.Response.Header.SetCanonical(strETag, ff.etag)
+		}
+		return
+	case StatusPreconditionFailed:
+		ff.decReadersCount()
+		ctx.Error(StatusMessage(StatusPreconditionFailed), StatusPreconditionFailed)
 		return
 	}
 
+	var byteRanges []byteRange
+	if h.acceptByteRange {
+		var err error
+		byteRanges, err = ctx.requestedByteRanges(ff.contentLength, ff.etag, ff.lastModified)
+		if err != nil {
+			ff.decReadersCount()
+			ctx.byteRangeNotSatisfiable(ff.contentLength)
+			return
+		}
+	}
+
 	r, err := ff.NewReader()
 	if err != nil {
 		ctx.Logger().Printf("cannot obtain file reader for path=%q: %s", path, err)
@@ -570,19 +1042,407 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		return
 	}
 
-	if ff.compressed {
-		ctx.Response.Header.SetCanonical(strContentEncoding, strGzip)
+	hdr := &ctx.Response.Header
+	if len(ff.contentEncoding) > 0 {
+		hdr.SetCanonical(strContentEncoding, ff.contentEncoding)
+	}
+	if h.acceptByteRange {
+		hdr.SetCanonical(strAcceptRanges, strBytes)
+	}
+	if len(ff.etag) > 0 {
+		hdr.SetCanonical(strETag, ff.etag)
+	}
+	hdr.SetCanonical(strLastModified, ff.lastModifiedStr)
+	if len(byteRanges) > 0 {
+		ctx.SetContentType(ff.contentType)
+		if err = ctx.setByteRangesBody(byteRanges, r, ff.readerAt(), ff.contentLength); err != nil {
+			r.(io.Closer).Close()
+			ctx.Logger().Printf("cannot seek to the requested byte range for path=%q: %s", path, err)
+			ctx.Error("Internal Server Error", StatusInternalServerError)
+		}
+		return
 	}
-	ctx.Response.Header.SetCanonical(strLastModified, ff.lastModifiedStr)
 	ctx.SetBodyStream(r, ff.contentLength)
 	ctx.SetContentType(ff.contentType)
 	ctx.SetStatusCode(StatusOK)
 }
 
-func (h *fsHandler) openIndexFile(ctx *RequestCtx, dirPath string, mustCompress bool) (*fsFile, error) {
+// byteRange is the range of bytes [start ... end] including end.
+type byteRange struct {
+	start int
+	end   int
+}
+
+// maxByteRanges is the maximum number of byte ranges per request.
+//
+// 'Range' header with more ranges is ignored, so clients cannot
+// force the server sending a lot of small overlapping ranges.
+const maxByteRanges = 100
+
+var (
+	errInvalidByteRange       = errors.New("invalid byte range")
+	errUnsatisfiableByteRange = errors.New("unsatisfiable byte range")
+)
+
+// requestedByteRanges returns byte ranges requested in 'Range' header
+// for the content with the given length, etag and modification time.
+//
+// nil is returned if the whole content must be sent.
+// errUnsatisfiableByteRange is returned if all the requested ranges
+// are outside the content.
+func (ctx *RequestCtx) requestedByteRanges(contentLength int, etag []byte, lastModified time.Time) ([]byteRange, error) {
+	if !ctx.IsGet() && !ctx.IsHead() {
+		return nil, nil
+	}
+	v := ctx.Request.Header.peek(strRange)
+	if len(v) == 0 || !ctx.ifRange(etag, lastModified) {
+		return nil, nil
+	}
+	byteRanges, err := parseByteRanges(v, contentLength)
+	if err == errInvalidByteRange {
+		// Invalid 'Range' header must be ignored.
+		// See https://tools.ietf.org/html/rfc7233#section-3.1 .
+		return nil, nil
+	}
+	return byteRanges, err
+}
+
+// ifRange returns true if 'If-Range' request header is missing
+// or matches the given etag or lastModified.
+func (ctx *RequestCtx) ifRange(etag []byte, lastModified time.Time) bool {
+	v := ctx.Request.Header.peek(strIfRange)
+	if len(v) == 0 {
+		return true
+	}
+	if v[0] == '"' || bytes.HasPrefix(v, strWeakETagPrefix) {
+		// Weak entity tags never match If-Range.
+		return !isWeakETag(etag) && bytes.Equal(v, etag)
+	}
+	t, err := ParseHTTPDate(v)
+	if err != nil {
+		return false
+	}
+	return t.Equal(fsModTime(lastModified))
+}
+
+// checkPreconditions evaluates 'If-Match', 'If-Unmodified-Since',
+// 'If-None-Match' and 'If-Modified-Since' request headers
+// for the resource with the given etag and modification time
+// in the order defined at https://tools.ietf.org/html/rfc7232#section-6 .
+//
+// StatusNotModified or StatusPreconditionFailed is returned
+// if the request mustn't be processed. Otherwise 0 is returned.
+func (ctx *RequestCtx) checkPreconditions(etag []byte, lastModified time.Time) int {
+	h := &ctx.Request.Header
+	if v := h.peek(strIfMatch); len(v) > 0 {
+		if !matchETags(v, etag, true) {
+			return StatusPreconditionFailed
+		}
+	} else if v := h.peek(strIfUnmodifiedSince); len(v) > 0 {
+		if t, err := ParseHTTPDate(v); err == nil && fsModTime(lastModified).After(t) {
+			return StatusPreconditionFailed
+		}
+	}
+
+	isGetOrHead := ctx.IsGet() || ctx.IsHead()
+	if v := h.peek(strIfNoneMatch); len(v) > 0 {
+		if matchETags(v, etag, false) {
+			if isGetOrHead {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if isGetOrHead && !ctx.IfModifiedSince(lastModified) {
+		return StatusNotModified
+	}
+	return 0
+}
+
+var strWeakETagPrefix = []byte("W/")
+
+func isWeakETag(etag []byte) bool {
+	return bytes.HasPrefix(etag, strWeakETagPrefix)
+}
+
+// matchETags returns true if comma-separated list of entity tags
+// from 'If-Match' or 'If-None-Match' request header contains '*'
+// or matches the given etag.
+//
+// Weak entity tags never match if strong comparison is requested.
+// See https://tools.ietf.org/html/rfc7232#section-2.3.2 .
+func matchETags(list, etag []byte, strong bool) bool {
+	if strong && isWeakETag(etag) {
+		etag = nil
+	}
+	opaqueTag := etag
+	if isWeakETag(opaqueTag) {
+		opaqueTag = opaqueTag[len(strWeakETagPrefix):]
+	}
+	for {
+		for len(list) > 0 && (list[0] == ' ' || list[0] == '\t' || list[0] == ',') {
+			list = list[1:]
+		}
+		if len(list) == 0 {
+			return false
+		}
+		if list[0] == '*' {
+			return true
+		}
+
+		isWeak := isWeakETag(list)
+		if isWeak {
+			list = list[len(strWeakETagPrefix):]
+		}
+		if len(list) == 0 || list[0] != '"' {
+			// Malformed entity tag.
+			return false
+		}
+		n := bytes.IndexByte(list[1:], '"')
+		if n < 0 {
+			return false
+		}
+		tag := list[:n+2]
+		list = list[n+2:]
+		if len(opaqueTag) > 0 && !(strong && isWeak) && bytes.Equal(tag, opaqueTag) {
+			return true
+		}
+	}
+}
+
+// parseByteRanges parses 'Range' header value such as 'bytes=0-99,-100'
+// for the content with the given length.
+//
+// Ranges outside the content are skipped, while ranges exceeding
+// the content are truncated.
+func parseByteRanges(v []byte, contentLength int) ([]byteRange, error) {
+	if !bytes.HasPrefix(v, strBytes) || len(v) == len(strBytes) || v[len(strBytes)] != '=' {
+		return nil, errInvalidByteRange
+	}
+	v = v[len(strBytes)+1:]
+
+	var byteRanges []byteRange
+	rangesCount := 0
+	for len(v) > 0 {
+		var spec []byte
+		n := bytes.IndexByte(v, ',')
+		if n < 0 {
+			spec = v
+			v = nil
+		} else {
+			spec = v[:n]
+			v = v[n+1:]
+		}
+		spec = bytes.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+		rangesCount++
+		if rangesCount > maxByteRanges {
+			return nil, errInvalidByteRange
+		}
+
+		n = bytes.IndexByte(spec, '-')
+		if n < 0 {
+			return nil, errInvalidByteRange
+		}
+		var start, end int
+		var err error
+		if n == 0 {
+			// Suffix range such as '-100' for the last 100 bytes.
+			var suffixLen int
+			if suffixLen, err = ParseUint(spec[1:]); err != nil {
+				return nil, errInvalidByteRange
+			}
+			if suffixLen == 0 {
+				continue
+			}
+			start = contentLength - suffixLen
+			if start < 0 {
+				start = 0
+			}
+			end = contentLength - 1
+		} else {
+			if start, err = ParseUint(spec[:n]); err != nil {
+				return nil, errInvalidByteRange
+			}
+			end = contentLength - 1
+			if n < len(spec)-1 {
+				var lastPos int
+				if lastPos, err = ParseUint(spec[n+1:]); err != nil || lastPos < start {
+					return nil, errInvalidByteRange
+				}
+				if lastPos < end {
+					end = lastPos
+				}
+			}
+		}
+		if start >= contentLength {
+			continue
+		}
+		byteRanges = append(byteRanges, byteRange{
+			start: start,
+			end:   end,
+		})
+	}
+	if rangesCount == 0 {
+		return nil, errInvalidByteRange
+	}
+	if len(byteRanges) == 0 {
+		return nil, errUnsatisfiableByteRange
+	}
+	return byteRanges, nil
+}
+
+// byteRangeNotSatisfiable sets '416 Requested Range Not Satisfiable'
+// response for the content with the given length.
+func (ctx *RequestCtx) byteRangeNotSatisfiable(contentLength int) {
+	ctx.Error(StatusMessage(StatusRequestedRangeNotSatisfiable), StatusRequestedRangeNotSatisfiable)
+	h := &ctx.Response.Header
+	b := h.bufKV.value[:0]
+	b = append(b, strBytes...)
+	b = append(b, " */"...)
+	b = AppendUint(b, contentLength)
+	h.bufKV.value = b
+	h.SetCanonical(strContentRange, h.bufKV.value)
+	h.SetCanonical(strAcceptRanges, strBytes)
+}
+
+// byteRangeUpdater is implemented by body readers, which may be limited
+// to the given byte range.
+type byteRangeUpdater interface {
+	UpdateByteRange(startPos, endPos int) error
+}
+
+// setByteRangesBody sets '206 Partial Content' response with the given
+// byte ranges of the content with the given length.
+//
+// r must read the whole content and implement byteRangeUpdater,
+// while ra must provide random access to the content. r is used
+// as the response body for a single byte range. Multiple byte ranges
+// are read from ra and sent in multipart/byteranges body, while r
+// is just closed after sending the body.
+//
+// multipart/byteranges body is built when writing the response,
+// so the response Content-Type may be set after setByteRangesBody call.
+func (ctx *RequestCtx) setByteRangesBody(byteRanges []byteRange, r io.Reader, ra io.ReaderAt, contentLength int) error {
+	if len(byteRanges) == 1 {
+		br := byteRanges[0]
+		if err := r.(byteRangeUpdater).UpdateByteRange(br.start, br.end); err != nil {
+			return err
+		}
+		ctx.Response.Header.SetContentRange(br.start, br.end, contentLength)
+		ctx.SetBodyStream(r, br.end-br.start+1)
+	} else {
+		ctx.SetBodyStream(&byteRangesReader{
+			h:             &ctx.Response.Header,
+			r:             r,
+			ra:            ra,
+			byteRanges:    byteRanges,
+			contentLength: contentLength,
+		}, -1)
+	}
+	ctx.SetStatusCode(StatusPartialContent)
+	return nil
+}
+
+// byteRangesReader reads multipart/byteranges body and closes
+// the underlying content reader r when closed.
+type byteRangesReader struct {
+	io.Reader
+
+	h             *ResponseHeader
+	r             io.Reader
+	ra            io.ReaderAt
+	byteRanges    []byteRange
+	contentLength int
+}
+
+// init builds multipart/byteranges body with the Content-Type
+// from h for each part and updates h for the multipart body.
+func (r *byteRangesReader) init(h *ResponseHeader) {
+	if r.Reader != nil {
+		return
+	}
+	contentType := h.ContentType()
+	boundary := randomBoundary()
+	var readers []io.Reader
+	bodySize := 0
+	var b []byte
+	for i, br := range r.byteRanges {
+		b = b[:0]
+		if i > 0 {
+			b = append(b, strCRLF...)
+		}
+		b = append(b, "--"...)
+		b = append(b, boundary...)
+		b = append(b, strCRLF...)
+		b = append(b, strContentType...)
+		b = append(b, ": "...)
+		b = append(b, contentType...)
+		b = append(b, strCRLF...)
+		b = append(b, strContentRange...)
+		b = append(b, ": "...)
+		b = append(b, strBytes...)
+		b = append(b, ' ')
+		b = AppendUint(b, br.start)
+		b = append(b, '-')
+		b = AppendUint(b, br.end)
+		b = append(b, '/')
+		b = AppendUint(b, r.contentLength)
+		b = append(b, strCRLF...)
+		b = append(b, strCRLF...)
+		partHeader := append([]byte(nil), b...)
+
+		n := br.end - br.start + 1
+		readers = append(readers, bytes.NewReader(partHeader), io.NewSectionReader(r.ra, int64(br.start), int64(n)))
+		bodySize += len(partHeader) + n
+	}
+	b = append(b[:0], strCRLF...)
+	b = append(b, "--"...)
+	b = append(b, boundary...)
+	b = append(b, "--"...)
+	b = append(b, strCRLF...)
+	readers = append(readers, bytes.NewReader(b))
+	bodySize += len(b)
+
+	r.Reader = io.MultiReader(readers...)
+	h.SetContentLength(bodySize)
+	h.SetContentType("multipart/byteranges; boundary=" + boundary)
+}
+
+func (r *byteRangesReader) Read(p []byte) (int, error) {
+	r.init(r.h)
+	return r.Reader.Read(p)
+}
+
+func (r *byteRangesReader) Close() error {
+	if rc, ok := r.r.(io.Closer); ok {
+		return rc.Close()
+	}
+	return nil
+}
+
+// initByteRangesBody builds multipart/byteranges body if it is set
+// as the response body stream. It must be called before writing
+// the response header.
+func (resp *Response) initByteRangesBody() {
+	if r, ok := resp.bodyStream.(*byteRangesReader); ok {
+		r.init(&resp.Header)
+	}
+}
+
+func randomBoundary() string {
+	var buf [16]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(fmt.Sprintf("BUG: cannot generate random boundary: %s", err))
+	}
+	return fmt.Sprintf("%x", buf[:])
+}
+
+func (h *fsHandler) openIndexFile(ctx *RequestCtx, dirPath string, encodingsMask uint64, mustCompress bool) (*fsFile, error) {
 	for _, indexName := range h.indexNames {
 		indexFilePath := dirPath + "/" + indexName
-		ff, err := h.openFSFile(indexFilePath, mustCompress)
+		ff, err := h.openFSFile(indexFilePath, encodingsMask, mustCompress)
 		if err == nil {
 			return ff, nil
 		}
@@ -620,7 +1480,7 @@ func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool)
 		fmt.Fprintf(w, `<li><a href="%s" class="dir">..</a></li>`, parentPathEscaped)
 	}
 
-	f, err := os.Open(dirPath)
+	f, err := h.filesystem.Open(dirPath)
 	if err != nil {
 		return nil, err
 	}
@@ -675,14 +1535,25 @@ func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool)
 		w = &zbuf
 	}
 
+	var contentEncoding []byte
+	if mustCompress {
+		contentEncoding = strGzip
+	}
+
 	dirIndex := w.Bytes()
 	lastModified := time.Now()
+	etag, err := h.newETag(bytes.NewReader(dirIndex), len(dirIndex), lastModified)
+	if err != nil {
+		return nil, err
+	}
 	ff := &fsFile{
 		h:               h,
-		dirIndex:        dirIndex,
+		filePath:        dirPath,
 		contentType:     "text/html; charset=utf-8",
 		contentLength:   len(dirIndex),
-		compressed:      mustCompress,
+		contentEncoding: contentEncoding,
+		etag:            etag,
+		data:            dirIndex,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
 
@@ -694,7 +1565,7 @@ func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool)
 const fsMinCompressRatio = 0.9
 
 func (h *fsHandler) compressAndOpenFSFile(filePath string) (*fsFile, error) {
-	f, err := os.Open(filePath)
+	f, err := h.filesystem.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -711,10 +1582,16 @@ func (h *fsHandler) compressAndOpenFSFile(filePath string) (*fsFile, error) {
 	}
 
 	if strings.HasSuffix(filePath, FSCompressedFileSuffix) || !isFileCompressible(f, fsMinCompressRatio) {
-		return h.newFSFile(f, fileInfo, false)
+		return h.newFSFile(f, fileInfo, filePath, false)
 	}
 
-	compressedFilePath := filePath + FSCompressedFileSuffix
+	if h.compressedDir == nil {
+		// Custom filesystems may be read-only, so cache compressed
+		// file in memory.
+		return h.compressFileInMemory(f, fileInfo, filePath)
+	}
+
+	compressedFilePath := h.compressedDir.dir.osPath(filePath + FSCompressedFileSuffix)
 	absPath, err := filepath.Abs(compressedFilePath)
 	if err != nil {
 		f.Close()
@@ -729,14 +1606,24 @@ func (h *fsHandler) compressAndOpenFSFile(filePath string) (*fsFile, error) {
 	return ff, err
 }
 
-func (h *fsHandler) compressFileNolock(f *os.File, fileInfo os.FileInfo, filePath, compressedFilePath string) (*fsFile, error) {
+func (h *fsHandler) compressFileNolock(f File, fileInfo os.FileInfo, filePath, compressedFilePath string) (*fsFile, error) {
 	// Attempt to open compressed file created by another concurrent
 	// goroutine.
 	// It is safe opening such a file, since the file creation
 	// is guarded by file mutex - see getFileLock call.
 	if _, err := os.Stat(compressedFilePath); err == nil {
 		f.Close()
-		return h.newCompressedFSFile(compressedFilePath)
+		return h.openCompressedFSFile(filePath + FSCompressedFileSuffix)
+	}
+
+	// The directory for the compressed file may be missing
+	// in FS.CompressedCacheDir.
+	if err := os.MkdirAll(filepath.Dir(compressedFilePath), 0755); err != nil {
+		f.Close()
+		if !os.IsPermission(err) {
+			return nil, fmt.Errorf("cannot create directory for compressed file %q: %s", compressedFilePath, err)
+		}
+		return nil, errNoCreatePermission
 	}
 
 	// Create temporary file, so concurrent goroutines don't use
@@ -769,11 +1656,53 @@ func (h *fsHandler) compressFileNolock(f *os.File, fileInfo os.FileInfo, filePat
 	if err = os.Rename(tmpFilePath, compressedFilePath); err != nil {
 		return nil, fmt.Errorf("cannot move compressed file from %q to %q: %s", tmpFilePath, compressedFilePath, err)
 	}
-	return h.newCompressedFSFile(compressedFilePath)
+	return h.openCompressedFSFile(filePath + FSCompressedFileSuffix)
 }
 
-func (h *fsHandler) newCompressedFSFile(filePath string) (*fsFile, error) {
-	f, err := os.Open(filePath)
+func (h *fsHandler) compressFileInMemory(f File, fileInfo os.FileInfo, filePath string) (*fsFile, error) {
+	var buf bytes.Buffer
+	zw := acquireGzipWriter(&buf, CompressDefaultCompression)
+	_, err := io.Copy(zw, f)
+	if err1 := zw.Flush(); err == nil {
+		err = err1
+	}
+	releaseGzipWriter(zw)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error when compressing file %q: %s", filePath, err)
+	}
+
+	data := buf.Bytes()
+	contentType, err := fsContentType(bytes.NewReader(data), fileInfo.Name(), true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read header of the file %q: %s", filePath, err)
+	}
+
+	lastModified := fileInfo.ModTime()
+	etag, err := h.newETag(bytes.NewReader(data), len(data), lastModified)
+	if err != nil {
+		return nil, fmt.Errorf("cannot calculate ETag for the file %q: %s", filePath, err)
+	}
+	ff := &fsFile{
+		h:               h,
+		filePath:        filePath,
+		contentType:     contentType,
+		contentLength:   len(data),
+		contentEncoding: strGzip,
+		etag:            etag,
+		data:            data,
+		lastModified:    lastModified,
+		lastModifiedStr: AppendHTTPDate(nil, lastModified),
+
+		t: time.Now(),
+	}
+	return ff, nil
+}
+
+// openCompressedFSFile opens the file with the given path
+// in h.compressedDir.
+func (h *fsHandler) openCompressedFSFile(filePath string) (*fsFile, error) {
+	f, err := h.compressedDir.dir.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open compressed file %q: %s", filePath, err)
 	}
@@ -782,18 +1711,50 @@ func (h *fsHandler) newCompressedFSFile(filePath string) (*fsFile, error) {
 		f.Close()
 		return nil, fmt.Errorf("cannot obtain info for compressed file %q: %s", filePath, err)
 	}
-	return h.newFSFile(f, fileInfo, true)
+	return h.newCompressedFSFile(f, fileInfo, filePath)
 }
 
-func (h *fsHandler) openFSFile(filePath string, mustCompress bool) (*fsFile, error) {
-	filePathOriginal := filePath
-	if mustCompress {
-		filePath += FSCompressedFileSuffix
+func (h *fsHandler) newCompressedFSFile(f File, fileInfo os.FileInfo, filePath string) (*fsFile, error) {
+	ff, err := h.newFSFile(f, fileInfo, filePath, true)
+	if err != nil {
+		return nil, err
+	}
+	ff.filesystem = h.compressedDir.dir
+	ff.compressedDirFile = h.compressedDir.add(h.compressedDir.dir.osPath(filePath), fileInfo.Size())
+	return ff, nil
+}
+
+func (h *fsHandler) openFSFile(filePath string, encodingsMask uint64, mustCompress bool) (*fsFile, error) {
+	if encodingsMask != 0 {
+		if ff := h.openPrecompressedFSFile(filePath, encodingsMask); ff != nil {
+			return ff, nil
+		}
+	}
+	if !mustCompress {
+		f, err := h.filesystem.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		fileInfo, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot obtain info for file %q: %s", filePath, err)
+		}
+		if fileInfo.IsDir() {
+			f.Close()
+			return nil, errDirIndexRequired
+		}
+		return h.newFSFile(f, fileInfo, filePath, false)
+	}
+	if h.compressedDir == nil {
+		return h.compressAndOpenFSFile(filePath)
 	}
 
-	f, err := os.Open(filePath)
+	filePathOriginal := filePath
+	filePath += FSCompressedFileSuffix
+	f, err := h.compressedDir.dir.Open(filePath)
 	if err != nil {
-		if mustCompress && os.IsNotExist(err) {
+		if os.IsNotExist(err) {
 			return h.compressAndOpenFSFile(filePathOriginal)
 		}
 		return nil, err
@@ -807,31 +1768,106 @@ func (h *fsHandler) openFSFile(filePath string, mustCompress bool) (*fsFile, err
 
 	if fileInfo.IsDir() {
 		f.Close()
-		if mustCompress {
-			return nil, fmt.Errorf("directory with unexpected suffix found: %q. Suffix: %q", filePath, FSCompressedFileSuffix)
-		}
-		return nil, errDirIndexRequired
+		return nil, fmt.Errorf("directory with unexpected suffix found: %q. Suffix: %q", filePath, FSCompressedFileSuffix)
 	}
 
-	if mustCompress {
-		fileInfoOriginal, err := os.Stat(filePathOriginal)
+	fileInfoOriginal, err := h.stat(filePathOriginal)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot obtain info for original file %q: %s", filePathOriginal, err)
+	}
+
+	if fileInfoOriginal.ModTime() != fileInfo.ModTime() {
+		// The compressed file became stale. Re-create it.
+		f.Close()
+		compressedFilePath := h.compressedDir.dir.osPath(filePath)
+		os.Remove(compressedFilePath)
+		h.compressedDir.remove(compressedFilePath)
+		return h.compressAndOpenFSFile(filePathOriginal)
+	}
+
+	return h.newCompressedFSFile(f, fileInfo, filePath)
+}
+
+// openPrecompressedFSFile opens precompressed sibling of the file
+// with the given path for the first encoding from h.precompressedEncodings
+// set in encodingsMask.
+//
+// nil is returned if there are no precompressed siblings
+// for the given encodings or if they are older than the file.
+func (h *fsHandler) openPrecompressedFSFile(filePath string, encodingsMask uint64) *fsFile {
+	fileInfo, err := h.stat(filePath)
+	if err != nil || fileInfo.IsDir() {
+		return nil
+	}
+	for i, encoding := range h.precompressedEncodings {
+		if encodingsMask&(1<<uint(i)) == 0 {
+			continue
+		}
+		compressedFilePath := filePath + h.compressedFileSuffixes[encoding]
+		f, err := h.filesystem.Open(compressedFilePath)
 		if err != nil {
+			continue
+		}
+		compressedFileInfo, err := f.Stat()
+		if err != nil || compressedFileInfo.IsDir() || compressedFileInfo.ModTime().Before(fileInfo.ModTime()) {
 			f.Close()
-			return nil, fmt.Errorf("cannot obtain info for original file %q: %s", filePathOriginal, err)
+			continue
 		}
-
-		if fileInfoOriginal.ModTime() != fileInfo.ModTime() {
-			// The compressed file became stale. Re-create it.
+		contentType, err := h.fileContentType(filePath)
+		if err != nil {
 			f.Close()
-			os.Remove(filePath)
-			return h.compressAndOpenFSFile(filePathOriginal)
+			continue
 		}
+		ff, err := h.newFSFileWithContentType(f, compressedFileInfo, compressedFilePath, contentType, []byte(encoding))
+		if err != nil {
+			continue
+		}
+		return ff
 	}
+	return nil
+}
 
-	return h.newFSFile(f, fileInfo, mustCompress)
+func (h *fsHandler) stat(filePath string) (os.FileInfo, error) {
+	f, err := h.filesystem.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := f.Stat()
+	f.Close()
+	return fileInfo, err
+}
+
+// fileContentType returns content-type for the uncompressed file
+// with the given path.
+func (h *fsHandler) fileContentType(filePath string) (string, error) {
+	if contentType := mime.TypeByExtension(fileExtension(filePath, false)); len(contentType) > 0 {
+		return contentType, nil
+	}
+	f, err := h.filesystem.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	contentType, err := fsContentType(f, filePath, false)
+	f.Close()
+	return contentType, err
+}
+
+func (h *fsHandler) newFSFile(f File, fileInfo os.FileInfo, filePath string, compressed bool) (*fsFile, error) {
+	contentType, err := fsContentType(f, fileInfo.Name(), compressed)
+	f.Seek(0, 0)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot read header of the file %q: %s", filePath, err)
+	}
+	var contentEncoding []byte
+	if compressed {
+		contentEncoding = strGzip
+	}
+	return h.newFSFileWithContentType(f, fileInfo, filePath, contentType, contentEncoding)
 }
 
-func (h *fsHandler) newFSFile(f *os.File, fileInfo os.FileInfo, compressed bool) (*fsFile, error) {
+func (h *fsHandler) newFSFileWithContentType(f File, fileInfo os.FileInfo, filePath, contentType string, contentEncoding []byte) (*fsFile, error) {
 	n := fileInfo.Size()
 	contentLength := int(n)
 	if n != int64(contentLength) {
@@ -839,24 +1875,21 @@ func (h *fsHandler) newFSFile(f *os.File, fileInfo os.FileInfo, compressed bool)
 		return nil, fmt.Errorf("too big file: %d bytes", n)
 	}
 
-	// detect content-type
-	ext := fileExtension(fileInfo.Name(), compressed)
-	contentType := mime.TypeByExtension(ext)
-	if len(contentType) == 0 {
-		data, err := readFileHeader(f, compressed)
-		if err != nil {
-			return nil, fmt.Errorf("cannot read header of the file %q: %s", f.Name(), err)
-		}
-		contentType = http.DetectContentType(data)
-	}
-
 	lastModified := fileInfo.ModTime()
+	etag, err := h.newETag(io.NewSectionReader(f, 0, n), contentLength, lastModified)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot calculate ETag for the file %q: %s", filePath, err)
+	}
 	ff := &fsFile{
 		h:               h,
 		f:               f,
+		filesystem:      h.filesystem,
+		filePath:        filePath,
 		contentType:     contentType,
 		contentLength:   contentLength,
-		compressed:      compressed,
+		contentEncoding: contentEncoding,
+		etag:            etag,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
 
@@ -865,8 +1898,43 @@ func (h *fsHandler) newFSFile(f *os.File, fileInfo os.FileInfo, compressed bool)
 	return ff, nil
 }
 
-func readFileHeader(f *os.File, compressed bool) ([]byte, error) {
-	r := io.Reader(f)
+// fsContentType detects content-type for the file with the given name
+// and contents read from r.
+func fsContentType(r io.Reader, name string, compressed bool) (string, error) {
+	ext := fileExtension(name, compressed)
+	if contentType := mime.TypeByExtension(ext); len(contentType) > 0 {
+		return contentType, nil
+	}
+	data, err := readFileHeader(r, compressed)
+	if err != nil {
+		return "", err
+	}
+	return http.DetectContentType(data), nil
+}
+
+// newETag returns ETag for the file with the given contents r, size
+// and modification time.
+//
+// nil is returned if ETags are disabled.
+func (h *fsHandler) newETag(r io.Reader, size int, lastModified time.Time) ([]byte, error) {
+	if !h.generateETag {
+		return nil, nil
+	}
+	if !h.etagContentHash {
+		return []byte(fmt.Sprintf(`W/"%x-%x"`, lastModified.UnixNano(), size)), nil
+	}
+
+	// Do not care about memory allocations here, since the hash
+	// is calculated once per cached file.
+	hash := sha256.New()
+	if _, err := copyZeroAlloc(hash, r); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])), nil
+}
+
+func readFileHeader(f io.Reader, compressed bool) ([]byte, error) {
+	r := f
 	var zr *gzip.Reader
 	if compressed {
 		var err error
@@ -881,7 +1949,6 @@ func readFileHeader(f *os.File, compressed bool) ([]byte, error) {
 		N: 512,
 	}
 	data, err := ioutil.ReadAll(lr)
-	f.Seek(0, 0)
 
 	if zr != nil {
 		releaseGzipReader(zr)
@@ -956,3 +2023,146 @@ func getFileLock(absPath string) *sync.Mutex {
 	filesLockMapLock.Unlock()
 	return flock
 }
+
+// fsCompressedDir is the directory for saving compressed files.
+type fsCompressedDir struct {
+	dir Dir
+
+	// Sizes of compressed files aren't tracked if maxSize is zero.
+	maxSize int64
+
+	lock  sync.Mutex
+	size  int64
+	files map[string]*fsCompressedDirFile
+}
+
+type fsCompressedDirFile struct {
+	size       int64
+	lastAccess time.Time
+
+	// refs is the number of opened fsFiles referring the file.
+	// Referred files aren't deleted, since big files are re-opened
+	// by path for each reader.
+	refs int
+}
+
+func newFSCompressedDir(dir string, maxSize int64) *fsCompressedDir {
+	d := &fsCompressedDir{
+		dir:     Dir(dir),
+		maxSize: maxSize,
+		files:   make(map[string]*fsCompressedDirFile),
+	}
+	if maxSize <= 0 {
+		return d
+	}
+
+	// Account compressed files left by previous runs.
+	filepath.Walk(d.dir.osPath(""), func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() && strings.HasSuffix(path, FSCompressedFileSuffix) {
+			d.files[path] = &fsCompressedDirFile{
+				size:       fi.Size(),
+				lastAccess: fi.ModTime(),
+			}
+			d.size += fi.Size()
+		}
+		return nil
+	})
+
+	// Delete excess files if d.maxSize has been decreased.
+	d.lock.Lock()
+	filesToRemove := d.shrinkNolock()
+	d.lock.Unlock()
+	removeFiles(filesToRemove)
+	return d
+}
+
+// add registers access to the compressed file with the given path
+// and size by the opened fsFile. The returned file must be passed
+// to release when the fsFile is released.
+//
+// Least recently accessed files without references are deleted
+// if the total size exceeds d.maxSize.
+func (d *fsCompressedDir) add(path string, size int64) *fsCompressedDirFile {
+	if d.maxSize <= 0 {
+		return nil
+	}
+
+	d.lock.Lock()
+	f := d.files[path]
+	if f == nil {
+		f = &fsCompressedDirFile{}
+		d.files[path] = f
+	}
+	d.size += size - f.size
+	f.size = size
+	f.lastAccess = time.Now()
+	f.refs++
+	filesToRemove := d.shrinkNolock()
+	d.lock.Unlock()
+
+	removeFiles(filesToRemove)
+	return f
+}
+
+// release releases the reference to the file obtained via add.
+//
+// Excess files, which couldn't be deleted before due to references,
+// are deleted.
+func (d *fsCompressedDir) release(f *fsCompressedDirFile) {
+	d.lock.Lock()
+	f.refs--
+	if f.refs < 0 {
+		panic("BUG: negative fsCompressedDirFile.refs!")
+	}
+	filesToRemove := d.shrinkNolock()
+	d.lock.Unlock()
+
+	removeFiles(filesToRemove)
+}
+
+// shrinkNolock unregisters least recently accessed files without
+// references until the total size fits d.maxSize and returns
+// their paths, which must be deleted.
+//
+// d.lock must be held.
+func (d *fsCompressedDir) shrinkNolock() []string {
+	var filesToRemove []string
+	for d.size > d.maxSize {
+		// Do not care about performance here, since files are deleted
+		// only after creating or releasing compressed files.
+		var oldestPath string
+		var oldest *fsCompressedDirFile
+		for p, f := range d.files {
+			if f.refs == 0 && (oldest == nil || f.lastAccess.Before(oldest.lastAccess)) {
+				oldestPath = p
+				oldest = f
+			}
+		}
+		if oldest == nil {
+			break
+		}
+		delete(d.files, oldestPath)
+		d.size -= oldest.size
+		filesToRemove = append(filesToRemove, oldestPath)
+	}
+	return filesToRemove
+}
+
+func removeFiles(paths []string) {
+	for _, p := range paths {
+		os.Remove(p)
+	}
+}
+
+// remove unregisters the deleted compressed file with the given path.
+func (d *fsCompressedDir) remove(path string) {
+	if d.maxSize <= 0 {
+		return
+	}
+	d.lock.Lock()
+	if f := d.files[path]; f != nil {
+		delete(d.files, path)
+		d.size -= f.size
+	}
+	d.lock.Unlock()
+}
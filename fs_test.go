@@ -3,10 +3,19 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -281,3 +290,886 @@ func testFileExtension(t *testing.T, path string, compressed bool, expectedExt s
 		t.Fatalf("unexpected file extension for file %q: %q. Expecting %q", path, ext, expectedExt)
 	}
 }
+
+func TestParseByteRanges(t *testing.T) {
+	testParseByteRangesSuccess(t, "bytes=0-0", 1, "0-0")
+	testParseByteRangesSuccess(t, "bytes=1234-6789", 10000, "1234-6789")
+	testParseByteRangesSuccess(t, "bytes=123-", 456, "123-455")
+	testParseByteRangesSuccess(t, "bytes=-10", 100, "90-99")
+	testParseByteRangesSuccess(t, "bytes=-200", 100, "0-99")
+	testParseByteRangesSuccess(t, "bytes=50-1000", 100, "50-99")
+	testParseByteRangesSuccess(t, "bytes=0-9, 20-29,-5", 100, "0-9,20-29,95-99")
+	testParseByteRangesSuccess(t, "bytes=0-9,,200-300", 100, "0-9")
+
+	testParseByteRangesError(t, "", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "bytes", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "bytes=", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "items=0-9", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "bytes=9", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "bytes=9-0", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "bytes=a-9", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "bytes=0-9,foobar", 100, errInvalidByteRange)
+	testParseByteRangesError(t, "bytes=-0", 100, errUnsatisfiableByteRange)
+	testParseByteRangesError(t, "bytes=100-", 100, errUnsatisfiableByteRange)
+	testParseByteRangesError(t, "bytes=200-300,-0", 100, errUnsatisfiableByteRange)
+	testParseByteRangesError(t, "bytes=0-", 0, errUnsatisfiableByteRange)
+	testParseByteRangesError(t, "bytes=0-0"+strings.Repeat(",0-0", maxByteRanges), 100, errInvalidByteRange)
+}
+
+func testParseByteRangesSuccess(t *testing.T, v string, contentLength int, expectedRanges string) {
+	byteRanges, err := parseByteRanges([]byte(v), contentLength)
+	if err != nil {
+		t.Fatalf("unexpected error: %s. v=%q, contentLength=%d", err, v, contentLength)
+	}
+	var a []string
+	for _, br := range byteRanges {
+		a = append(a, fmt.Sprintf("%d-%d", br.start, br.end))
+	}
+	if strings.Join(a, ",") != expectedRanges {
+		t.Fatalf("unexpected byte ranges %q. Expecting %q. v=%q, contentLength=%d", a, expectedRanges, v, contentLength)
+	}
+}
+
+func testParseByteRangesError(t *testing.T, v string, contentLength int, expectedErr error) {
+	if _, err := parseByteRanges([]byte(v), contentLength); err != expectedErr {
+		t.Fatalf("unexpected error: %v. Expecting %s. v=%q, contentLength=%d", err, expectedErr, v, contentLength)
+	}
+}
+
+func TestFSByteRangeConcurrent(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+	}
+	h := fs.NewRequestHandler()
+
+	concurrency := 4
+	ch := make(chan struct{}, concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for j := 0; j < 10; j++ {
+				testFSByteRange(t, h, "/fs.go")
+				testFSByteRange(t, h, "/LICENSE")
+			}
+			ch <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < concurrency; i++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+}
+
+func TestFSByteRangeSingleThread(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+	}
+	h := fs.NewRequestHandler()
+
+	testFSByteRange(t, h, "/fs.go")
+	testFSByteRange(t, h, "/LICENSE")
+}
+
+func testFSByteRange(t *testing.T, h RequestHandler, filePath string) {
+	expectedBody, err := ioutil.ReadFile("." + filePath)
+	if err != nil {
+		t.Fatalf("cannot read file %q: %s", filePath, err)
+	}
+	fileSize := len(expectedBody)
+
+	var ctx RequestCtx
+	ctx.Init(&Request{}, nil, nil)
+
+	for _, v := range []string{"bytes=0-0", "bytes=10-100", "bytes=-300", "bytes=500-", "bytes=0-100000000"} {
+		byteRanges, err := parseByteRanges([]byte(v), fileSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		br := byteRanges[0]
+
+		ctx.Request.Reset()
+		ctx.Request.SetRequestURI(filePath)
+		ctx.Request.Header.Set("Range", v)
+		h(&ctx)
+
+		var resp Response
+		s := ctx.Response.String()
+		if err := resp.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+			t.Fatalf("unexpected error: %s. filePath=%q, range=%q", err, filePath, v)
+		}
+		if resp.StatusCode() != StatusPartialContent {
+			t.Fatalf("unexpected status code %d. Expecting %d. filePath=%q, range=%q", resp.StatusCode(), StatusPartialContent, filePath, v)
+		}
+		expectedContentRange := fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, fileSize)
+		if string(resp.Header.Peek("Content-Range")) != expectedContentRange {
+			t.Fatalf("unexpected Content-Range %q. Expecting %q. filePath=%q", resp.Header.Peek("Content-Range"), expectedContentRange, filePath)
+		}
+		if string(resp.Header.Peek("Accept-Ranges")) != "bytes" {
+			t.Fatalf("unexpected Accept-Ranges %q. Expecting %q", resp.Header.Peek("Accept-Ranges"), "bytes")
+		}
+		if !bytes.Equal(resp.Body(), expectedBody[br.start:br.end+1]) {
+			t.Fatalf("unexpected body %q. Expecting %q. filePath=%q, range=%q", resp.Body(), expectedBody[br.start:br.end+1], filePath, v)
+		}
+	}
+}
+
+func TestFSByteRangeMultiple(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+	}
+	h := fs.NewRequestHandler()
+
+	expectedBody, err := ioutil.ReadFile("./fs.go")
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+
+	var ctx RequestCtx
+	ctx.Init(&Request{}, nil, nil)
+	ctx.Request.SetRequestURI("/fs.go")
+	ctx.Request.Header.Set("Range", "bytes=0-9,100-199,-50")
+	h(&ctx)
+
+	var resp Response
+	s := ctx.Response.String()
+	if err := resp.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusPartialContent {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+	}
+	contentType := string(resp.Header.ContentType())
+	if !strings.HasPrefix(contentType, "multipart/byteranges; boundary=") {
+		t.Fatalf("unexpected Content-Type %q", contentType)
+	}
+	if len(resp.Header.Peek("Content-Range")) > 0 {
+		t.Fatalf("unexpected Content-Range %q", resp.Header.Peek("Content-Range"))
+	}
+
+	expectedContentType := mime.TypeByExtension(".go")
+	if len(expectedContentType) == 0 {
+		expectedContentType = http.DetectContentType(expectedBody)
+	}
+	mr := multipart.NewReader(bytes.NewReader(resp.Body()), contentType[len("multipart/byteranges; boundary="):])
+	fileSize := len(expectedBody)
+	for _, br := range []byteRange{{0, 9}, {100, 199}, {fileSize - 50, fileSize - 1}} {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expectedContentRange := fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, fileSize)
+		if p.Header.Get("Content-Range") != expectedContentRange {
+			t.Fatalf("unexpected Content-Range %q. Expecting %q", p.Header.Get("Content-Range"), expectedContentRange)
+		}
+		if p.Header.Get("Content-Type") != expectedContentType {
+			t.Fatalf("unexpected part Content-Type %q. Expecting %q", p.Header.Get("Content-Type"), expectedContentType)
+		}
+		body, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(body, expectedBody[br.start:br.end+1]) {
+			t.Fatalf("unexpected part body %q. Expecting %q", body, expectedBody[br.start:br.end+1])
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting io.EOF", err)
+	}
+}
+
+func TestFSByteRangeNotSatisfiable(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+	}
+	h := fs.NewRequestHandler()
+
+	fi, err := os.Stat("./fs.go")
+	if err != nil {
+		t.Fatalf("cannot stat file: %s", err)
+	}
+
+	var ctx RequestCtx
+	ctx.Init(&Request{}, nil, nil)
+	ctx.Request.SetRequestURI("/fs.go")
+	ctx.Request.Header.Set("Range", fmt.Sprintf("bytes=%d-", fi.Size()))
+	h(&ctx)
+	if ctx.Response.StatusCode() != StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unexpected status code %d. Expecting %d", ctx.Response.StatusCode(), StatusRequestedRangeNotSatisfiable)
+	}
+	expectedContentRange := fmt.Sprintf("bytes */%d", fi.Size())
+	if string(ctx.Response.Header.Peek("Content-Range")) != expectedContentRange {
+		t.Fatalf("unexpected Content-Range %q. Expecting %q", ctx.Response.Header.Peek("Content-Range"), expectedContentRange)
+	}
+}
+
+func TestFSByteRangeIfRange(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+	}
+	h := fs.NewRequestHandler()
+
+	fi, err := os.Stat("./fs.go")
+	if err != nil {
+		t.Fatalf("cannot stat file: %s", err)
+	}
+
+	testFSByteRangeIfRange(t, h, string(AppendHTTPDate(nil, fi.ModTime())), StatusPartialContent)
+	testFSByteRangeIfRange(t, h, string(AppendHTTPDate(nil, fi.ModTime().Add(-time.Hour))), StatusOK)
+	testFSByteRangeIfRange(t, h, `"some-etag"`, StatusOK)
+}
+
+func testFSByteRangeIfRange(t *testing.T, h RequestHandler, ifRange string, expectedStatusCode int) {
+	var ctx RequestCtx
+	ctx.Init(&Request{}, nil, nil)
+	ctx.Request.SetRequestURI("/fs.go")
+	ctx.Request.Header.Set("Range", "bytes=0-9")
+	ctx.Request.Header.Set("If-Range", ifRange)
+	h(&ctx)
+	if ctx.Response.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d. If-Range=%q", ctx.Response.StatusCode(), expectedStatusCode, ifRange)
+	}
+	ctx.Response.CloseBodyStream()
+}
+
+func TestFSHandlerNoByteRangesETags(t *testing.T) {
+	h := FSHandler(".", 0)
+
+	var ctx RequestCtx
+	ctx.Init(&Request{}, nil, nil)
+	ctx.Request.SetRequestURI("/fs.go")
+	ctx.Request.Header.Set("Range", "bytes=0-9")
+	h(&ctx)
+	defer ctx.Response.CloseBodyStream()
+
+	if ctx.Response.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", ctx.Response.StatusCode(), StatusOK)
+	}
+	if len(ctx.Response.Header.Peek("Accept-Ranges")) > 0 {
+		t.Fatalf("unexpected Accept-Ranges %q", ctx.Response.Header.Peek("Accept-Ranges"))
+	}
+	if len(ctx.Response.Header.Peek("ETag")) > 0 {
+		t.Fatalf("unexpected ETag %q", ctx.Response.Header.Peek("ETag"))
+	}
+}
+
+func TestFSByteRangeServer(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+	}
+	addr, stop := startTestServer(t, &Server{
+		Handler: fs.NewRequestHandler(),
+	})
+	defer stop()
+
+	expectedBody, err := ioutil.ReadFile("./fs.go")
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+
+	// Big file ranges must be sent via sendfile over real connection.
+	c := &HostClient{
+		Addr: addr,
+	}
+	var req Request
+	var resp Response
+	for i := 0; i < 3; i++ {
+		req.SetRequestURI("http://example.com/fs.go")
+		req.Header.Set("Range", "bytes=1000-20000")
+		if err := c.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.StatusCode() != StatusPartialContent {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+		}
+		if !bytes.Equal(resp.Body(), expectedBody[1000:20001]) {
+			t.Fatalf("unexpected body with length %d. Expecting length %d", len(resp.Body()), 19001)
+		}
+
+		// The whole file must be sent after the byte range request.
+		req.Header.Del("Range")
+		if err := c.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+		}
+		if !bytes.Equal(resp.Body(), expectedBody) {
+			t.Fatalf("unexpected body with length %d. Expecting length %d", len(resp.Body()), len(expectedBody))
+		}
+	}
+}
+
+func TestMatchETags(t *testing.T) {
+	testMatchETags(t, `"foo"`, `"foo"`, true, true)
+	testMatchETags(t, `"foo"`, `"foo"`, false, true)
+	testMatchETags(t, `"bar", "foo"`, `"foo"`, true, true)
+	testMatchETags(t, `"bar","baz"`, `"foo"`, false, false)
+	testMatchETags(t, `*`, `"foo"`, true, true)
+	testMatchETags(t, `*`, ``, true, true)
+	testMatchETags(t, `"foo"`, ``, false, false)
+	testMatchETags(t, `"a,b"`, `"a,b"`, true, true)
+	testMatchETags(t, `"a,b"`, `"a"`, true, false)
+
+	// Weak comparison.
+	testMatchETags(t, `W/"foo"`, `"foo"`, false, true)
+	testMatchETags(t, `"foo"`, `W/"foo"`, false, true)
+	testMatchETags(t, `W/"foo"`, `W/"foo"`, false, true)
+
+	// Strong comparison.
+	testMatchETags(t, `W/"foo"`, `"foo"`, true, false)
+	testMatchETags(t, `"foo"`, `W/"foo"`, true, false)
+	testMatchETags(t, `W/"foo"`, `W/"foo"`, true, false)
+
+	// Malformed lists.
+	testMatchETags(t, `foo`, `"foo"`, false, false)
+	testMatchETags(t, `"foo`, `"foo"`, false, false)
+}
+
+func testMatchETags(t *testing.T, list, etag string, strong, expectedResult bool) {
+	if result := matchETags([]byte(list), []byte(etag), strong); result != expectedResult {
+		t.Fatalf("unexpected result %v for list=%q, etag=%q, strong=%v. Expecting %v", result, list, etag, strong, expectedResult)
+	}
+}
+
+func TestFSETag(t *testing.T) {
+	fs := &FS{
+		Root:         ".",
+		GenerateETag: true,
+	}
+	h := fs.NewRequestHandler()
+
+	fi, err := os.Stat("./fs.go")
+	if err != nil {
+		t.Fatalf("cannot stat file: %s", err)
+	}
+	expectedETag := fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+	lastModified := string(AppendHTTPDate(nil, fi.ModTime()))
+	before := string(AppendHTTPDate(nil, fi.ModTime().Add(-time.Hour)))
+
+	resp := testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK)
+	if string(resp.Header.Peek("ETag")) != expectedETag {
+		t.Fatalf("unexpected ETag %q. Expecting %q", resp.Header.Peek("ETag"), expectedETag)
+	}
+
+	// If-None-Match uses weak comparison.
+	resp = testFSConditionalRequest(t, h, "GET", "/fs.go", StatusNotModified, "If-None-Match", expectedETag)
+	if string(resp.Header.Peek("ETag")) != expectedETag {
+		t.Fatalf("unexpected ETag %q in 304 response. Expecting %q", resp.Header.Peek("ETag"), expectedETag)
+	}
+	testFSConditionalRequest(t, h, "HEAD", "/fs.go", StatusNotModified, "If-None-Match", `"foo", `+expectedETag[2:])
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusNotModified, "If-None-Match", "*")
+	testFSConditionalRequest(t, h, "POST", "/fs.go", StatusPreconditionFailed, "If-None-Match", expectedETag)
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "If-None-Match", `"foo"`, "If-Modified-Since", lastModified)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusNotModified, "If-Modified-Since", lastModified)
+	testFSConditionalRequest(t, h, "POST", "/fs.go", StatusOK, "If-Modified-Since", lastModified)
+
+	// If-Match uses strong comparison, so weak ETags never match.
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusPreconditionFailed, "If-Match", expectedETag)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "If-Match", "*")
+
+	// If-Match takes precedence over If-Unmodified-Since.
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusPreconditionFailed, "If-Unmodified-Since", before)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "If-Unmodified-Since", lastModified)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "If-Match", "*", "If-Unmodified-Since", before)
+}
+
+func TestFSETagContentHash(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		GenerateETag:    true,
+		ETagContentHash: true,
+		AcceptByteRange: true,
+	}
+	h := fs.NewRequestHandler()
+
+	resp := testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK)
+	etag := string(resp.Header.Peek("ETag"))
+	if len(etag) != 34 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		t.Fatalf("unexpected strong ETag %q", etag)
+	}
+
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "If-Match", etag)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusPreconditionFailed, "If-Match", `"foo"`)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusNotModified, "If-None-Match", "W/"+etag)
+
+	// If-Range with strong ETag.
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusPartialContent, "Range", "bytes=0-9", "If-Range", etag)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "Range", "bytes=0-9", "If-Range", "W/"+etag)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "Range", "bytes=0-9", "If-Range", `"foo"`)
+
+	// Generated directory index has ETag too.
+	fs = &FS{
+		Root:               ".",
+		GenerateIndexPages: true,
+		GenerateETag:       true,
+		ETagContentHash:    true,
+	}
+	h = fs.NewRequestHandler()
+	resp = testFSConditionalRequest(t, h, "GET", "/", StatusOK)
+	etag = string(resp.Header.Peek("ETag"))
+	if len(etag) == 0 {
+		t.Fatalf("missing ETag for directory index")
+	}
+	testFSConditionalRequest(t, h, "GET", "/", StatusNotModified, "If-None-Match", etag)
+}
+
+func TestFSETagCompress(t *testing.T) {
+	fs := &FS{
+		Root:         ".",
+		Compress:     true,
+		GenerateETag: true,
+	}
+	h := fs.NewRequestHandler()
+
+	resp := testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK)
+	etag := string(resp.Header.Peek("ETag"))
+	resp = testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "Accept-Encoding", "gzip")
+	if string(resp.Header.Peek("Content-Encoding")) != "gzip" {
+		t.Fatalf("unexpected Content-Encoding %q. Expecting %q", resp.Header.Peek("Content-Encoding"), "gzip")
+	}
+	compressedETag := string(resp.Header.Peek("ETag"))
+	if len(etag) == 0 || etag == compressedETag {
+		t.Fatalf("compressed file ETag %q must differ from uncompressed file ETag %q", compressedETag, etag)
+	}
+
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "Accept-Encoding", "gzip", "If-None-Match", etag)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusNotModified, "Accept-Encoding", "gzip", "If-None-Match", compressedETag)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusOK, "If-None-Match", compressedETag)
+	testFSConditionalRequest(t, h, "GET", "/fs.go", StatusNotModified, "If-None-Match", etag)
+}
+
+func testFSConditionalRequest(t *testing.T, h RequestHandler, method, filePath string, expectedStatusCode int, headers ...string) *Response {
+	var ctx RequestCtx
+	ctx.Init(&Request{}, nil, nil)
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(filePath)
+	for i := 0; i < len(headers); i += 2 {
+		ctx.Request.Header.Set(headers[i], headers[i+1])
+	}
+	h(&ctx)
+
+	var resp Response
+	resp.SkipBody = method == "HEAD"
+	s := ctx.Response.String()
+	if err := resp.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s. headers=%q", err, headers)
+	}
+	if resp.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d. method=%q, headers=%q", resp.StatusCode(), expectedStatusCode, method, headers)
+	}
+	return &resp
+}
+
+func TestFSFileSystem(t *testing.T) {
+	bigBody := createFixedBody(3 * maxSmallFileSize)
+	smallBody := []byte(strings.Repeat("small file ", 100))
+	modTime := time.Unix(1500000000, 0)
+	fs := &FS{
+		FileSystem: NewMemFS(map[string][]byte{
+			"/big.txt":             bigBody,
+			"/dir/small.txt":       smallBody,
+			"/index/index.html":    []byte("<html>index</html>"),
+			"/index/ignored.txt":   []byte("ignored"),
+			"/noindex/foobar.html": []byte("foobar"),
+		}, modTime),
+		IndexNames:         []string{"index.html"},
+		GenerateIndexPages: true,
+		Compress:           true,
+		AcceptByteRange:    true,
+		GenerateETag:       true,
+	}
+	h := fs.NewRequestHandler()
+
+	for i := 0; i < 3; i++ {
+		resp := testFSConditionalRequest(t, h, "GET", "/big.txt", StatusOK)
+		if !bytes.Equal(resp.Body(), bigBody) {
+			t.Fatalf("unexpected body for big file. len=%d. Expecting len=%d", len(resp.Body()), len(bigBody))
+		}
+		if string(resp.Header.ContentType()) != "text/plain; charset=utf-8" {
+			t.Fatalf("unexpected content-type %q", resp.Header.ContentType())
+		}
+		if string(resp.Header.Peek("Last-Modified")) != string(AppendHTTPDate(nil, modTime)) {
+			t.Fatalf("unexpected Last-Modified %q", resp.Header.Peek("Last-Modified"))
+		}
+
+		resp = testFSConditionalRequest(t, h, "GET", "/big.txt", StatusPartialContent, "Range", "bytes=10-19")
+		if !bytes.Equal(resp.Body(), bigBody[10:20]) {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), bigBody[10:20])
+		}
+
+		resp = testFSConditionalRequest(t, h, "GET", "/dir/small.txt", StatusOK)
+		if !bytes.Equal(resp.Body(), smallBody) {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), smallBody)
+		}
+
+		// Compressed files are cached in memory.
+		resp = testFSConditionalRequest(t, h, "GET", "/dir/small.txt", StatusOK, "Accept-Encoding", "gzip")
+		if string(resp.Header.Peek("Content-Encoding")) != "gzip" {
+			t.Fatalf("unexpected Content-Encoding %q. Expecting %q", resp.Header.Peek("Content-Encoding"), "gzip")
+		}
+		if string(resp.Header.ContentType()) != "text/plain; charset=utf-8" {
+			t.Fatalf("unexpected content-type %q", resp.Header.ContentType())
+		}
+		body, err := resp.BodyGunzip()
+		if err != nil {
+			t.Fatalf("cannot gunzip body: %s", err)
+		}
+		if !bytes.Equal(body, smallBody) {
+			t.Fatalf("unexpected body %q. Expecting %q", body, smallBody)
+		}
+
+		resp = testFSConditionalRequest(t, h, "GET", "/index", StatusOK)
+		if string(resp.Body()) != "<html>index</html>" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "<html>index</html>")
+		}
+
+		resp = testFSConditionalRequest(t, h, "GET", "/noindex/", StatusOK)
+		if !strings.Contains(string(resp.Body()), `<a href="/noindex/foobar.html" class="file">foobar.html</a>`) {
+			t.Fatalf("missing file in the generated index page: %q", resp.Body())
+		}
+
+		resp = testFSConditionalRequest(t, h, "GET", "/", StatusOK, "Accept-Encoding", "gzip")
+		if body, err = resp.BodyGunzip(); err != nil {
+			t.Fatalf("cannot gunzip body: %s", err)
+		}
+		if !strings.Contains(string(body), `<a href="/dir" class="dir">dir</a>`) {
+			t.Fatalf("missing directory in the generated index page: %q", body)
+		}
+
+		testFSConditionalRequest(t, h, "GET", "/non-existing.txt", StatusNotFound)
+		testFSConditionalRequest(t, h, "GET", "/dir/small.txt/foo", StatusNotFound)
+	}
+}
+
+func TestFSCompressedFileSuffixes(t *testing.T) {
+	jsBody := []byte(strings.Repeat("var foo = 'bar';\n", 100))
+	var gzBody bytes.Buffer
+	zw := gzip.NewWriter(&gzBody)
+	zw.Write(jsBody)
+	zw.Close()
+	fs := &FS{
+		FileSystem: NewMemFS(map[string][]byte{
+			"/app.js":               jsBody,
+			"/app.js.br":            []byte("brotli data"),
+			"/app.js.gz":            gzBody.Bytes(),
+			"/style.css":            []byte("body {}"),
+			"/dir/index.html":       []byte("<html></html>"),
+			"/dir/index.html.gz":    []byte("gzipped index"),
+			"/dir/unknown-file":     []byte("<html><body>foo</body></html>"),
+			"/dir/unknown-file.br":  []byte("brotli unknown file"),
+			"/dir/unknown-file.zst": []byte("zstd unknown file"),
+		}, time.Now()),
+		IndexNames: []string{"index.html"},
+		CompressedFileSuffixes: map[string]string{
+			"br":   ".br",
+			"gzip": ".gz",
+		},
+	}
+	h := fs.NewRequestHandler()
+	jsContentType := mime.TypeByExtension(".js")
+
+	for i := 0; i < 3; i++ {
+		testFSCompressedFileSuffixes(t, h, "/app.js", "gzip, deflate, br", "br", jsContentType, "brotli data")
+		testFSCompressedFileSuffixes(t, h, "/app.js", "br", "br", jsContentType, "brotli data")
+		testFSCompressedFileSuffixes(t, h, "/app.js", "gzip", "gzip", jsContentType, gzBody.String())
+		testFSCompressedFileSuffixes(t, h, "/app.js", "deflate", "", jsContentType, string(jsBody))
+		testFSCompressedFileSuffixes(t, h, "/app.js", "", "", jsContentType, string(jsBody))
+		testFSCompressedFileSuffixes(t, h, "/style.css", "gzip, br", "", "text/css; charset=utf-8", "body {}")
+		testFSCompressedFileSuffixes(t, h, "/dir", "gzip, br", "gzip", "text/html; charset=utf-8", "gzipped index")
+		testFSCompressedFileSuffixes(t, h, "/dir/unknown-file", "br", "br", "text/html; charset=utf-8", "brotli unknown file")
+		testFSCompressedFileSuffixes(t, h, "/dir/unknown-file", "zstd", "", "text/html; charset=utf-8", "<html><body>foo</body></html>")
+
+		// Precompressed files may be requested directly.
+		testFSCompressedFileSuffixes(t, h, "/app.js.br", "br", "", "", "brotli data")
+	}
+}
+
+func TestFSCompressedFileSuffixesStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-fs")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	modTime := time.Now().Add(-time.Hour)
+	testFSWriteFile(t, dir+"/fresh.txt", "fresh", modTime)
+	testFSWriteFile(t, dir+"/fresh.txt.br", "fresh br", modTime)
+	testFSWriteFile(t, dir+"/stale.txt", "stale", modTime)
+	testFSWriteFile(t, dir+"/stale.txt.br", "stale br", modTime.Add(-time.Second))
+
+	fs := &FS{
+		Root:                   dir,
+		CompressedFileSuffixes: map[string]string{"br": ".br"},
+	}
+	h := fs.NewRequestHandler()
+	testFSCompressedFileSuffixes(t, h, "/fresh.txt", "br", "br", "text/plain; charset=utf-8", "fresh br")
+	testFSCompressedFileSuffixes(t, h, "/stale.txt", "br", "", "text/plain; charset=utf-8", "stale")
+}
+
+func testFSCompressedFileSuffixes(t *testing.T, h RequestHandler, filePath, acceptEncoding, expectedEncoding, expectedContentType, expectedBody string) {
+	var headers []string
+	if len(acceptEncoding) > 0 {
+		headers = append(headers, "Accept-Encoding", acceptEncoding)
+	}
+	resp := testFSConditionalRequest(t, h, "GET", filePath, StatusOK, headers...)
+	if encoding := string(resp.Header.Peek("Content-Encoding")); encoding != expectedEncoding {
+		t.Fatalf("unexpected Content-Encoding %q for %q. Expecting %q. Accept-Encoding: %q", encoding, filePath, expectedEncoding, acceptEncoding)
+	}
+	if len(expectedContentType) > 0 && string(resp.Header.ContentType()) != expectedContentType {
+		t.Fatalf("unexpected content-type %q for %q. Expecting %q", resp.Header.ContentType(), filePath, expectedContentType)
+	}
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q for %q. Expecting %q. Accept-Encoding: %q", resp.Body(), filePath, expectedBody, acceptEncoding)
+	}
+}
+
+func TestFSCompressedCacheDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "fasthttp-fs-root")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+	cacheDir, err := ioutil.TempDir("", "fasthttp-fs-cache")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	body := strings.Repeat("compressible file contents ", 1000)
+	modTime := time.Now().Add(-time.Hour)
+	if err = os.Mkdir(root+"/dir", 0755); err != nil {
+		t.Fatalf("cannot create directory: %s", err)
+	}
+	testFSWriteFile(t, root+"/foo.txt", body, modTime)
+	testFSWriteFile(t, root+"/dir/bar.txt", body, modTime)
+
+	fs := &FS{
+		Root:               root,
+		Compress:           true,
+		CompressedCacheDir: cacheDir,
+	}
+	h := fs.NewRequestHandler()
+	for _, filePath := range []string{"/foo.txt", "/dir/bar.txt"} {
+		resp := testFSConditionalRequest(t, h, "GET", filePath, StatusOK, "Accept-Encoding", "gzip")
+		if string(resp.Header.Peek("Content-Encoding")) != "gzip" {
+			t.Fatalf("unexpected Content-Encoding %q. Expecting %q", resp.Header.Peek("Content-Encoding"), "gzip")
+		}
+		b, err := resp.BodyGunzip()
+		if err != nil {
+			t.Fatalf("cannot gunzip body: %s", err)
+		}
+		if string(b) != body {
+			t.Fatalf("unexpected body for %q", filePath)
+		}
+
+		fi, err := os.Stat(cacheDir + filePath + FSCompressedFileSuffix)
+		if err != nil {
+			t.Fatalf("cannot find compressed file in the cache dir: %s", err)
+		}
+		if !fi.ModTime().Equal(modTime) {
+			t.Fatalf("unexpected modification time of the compressed file: %s. Expecting %s", fi.ModTime(), modTime)
+		}
+		if _, err = os.Stat(root + filePath + FSCompressedFileSuffix); !os.IsNotExist(err) {
+			t.Fatalf("compressed file mustn't be saved in the root dir. err=%v", err)
+		}
+	}
+
+	// Compressed files are re-used by new handlers.
+	fs = &FS{
+		Root:               root,
+		Compress:           true,
+		CompressedCacheDir: cacheDir,
+	}
+	h = fs.NewRequestHandler()
+	testFSWriteFile(t, cacheDir+"/foo.txt"+FSCompressedFileSuffix, "", modTime)
+	resp := testFSConditionalRequest(t, h, "GET", "/foo.txt", StatusOK, "Accept-Encoding", "gzip")
+	if len(resp.Body()) != 0 {
+		t.Fatalf("unexpected non-empty body. Compressed file in the cache dir must be served")
+	}
+
+	// Stale compressed files are re-created.
+	testFSWriteFile(t, root+"/dir/bar.txt", body+"new", modTime.Add(time.Minute))
+	resp = testFSConditionalRequest(t, h, "GET", "/dir/bar.txt", StatusOK, "Accept-Encoding", "gzip")
+	b, err := resp.BodyGunzip()
+	if err != nil {
+		t.Fatalf("cannot gunzip body: %s", err)
+	}
+	if string(b) != body+"new" {
+		t.Fatalf("unexpected body. Stale compressed file must be re-created")
+	}
+}
+
+func TestFSCompressedCacheDirMaxSize(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "fasthttp-fs-cache")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	modTime := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		testFSWriteFile(t, fmt.Sprintf("%s/%d.txt%s", cacheDir, i, FSCompressedFileSuffix), "0123456789", modTime.Add(time.Duration(i)*time.Second))
+	}
+	testFSWriteFile(t, cacheDir+"/foo.txt", "not a compressed file", modTime)
+
+	// The oldest files are deleted on start.
+	d := newFSCompressedDir(cacheDir, 35)
+	testFSCompressedDirFiles(t, cacheDir, "2.txt.fasthttp.gz, 3.txt.fasthttp.gz, 4.txt.fasthttp.gz, foo.txt")
+
+	// Least recently accessed files are deleted.
+	f2 := d.add(filepath.Join(cacheDir, "2.txt"+FSCompressedFileSuffix), 10)
+	testFSWriteFile(t, cacheDir+"/5.txt"+FSCompressedFileSuffix, "0123456789", modTime)
+	f5 := d.add(filepath.Join(cacheDir, "5.txt"+FSCompressedFileSuffix), 10)
+	testFSCompressedDirFiles(t, cacheDir, "2.txt.fasthttp.gz, 4.txt.fasthttp.gz, 5.txt.fasthttp.gz, foo.txt")
+
+	// Referred files aren't deleted even if they exceed the limit.
+	testFSWriteFile(t, cacheDir+"/6.txt"+FSCompressedFileSuffix, strings.Repeat("x", 100), modTime)
+	d.add(filepath.Join(cacheDir, "6.txt"+FSCompressedFileSuffix), 100)
+	testFSCompressedDirFiles(t, cacheDir, "2.txt.fasthttp.gz, 5.txt.fasthttp.gz, 6.txt.fasthttp.gz, foo.txt")
+
+	// Excess files are deleted after they are released.
+	d.release(f2)
+	d.release(f5)
+	testFSCompressedDirFiles(t, cacheDir, "6.txt.fasthttp.gz, foo.txt")
+
+	d.remove(filepath.Join(cacheDir, "6.txt"+FSCompressedFileSuffix))
+	if d.size != 0 || len(d.files) != 0 {
+		t.Fatalf("unexpected size %d and files count %d after removing all the files", d.size, len(d.files))
+	}
+}
+
+func TestFSCompressedCacheDirMaxSizeBigFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "fasthttp-fs-root")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+	cacheDir, err := ioutil.TempDir("", "fasthttp-fs-cache")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	// Random numbers are compressible, while compressed files are big
+	// enough for re-opening them for each reader.
+	bodies := make(map[string]string)
+	rnd := rand.New(rand.NewSource(1))
+	for _, filePath := range []string{"/a.txt", "/b.txt"} {
+		var b []byte
+		for len(b) < 200*1024 {
+			b = strconv.AppendInt(b, rnd.Int63(), 10)
+			b = append(b, ' ')
+		}
+		bodies[filePath] = string(b)
+		testFSWriteFile(t, root+filePath, string(b), time.Now())
+	}
+
+	fs := &FS{
+		Root:                      root,
+		Compress:                  true,
+		CompressedCacheDir:        cacheDir,
+		CompressedCacheDirMaxSize: 1,
+	}
+	h := fs.NewRequestHandler()
+
+	for _, filePath := range []string{"/a.txt", "/b.txt"} {
+		resp := testFSConditionalRequest(t, h, "GET", filePath, StatusOK, "Accept-Encoding", "gzip")
+		testFSCompressedBigFileBody(t, resp, filePath, bodies[filePath])
+	}
+
+	// Compressed files of cached files mustn't be deleted, since they
+	// are re-opened for concurrent readers.
+	var ctxs [2]RequestCtx
+	for i := range ctxs {
+		ctx := &ctxs[i]
+		ctx.Init(&Request{}, nil, nil)
+		ctx.Request.SetRequestURI("/a.txt")
+		ctx.Request.Header.Set("Accept-Encoding", "gzip")
+		h(ctx)
+	}
+	for i := range ctxs {
+		var resp Response
+		if err := resp.Read(bufio.NewReader(bytes.NewBufferString(ctxs[i].Response.String()))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d. body=%q", resp.StatusCode(), StatusOK, resp.Body())
+		}
+		testFSCompressedBigFileBody(t, &resp, "/a.txt", bodies["/a.txt"])
+	}
+	testFSCompressedDirFiles(t, cacheDir, "a.txt.fasthttp.gz, b.txt.fasthttp.gz")
+}
+
+func testFSCompressedBigFileBody(t *testing.T, resp *Response, filePath, expectedBody string) {
+	if len(resp.Body()) <= maxSmallFileSize {
+		t.Fatalf("unexpected compressed body length %d for %q. Expecting more than %d", len(resp.Body()), filePath, maxSmallFileSize)
+	}
+	b, err := resp.BodyGunzip()
+	if err != nil {
+		t.Fatalf("cannot gunzip body for %q: %s", filePath, err)
+	}
+	if string(b) != expectedBody {
+		t.Fatalf("unexpected body for %q", filePath)
+	}
+}
+
+func testFSCompressedDirFiles(t *testing.T, dir, expectedFiles string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatalf("cannot open directory: %s", err)
+	}
+	names, err := f.Readdirnames(0)
+	f.Close()
+	if err != nil {
+		t.Fatalf("cannot read directory: %s", err)
+	}
+	sort.Strings(names)
+	if files := strings.Join(names, ", "); files != expectedFiles {
+		t.Fatalf("unexpected files %q. Expecting %q", files, expectedFiles)
+	}
+}
+
+func testFSWriteFile(t *testing.T, filePath, data string, modTime time.Time) {
+	if err := ioutil.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatalf("cannot write file %q: %s", filePath, err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatalf("cannot change modification time for %q: %s", filePath, err)
+	}
+}
+
+func TestFSCacheKeyAffected(t *testing.T) {
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "/foo/bar.txt", true)
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "/foo", true)
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "", true)
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "/foo/bar.txt.br", true)
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "/foo/baz.txt", false)
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "/fo", false)
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "/foo/bar", false)
+	testFSCacheKeyAffected(t, "/foo/bar.txt", "/foo/bar.txt.d/x", false)
+
+	// Directory index.
+	testFSCacheKeyAffected(t, "/foo", "/foo/bar.txt", true)
+	testFSCacheKeyAffected(t, "/foo", "/foo/index.html", true)
+	testFSCacheKeyAffected(t, "/foo", "/foo/bar/baz.txt", false)
+	testFSCacheKeyAffected(t, "", "/foo", true)
+	testFSCacheKeyAffected(t, "", "/foo/bar", false)
+	testFSCacheKeyAffected(t, "", "", true)
+}
+
+func testFSCacheKeyAffected(t *testing.T, key, path string, expectedResult bool) {
+	if result := fsCacheKeyAffected(key, path); result != expectedResult {
+		t.Fatalf("unexpected result %v for key=%q, path=%q. Expecting %v", result, key, path, expectedResult)
+	}
+}
@@ -0,0 +1,182 @@
+// +build linux
+
+package fasthttp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// fsWatcher tracks changes in the directory tree via inotify.
+type fsWatcher struct {
+	fd       int
+	f        *os.File
+	root     string
+	onChange func(path string)
+
+	lock   sync.Mutex
+	paths  map[int]string
+	closed bool
+}
+
+const fsWatcherMask = syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// newFSWatcher starts watching the directory tree under root.
+//
+// onChange is called with slash-separated path relative to root
+// for each changed file or directory, for instance "/foo/bar.html".
+// onChange is called with empty path if changes may be lost.
+func newFSWatcher(root string, onChange func(path string)) (*fsWatcher, error) {
+	// Non-blocking descriptor is read via runtime poller,
+	// so pending read is interrupted by close.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &fsWatcher{
+		fd:       fd,
+		f:        os.NewFile(uintptr(fd), "inotify"),
+		root:     root,
+		onChange: onChange,
+		paths:    make(map[int]string),
+	}
+	if err = w.addTree(""); err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// close stops watching changes. Pending onChange calls may still run
+// after close returns.
+func (w *fsWatcher) close() {
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		w.f.Close()
+	}
+	w.lock.Unlock()
+}
+
+// addTree adds watches for the directory with the given path
+// and for all its subdirectories.
+func (w *fsWatcher) addTree(path string) error {
+	dir := filepath.Join(w.root, filepath.FromSlash(path))
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			// Skip inaccessible subdirectories.
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(w.root, p)
+		if err != nil {
+			return err
+		}
+		rel = "/" + filepath.ToSlash(rel)
+		if rel == "/." {
+			rel = ""
+		}
+
+		// The lock guards w.fd against re-use after close.
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if w.closed {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, fsWatcherMask)
+		if err != nil {
+			if p == dir {
+				return os.NewSyscallError("inotify_add_watch", err)
+			}
+			return nil
+		}
+		w.paths[wd] = rel
+		return nil
+	})
+}
+
+// removeTree removes watches for the directory with the given path
+// and for all its subdirectories.
+func (w *fsWatcher) removeTree(path string) {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return
+	}
+	for wd, p := range w.paths {
+		if p == path || (len(p) > len(path) && p[:len(path)] == path && p[len(path)] == '/') {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.paths, wd)
+		}
+	}
+	w.lock.Unlock()
+}
+
+func (w *fsWatcher) run() {
+	var buf [64 * 1024]byte
+	for {
+		n, err := w.f.Read(buf[:])
+		if err != nil {
+			// The watcher has been closed.
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(e.Len)
+			if offset > n {
+				break
+			}
+			name := buf[nameStart:offset]
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			w.handleEvent(int(e.Wd), e.Mask, string(name))
+		}
+	}
+}
+
+func (w *fsWatcher) handleEvent(wd int, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.onChange("")
+		return
+	}
+
+	w.lock.Lock()
+	dir, ok := w.paths[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		// The watch has been removed, since the directory
+		// has been deleted or moved.
+		delete(w.paths, wd)
+		ok = false
+	}
+	w.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	path := dir
+	if len(name) > 0 {
+		path += "/" + name
+	}
+	if mask&syscall.IN_ISDIR != 0 {
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			// Errors are ignored, since the directory may be already removed.
+			w.addTree(path)
+		} else if mask&syscall.IN_MOVED_FROM != 0 {
+			w.removeTree(path)
+		}
+	}
+	w.onChange(path)
+}
@@ -0,0 +1,210 @@
+// +build linux
+
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFSWatchChanges(t *testing.T) {
+	root, err := ioutil.TempDir("", "fasthttp-fs-watch")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	testFSWriteFile(t, root+"/foo.txt", "foo", time.Now())
+
+	fs := &FS{
+		Root:               root,
+		GenerateIndexPages: true,
+		WatchChanges:       true,
+		CacheDuration:      time.Hour,
+	}
+	h := fs.NewRequestHandler()
+
+	testFSWatchChangesBody(t, h, "/foo.txt", StatusOK, "foo")
+	testFSWatchChangesBody(t, h, "/foo.txt", StatusOK, "foo")
+
+	// Modified file. The file grows, so it is safe modifying it in place
+	// while it is cached.
+	testFSWriteFile(t, root+"/foo.txt", "modified foo", time.Now())
+	testFSWatchChangesBody(t, h, "/foo.txt", StatusOK, "modified foo")
+
+	// File replaced via rename.
+	testFSReplaceFile(t, root+"/foo.txt", "renamed foo")
+	testFSWatchChangesBody(t, h, "/foo.txt", StatusOK, "renamed foo")
+
+	// Files in new directories are watched too.
+	if err = os.MkdirAll(root+"/dir/subdir", 0755); err != nil {
+		t.Fatalf("cannot create directory: %s", err)
+	}
+	testFSWatchChangesBody(t, h, "/", StatusOK, `<a href="/dir" class="dir">dir</a>`)
+	testFSWriteFile(t, root+"/dir/subdir/bar.txt", "bar", time.Now())
+	testFSWatchChangesBody(t, h, "/dir/subdir/bar.txt", StatusOK, "bar")
+	testFSReplaceFile(t, root+"/dir/subdir/bar.txt", "modified bar")
+	testFSWatchChangesBody(t, h, "/dir/subdir/bar.txt", StatusOK, "modified bar")
+
+	// Removed file.
+	if err = os.Remove(root + "/foo.txt"); err != nil {
+		t.Fatalf("cannot remove file: %s", err)
+	}
+	testFSWatchChangesBody(t, h, "/foo.txt", StatusNotFound, "")
+
+	// Moved directory.
+	if err = os.Rename(root+"/dir", root+"/newdir"); err != nil {
+		t.Fatalf("cannot rename directory: %s", err)
+	}
+	testFSWatchChangesBody(t, h, "/dir/subdir/bar.txt", StatusNotFound, "")
+	testFSWatchChangesBody(t, h, "/newdir/subdir/bar.txt", StatusOK, "modified bar")
+	testFSReplaceFile(t, root+"/newdir/subdir/bar.txt", "moved bar")
+	testFSWatchChangesBody(t, h, "/newdir/subdir/bar.txt", StatusOK, "moved bar")
+}
+
+func TestFSWatchChangesCompress(t *testing.T) {
+	root, err := ioutil.TempDir("", "fasthttp-fs-watch")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	body := strings.Repeat("compressible file contents ", 1000)
+	modTime := time.Now().Add(-time.Hour)
+	testFSWriteFile(t, root+"/foo.txt", body, modTime)
+
+	fs := &FS{
+		Root:          root,
+		Compress:      true,
+		WatchChanges:  true,
+		CacheDuration: time.Hour,
+	}
+	h := fs.NewRequestHandler()
+	testFSConditionalRequest(t, h, "GET", "/foo.txt", StatusOK, "Accept-Encoding", "gzip")
+
+	// The compressed file is re-created without requests.
+	newModTime := modTime.Add(time.Minute)
+	testFSWriteFile(t, root+"/foo.txt", body+"new", newModTime)
+	compressedFilePath := root + "/foo.txt" + FSCompressedFileSuffix
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fi, err := os.Stat(compressedFilePath)
+		if err == nil && fi.ModTime().Equal(newModTime) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("compressed file hasn't been re-created. err=%v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp := testFSConditionalRequest(t, h, "GET", "/foo.txt", StatusOK, "Accept-Encoding", "gzip")
+	b, err := resp.BodyGunzip()
+	if err != nil {
+		t.Fatalf("cannot gunzip body: %s", err)
+	}
+	if string(b) != body+"new" {
+		t.Fatalf("unexpected body. Expecting the modified file contents")
+	}
+}
+
+func TestFSWatchChangesClose(t *testing.T) {
+	root, err := ioutil.TempDir("", "fasthttp-fs-watch")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	testFSWriteFile(t, root+"/foo.txt", "foo", time.Now())
+
+	fdsCount := testFSOpenFilesCount(t)
+	for i := 0; i < 10; i++ {
+		fs := &FS{
+			Root:          root,
+			Compress:      true,
+			WatchChanges:  true,
+			CacheDuration: time.Hour,
+		}
+		fs.NewRequestHandler()
+		if err = fs.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err = fs.Close(); err != nil {
+			t.Fatalf("unexpected error on the second close: %s", err)
+		}
+	}
+	// Descriptors are closed after pending reads are interrupted.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n := testFSOpenFilesCount(t)
+		if n <= fdsCount {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected number of open files after closing watchers: %d. Expecting %d", n, fdsCount)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Changes aren't tracked after Close, while the handler serves
+	// cached files.
+	fs := &FS{
+		Root:          root,
+		WatchChanges:  true,
+		CacheDuration: time.Hour,
+	}
+	h := fs.NewRequestHandler()
+	testFSWatchChangesBody(t, h, "/foo.txt", StatusOK, "foo")
+	fs.Close()
+	testFSReplaceFile(t, root+"/foo.txt", "modified foo")
+	time.Sleep(100 * time.Millisecond)
+	testFSWatchChangesBody(t, h, "/foo.txt", StatusOK, "foo")
+}
+
+func testFSOpenFilesCount(t *testing.T) int {
+	fis, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("cannot read open files: %s", err)
+	}
+	return len(fis)
+}
+
+// testFSReplaceFile atomically replaces the file with the given path.
+func testFSReplaceFile(t *testing.T, filePath, data string) {
+	tmpFilePath := filePath + ".tmp"
+	testFSWriteFile(t, tmpFilePath, data, time.Now())
+	if err := os.Rename(tmpFilePath, filePath); err != nil {
+		t.Fatalf("cannot rename %q to %q: %s", tmpFilePath, filePath, err)
+	}
+}
+
+// testFSWatchChangesBody waits until the response for the given path
+// has the expected status code and contains the expected body.
+func testFSWatchChangesBody(t *testing.T, h RequestHandler, path string, expectedStatusCode int, expectedBody string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var ctx RequestCtx
+		ctx.Init(&Request{}, nil, nil)
+		ctx.Request.SetRequestURI(path)
+		h(&ctx)
+
+		var resp Response
+		s := ctx.Response.String()
+		if err := resp.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+			t.Fatalf("unexpected error: %s. path=%q, response=%q", err, path, s)
+		}
+		if resp.StatusCode() == expectedStatusCode && strings.Contains(string(resp.Body()), expectedBody) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected response for %q: status code %d, body %q. Expecting status code %d, body %q",
+				path, resp.StatusCode(), resp.Body(), expectedStatusCode, expectedBody)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
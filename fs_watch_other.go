@@ -0,0 +1,19 @@
+// +build !linux
+
+package fasthttp
+
+import (
+	"errors"
+)
+
+// fsWatcher is unsupported on this platform, so FS falls back
+// to FS.CacheDuration expiry.
+type fsWatcher struct{}
+
+var errFSWatcherUnsupported = errors.New("watching filesystem changes is unsupported on this platform")
+
+func newFSWatcher(root string, onChange func(path string)) (*fsWatcher, error) {
+	return nil, errFSWatcherUnsupported
+}
+
+func (w *fsWatcher) close() {}
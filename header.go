@@ -33,6 +33,10 @@ type ResponseHeader struct {
 	bufKV argsKV
 
 	cookies []argsKV
+
+	// connectTunnel is set by the server when writing the response
+	// to CONNECT request.
+	connectTunnel bool
 }
 
 // RequestHeader represents HTTP request header.
@@ -76,6 +80,22 @@ func (h *ResponseHeader) SetStatusCode(statusCode int) {
 	h.statusCode = statusCode
 }
 
+// SetContentRange sets 'Content-Range: bytes startPos-endPos/contentLength'
+// header.
+func (h *ResponseHeader) SetContentRange(startPos, endPos, contentLength int) {
+	b := h.bufKV.value[:0]
+	b = append(b, strBytes...)
+	b = append(b, ' ')
+	b = AppendUint(b, startPos)
+	b = append(b, '-')
+	b = AppendUint(b, endPos)
+	b = append(b, '/')
+	b = AppendUint(b, contentLength)
+	h.bufKV.value = b
+
+	h.SetCanonical(strContentRange, h.bufKV.value)
+}
+
 // SetLastModified sets 'Last-Modified' header to the given value.
 func (h *ResponseHeader) SetLastModified(t time.Time) {
 	h.bufKV.value = AppendHTTPDate(h.bufKV.value[:0], t)
@@ -348,12 +368,14 @@ func (h *RequestHeader) Method() []byte {
 
 // SetMethod sets HTTP request method.
 func (h *RequestHeader) SetMethod(method string) {
-	h.method = append(h.method, method...)
+	h.method = append(h.method[:0], method...)
+	h.isGet = false
 }
 
-// SetMethod sets HTTP request method.
+// SetMethodBytes sets HTTP request method.
 func (h *RequestHeader) SetMethodBytes(method []byte) {
 	h.method = append(h.method[:0], method...)
+	h.isGet = false
 }
 
 // RequestURI returns RequestURI from the first HTTP request line.
@@ -403,6 +425,11 @@ func (h *RequestHeader) IsHead() bool {
 	return bytes.Equal(h.Method(), strHead)
 }
 
+// IsConnect returns true if request method is CONNECT.
+func (h *RequestHeader) IsConnect() bool {
+	return bytes.Equal(h.Method(), strConnect)
+}
+
 // IsHTTP11 returns true if the request is HTTP/1.1.
 func (h *RequestHeader) IsHTTP11() bool {
 	return !h.noHTTP11
@@ -716,6 +743,13 @@ func (h *RequestHeader) SetCookieBytesKV(key, value []byte) {
 	h.cookies = setArg(h.cookies, key, value)
 }
 
+// DelAllCookies removes all the cookies from request headers.
+func (h *RequestHeader) DelAllCookies() {
+	h.parseRawHeaders()
+	h.collectCookies()
+	h.cookies = h.cookies[:0]
+}
+
 // Set sets the given 'key: value' header.
 func (h *RequestHeader) Set(key, value string) {
 	initHeaderKV(&h.bufKV, key, value)
@@ -1017,14 +1051,23 @@ func (h *ResponseHeader) AppendBytes(dst []byte) []byte {
 	dst = appendHeaderLine(dst, strServer, server)
 	dst = appendHeaderLine(dst, strDate, serverDate.Load().([]byte))
 
-	dst = appendHeaderLine(dst, strContentType, h.ContentType())
+	// 2xx response to CONNECT request switches the connection to tunnel mode,
+	// so it mustn't contain Content-Length and Transfer-Encoding headers.
+	// See https://tools.ietf.org/html/rfc7231#section-4.3.6 .
+	isTunnel := h.connectTunnel && statusCode >= 200 && statusCode < 300
+	if !isTunnel {
+		dst = appendHeaderLine(dst, strContentType, h.ContentType())
 
-	if len(h.contentLengthBytes) > 0 {
-		dst = appendHeaderLine(dst, strContentLength, h.contentLengthBytes)
+		if len(h.contentLengthBytes) > 0 {
+			dst = appendHeaderLine(dst, strContentLength, h.contentLengthBytes)
+		}
 	}
 
 	for i, n := 0, len(h.h); i < n; i++ {
 		kv := &h.h[i]
+		if isTunnel && bytes.Equal(kv.key, strTransferEncoding) {
+			continue
+		}
 		dst = appendHeaderLine(dst, kv.key, kv.value)
 	}
 
@@ -1152,7 +1195,7 @@ func (h *ResponseHeader) parse(buf []byte) (int, error) {
 }
 
 func (h *RequestHeader) noBody() bool {
-	return h.IsGet() || h.IsHead()
+	return h.IsGet() || h.IsHead() || h.IsConnect()
 }
 
 func (h *RequestHeader) parse(buf []byte) (int, error) {
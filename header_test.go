@@ -193,6 +193,28 @@ func testPeekRawHeader(t *testing.T, rawHeaders, key string, expectedValue strin
 	}
 }
 
+func TestResponseHeaderConnectTunnel(t *testing.T) {
+	var h ResponseHeader
+	h.connectTunnel = true
+	h.SetContentLength(-1)
+	h.Set("X-Foo", "bar")
+	s := string(h.Header())
+	if strings.Contains(s, "Content-Length") || strings.Contains(s, "Transfer-Encoding") || strings.Contains(s, "Content-Type") {
+		t.Fatalf("unexpected content headers in 2xx response to CONNECT: %q", s)
+	}
+	if !strings.Contains(s, "X-Foo: bar\r\n") {
+		t.Fatalf("missing X-Foo header in %q", s)
+	}
+
+	// Non-2xx responses to CONNECT may contain body.
+	h.SetStatusCode(StatusForbidden)
+	h.SetContentLength(3)
+	s = string(h.Header())
+	if !strings.Contains(s, "Content-Length: 3\r\n") {
+		t.Fatalf("missing Content-Length header in %q", s)
+	}
+}
+
 func TestResponseHeaderFirstByteReadEOF(t *testing.T) {
 	var h ResponseHeader
 
@@ -243,6 +265,29 @@ func TestRequestHeaderEmptyMethod(t *testing.T) {
 	}
 }
 
+func TestRequestHeaderSetMethod(t *testing.T) {
+	var h RequestHeader
+
+	if !h.IsGet() {
+		t.Fatalf("empty method must be equivalent to GET")
+	}
+	h.SetMethod("POST")
+	if string(h.Method()) != "POST" {
+		t.Fatalf("unexpected method %q. Expecting %q", h.Method(), "POST")
+	}
+	if h.IsGet() {
+		t.Fatalf("POST method cannot be GET")
+	}
+	h.SetMethodBytes([]byte("HEAD"))
+	if !h.IsHead() {
+		t.Fatalf("unexpected method %q. Expecting %q", h.Method(), "HEAD")
+	}
+	h.SetMethod("GET")
+	if !h.IsGet() {
+		t.Fatalf("unexpected method %q. Expecting %q", h.Method(), "GET")
+	}
+}
+
 func TestResponseHeaderHTTPVer(t *testing.T) {
 	// non-http/1.1
 	testResponseHeaderHTTPVer(t, "HTTP/1.0 200 OK\r\nContent-Type: aaa\r\nContent-Length: 123\r\n\r\n", true)
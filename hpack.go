@@ -0,0 +1,380 @@
+package fasthttp
+
+import (
+	"bytes"
+	"errors"
+)
+
+// HPACK header compression for HTTP/2.
+// See https://tools.ietf.org/html/rfc7541 .
+
+const hpackDefaultTableSize = 4096
+
+var (
+	errHPACKIntegerOverflow  = errors.New("hpack: integer overflow")
+	errHPACKTruncated        = errors.New("hpack: truncated header block")
+	errHPACKInvalidIndex     = errors.New("hpack: invalid table index")
+	errHPACKInvalidHuffman   = errors.New("hpack: invalid huffman-encoded data")
+	errHPACKTableSizeUpdate  = errors.New("hpack: invalid dynamic table size update")
+	errHPACKHeaderListTooBig = errors.New("hpack: header list too big")
+)
+
+// hpackDecoder decodes HPACK header blocks.
+//
+// hpackDecoder maintains the dynamic table, so a single decoder
+// must be used for all the header blocks received on a connection.
+type hpackDecoder struct {
+	// maxTableSize is the upper limit for the dynamic table size
+	// advertised to the peer via SETTINGS_HEADER_TABLE_SIZE.
+	maxTableSize int
+
+	// maxHeaderListSize limits the size of a decoded header list.
+	// Zero means no limit.
+	maxHeaderListSize int
+
+	// dynamic table entries. The most recently added entry is the last one.
+	table     []argsKV
+	tableSize int
+	tableMax  int
+
+	name  []byte
+	value []byte
+}
+
+// setMaxTableSize sets the upper limit for the dynamic table size.
+func (d *hpackDecoder) setMaxTableSize(n int) {
+	d.maxTableSize = n
+	d.tableMax = n
+	d.evict(0)
+}
+
+// decode decodes header block b and calls f for each decoded header field.
+//
+// name and value passed to f are valid only until f returns.
+//
+// errHPACKHeaderListTooBig is returned if the decoded header list exceeds
+// maxHeaderListSize. The whole block is decoded anyway in order to keep
+// the dynamic table in sync with the encoder.
+func (d *hpackDecoder) decode(b []byte, f func(name, value []byte) error) error {
+	headerListSize := 0
+	tooBig := false
+	canUpdateSize := true
+	for len(b) > 0 {
+		c := b[0]
+		var err error
+		switch {
+		case c&0x80 != 0:
+			// Indexed header field.
+			var idx uint64
+			if idx, b, err = hpackReadInt(b, 7); err != nil {
+				return err
+			}
+			kv := d.at(idx)
+			if kv == nil {
+				return errHPACKInvalidIndex
+			}
+			d.name = append(d.name[:0], kv.key...)
+			d.value = append(d.value[:0], kv.value...)
+		case c&0xc0 == 0x40:
+			// Literal header field with incremental indexing.
+			if b, err = d.readLiteral(b, 6); err != nil {
+				return err
+			}
+			d.add(d.name, d.value)
+		case c&0xe0 == 0x20:
+			// Dynamic table size update.
+			if !canUpdateSize {
+				return errHPACKTableSizeUpdate
+			}
+			var n uint64
+			if n, b, err = hpackReadInt(b, 5); err != nil {
+				return err
+			}
+			if n > uint64(d.maxTableSize) {
+				return errHPACKTableSizeUpdate
+			}
+			d.tableMax = int(n)
+			d.evict(0)
+			continue
+		default:
+			// Literal header field without indexing or never indexed.
+			if b, err = d.readLiteral(b, 4); err != nil {
+				return err
+			}
+		}
+		canUpdateSize = false
+
+		headerListSize += len(d.name) + len(d.value) + 32
+		if d.maxHeaderListSize > 0 && headerListSize > d.maxHeaderListSize {
+			tooBig = true
+		}
+		if tooBig {
+			continue
+		}
+		if err = f(d.name, d.value); err != nil {
+			return err
+		}
+	}
+	if tooBig {
+		return errHPACKHeaderListTooBig
+	}
+	return nil
+}
+
+func (d *hpackDecoder) readLiteral(b []byte, prefixBits uint) ([]byte, error) {
+	idx, b, err := hpackReadInt(b, prefixBits)
+	if err != nil {
+		return b, err
+	}
+	if idx == 0 {
+		if d.name, b, err = hpackReadString(d.name[:0], b); err != nil {
+			return b, err
+		}
+	} else {
+		kv := d.at(idx)
+		if kv == nil {
+			return b, errHPACKInvalidIndex
+		}
+		d.name = append(d.name[:0], kv.key...)
+	}
+	d.value, b, err = hpackReadString(d.value[:0], b)
+	return b, err
+}
+
+func (d *hpackDecoder) at(idx uint64) *argsKV {
+	if idx == 0 {
+		return nil
+	}
+	if idx <= uint64(len(hpackStaticTable)) {
+		return &hpackStaticTable[idx-1]
+	}
+	idx -= uint64(len(hpackStaticTable))
+	n := uint64(len(d.table))
+	if idx > n {
+		return nil
+	}
+	return &d.table[n-idx]
+}
+
+func (d *hpackDecoder) add(name, value []byte) {
+	size := len(name) + len(value) + 32
+	if size > d.tableMax {
+		// An entry bigger than the table empties the table.
+		d.evict(d.tableMax)
+		return
+	}
+	d.evict(size)
+	var kv *argsKV
+	d.table, kv = allocArg(d.table)
+	kv.key = append(kv.key[:0], name...)
+	kv.value = append(kv.value[:0], value...)
+	d.tableSize += size
+}
+
+// evict removes the oldest dynamic table entries until there is
+// a room for the entry with the given size.
+func (d *hpackDecoder) evict(size int) {
+	for len(d.table) > 0 && d.tableSize+size > d.tableMax {
+		kv := d.table[0]
+		d.tableSize -= len(kv.key) + len(kv.value) + 32
+		copy(d.table, d.table[1:])
+		d.table[len(d.table)-1] = kv
+		d.table = d.table[:len(d.table)-1]
+	}
+}
+
+func hpackReadInt(b []byte, prefixBits uint) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, b, errHPACKTruncated
+	}
+	mask := uint64(1)<<prefixBits - 1
+	n := uint64(b[0]) & mask
+	b = b[1:]
+	if n < mask {
+		return n, b, nil
+	}
+	var shift uint
+	for len(b) > 0 {
+		c := b[0]
+		b = b[1:]
+		n += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return n, b, nil
+		}
+		shift += 7
+		if shift >= 63 {
+			return 0, b, errHPACKIntegerOverflow
+		}
+	}
+	return 0, b, errHPACKTruncated
+}
+
+func hpackReadString(dst, b []byte) ([]byte, []byte, error) {
+	if len(b) == 0 {
+		return dst, b, errHPACKTruncated
+	}
+	huffman := b[0]&0x80 != 0
+	n, b, err := hpackReadInt(b, 7)
+	if err != nil {
+		return dst, b, err
+	}
+	if uint64(len(b)) < n {
+		return dst, b, errHPACKTruncated
+	}
+	s := b[:n]
+	b = b[n:]
+	if huffman {
+		dst, err = hpackAppendHuffmanDecode(dst, s)
+		return dst, b, err
+	}
+	return append(dst, s...), b, nil
+}
+
+// hpackAppendHeader appends HPACK-encoded header field to dst.
+//
+// The encoder never adds entries to the dynamic table, so it doesn't
+// need per-connection state.
+func hpackAppendHeader(dst, name, value []byte) []byte {
+	if idxs := hpackStaticIndex[string(name)]; len(idxs) > 0 {
+		for _, idx := range idxs {
+			if bytes.Equal(hpackStaticTable[idx-1].value, value) {
+				return hpackAppendInt(dst, 0x80, 7, uint64(idx))
+			}
+		}
+		dst = hpackAppendInt(dst, 0, 4, uint64(idxs[0]))
+	} else {
+		dst = append(dst, 0)
+		dst = hpackAppendString(dst, name)
+	}
+	return hpackAppendString(dst, value)
+}
+
+func hpackAppendInt(dst []byte, flags byte, prefixBits uint, n uint64) []byte {
+	mask := uint64(1)<<prefixBits - 1
+	if n < mask {
+		return append(dst, flags|byte(n))
+	}
+	dst = append(dst, flags|byte(mask))
+	n -= mask
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(dst, byte(n))
+}
+
+func hpackAppendString(dst, s []byte) []byte {
+	n := hpackHuffmanEncodedLen(s)
+	if n < len(s) {
+		dst = hpackAppendInt(dst, 0x80, 7, uint64(n))
+		return hpackAppendHuffmanEncode(dst, s)
+	}
+	dst = hpackAppendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func hpackHuffmanEncodedLen(s []byte) int {
+	n := 0
+	for _, c := range s {
+		n += int(hpackHuffmanCodeLens[c])
+	}
+	return (n + 7) / 8
+}
+
+func hpackAppendHuffmanEncode(dst, s []byte) []byte {
+	var x uint64
+	var bits uint
+	for _, c := range s {
+		n := uint(hpackHuffmanCodeLens[c])
+		x = x<<n | uint64(hpackHuffmanCodes[c])
+		bits += n
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(x>>bits))
+		}
+	}
+	if bits > 0 {
+		// Pad with the most significant bits of EOS, i.e. with ones.
+		x = x<<(8-bits) | (1<<(8-bits) - 1)
+		dst = append(dst, byte(x))
+	}
+	return dst
+}
+
+func hpackAppendHuffmanDecode(dst, s []byte) ([]byte, error) {
+	node := 0
+	depth := 0
+	allOnes := true
+	for _, c := range s {
+		for i := 7; i >= 0; i-- {
+			bit := (c >> uint(i)) & 1
+			if bit == 0 {
+				allOnes = false
+			}
+			next := hpackHuffmanTree[node][bit]
+			depth++
+			if next == 0 {
+				return dst, errHPACKInvalidHuffman
+			}
+			if next < 0 {
+				sym := -next - 1
+				if sym == 256 {
+					// EOS mustn't appear in the encoded string.
+					return dst, errHPACKInvalidHuffman
+				}
+				dst = append(dst, byte(sym))
+				node = 0
+				depth = 0
+				allOnes = true
+				continue
+			}
+			node = int(next)
+		}
+	}
+	// The padding must be shorter than 8 bits and consist of EOS prefix.
+	if depth > 7 || !allOnes {
+		return dst, errHPACKInvalidHuffman
+	}
+	return dst, nil
+}
+
+// hpackHuffmanTree is a binary tree for decoding Huffman-encoded strings.
+//
+// Positive values point to the next node, negative values contain
+// -(symbol+1) for leaves, zero means invalid code.
+var hpackHuffmanTree = func() [][2]int16 {
+	tree := make([][2]int16, 1, 256)
+	addSym := func(code uint32, n uint8, sym int) {
+		node := 0
+		for i := int(n) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if i == 0 {
+				tree[node][bit] = int16(-sym - 1)
+				return
+			}
+			next := tree[node][bit]
+			if next == 0 {
+				tree = append(tree, [2]int16{})
+				next = int16(len(tree) - 1)
+				tree[node][bit] = next
+			}
+			node = int(next)
+		}
+	}
+	for i := 0; i < 256; i++ {
+		addSym(hpackHuffmanCodes[i], hpackHuffmanCodeLens[i], i)
+	}
+	// EOS
+	addSym(0x3fffffff, 30, 256)
+	return tree
+}()
+
+// hpackStaticIndex maps header names to hpackStaticTable indexes.
+var hpackStaticIndex = func() map[string][]int {
+	m := make(map[string][]int, len(hpackStaticTable))
+	for i := range hpackStaticTable {
+		k := string(hpackStaticTable[i].key)
+		m[k] = append(m[k], i+1)
+	}
+	return m
+}()
@@ -0,0 +1,156 @@
+package fasthttp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHPACKDecodeRFCRequests(t *testing.T) {
+	// See https://tools.ietf.org/html/rfc7541#appendix-C.4
+	var d hpackDecoder
+	d.setMaxTableSize(hpackDefaultTableSize)
+
+	testHPACKDecode(t, &d, "828684418cf1e3c2e5f23a6ba0ab90f4ff",
+		":method: GET\n:scheme: http\n:path: /\n:authority: www.example.com\n", 57)
+	testHPACKDecode(t, &d, "828684be5886a8eb10649cbf",
+		":method: GET\n:scheme: http\n:path: /\n:authority: www.example.com\ncache-control: no-cache\n", 110)
+	testHPACKDecode(t, &d, "828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+		":method: GET\n:scheme: https\n:path: /index.html\n:authority: www.example.com\ncustom-key: custom-value\n", 164)
+}
+
+func TestHPACKDecodeRFCResponses(t *testing.T) {
+	// See https://tools.ietf.org/html/rfc7541#appendix-C.6
+	var d hpackDecoder
+	d.setMaxTableSize(256)
+
+	testHPACKDecode(t, &d, "488264025885aec3771a4b6196d07abe941054d444a8200595040b8166e082a62d1bff"+
+		"6e919d29ad171863c78f0b97c8e9ae82ae43d3",
+		":status: 302\ncache-control: private\ndate: Mon, 21 Oct 2013 20:13:21 GMT\nlocation: https://www.example.com\n", 222)
+	testHPACKDecode(t, &d, "4883640effc1c0bf",
+		":status: 307\ncache-control: private\ndate: Mon, 21 Oct 2013 20:13:21 GMT\nlocation: https://www.example.com\n", 222)
+	testHPACKDecode(t, &d, "88c16196d07abe941054d444a8200595040b8166e084a62d1bffc05a839bd9ab77ad94e7"+
+		"821dd7f2e6c7b335dfdfcd5b3960d5af27087f3672c1ab270fb5291f9587316065c003ed4ee5b1063d5007",
+		":status: 200\ncache-control: private\ndate: Mon, 21 Oct 2013 20:13:22 GMT\nlocation: https://www.example.com\n"+
+			"content-encoding: gzip\nset-cookie: foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1\n", 215)
+}
+
+func testHPACKDecode(t *testing.T, d *hpackDecoder, block, expectedHeaders string, expectedTableSize int) {
+	b, err := hex.DecodeString(block)
+	if err != nil {
+		t.Fatalf("cannot decode hex %q: %s", block, err)
+	}
+	var headers []string
+	err = d.decode(b, func(name, value []byte) error {
+		headers = append(headers, fmt.Sprintf("%s: %s\n", name, value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when decoding %q: %s", block, err)
+	}
+	if strings.Join(headers, "") != expectedHeaders {
+		t.Fatalf("unexpected headers decoded from %q: %q. Expecting %q", block, strings.Join(headers, ""), expectedHeaders)
+	}
+	if d.tableSize != expectedTableSize {
+		t.Fatalf("unexpected dynamic table size %d. Expecting %d", d.tableSize, expectedTableSize)
+	}
+}
+
+func TestHPACKEncodeDecode(t *testing.T) {
+	testHPACKEncodeDecode(t, ":status", "200")
+	testHPACKEncodeDecode(t, ":status", "418")
+	testHPACKEncodeDecode(t, "content-type", "text/html; charset=utf-8")
+	testHPACKEncodeDecode(t, "x-custom-header", "")
+	testHPACKEncodeDecode(t, "x-custom-header", strings.Repeat("foobar", 100))
+	testHPACKEncodeDecode(t, "x-binary", "\x00\x01\xff\xfe")
+}
+
+func testHPACKEncodeDecode(t *testing.T, name, value string) {
+	b := hpackAppendHeader(nil, []byte(name), []byte(value))
+
+	var d hpackDecoder
+	d.setMaxTableSize(hpackDefaultTableSize)
+	n := 0
+	err := d.decode(b, func(k, v []byte) error {
+		if string(k) != name {
+			t.Fatalf("unexpected header name %q. Expecting %q", k, name)
+		}
+		if string(v) != value {
+			t.Fatalf("unexpected header value %q. Expecting %q", v, value)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != 1 {
+		t.Fatalf("unexpected number of decoded headers: %d. Expecting 1", n)
+	}
+	if len(d.table) != 0 {
+		t.Fatalf("the encoder mustn't add entries to dynamic table")
+	}
+}
+
+func TestHPACKHuffman(t *testing.T) {
+	for _, s := range []string{"", "a", "www.example.com", "no-cache", "Mon, 21 Oct 2013 20:13:21 GMT", "\x00\xff~!@#$%^&*()"} {
+		b := hpackAppendHuffmanEncode(nil, []byte(s))
+		if len(b) != hpackHuffmanEncodedLen([]byte(s)) {
+			t.Fatalf("unexpected encoded length for %q: %d. Expecting %d", s, len(b), hpackHuffmanEncodedLen([]byte(s)))
+		}
+		result, err := hpackAppendHuffmanDecode(nil, b)
+		if err != nil {
+			t.Fatalf("unexpected error when decoding %q: %s", s, err)
+		}
+		if string(result) != s {
+			t.Fatalf("unexpected decoded string %q. Expecting %q", result, s)
+		}
+	}
+}
+
+func TestHPACKDecodeError(t *testing.T) {
+	// truncated block
+	testHPACKDecodeError(t, "82418c", errHPACKTruncated)
+
+	// invalid index
+	testHPACKDecodeError(t, "80", errHPACKInvalidIndex)
+	testHPACKDecodeError(t, "be", errHPACKInvalidIndex)
+
+	// invalid huffman padding
+	testHPACKDecodeError(t, "0001788100", errHPACKInvalidHuffman)
+
+	// size update after header field
+	testHPACKDecodeError(t, "8220", errHPACKTableSizeUpdate)
+
+	// too big size update
+	testHPACKDecodeError(t, "3fe21f", errHPACKTableSizeUpdate)
+}
+
+func testHPACKDecodeError(t *testing.T, block string, expectedErr error) {
+	b, err := hex.DecodeString(block)
+	if err != nil {
+		t.Fatalf("cannot decode hex %q: %s", block, err)
+	}
+	var d hpackDecoder
+	d.setMaxTableSize(hpackDefaultTableSize)
+	err = d.decode(b, func(name, value []byte) error { return nil })
+	if err != expectedErr {
+		t.Fatalf("unexpected error when decoding %q: %v. Expecting %v", block, err, expectedErr)
+	}
+}
+
+func TestHPACKHeaderListSizeLimit(t *testing.T) {
+	var d hpackDecoder
+	d.setMaxTableSize(hpackDefaultTableSize)
+	d.maxHeaderListSize = 100
+
+	b := hpackAppendHeader(nil, []byte("x-foo"), []byte("bar"))
+	if err := d.decode(b, func(name, value []byte) error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b = hpackAppendHeader(b, []byte("x-foo"), []byte(strings.Repeat("x", 100)))
+	if err := d.decode(b, func(name, value []byte) error { return nil }); err != errHPACKHeaderListTooBig {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errHPACKHeaderListTooBig)
+	}
+}
@@ -3,6 +3,7 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -25,9 +26,17 @@ type Request struct {
 	// Copying Header by value is forbidden. Use pointer to Header instead.
 	Header RequestHeader
 
+	// Trace contains hooks called by HostClient and Client while
+	// performing the request.
+	//
+	// Tracing is disabled if Trace is nil.
+	Trace *ClientTrace
+
 	body []byte
 	w    requestBodyWriter
 
+	bodyStream io.Reader
+
 	uri       URI
 	parsedURI bool
 
@@ -54,10 +63,27 @@ type Response struct {
 	// Use it for HEAD requests.
 	SkipBody bool
 
+	// Response.Read() doesn't read body into memory if set to true.
+	// The body may be read via BodyStream instead.
+	//
+	// HostClient returns the connection to the pool only after
+	// the body stream is read until io.EOF. The connection is closed
+	// if the body stream is closed via CloseBodyStream before that.
+	//
+	// HostClient.ReadTimeout limits the duration for reading the whole
+	// response including the streamed body. PipelineClient ignores
+	// StreamBody.
+	//
+	// Use it for downloading big files or for consuming long-lived
+	// chunked responses.
+	StreamBody bool
+
 	body []byte
 	w    responseBodyWriter
 
 	bodyStream io.Reader
+
+	decompressedEncoding []byte
 }
 
 // SetRequestURI sets RequestURI.
@@ -157,6 +183,39 @@ func (resp *Response) SetBodyStreamWriter(sw StreamWriter) {
 	resp.SetBodyStream(sr, -1)
 }
 
+// SetBodyStream sets request body stream and, optionally body size.
+//
+// If bodySize is >= 0, then the bodyStream must provide exactly bodySize bytes
+// before returning io.EOF.
+//
+// If bodySize < 0, then bodyStream is read until io.EOF and the body
+// is sent with 'Transfer-Encoding: chunked'.
+//
+// bodyStream.Close() is called after finishing reading all body data
+// if it implements io.Closer.
+//
+// Requests with body stream aren't retried by clients, since
+// the stream may be already consumed.
+//
+// See also SetBodyStreamWriter.
+func (req *Request) SetBodyStream(bodyStream io.Reader, bodySize int) {
+	req.ResetBody()
+	req.bodyStream = bodyStream
+	req.Header.SetContentLength(bodySize)
+}
+
+// SetBodyStreamWriter registers the given sw for populating request body.
+//
+// This function may be used in the following cases:
+//
+//     * if request body is too big (more than 10MB).
+//     * if request body is streamed from slow external sources.
+//     * if request body must be streamed to the server in chunks.
+func (req *Request) SetBodyStreamWriter(sw StreamWriter) {
+	sr := NewStreamReader(sw)
+	req.SetBodyStream(sr, -1)
+}
+
 // BodyWriter returns writer for populating response body.
 //
 // If used inside RequestHandler, the returned writer must not be used
@@ -192,10 +251,28 @@ func (w *requestBodyWriter) Write(p []byte) (int, error) {
 }
 
 // Body returns response body.
+//
+// The body is empty if the body is streamed. See StreamBody
+// and SetBodyStream for details.
 func (resp *Response) Body() []byte {
 	return resp.body
 }
 
+// BodyStream returns response body stream.
+//
+// nil is returned if the body isn't streamed.
+func (resp *Response) BodyStream() io.Reader {
+	return resp.bodyStream
+}
+
+// CloseBodyStream closes response body stream.
+//
+// It must be called if the body stream isn't read until io.EOF,
+// so the underlying resources may be released.
+func (resp *Response) CloseBodyStream() error {
+	return resp.closeBodyStream()
+}
+
 // BodyGunzip returns un-gzipped body data.
 //
 // This method may be used if the response header contains
@@ -218,6 +295,15 @@ func (resp *Response) BodyGunzip() ([]byte, error) {
 	return b, nil
 }
 
+// DecompressedEncoding returns the original Content-Encoding
+// of the response body decompressed by the client.
+//
+// Empty value is returned if the body hasn't been decompressed.
+// See HostClient.DecompressResponse for details.
+func (resp *Response) DecompressedEncoding() []byte {
+	return resp.decompressedEncoding
+}
+
 // BodyInflate returns un-deflated body data.
 //
 // This method may be used if the response header contains
@@ -271,10 +357,57 @@ func (resp *Response) ResetBody() {
 }
 
 // Body returns request body.
+//
+// The whole request body is read into memory on the first call
+// if the body is streamed. See SetBodyStream
+// and Server.StreamRequestBody for details.
 func (req *Request) Body() []byte {
+	if req.bodyStream != nil {
+		req.readBodyStream()
+	}
 	return req.body
 }
 
+// BodyStream returns a reader for the request body.
+//
+// The body stream is returned if the body is streamed. See SetBodyStream
+// and Server.StreamRequestBody for details. Otherwise the returned reader
+// is backed by the request body.
+func (req *Request) BodyStream() io.Reader {
+	if req.bodyStream != nil {
+		return req.bodyStream
+	}
+	return bytes.NewReader(req.body)
+}
+
+func (req *Request) readBodyStream() {
+	r := req.bodyStream
+	defer req.closeBodyStream()
+
+	s, isServerStream := r.(*bodyStreamReader)
+	maxBodySize := 0
+	if isServerStream {
+		maxBodySize = s.maxBodySize
+	}
+	if maxBodySize > 0 {
+		r = io.LimitReader(r, int64(maxBodySize)+1)
+	}
+
+	b := bytes.NewBuffer(req.body[:0])
+	_, err := b.ReadFrom(r)
+	req.body = b.Bytes()
+	if err == nil && maxBodySize > 0 && len(req.body) > maxBodySize {
+		err = ErrBodyTooLarge
+	}
+	if err != nil {
+		// Do not expose partially read body.
+		req.body = req.body[:0]
+		if isServerStream && s.err == nil {
+			s.err = err
+		}
+	}
+}
+
 // AppendBody appends p to request body.
 func (req *Request) AppendBody(p []byte) {
 	req.body = append(req.body, p...)
@@ -287,20 +420,23 @@ func (req *Request) AppendBodyString(s string) {
 
 // SetBody sets request body.
 func (req *Request) SetBody(body []byte) {
+	req.closeBodyStream()
 	req.body = append(req.body[:0], body...)
 }
 
 // SetBodyString sets request body.
 func (req *Request) SetBodyString(body string) {
+	req.closeBodyStream()
 	req.body = append(req.body[:0], body...)
 }
 
 // ResetBody resets request body.
 func (req *Request) ResetBody() {
+	req.closeBodyStream()
 	req.body = req.body[:0]
 }
 
-// CopyTo copies req contents to dst.
+// CopyTo copies req contents to dst except of body stream.
 func (req *Request) CopyTo(dst *Request) {
 	dst.Reset()
 	req.Header.CopyTo(&dst.Header)
@@ -312,6 +448,8 @@ func (req *Request) CopyTo(dst *Request) {
 	req.postArgs.CopyTo(&dst.postArgs)
 	dst.parsedPostArgs = req.parsedPostArgs
 
+	dst.Trace = req.Trace
+
 	// do not copy multipartForm - it will be automatically
 	// re-created on the first call to MultipartForm.
 }
@@ -322,6 +460,8 @@ func (resp *Response) CopyTo(dst *Response) {
 	resp.Header.CopyTo(&dst.Header)
 	dst.body = append(dst.body[:0], resp.body...)
 	dst.SkipBody = resp.SkipBody
+	dst.StreamBody = resp.StreamBody
+	dst.decompressedEncoding = append(dst.decompressedEncoding[:0], resp.decompressedEncoding...)
 }
 
 // URI returns request URI
@@ -336,6 +476,12 @@ func (req *Request) parseURI() {
 	}
 	req.parsedURI = true
 
+	if req.Header.IsConnect() {
+		// CONNECT request contains authority-form request uri,
+		// i.e. host:port. See https://tools.ietf.org/html/rfc7230#section-5.3.3 .
+		req.uri.Parse(req.Header.RequestURI(), nil)
+		return
+	}
 	req.uri.parseQuick(req.Header.RequestURI(), &req.Header)
 }
 
@@ -357,7 +503,7 @@ func (req *Request) parsePostArgs() {
 	if !bytes.Equal(req.Header.ContentType(), strPostArgsContentType) {
 		return
 	}
-	req.postArgs.ParseBytes(req.body)
+	req.postArgs.ParseBytes(req.Body())
 	return
 }
 
@@ -378,7 +524,17 @@ func (req *Request) MultipartForm() (*multipart.Form, error) {
 	if len(boundary) == 0 {
 		return nil, ErrNoMultipartForm
 	}
-	f, err := readMultipartFormBody(bytes.NewReader(req.body), boundary, 0, len(req.body))
+	var f *multipart.Form
+	var err error
+	if s, ok := req.bodyStream.(*bodyStreamReader); ok {
+		// Stream multipart form data into temporary files instead
+		// of reading the whole body into memory.
+		req.bodyStream = nil
+		f, err = readMultipartFormBody(s, boundary, s.maxBodySize, defaultMaxInMemoryFileSize)
+	} else {
+		body := req.Body()
+		f, err = readMultipartFormBody(bytes.NewReader(body), boundary, 0, len(body))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -406,10 +562,12 @@ func readMultipartFormBody(r io.Reader, boundary []byte, maxBodySize, maxInMemor
 func (req *Request) Reset() {
 	req.Header.Reset()
 	req.resetSkipHeader()
+	req.Trace = nil
 }
 
 func (req *Request) resetSkipHeader() {
 	req.body = req.body[:0]
+	req.closeBodyStream()
 	req.uri.Reset()
 	req.parsedURI = false
 	req.postArgs.Reset()
@@ -433,11 +591,13 @@ func (resp *Response) Reset() {
 	resp.Header.Reset()
 	resp.resetSkipHeader()
 	resp.SkipBody = false
+	resp.StreamBody = false
 }
 
 func (resp *Response) resetSkipHeader() {
 	resp.closeBodyStream()
 	resp.body = resp.body[:0]
+	resp.decompressedEncoding = resp.decompressedEncoding[:0]
 }
 
 // Read reads request (including body) from the given r.
@@ -478,10 +638,14 @@ var errGetOnly = errors.New("non-GET request received")
 //       with ContinueReadBody.
 //     - Or close the connection.
 func (req *Request) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
-	return req.readLimitBody(r, maxBodySize, false)
+	return req.readLimitBody(r, maxBodySize, false, false)
 }
 
-func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly bool) error {
+// readLimitBody reads request from r.
+//
+// Only request header is read if streamBody is set. The caller
+// is responsible for reading request body in this case.
+func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly, streamBody bool) error {
 	req.resetSkipHeader()
 	err := req.Header.Read(r)
 	if err != nil {
@@ -490,6 +654,9 @@ func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly bool
 	if getOnly && !req.Header.IsGet() {
 		return errGetOnly
 	}
+	if streamBody {
+		return nil
+	}
 
 	if req.Header.noBody() {
 		return nil
@@ -561,6 +728,10 @@ func (resp *Response) Read(r *bufio.Reader) error {
 //
 // If maxBodySize > 0 and the body size exceeds maxBodySize,
 // then ErrBodyTooLarge is returned.
+//
+// Only response header is read if StreamBody is set. The body
+// must be read from BodyStream before reading the next response from r.
+// maxBodySize doesn't limit the streamed body size.
 func (resp *Response) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
 	resp.resetSkipHeader()
 	err := resp.Header.Read(r)
@@ -575,6 +746,10 @@ func (resp *Response) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
 	}
 
 	if !isSkipResponseBody(resp.Header.StatusCode()) && !resp.SkipBody {
+		if resp.StreamBody {
+			resp.bodyStream = acquireBodyStreamReader(r, resp.Header.ContentLength(), 0)
+			return nil
+		}
 		resp.body, err = readBody(r, resp.Header.ContentLength(), maxBodySize, resp.body)
 		if err != nil {
 			resp.Reset()
@@ -607,8 +782,14 @@ func (req *Request) Write(w *bufio.Writer) error {
 			return errRequestHostRequired
 		}
 		req.Header.SetHostBytes(host)
-		req.Header.SetRequestURIBytes(uri.RequestURI())
+		if !req.Header.IsConnect() {
+			req.Header.SetRequestURIBytes(uri.RequestURI())
+		}
 	}
+	if req.bodyStream != nil {
+		return req.writeBodyStream(w)
+	}
+
 	req.Header.SetContentLength(len(req.body))
 	err := req.Header.Write(w)
 	if err != nil {
@@ -622,6 +803,46 @@ func (req *Request) Write(w *bufio.Writer) error {
 	return err
 }
 
+func (req *Request) writeBodyStream(w *bufio.Writer) error {
+	if req.Header.noBody() {
+		req.closeBodyStream()
+		return fmt.Errorf("Non-zero body stream for non-POST request")
+	}
+
+	var err error
+	contentLength := bodyStreamSize(req.bodyStream, req.Header.ContentLength())
+	if contentLength >= 0 {
+		req.Header.SetContentLength(contentLength)
+		if err = req.Header.Write(w); err != nil {
+			return err
+		}
+		if err = writeBodyFixedSize(w, req.bodyStream, int64(contentLength)); err != nil {
+			return err
+		}
+	} else {
+		req.Header.SetContentLength(-1)
+		if err = req.Header.Write(w); err != nil {
+			return err
+		}
+		if err = writeBodyChunked(w, req.bodyStream); err != nil {
+			return err
+		}
+	}
+	return req.closeBodyStream()
+}
+
+func (req *Request) closeBodyStream() error {
+	if req.bodyStream == nil {
+		return nil
+	}
+	var err error
+	if bsc, ok := req.bodyStream.(io.Closer); ok {
+		err = bsc.Close()
+	}
+	req.bodyStream = nil
+	return err
+}
+
 // WriteGzip writes response with gzipped body to w.
 //
 // The method sets 'Content-Encoding: gzip' header.
@@ -682,6 +903,7 @@ func (resp *Response) gzipBody(level int) error {
 	// Do not care about memory allocations here, since gzip is slow
 	// and allocates a lot of memory by itself.
 	if resp.bodyStream != nil {
+		resp.initByteRangesBody()
 		bs := resp.bodyStream
 		resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
 			zw := acquireGzipWriter(sw, level)
@@ -706,6 +928,7 @@ func (resp *Response) deflateBody(level int) error {
 	// Do not care about memory allocations here, since flate is slow
 	// and allocates a lot of memory by itself.
 	if resp.bodyStream != nil {
+		resp.initByteRangesBody()
 		bs := resp.bodyStream
 		resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
 			zw := acquireFlateWriter(sw, level)
@@ -726,22 +949,161 @@ func (resp *Response) deflateBody(level int) error {
 	return nil
 }
 
+// decompressBody decompresses gzip and deflate response body
+// and removes Content-Encoding header.
+//
+// If maxBodySize > 0 and the decompressed body size exceeds maxBodySize,
+// then ErrBodyTooLarge is returned. Body stream is decompressed
+// on the fly, so reading it returns ErrBodyTooLarge after maxBodySize
+// decompressed bytes.
+func (resp *Response) decompressBody(maxBodySize int) error {
+	ce := resp.Header.peek(strContentEncoding)
+	isGzip := bytes.EqualFold(ce, strGzip)
+	if !isGzip && !bytes.EqualFold(ce, strDeflate) {
+		return nil
+	}
+	if resp.bodyStream != nil {
+		resp.bodyStream = &decompressBodyStream{
+			bs:          resp.bodyStream,
+			isGzip:      isGzip,
+			maxBodySize: maxBodySize,
+		}
+		resp.Header.SetContentLength(-1)
+	} else {
+		if len(resp.body) == 0 {
+			return nil
+		}
+		// Do not care about memory allocations here,
+		// since gzip and flate are slow and generate a lot
+		// of memory allocations by themselves.
+		b, err := decompressData(resp.body, isGzip, maxBodySize)
+		if err != nil {
+			return err
+		}
+		resp.body = b
+		resp.Header.SetContentLength(len(b))
+	}
+	resp.decompressedEncoding = append(resp.decompressedEncoding[:0], ce...)
+	resp.Header.DelBytes(strContentEncoding)
+	return nil
+}
+
+func decompressData(src []byte, isGzip bool, maxBodySize int) ([]byte, error) {
+	zr, err := acquireDecompressReader(bytes.NewReader(src), isGzip)
+	if err != nil {
+		return nil, err
+	}
+
+	var lr io.Reader = zr
+	if maxBodySize > 0 {
+		// Read one byte more than the limit in order to detect
+		// too large bodies without decompressing them entirely.
+		lr = io.LimitReader(zr, int64(maxBodySize)+1)
+	}
+	b, err := ioutil.ReadAll(lr)
+	releaseDecompressReader(zr, isGzip)
+	if err != nil {
+		return nil, err
+	}
+	if maxBodySize > 0 && len(b) > maxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	return b, nil
+}
+
+func acquireDecompressReader(r io.Reader, isGzip bool) (io.ReadCloser, error) {
+	if !isGzip {
+		return acquireInflateReader(r)
+	}
+	zr, err := acquireGzipReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
+
+func releaseDecompressReader(zr io.ReadCloser, isGzip bool) {
+	if isGzip {
+		releaseGzipReader(zr.(*gzip.Reader))
+	} else {
+		releaseInflateReader(zr)
+	}
+}
+
+// decompressBodyStream decompresses the underlying response body stream.
+//
+// The underlying body stream is closed after reading the decompressed
+// body until io.EOF or after closing decompressBodyStream.
+type decompressBodyStream struct {
+	bs     io.Reader
+	zr     io.ReadCloser
+	isGzip bool
+	err    error
+
+	maxBodySize int
+	bodySize    int
+}
+
+func (s *decompressBodyStream) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.zr == nil {
+		// Acquire decompressor lazily, since it reads compression header
+		// from the underlying body stream.
+		zr, err := acquireDecompressReader(s.bs, s.isGzip)
+		if err != nil {
+			s.release(err)
+			return 0, err
+		}
+		s.zr = zr
+	}
+	n, err := s.zr.Read(p)
+	s.bodySize += n
+	if s.maxBodySize > 0 && s.bodySize > s.maxBodySize {
+		n -= s.bodySize - s.maxBodySize
+		s.bodySize = s.maxBodySize
+		err = ErrBodyTooLarge
+	} else if err == io.EOF {
+		// Read the rest of the underlying body stream, so the connection
+		// may be re-used.
+		if _, err = copyZeroAlloc(ioutil.Discard, s.bs); err == nil {
+			err = io.EOF
+		}
+	}
+	if err != nil {
+		s.release(err)
+	}
+	return n, err
+}
+
+func (s *decompressBodyStream) Close() error {
+	if s.err == nil {
+		s.release(errBodyStreamClosed)
+	}
+	return nil
+}
+
+func (s *decompressBodyStream) release(err error) {
+	s.err = err
+	if s.zr != nil {
+		releaseDecompressReader(s.zr, s.isGzip)
+		s.zr = nil
+	}
+	if bsc, ok := s.bs.(io.Closer); ok {
+		bsc.Close()
+	}
+	s.bs = nil
+}
+
 // Write writes response to w.
 //
 // Write doesn't flush response to w for performance reasons.
 func (resp *Response) Write(w *bufio.Writer) error {
 	var err error
 	if resp.bodyStream != nil {
-		contentLength := resp.Header.ContentLength()
-		if contentLength < 0 {
-			lrSize := limitedReaderSize(resp.bodyStream)
-			if lrSize >= 0 {
-				contentLength = int(lrSize)
-				if int64(contentLength) != lrSize {
-					contentLength = -1
-				}
-			}
-		}
+		resp.initByteRangesBody()
+		contentLength := bodyStreamSize(resp.bodyStream, resp.Header.ContentLength())
 		if contentLength >= 0 {
 			resp.Header.SetContentLength(contentLength)
 			if err = resp.Header.Write(w); err != nil {
@@ -845,6 +1207,26 @@ func writeBodyChunked(w *bufio.Writer, r io.Reader) error {
 	return err
 }
 
+// bodyStreamSize returns the body size for the given body stream.
+//
+// -1 is returned if the size is unknown.
+func bodyStreamSize(bodyStream io.Reader, contentLength int) int {
+	if contentLength >= 0 {
+		return contentLength
+	}
+	lrSize := limitedReaderSize(bodyStream)
+	if lrSize >= 0 {
+		contentLength = int(lrSize)
+		if int64(contentLength) != lrSize {
+			contentLength = -1
+		}
+	}
+	if contentLength < 0 {
+		contentLength = -1
+	}
+	return contentLength
+}
+
 func limitedReaderSize(r io.Reader) int64 {
 	lr, ok := r.(*io.LimitedReader)
 	if !ok {
@@ -876,7 +1258,7 @@ func writeBodyFixedSize(w *bufio.Writer, r io.Reader, size int64) error {
 	}
 
 	if n != size && err == nil {
-		err = fmt.Errorf("copied %d bytes from response body stream instead of %d bytes", n, size)
+		err = fmt.Errorf("copied %d bytes from body stream instead of %d bytes", n, size)
 	}
 	return err
 }
@@ -1015,6 +1397,141 @@ func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte) ([]byte, erro
 	}
 }
 
+// bodyStreamReader reads request or response body directly
+// from the connection without buffering the whole body in memory.
+//
+// It is used by Server if Server.StreamRequestBody is enabled
+// and by Response.ReadLimitBody if Response.StreamBody is set.
+type bodyStreamReader struct {
+	r             *bufio.Reader
+	contentLength int
+	maxBodySize   int
+
+	// The number of unread bytes in the body of known length
+	// or in the current chunk of chunked body.
+	n int
+
+	err error
+}
+
+// maxRequestBodyDrainSize is the maximum number of unread request body
+// bytes, which are drained by Server after RequestHandler returns.
+// The connection is closed if more bytes remain unread.
+const maxRequestBodyDrainSize = 256 * 1024
+
+func acquireBodyStreamReader(r *bufio.Reader, contentLength, maxBodySize int) *bodyStreamReader {
+	v := bodyStreamReaderPool.Get()
+	if v == nil {
+		v = &bodyStreamReader{}
+	}
+	s := v.(*bodyStreamReader)
+	s.r = r
+	s.contentLength = contentLength
+	s.maxBodySize = maxBodySize
+	s.n = 0
+	if contentLength > 0 {
+		s.n = contentLength
+	}
+	s.err = nil
+	return s
+}
+
+func releaseBodyStreamReader(s *bodyStreamReader) {
+	s.r = nil
+	bodyStreamReaderPool.Put(s)
+}
+
+var bodyStreamReaderPool sync.Pool
+
+func (s *bodyStreamReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	isChunked := s.contentLength == -1
+	if isChunked && s.n == 0 {
+		chunkSize, err := parseChunkSize(s.r)
+		if err == nil && chunkSize == 0 {
+			err = readChunkCRLF(s.r)
+			if err == nil {
+				err = io.EOF
+			}
+		}
+		if err != nil {
+			s.err = err
+			return 0, err
+		}
+		s.n = chunkSize
+	}
+
+	isIdentity := s.contentLength == -2
+	if !isIdentity {
+		if s.n == 0 {
+			s.err = io.EOF
+			return 0, io.EOF
+		}
+		if len(p) > s.n {
+			p = p[:s.n]
+		}
+	}
+
+	n, err := s.r.Read(p)
+	if err != nil {
+		if err == io.EOF && !isIdentity {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = err
+		return n, err
+	}
+	if !isIdentity {
+		s.n -= n
+		if isChunked && s.n == 0 {
+			if err = readChunkCRLF(s.r); err != nil {
+				s.err = err
+			}
+		}
+	}
+	return n, err
+}
+
+// drain reads the rest of request body, so the next request
+// may be read from the connection.
+//
+// Returns false if the body cannot be drained. The connection
+// must be closed in this case.
+func (s *bodyStreamReader) drain() bool {
+	if s.err == io.EOF {
+		return true
+	}
+	if s.err != nil || s.contentLength == -2 {
+		// Identity body lasts until the connection is closed.
+		return false
+	}
+	if s.contentLength >= 0 && s.n > maxRequestBodyDrainSize {
+		return false
+	}
+	_, err := io.CopyN(ioutil.Discard, s, maxRequestBodyDrainSize+1)
+	return err == io.EOF
+}
+
+func readChunkCRLF(r *bufio.Reader) error {
+	b, err := r.Peek(len(strCRLF))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if !bytes.Equal(b, strCRLF) {
+		return fmt.Errorf("cannot find crlf at the end of chunk")
+	}
+	r.Discard(len(b))
+	return nil
+}
+
 func parseChunkSize(r *bufio.Reader) (int, error) {
 	n, err := readHexInt(r)
 	if err != nil {
@@ -0,0 +1,1561 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP/2 server support. See https://tools.ietf.org/html/rfc7540 .
+
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Frame types.
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FramePriority     = 0x2
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+)
+
+// Frame flags.
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+// Settings identifiers.
+const (
+	http2SettingHeaderTableSize      = 0x1
+	http2SettingEnablePush           = 0x2
+	http2SettingMaxConcurrentStreams = 0x3
+	http2SettingInitialWindowSize    = 0x4
+	http2SettingMaxFrameSize         = 0x5
+	http2SettingMaxHeaderListSize    = 0x6
+)
+
+// Error codes.
+const (
+	http2ErrCodeNo            = 0x0
+	http2ErrCodeProtocol      = 0x1
+	http2ErrCodeInternal      = 0x2
+	http2ErrCodeFlowControl   = 0x3
+	http2ErrCodeStreamClosed  = 0x5
+	http2ErrCodeFrameSize     = 0x6
+	http2ErrCodeRefusedStream = 0x7
+	http2ErrCodeCompression   = 0x9
+)
+
+const (
+	http2FrameHeaderSize     = 9
+	http2DefaultWindowSize   = 65535
+	http2MaxWindowSize       = 1<<31 - 1
+	http2DefaultMaxFrameSize = 16384
+	http2MaxFrameSize        = 1<<24 - 1
+
+	// http2MaxConcurrentStreams is the maximum number of concurrent
+	// streams per connection advertised to clients.
+	// It is additionally limited by Server.Concurrency.
+	http2MaxConcurrentStreams = 100
+)
+
+var (
+	errHTTP2InvalidPreface = errors.New("http2: invalid connection preface")
+	errHTTP2StreamClosed   = errors.New("http2: stream closed")
+	errHTTP2ConnClosed     = errors.New("http2: connection closed")
+	errHTTP2Malformed      = errors.New("http2: malformed request")
+)
+
+// http2ConnError is a connection error.
+//
+// The connection is closed with GOAWAY frame containing the error code.
+type http2ConnError struct {
+	code   uint32
+	reason string
+}
+
+func (e *http2ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.code, e.reason)
+}
+
+func newHTTP2ConnError(code uint32, reason string) error {
+	return &http2ConnError{
+		code:   code,
+		reason: reason,
+	}
+}
+
+type http2FrameHeader struct {
+	length   int
+	typ      byte
+	flags    byte
+	streamID uint32
+}
+
+// http2Conn is a server-side HTTP/2 connection.
+//
+// Frames are read and processed by a single goroutine, while each request
+// is served by a separate goroutine calling Server.Handler.
+type http2Conn struct {
+	s        *Server
+	c        net.Conn
+	br       *bufio.Reader
+	connTime time.Time
+
+	// The following fields are accessed only by the reading goroutine.
+	dec               hpackDecoder
+	fh                http2FrameHeader
+	frameHeader       [http2FrameHeaderSize]byte
+	frameBuf          []byte
+	gotSettings       bool
+	maxStreams        int
+	maxHeaderListSize int
+	maxStreamID       uint32
+	requestNum        uint64
+
+	// Header block being received.
+	headerBlock     []byte
+	headerStreamID  uint32
+	headerEndStream bool
+
+	// Header block decoding state.
+	hreq          *Request
+	hpseudo       byte
+	hsawRegular   bool
+	hdrErr        error
+	onHeaderField func(name, value []byte) error
+
+	// wmu serializes frame writes and protects the fields below.
+	wmu    sync.Mutex
+	bw     *bufio.Writer
+	wbuf   []byte
+	keyBuf []byte
+
+	// mu protects the fields below.
+	mu                sync.Mutex
+	cond              sync.Cond
+	streams           map[uint32]*http2Stream
+	lastStreamID      uint32
+	sendWindow        int32
+	recvWindow        int32
+	initialWindowSize int32
+	goAwaySent        bool
+	closed            bool
+
+	wg sync.WaitGroup
+}
+
+type http2Stream struct {
+	id  uint32
+	ctx *RequestCtx
+
+	// The following fields are accessed only by the reading goroutine.
+	remoteClosed   bool
+	handlerStarted bool
+	contentLength  int
+	bodyLen        int
+
+	// The following fields are protected by http2Conn.mu.
+	sendWindow int32
+	recvWindow int32
+	reset      bool
+
+	// Request body received from the client, but not read yet.
+	body    bytes.Buffer
+	bodyEnd bool
+}
+
+// Pseudo-header flags.
+const (
+	http2PseudoMethod = 1 << iota
+	http2PseudoPath
+	http2PseudoScheme
+	http2PseudoAuthority
+)
+
+// serveHTTP2 serves HTTP/2 connection ctx.c.
+//
+// br may contain data already read from the connection. It is released
+// by serveHTTP2. upgradeReq and settings are set for connections upgraded
+// via 'Upgrade: h2c'. In this case upgradeReq is served on the stream 1.
+func (s *Server) serveHTTP2(ctx *RequestCtx, br *bufio.Reader, upgradeReq *Request, settings []byte) error {
+	if br == nil {
+		br = acquireReader(ctx)
+	}
+	hc := &http2Conn{
+		s:        s,
+		c:        ctx.c,
+		br:       br,
+		bw:       acquireWriter(ctx),
+		connTime: time.Now(),
+	}
+	err := hc.serve(upgradeReq, settings)
+	releaseReader(s, hc.br)
+	releaseWriter(s, hc.bw)
+	return err
+}
+
+// upgradeHTTP2 switches the connection to HTTP/2 after 'Upgrade: h2c'
+// request stored in ctx.
+func (s *Server) upgradeHTTP2(ctx *RequestCtx, br *bufio.Reader, bw *bufio.Writer, settings []byte) error {
+	if bw == nil {
+		bw = acquireWriter(ctx)
+	}
+	bw.Write(strResponseSwitchingProtocolsH2C)
+	err := bw.Flush()
+	releaseWriter(s, bw)
+	if err != nil {
+		if br != nil {
+			releaseReader(s, br)
+		}
+		return err
+	}
+	return s.serveHTTP2(ctx, br, &ctx.Request, settings)
+}
+
+// http2UpgradeSettings returns decoded HTTP2-Settings header value
+// if the request asks for upgrading to HTTP/2 via 'Upgrade: h2c'.
+func http2UpgradeSettings(h *RequestHeader) ([]byte, bool) {
+	if !h.ConnectionUpgrade() || !bytes.Equal(h.Peek("Upgrade"), strH2C) {
+		return nil, false
+	}
+	v := h.Peek("HTTP2-Settings")
+	for len(v) > 0 && v[len(v)-1] == '=' {
+		v = v[:len(v)-1]
+	}
+	settings := make([]byte, base64.RawURLEncoding.DecodedLen(len(v)))
+	n, err := base64.RawURLEncoding.Decode(settings, v)
+	if err != nil || n%6 != 0 {
+		return nil, false
+	}
+	return settings[:n], true
+}
+
+// hasHTTP2Preface returns true if br starts with HTTP/2 connection preface.
+//
+// The function reads only the bytes matching the preface, so it doesn't
+// block on HTTP/1 requests.
+func hasHTTP2Preface(br *bufio.Reader) bool {
+	for i := 1; i <= len(http2Preface); i++ {
+		b, err := br.Peek(i)
+		if err != nil || b[i-1] != http2Preface[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// isHTTP2TLSConn returns true if h2 protocol has been negotiated
+// via ALPN on c.
+func isHTTP2TLSConn(c net.Conn, handshakeTimeout time.Duration) bool {
+	if pic, ok := c.(*perIPConn); ok {
+		c = pic.Conn
+	}
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	if handshakeTimeout > 0 {
+		tc.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	}
+	if err := tc.Handshake(); err != nil {
+		return false
+	}
+	return tc.ConnectionState().NegotiatedProtocol == "h2"
+}
+
+func (hc *http2Conn) serve(upgradeReq *Request, settings []byte) error {
+	s := hc.s
+	hc.cond.L = &hc.mu
+	hc.streams = make(map[uint32]*http2Stream)
+	hc.sendWindow = http2DefaultWindowSize
+	hc.initialWindowSize = http2DefaultWindowSize
+	hc.recvWindow = http2DefaultWindowSize
+	hc.maxStreams = http2MaxConcurrentStreams
+	if n := s.getConcurrency(); n < hc.maxStreams {
+		hc.maxStreams = n
+	}
+	hc.maxHeaderListSize = s.ReadBufferSize
+	if hc.maxHeaderListSize <= 0 {
+		hc.maxHeaderListSize = defaultReadBufferSize
+	}
+	hc.dec.maxHeaderListSize = hc.maxHeaderListSize
+	hc.dec.setMaxTableSize(hpackDefaultTableSize)
+	hc.frameBuf = make([]byte, http2DefaultMaxFrameSize)
+	hc.onHeaderField = hc.handleHeaderField
+
+	err := hc.applySettings(settings)
+	if err == nil {
+		err = hc.writeSettings()
+	}
+	if err == nil && upgradeReq != nil {
+		hc.serveUpgradeRequest(upgradeReq)
+	}
+	if err == nil {
+		err = hc.readPreface()
+	}
+	if err == nil {
+		err = hc.readLoop()
+	}
+	hc.close(err)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+func (hc *http2Conn) serveUpgradeRequest(req *Request) {
+	ctx := hc.s.acquireCtx(hc.c)
+	req.CopyTo(&ctx.Request)
+	h := &ctx.Request.Header
+	h.Del("Connection")
+	h.Del("Upgrade")
+	h.Del("HTTP2-Settings")
+	st := hc.newStream(1, ctx)
+	st.remoteClosed = true
+	st.bodyEnd = true
+	hc.maxStreamID = 1
+	hc.startHandler(st)
+}
+
+func (hc *http2Conn) readPreface() error {
+	if hc.s.ReadTimeout > 0 {
+		if err := hc.c.SetReadDeadline(time.Now().Add(hc.s.ReadTimeout)); err != nil {
+			return err
+		}
+	}
+	b, err := hc.br.Peek(len(http2Preface))
+	if err != nil {
+		return err
+	}
+	if string(b) != http2Preface {
+		return errHTTP2InvalidPreface
+	}
+	_, err = hc.br.Discard(len(http2Preface))
+	return err
+}
+
+func (hc *http2Conn) readLoop() error {
+	s := hc.s
+
+	// HTTP/2 connection is registered as idle for its' whole lifetime,
+	// so Shutdown could interrupt reading frames from it.
+	tc := s.trackConn(hc.c)
+	defer s.untrackConn(tc)
+	if !s.setConnIdle(tc) {
+		// The server is shutting down.
+		return hc.goAway(http2ErrCodeNo)
+	}
+
+	for {
+		hc.mu.Lock()
+		idle := len(hc.streams) == 0
+		if idle && hc.goAwaySent {
+			hc.mu.Unlock()
+			return nil
+		}
+		var deadline time.Time
+		if s.ReadTimeout > 0 && (idle || hc.isReceivingNolock()) {
+			deadline = time.Now().Add(s.ReadTimeout)
+		}
+		err := hc.c.SetReadDeadline(deadline)
+		hc.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		// Check for shutdown after setting the read deadline, since
+		// the deadline set by Shutdown could be overwritten above.
+		if atomic.LoadInt32(&s.stop) == 1 ||
+			(s.MaxKeepaliveDuration > 0 && time.Since(hc.connTime) > s.MaxKeepaliveDuration) {
+			if err = hc.goAway(http2ErrCodeNo); err != nil {
+				return err
+			}
+			if idle {
+				return nil
+			}
+		}
+
+		if err = hc.readFrame(); err != nil {
+			if err != io.ErrUnexpectedEOF && atomic.LoadInt32(&s.stop) == 1 && !hc.isGoingAway() {
+				// Shutdown interrupted waiting for the frame. Continue
+				// reading frames until active streams are served.
+				continue
+			}
+			if idle || hc.isGoingAway() {
+				// Treat all errors on idle connections as EOF.
+				return nil
+			}
+			return err
+		}
+
+		if err = hc.handleFrame(); err != nil {
+			return err
+		}
+	}
+}
+
+// isReceivingNolock returns true if the client may send request body
+// on any stream.
+//
+// Streams blocked by flow control are ignored, since the client
+// waits for the handler reading the request body.
+func (hc *http2Conn) isReceivingNolock() bool {
+	if hc.recvWindow <= 0 {
+		return false
+	}
+	for _, st := range hc.streams {
+		if !st.bodyEnd && !st.reset && st.recvWindow > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// readFrame reads the next frame into hc.fh and hc.frameBuf.
+//
+// io.ErrUnexpectedEOF is returned if the frame has been read partially.
+func (hc *http2Conn) readFrame() error {
+	h := hc.frameHeader[:]
+	if n, err := io.ReadFull(hc.br, h); err != nil {
+		if n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	fh := &hc.fh
+	fh.length = int(h[0])<<16 | int(h[1])<<8 | int(h[2])
+	fh.typ = h[3]
+	fh.flags = h[4]
+	fh.streamID = binary.BigEndian.Uint32(h[5:]) & (1<<31 - 1)
+	if fh.length > len(hc.frameBuf) {
+		return newHTTP2ConnError(http2ErrCodeFrameSize, "too big frame")
+	}
+	if _, err := io.ReadFull(hc.br, hc.frameBuf[:fh.length]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func (hc *http2Conn) handleFrame() error {
+	fh := &hc.fh
+	payload := hc.frameBuf[:fh.length]
+	if hc.headerStreamID != 0 && (fh.typ != http2FrameContinuation || fh.streamID != hc.headerStreamID) {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "expecting CONTINUATION frame")
+	}
+	if !hc.gotSettings {
+		if fh.typ != http2FrameSettings || fh.flags&http2FlagAck != 0 {
+			return newHTTP2ConnError(http2ErrCodeProtocol, "expecting SETTINGS frame")
+		}
+		hc.gotSettings = true
+	}
+
+	switch fh.typ {
+	case http2FrameData:
+		return hc.handleData(payload)
+	case http2FrameHeaders:
+		return hc.handleHeaders(payload)
+	case http2FrameContinuation:
+		return hc.handleContinuation(payload)
+	case http2FramePriority:
+		return hc.handlePriority(payload)
+	case http2FrameRSTStream:
+		return hc.handleRSTStream(payload)
+	case http2FrameSettings:
+		return hc.handleSettings(payload)
+	case http2FramePushPromise:
+		return newHTTP2ConnError(http2ErrCodeProtocol, "unexpected PUSH_PROMISE frame")
+	case http2FramePing:
+		return hc.handlePing(payload)
+	case http2FrameGoAway:
+		if fh.streamID != 0 {
+			return newHTTP2ConnError(http2ErrCodeProtocol, "GOAWAY frame on non-zero stream")
+		}
+		// The client closes the connection after receiving responses
+		// for active streams.
+		return nil
+	case http2FrameWindowUpdate:
+		return hc.handleWindowUpdate(payload)
+	default:
+		// Unknown frame types must be ignored.
+		return nil
+	}
+}
+
+func (hc *http2Conn) stripPadding(payload []byte) ([]byte, error) {
+	if hc.fh.flags&http2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, newHTTP2ConnError(http2ErrCodeProtocol, "missing padding length")
+	}
+	padLen := int(payload[0])
+	payload = payload[1:]
+	if padLen > len(payload) {
+		return nil, newHTTP2ConnError(http2ErrCodeProtocol, "too big padding")
+	}
+	return payload[:len(payload)-padLen], nil
+}
+
+func (hc *http2Conn) lookupStream(id uint32) *http2Stream {
+	hc.mu.Lock()
+	st := hc.streams[id]
+	hc.mu.Unlock()
+	return st
+}
+
+func (hc *http2Conn) handleData(payload []byte) error {
+	id := hc.fh.streamID
+	if id == 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "DATA frame on stream 0")
+	}
+	if id > hc.maxStreamID {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "DATA frame on idle stream")
+	}
+
+	// The whole frame payload including padding is subject to flow control.
+	n := int32(len(payload))
+	hc.mu.Lock()
+	hc.recvWindow -= n
+	windowExceeded := hc.recvWindow < 0
+	hc.mu.Unlock()
+	if windowExceeded {
+		return newHTTP2ConnError(http2ErrCodeFlowControl, "connection flow-control window exceeded")
+	}
+	data, err := hc.stripPadding(payload)
+	if err != nil {
+		return err
+	}
+
+	st := hc.lookupStream(id)
+	if st == nil || st.remoteClosed {
+		// Nobody reads data sent to closed streams.
+		if err = hc.updateRecvWindow(nil, n); err != nil {
+			return err
+		}
+		return hc.writeRSTStream(id, http2ErrCodeStreamClosed)
+	}
+	endStream := hc.fh.flags&http2FlagEndStream != 0
+	st.bodyLen += len(data)
+	if st.contentLength >= 0 && st.bodyLen > st.contentLength {
+		if err = hc.updateRecvWindow(nil, n); err != nil {
+			return err
+		}
+		return hc.streamError(st, http2ErrCodeProtocol)
+	}
+
+	hc.mu.Lock()
+	st.recvWindow -= n
+	if st.recvWindow < 0 {
+		hc.mu.Unlock()
+		if err = hc.updateRecvWindow(nil, n); err != nil {
+			return err
+		}
+		return hc.streamError(st, http2ErrCodeFlowControl)
+	}
+	// Padding is consumed immediately, while data is consumed
+	// when the handler reads the request body.
+	consumed := n - int32(len(data))
+	if st.reset || hc.streams[id] != st {
+		// The handler doesn't read the request body anymore.
+		consumed = n
+	} else {
+		st.body.Write(data)
+		hc.cond.Broadcast()
+	}
+	hc.mu.Unlock()
+
+	if err = hc.updateRecvWindow(st, consumed); err != nil {
+		return err
+	}
+	if endStream {
+		return hc.endRequest(st)
+	}
+	return nil
+}
+
+// updateRecvWindow returns n consumed bytes to the connection flow-control
+// window and to st window if st isn't nil.
+func (hc *http2Conn) updateRecvWindow(st *http2Stream, n int32) error {
+	if n <= 0 {
+		return nil
+	}
+	hc.mu.Lock()
+	hc.recvWindow += n
+	updateStream := st != nil && !st.bodyEnd && !st.reset
+	if updateStream {
+		st.recvWindow += n
+	}
+	hc.mu.Unlock()
+
+	if err := hc.writeWindowUpdate(0, uint32(n)); err != nil {
+		return err
+	}
+	if updateStream {
+		return hc.writeWindowUpdate(st.id, uint32(n))
+	}
+	return nil
+}
+
+func (hc *http2Conn) handleHeaders(payload []byte) error {
+	if hc.fh.streamID == 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "HEADERS frame on stream 0")
+	}
+	payload, err := hc.stripPadding(payload)
+	if err != nil {
+		return err
+	}
+	if hc.fh.flags&http2FlagPriority != 0 {
+		// Stream priorities are ignored.
+		if len(payload) < 5 {
+			return newHTTP2ConnError(http2ErrCodeFrameSize, "too short HEADERS frame")
+		}
+		payload = payload[5:]
+	}
+	hc.headerBlock = append(hc.headerBlock[:0], payload...)
+	hc.headerEndStream = hc.fh.flags&http2FlagEndStream != 0
+	if hc.fh.flags&http2FlagEndHeaders == 0 {
+		hc.headerStreamID = hc.fh.streamID
+		return nil
+	}
+	return hc.processHeaderBlock(hc.fh.streamID)
+}
+
+func (hc *http2Conn) handleContinuation(payload []byte) error {
+	if hc.headerStreamID == 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "unexpected CONTINUATION frame")
+	}
+	hc.headerBlock = append(hc.headerBlock, payload...)
+	if len(hc.headerBlock) > hc.maxHeaderListSize+http2DefaultMaxFrameSize {
+		// Compressed header block cannot be much bigger
+		// than the decoded header list.
+		return newHTTP2ConnError(http2ErrCodeProtocol, "too big header block")
+	}
+	if hc.fh.flags&http2FlagEndHeaders == 0 {
+		return nil
+	}
+	hc.headerStreamID = 0
+	return hc.processHeaderBlock(hc.fh.streamID)
+}
+
+func (hc *http2Conn) processHeaderBlock(id uint32) error {
+	if st := hc.lookupStream(id); st != nil {
+		// Trailers. They are ignored.
+		if err := hc.decodeHeaderBlock(nil); err != nil && err != errHPACKHeaderListTooBig {
+			return newHTTP2ConnError(http2ErrCodeCompression, err.Error())
+		}
+		if st.remoteClosed {
+			return hc.streamError(st, http2ErrCodeStreamClosed)
+		}
+		if !hc.headerEndStream {
+			return hc.streamError(st, http2ErrCodeProtocol)
+		}
+		return hc.endRequest(st)
+	}
+
+	if id%2 == 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "invalid stream identifier")
+	}
+	if id <= hc.maxStreamID {
+		// The stream has been closed by the server before receiving
+		// the whole request. The header block must be decoded anyway
+		// in order to keep the decoder state in sync with the client.
+		if err := hc.decodeHeaderBlock(nil); err != nil && err != errHPACKHeaderListTooBig {
+			return newHTTP2ConnError(http2ErrCodeCompression, err.Error())
+		}
+		return hc.writeRSTStream(id, http2ErrCodeStreamClosed)
+	}
+	hc.maxStreamID = id
+
+	s := hc.s
+	hc.mu.Lock()
+	refuse := hc.goAwaySent || len(hc.streams) >= hc.maxStreams
+	hc.mu.Unlock()
+	if !refuse && atomic.LoadUint32(&s.http2Streams) >= uint32(s.getConcurrency()) {
+		refuse = true
+	}
+	if refuse {
+		// The header block must be decoded anyway in order to keep
+		// the decoder state in sync with the client.
+		if err := hc.decodeHeaderBlock(nil); err != nil && err != errHPACKHeaderListTooBig {
+			return newHTTP2ConnError(http2ErrCodeCompression, err.Error())
+		}
+		if hc.isGoingAway() {
+			// Streams initiated after GOAWAY are ignored.
+			return nil
+		}
+		return hc.writeRSTStream(id, http2ErrCodeRefusedStream)
+	}
+
+	ctx := s.acquireCtx(hc.c)
+	ctx.Request.Reset()
+	err := hc.decodeHeaderBlock(&ctx.Request)
+	st := hc.newStream(id, ctx)
+	if err != nil {
+		if err == errHPACKHeaderListTooBig {
+			return hc.refuseStream(st, hc.headerEndStream, StatusRequestHeaderFieldsTooLarge)
+		}
+		hc.abortStream(st)
+		return newHTTP2ConnError(http2ErrCodeCompression, err.Error())
+	}
+	if hc.hdrErr != nil {
+		return hc.streamError(st, http2ErrCodeProtocol)
+	}
+	st.contentLength = -1
+	h := &ctx.Request.Header
+	if len(h.contentLengthBytes) > 0 && !h.noBody() {
+		if st.contentLength = h.ContentLength(); st.contentLength < 0 {
+			return hc.streamError(st, http2ErrCodeProtocol)
+		}
+	}
+	if hc.headerEndStream {
+		if err = hc.endRequest(st); err != nil {
+			return err
+		}
+		if hc.lookupStream(id) == nil {
+			// The stream has been reset by endRequest.
+			return nil
+		}
+	}
+	hc.startHandler(st)
+	return nil
+}
+
+// decodeHeaderBlock decodes the received header block into req.
+//
+// The decoded header fields are ignored if req is nil.
+// Malformed request errors are stored in hc.hdrErr.
+func (hc *http2Conn) decodeHeaderBlock(req *Request) error {
+	hc.hreq = req
+	hc.hpseudo = 0
+	hc.hsawRegular = false
+	hc.hdrErr = nil
+	err := hc.dec.decode(hc.headerBlock, hc.onHeaderField)
+	hc.hreq = nil
+	if err != nil || req == nil || hc.hdrErr != nil {
+		return err
+	}
+
+	// Verify mandatory pseudo-headers.
+	if bytes.Equal(req.Header.Method(), strConnect) {
+		if hc.hpseudo != http2PseudoMethod|http2PseudoAuthority {
+			hc.hdrErr = errHTTP2Malformed
+			return nil
+		}
+		req.Header.SetRequestURIBytes(req.Header.Host())
+	} else if hc.hpseudo&(http2PseudoMethod|http2PseudoPath|http2PseudoScheme) != http2PseudoMethod|http2PseudoPath|http2PseudoScheme {
+		hc.hdrErr = errHTTP2Malformed
+	}
+	return nil
+}
+
+func (hc *http2Conn) handleHeaderField(name, value []byte) error {
+	req := hc.hreq
+	if req == nil || hc.hdrErr != nil {
+		return nil
+	}
+	h := &req.Header
+
+	if len(name) > 0 && name[0] == ':' {
+		if hc.hsawRegular {
+			hc.hdrErr = errHTTP2Malformed
+			return nil
+		}
+		var flag byte
+		switch string(name) {
+		case ":method":
+			flag = http2PseudoMethod
+			h.SetMethodBytes(value)
+		case ":path":
+			flag = http2PseudoPath
+			if len(value) == 0 {
+				hc.hdrErr = errHTTP2Malformed
+				return nil
+			}
+			h.SetRequestURIBytes(value)
+		case ":scheme":
+			flag = http2PseudoScheme
+		case ":authority":
+			flag = http2PseudoAuthority
+			h.SetHostBytes(value)
+		default:
+			hc.hdrErr = errHTTP2Malformed
+			return nil
+		}
+		if hc.hpseudo&flag != 0 {
+			hc.hdrErr = errHTTP2Malformed
+			return nil
+		}
+		hc.hpseudo |= flag
+		return nil
+	}
+
+	hc.hsawRegular = true
+	for _, c := range name {
+		if c >= 'A' && c <= 'Z' {
+			// Header names must be lowercase in HTTP/2.
+			hc.hdrErr = errHTTP2Malformed
+			return nil
+		}
+	}
+	if isHTTP2ConnectionHeader(name, value) {
+		hc.hdrErr = errHTTP2Malformed
+		return nil
+	}
+	if string(name) == "host" && hc.hpseudo&http2PseudoAuthority != 0 {
+		// :authority takes precedence over Host header.
+		return nil
+	}
+	h.SetBytesKV(name, value)
+	return nil
+}
+
+// isHTTP2ConnectionHeader returns true if the given lowercase header
+// is connection-specific, so it is forbidden in HTTP/2.
+func isHTTP2ConnectionHeader(name, value []byte) bool {
+	switch string(name) {
+	case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+		return true
+	case "te":
+		return string(value) != "trailers"
+	}
+	return false
+}
+
+func (hc *http2Conn) newStream(id uint32, ctx *RequestCtx) *http2Stream {
+	st := &http2Stream{
+		id:         id,
+		ctx:        ctx,
+		recvWindow: http2DefaultWindowSize,
+	}
+	atomic.AddUint32(&hc.s.http2Streams, 1)
+	hc.mu.Lock()
+	st.sendWindow = hc.initialWindowSize
+	hc.streams[id] = st
+	hc.lastStreamID = id
+	hc.mu.Unlock()
+	return st
+}
+
+// closeStream removes st from the connection.
+func (hc *http2Conn) closeStream(st *http2Stream) {
+	hc.mu.Lock()
+	unread := int32(st.body.Len())
+	st.body.Reset()
+	hc.cond.Broadcast()
+	if _, ok := hc.streams[st.id]; ok {
+		delete(hc.streams, st.id)
+		atomic.AddUint32(&hc.s.http2Streams, ^uint32(0))
+	}
+	if hc.goAwaySent && len(hc.streams) == 0 {
+		// Wake up the reading goroutine, so it could close the connection.
+		hc.c.SetReadDeadline(shutdownTime)
+	}
+	hc.mu.Unlock()
+
+	// Return the unread request body to the connection flow-control window.
+	hc.updateRecvWindow(nil, unread)
+}
+
+// abortStream marks st as reset and closes it if the handler
+// isn't running.
+//
+// Must be called by the reading goroutine.
+func (hc *http2Conn) abortStream(st *http2Stream) {
+	hc.mu.Lock()
+	st.reset = true
+	hc.cond.Broadcast()
+	hc.mu.Unlock()
+	st.remoteClosed = true
+	if !st.handlerStarted {
+		hc.closeStream(st)
+		hc.s.releaseCtx(st.ctx)
+	}
+}
+
+// resetStream resets st with the given error code.
+//
+// Must be called by the goroutine serving st.
+func (hc *http2Conn) resetStream(st *http2Stream, code uint32) {
+	hc.mu.Lock()
+	st.reset = true
+	hc.mu.Unlock()
+	hc.writeRSTStream(st.id, code)
+}
+
+func (hc *http2Conn) isStreamReset(st *http2Stream) bool {
+	hc.mu.Lock()
+	reset := st.reset
+	hc.mu.Unlock()
+	return reset
+}
+
+// streamError resets st with the given error code.
+//
+// Must be called by the reading goroutine.
+func (hc *http2Conn) streamError(st *http2Stream, code uint32) error {
+	hc.abortStream(st)
+	return hc.writeRSTStream(st.id, code)
+}
+
+// refuseStream sends response with the given status code without calling
+// the handler.
+//
+// Must be called by the reading goroutine.
+func (hc *http2Conn) refuseStream(st *http2Stream, remoteClosed bool, statusCode int) error {
+	hc.abortStream(st)
+
+	var h ResponseHeader
+	h.SetStatusCode(statusCode)
+	h.SetContentLength(0)
+	err := hc.writeHeaders(st.id, &h, true)
+	if err == nil && !remoteClosed {
+		// There is no need in the rest of the request.
+		err = hc.writeRSTStream(st.id, http2ErrCodeNo)
+	}
+	return err
+}
+
+// endRequest marks the request body on st as fully received.
+//
+// Must be called by the reading goroutine.
+func (hc *http2Conn) endRequest(st *http2Stream) error {
+	st.remoteClosed = true
+	if st.contentLength >= 0 && st.bodyLen != st.contentLength {
+		return hc.streamError(st, http2ErrCodeProtocol)
+	}
+	hc.mu.Lock()
+	st.bodyEnd = true
+	hc.cond.Broadcast()
+	hc.mu.Unlock()
+	return nil
+}
+
+// startHandler starts serving st.
+//
+// The request body is read by the goroutine serving st if it isn't
+// received yet. See http2RequestBody for details.
+func (hc *http2Conn) startHandler(st *http2Stream) {
+	st.handlerStarted = true
+	hc.requestNum++
+	ctx := st.ctx
+	if !st.remoteClosed {
+		ctx.Request.bodyStream = &http2RequestBody{
+			hc: hc,
+			st: st,
+		}
+		if st.contentLength < 0 && hc.s.StreamRequestBody {
+			ctx.Request.Header.SetContentLength(-1)
+		}
+	}
+	ctx.id++
+	ctx.connRequestNum = hc.requestNum
+	ctx.connTime = hc.connTime
+	ctx.time = time.Now()
+	hc.wg.Add(1)
+	go hc.serveStream(st)
+}
+
+func (hc *http2Conn) serveStream(st *http2Stream) {
+	s := hc.s
+	ctx := st.ctx
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger().Printf("panic: %s\nStack trace:\n%s", r, debug.Stack())
+			hc.resetStream(st, http2ErrCodeInternal)
+			hc.closeStream(st)
+		}
+		hc.wg.Done()
+	}()
+
+	ctx.Response.Reset()
+	if hc.readRequestBody(st) {
+		s.Handler(ctx)
+	}
+
+	// Connection hijacking isn't supported for HTTP/2.
+	ctx.hijackHandler = nil
+	ctx.userValues.Reset()
+
+	// Remove temporary files, which may be uploaded during the request.
+	ctx.Request.RemoveMultipartFormFiles()
+
+	resp := &ctx.Response
+	timeoutResponse := ctx.timeoutResponse
+	if timeoutResponse != nil {
+		resp = timeoutResponse
+	}
+	err := hc.writeResponse(st, resp, ctx.Request.Header.IsHead())
+	if err == nil && !hc.isRequestReceived(st) {
+		// There is no need in the rest of the request.
+		hc.resetStream(st, http2ErrCodeNo)
+	}
+	if err == nil && (resp.ConnectionClose() || atomic.LoadInt32(&s.stop) == 1) {
+		hc.goAway(http2ErrCodeNo)
+	}
+	hc.closeStream(st)
+
+	if timeoutResponse == nil {
+		// ctx may be still used by the handler if it timed out.
+		ctx.Request.bodyStream = nil
+		s.releaseCtx(ctx)
+	}
+}
+
+// readRequestBody reads the request body on st into memory
+// unless Server.StreamRequestBody is set.
+//
+// false is returned if the handler mustn't be called.
+func (hc *http2Conn) readRequestBody(st *http2Stream) bool {
+	req := &st.ctx.Request
+	if req.bodyStream == nil || hc.s.StreamRequestBody {
+		return true
+	}
+	r := req.bodyStream
+	req.bodyStream = nil
+
+	maxBodySize := hc.s.MaxRequestBodySize
+	if maxBodySize > 0 {
+		r = io.LimitReader(r, int64(maxBodySize)+1)
+	}
+	b := bytes.NewBuffer(req.body[:0])
+	_, err := b.ReadFrom(r)
+	req.body = b.Bytes()
+	if err != nil {
+		// The stream has been reset.
+		return false
+	}
+	if maxBodySize > 0 && len(req.body) > maxBodySize {
+		st.ctx.Error(StatusMessage(StatusRequestEntityTooLarge), StatusRequestEntityTooLarge)
+		return false
+	}
+	if len(req.Header.contentLengthBytes) == 0 && len(req.body) > 0 {
+		req.Header.SetContentLength(len(req.body))
+	}
+	return true
+}
+
+// isRequestReceived returns true if the whole request on st
+// has been received.
+func (hc *http2Conn) isRequestReceived(st *http2Stream) bool {
+	hc.mu.Lock()
+	bodyEnd := st.bodyEnd
+	hc.mu.Unlock()
+	return bodyEnd
+}
+
+// http2RequestBody reads the request body received on the stream.
+//
+// The read data is returned to flow-control windows, so the client
+// cannot send more data than the handler reads.
+type http2RequestBody struct {
+	hc *http2Conn
+	st *http2Stream
+}
+
+func (r *http2RequestBody) Read(p []byte) (int, error) {
+	hc := r.hc
+	st := r.st
+	hc.mu.Lock()
+	for {
+		if hc.closed || st.reset || hc.streams[st.id] != st {
+			hc.mu.Unlock()
+			return 0, errHTTP2StreamClosed
+		}
+		if st.body.Len() > 0 {
+			break
+		}
+		if st.bodyEnd {
+			hc.mu.Unlock()
+			return 0, io.EOF
+		}
+		hc.cond.Wait()
+	}
+	n, _ := st.body.Read(p)
+	hc.mu.Unlock()
+
+	if err := hc.updateRecvWindow(st, int32(n)); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (hc *http2Conn) writeResponse(st *http2Stream, resp *Response, noBody bool) error {
+	bodySize := int64(len(resp.body))
+	if resp.bodyStream != nil {
+		resp.initByteRangesBody()
+		bodySize = int64(resp.Header.ContentLength())
+		if bodySize < 0 {
+			bodySize = limitedReaderSize(resp.bodyStream)
+		}
+	}
+	if bodySize >= 0 && int64(int(bodySize)) == bodySize {
+		resp.Header.SetContentLength(int(bodySize))
+	}
+	endStream := noBody || bodySize == 0
+
+	if hc.isStreamReset(st) {
+		resp.closeBodyStream()
+		return errHTTP2StreamClosed
+	}
+	if err := hc.writeHeaders(st.id, &resp.Header, endStream); err != nil {
+		resp.closeBodyStream()
+		return err
+	}
+	if endStream {
+		return resp.closeBodyStream()
+	}
+
+	if resp.bodyStream == nil {
+		return hc.writeData(st, resp.body, true)
+	}
+	err := hc.writeDataStream(st, resp.bodyStream, bodySize)
+	if err1 := resp.closeBodyStream(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (hc *http2Conn) writeHeaders(id uint32, h *ResponseHeader, endStream bool) error {
+	hc.wmu.Lock()
+	defer hc.wmu.Unlock()
+
+	b := hc.appendResponseHeaders(hc.wbuf[:0], h)
+	hc.wbuf = b
+	if err := hc.setWriteDeadline(); err != nil {
+		return err
+	}
+	typ := byte(http2FrameHeaders)
+	var flags byte
+	if endStream {
+		flags = http2FlagEndStream
+	}
+	for {
+		n := len(b)
+		if n > http2DefaultMaxFrameSize {
+			n = http2DefaultMaxFrameSize
+		} else {
+			flags |= http2FlagEndHeaders
+		}
+		hc.writeFrameHeader(n, typ, flags, id)
+		hc.bw.Write(b[:n])
+		b = b[n:]
+		if len(b) == 0 {
+			break
+		}
+		typ = http2FrameContinuation
+		flags = 0
+	}
+	return hc.bw.Flush()
+}
+
+func (hc *http2Conn) appendResponseHeaders(dst []byte, h *ResponseHeader) []byte {
+	statusCode := h.StatusCode()
+	if statusCode <= 0 {
+		statusCode = StatusOK
+	}
+	hc.keyBuf = AppendUint(hc.keyBuf[:0], statusCode)
+	dst = hpackAppendHeader(dst, strH2Status, hc.keyBuf)
+
+	server := h.Server()
+	if len(server) == 0 {
+		server = hc.s.getServerName()
+	}
+	dst = hpackAppendHeader(dst, strH2Server, server)
+	dst = hpackAppendHeader(dst, strH2Date, serverDate.Load().([]byte))
+	dst = hc.appendResponseHeader(dst, strContentType, h.ContentType())
+	if len(h.contentLengthBytes) > 0 {
+		dst = hc.appendResponseHeader(dst, strContentLength, h.contentLengthBytes)
+	}
+	for i, n := 0, len(h.h); i < n; i++ {
+		kv := &h.h[i]
+		dst = hc.appendResponseHeader(dst, kv.key, kv.value)
+	}
+	for i, n := 0, len(h.cookies); i < n; i++ {
+		kv := &h.cookies[i]
+		dst = hc.appendResponseHeader(dst, strSetCookie, kv.value)
+	}
+	return dst
+}
+
+func (hc *http2Conn) appendResponseHeader(dst, key, value []byte) []byte {
+	hc.keyBuf = append(hc.keyBuf[:0], key...)
+	lowercaseBytes(hc.keyBuf)
+	if isHTTP2ConnectionHeader(hc.keyBuf, value) {
+		return dst
+	}
+	return hpackAppendHeader(dst, hc.keyBuf, value)
+}
+
+var http2DataBufPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, http2DefaultMaxFrameSize)
+	},
+}
+
+// writeDataStream sends up to size bytes from r on st.
+//
+// The whole r contents is sent if size is negative.
+func (hc *http2Conn) writeDataStream(st *http2Stream, r io.Reader, size int64) error {
+	vbuf := http2DataBufPool.Get()
+	buf := vbuf.([]byte)
+	var err error
+	for {
+		b := buf
+		if size >= 0 && size < int64(len(b)) {
+			b = b[:size]
+		}
+		var n int
+		if len(b) > 0 {
+			n, err = r.Read(b)
+		} else {
+			err = io.EOF
+		}
+		if n > 0 {
+			if size >= 0 {
+				size -= int64(n)
+			}
+			if err1 := hc.writeData(st, b[:n], false); err1 != nil {
+				err = err1
+				break
+			}
+		}
+		if err == io.EOF {
+			err = hc.writeData(st, nil, true)
+			break
+		}
+		if err != nil {
+			hc.resetStream(st, http2ErrCodeInternal)
+			break
+		}
+	}
+	http2DataBufPool.Put(vbuf)
+	return err
+}
+
+func (hc *http2Conn) writeData(st *http2Stream, b []byte, endStream bool) error {
+	if len(b) == 0 {
+		if !endStream {
+			return nil
+		}
+		if hc.isStreamReset(st) {
+			return errHTTP2StreamClosed
+		}
+		return hc.writeFrame(http2FrameData, http2FlagEndStream, st.id, nil)
+	}
+	for len(b) > 0 {
+		n, err := hc.acquireWindow(st, len(b))
+		if err != nil {
+			return err
+		}
+		var flags byte
+		if endStream && n == len(b) {
+			flags = http2FlagEndStream
+		}
+		if err = hc.writeFrame(http2FrameData, flags, st.id, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// acquireWindow waits until the flow-control windows allow sending
+// up to n bytes on st and returns the number of bytes that may be sent.
+func (hc *http2Conn) acquireWindow(st *http2Stream, n int) (int, error) {
+	if n > http2DefaultMaxFrameSize {
+		n = http2DefaultMaxFrameSize
+	}
+	hc.mu.Lock()
+	for {
+		if hc.closed {
+			hc.mu.Unlock()
+			return 0, errHTTP2ConnClosed
+		}
+		if st.reset {
+			hc.mu.Unlock()
+			return 0, errHTTP2StreamClosed
+		}
+		w := int32(n)
+		if hc.sendWindow < w {
+			w = hc.sendWindow
+		}
+		if st.sendWindow < w {
+			w = st.sendWindow
+		}
+		if w > 0 {
+			hc.sendWindow -= w
+			st.sendWindow -= w
+			hc.mu.Unlock()
+			return int(w), nil
+		}
+		hc.cond.Wait()
+	}
+}
+
+func (hc *http2Conn) handlePriority(payload []byte) error {
+	if hc.fh.streamID == 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "PRIORITY frame on stream 0")
+	}
+	if len(payload) != 5 {
+		return hc.writeRSTStream(hc.fh.streamID, http2ErrCodeFrameSize)
+	}
+	// Stream priorities are ignored.
+	return nil
+}
+
+func (hc *http2Conn) handleRSTStream(payload []byte) error {
+	id := hc.fh.streamID
+	if id == 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "RST_STREAM frame on stream 0")
+	}
+	if len(payload) != 4 {
+		return newHTTP2ConnError(http2ErrCodeFrameSize, "invalid RST_STREAM frame size")
+	}
+	if id > hc.maxStreamID {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "RST_STREAM frame on idle stream")
+	}
+	if st := hc.lookupStream(id); st != nil {
+		hc.abortStream(st)
+	}
+	return nil
+}
+
+func (hc *http2Conn) handleSettings(payload []byte) error {
+	if hc.fh.streamID != 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "SETTINGS frame on non-zero stream")
+	}
+	if hc.fh.flags&http2FlagAck != 0 {
+		if len(payload) != 0 {
+			return newHTTP2ConnError(http2ErrCodeFrameSize, "non-empty SETTINGS ack")
+		}
+		return nil
+	}
+	if len(payload)%6 != 0 {
+		return newHTTP2ConnError(http2ErrCodeFrameSize, "invalid SETTINGS frame size")
+	}
+	if err := hc.applySettings(payload); err != nil {
+		return err
+	}
+	return hc.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+}
+
+func (hc *http2Conn) applySettings(b []byte) error {
+	for len(b) >= 6 {
+		id := binary.BigEndian.Uint16(b)
+		v := binary.BigEndian.Uint32(b[2:])
+		b = b[6:]
+		switch id {
+		case http2SettingEnablePush:
+			if v > 1 {
+				return newHTTP2ConnError(http2ErrCodeProtocol, "invalid SETTINGS_ENABLE_PUSH")
+			}
+		case http2SettingInitialWindowSize:
+			if v > http2MaxWindowSize {
+				return newHTTP2ConnError(http2ErrCodeFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE")
+			}
+			if err := hc.setInitialWindowSize(int32(v)); err != nil {
+				return err
+			}
+		case http2SettingMaxFrameSize:
+			if v < http2DefaultMaxFrameSize || v > http2MaxFrameSize {
+				return newHTTP2ConnError(http2ErrCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE")
+			}
+			// Outgoing frames never exceed the default max frame size.
+		}
+		// The encoder doesn't use the dynamic table, so
+		// SETTINGS_HEADER_TABLE_SIZE may be ignored. Other settings
+		// are irrelevant for the server.
+	}
+	return nil
+}
+
+func (hc *http2Conn) setInitialWindowSize(n int32) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	delta := int64(n) - int64(hc.initialWindowSize)
+	hc.initialWindowSize = n
+	for _, st := range hc.streams {
+		w := int64(st.sendWindow) + delta
+		if w > http2MaxWindowSize {
+			return newHTTP2ConnError(http2ErrCodeFlowControl, "stream flow-control window overflow")
+		}
+		st.sendWindow = int32(w)
+	}
+	hc.cond.Broadcast()
+	return nil
+}
+
+func (hc *http2Conn) handlePing(payload []byte) error {
+	if hc.fh.streamID != 0 {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "PING frame on non-zero stream")
+	}
+	if len(payload) != 8 {
+		return newHTTP2ConnError(http2ErrCodeFrameSize, "invalid PING frame size")
+	}
+	if hc.fh.flags&http2FlagAck != 0 {
+		return nil
+	}
+	return hc.writeFrame(http2FramePing, http2FlagAck, 0, payload)
+}
+
+func (hc *http2Conn) handleWindowUpdate(payload []byte) error {
+	id := hc.fh.streamID
+	if len(payload) != 4 {
+		return newHTTP2ConnError(http2ErrCodeFrameSize, "invalid WINDOW_UPDATE frame size")
+	}
+	inc := int64(binary.BigEndian.Uint32(payload) & (1<<31 - 1))
+	if id == 0 {
+		if inc == 0 {
+			return newHTTP2ConnError(http2ErrCodeProtocol, "zero WINDOW_UPDATE increment")
+		}
+		hc.mu.Lock()
+		w := int64(hc.sendWindow) + inc
+		if w > http2MaxWindowSize {
+			hc.mu.Unlock()
+			return newHTTP2ConnError(http2ErrCodeFlowControl, "connection flow-control window overflow")
+		}
+		hc.sendWindow = int32(w)
+		hc.cond.Broadcast()
+		hc.mu.Unlock()
+		return nil
+	}
+
+	if id > hc.maxStreamID {
+		return newHTTP2ConnError(http2ErrCodeProtocol, "WINDOW_UPDATE frame on idle stream")
+	}
+	st := hc.lookupStream(id)
+	if st == nil {
+		// The stream is closed.
+		return nil
+	}
+	if inc == 0 {
+		return hc.streamError(st, http2ErrCodeProtocol)
+	}
+	hc.mu.Lock()
+	w := int64(st.sendWindow) + inc
+	if w > http2MaxWindowSize {
+		hc.mu.Unlock()
+		return hc.streamError(st, http2ErrCodeFlowControl)
+	}
+	st.sendWindow = int32(w)
+	hc.cond.Broadcast()
+	hc.mu.Unlock()
+	return nil
+}
+
+func (hc *http2Conn) isGoingAway() bool {
+	hc.mu.Lock()
+	goAwaySent := hc.goAwaySent
+	hc.mu.Unlock()
+	return goAwaySent
+}
+
+// goAway sends GOAWAY frame with the given error code, so the client
+// stops sending new requests over the connection.
+//
+// The connection is closed after serving active streams.
+func (hc *http2Conn) goAway(code uint32) error {
+	hc.mu.Lock()
+	if hc.goAwaySent {
+		hc.mu.Unlock()
+		return nil
+	}
+	hc.goAwaySent = true
+	lastStreamID := hc.lastStreamID
+	if len(hc.streams) == 0 {
+		hc.c.SetReadDeadline(shutdownTime)
+	}
+	hc.mu.Unlock()
+
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:], lastStreamID)
+	binary.BigEndian.PutUint32(b[4:], code)
+	return hc.writeFrame(http2FrameGoAway, 0, 0, b[:])
+}
+
+func (hc *http2Conn) close(err error) {
+	if ce, ok := err.(*http2ConnError); ok {
+		hc.goAway(ce.code)
+	}
+
+	hc.mu.Lock()
+	hc.closed = true
+	hc.cond.Broadcast()
+	hc.mu.Unlock()
+	if err != nil && err != io.EOF {
+		// Interrupt pending writes.
+		hc.c.SetWriteDeadline(shutdownTime)
+	}
+	hc.wg.Wait()
+}
+
+func (hc *http2Conn) writeSettings() error {
+	var b [12]byte
+	binary.BigEndian.PutUint16(b[:], http2SettingMaxConcurrentStreams)
+	binary.BigEndian.PutUint32(b[2:], uint32(hc.maxStreams))
+	binary.BigEndian.PutUint16(b[6:], http2SettingMaxHeaderListSize)
+	binary.BigEndian.PutUint32(b[8:], uint32(hc.maxHeaderListSize))
+	return hc.writeFrame(http2FrameSettings, 0, 0, b[:])
+}
+
+func (hc *http2Conn) writeWindowUpdate(id, n uint32) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	return hc.writeFrame(http2FrameWindowUpdate, 0, id, b[:])
+}
+
+func (hc *http2Conn) writeRSTStream(id, code uint32) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], code)
+	return hc.writeFrame(http2FrameRSTStream, 0, id, b[:])
+}
+
+func (hc *http2Conn) writeFrame(typ, flags byte, id uint32, payload []byte) error {
+	hc.wmu.Lock()
+	err := hc.setWriteDeadline()
+	if err == nil {
+		hc.writeFrameHeader(len(payload), typ, flags, id)
+		hc.bw.Write(payload)
+		err = hc.bw.Flush()
+	}
+	hc.wmu.Unlock()
+	return err
+}
+
+func (hc *http2Conn) writeFrameHeader(length int, typ, flags byte, id uint32) {
+	var b [http2FrameHeaderSize]byte
+	b[0] = byte(length >> 16)
+	b[1] = byte(length >> 8)
+	b[2] = byte(length)
+	b[3] = typ
+	b[4] = flags
+	binary.BigEndian.PutUint32(b[5:], id)
+	hc.bw.Write(b[:])
+}
+
+func (hc *http2Conn) setWriteDeadline() error {
+	if hc.s.WriteTimeout <= 0 {
+		return nil
+	}
+	return hc.c.SetWriteDeadline(time.Now().Add(hc.s.WriteTimeout))
+}
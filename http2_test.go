@@ -0,0 +1,663 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type http2TestClient struct {
+	t   *testing.T
+	c   net.Conn
+	br  *bufio.Reader
+	dec hpackDecoder
+}
+
+type http2TestResponse struct {
+	statusCode int
+	header     map[string]string
+	body       []byte
+}
+
+func startHTTP2TestServer(t *testing.T, s *Server) (net.Listener, chan error) {
+	s.EnableHTTP2 = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- s.Serve(ln)
+	}()
+	return ln, ch
+}
+
+func newHTTP2TestClient(t *testing.T, addr string) *http2TestClient {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot dial %q: %s", addr, err)
+	}
+	hc := &http2TestClient{
+		t:  t,
+		c:  c,
+		br: bufio.NewReader(c),
+	}
+	hc.dec.setMaxTableSize(hpackDefaultTableSize)
+	if _, err = c.Write([]byte(http2Preface)); err != nil {
+		t.Fatalf("cannot write preface: %s", err)
+	}
+	hc.writeFrame(http2FrameSettings, 0, 0, nil)
+	return hc
+}
+
+func (hc *http2TestClient) writeFrame(typ, flags byte, id uint32, payload []byte) {
+	b := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[5:], id)
+	b = append(b, payload...)
+	if _, err := hc.c.Write(b); err != nil {
+		hc.t.Fatalf("cannot write frame: %s", err)
+	}
+}
+
+func (hc *http2TestClient) writeHeaders(id uint32, endStream bool, kvs ...string) {
+	var b []byte
+	for i := 0; i < len(kvs); i += 2 {
+		b = hpackAppendHeader(b, []byte(kvs[i]), []byte(kvs[i+1]))
+	}
+	flags := byte(http2FlagEndHeaders)
+	if endStream {
+		flags |= http2FlagEndStream
+	}
+	hc.writeFrame(http2FrameHeaders, flags, id, b)
+}
+
+func (hc *http2TestClient) writeGet(id uint32, path string) {
+	hc.writeHeaders(id, true, ":method", "GET", ":scheme", "http", ":path", path, ":authority", "example.com")
+}
+
+func (hc *http2TestClient) writeWindowUpdate(id, n uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	hc.writeFrame(http2FrameWindowUpdate, 0, id, b[:])
+}
+
+func (hc *http2TestClient) readFrame() (http2FrameHeader, []byte) {
+	hc.c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var h [http2FrameHeaderSize]byte
+	if _, err := io.ReadFull(hc.br, h[:]); err != nil {
+		hc.t.Fatalf("cannot read frame header: %s", err)
+	}
+	fh := http2FrameHeader{
+		length:   int(h[0])<<16 | int(h[1])<<8 | int(h[2]),
+		typ:      h[3],
+		flags:    h[4],
+		streamID: binary.BigEndian.Uint32(h[5:]),
+	}
+	payload := make([]byte, fh.length)
+	if _, err := io.ReadFull(hc.br, payload); err != nil {
+		hc.t.Fatalf("cannot read frame payload: %s", err)
+	}
+	return fh, payload
+}
+
+// readNextFrame skips SETTINGS and WINDOW_UPDATE frames
+// and returns the next frame.
+func (hc *http2TestClient) readNextFrame() (http2FrameHeader, []byte) {
+	for {
+		fh, payload := hc.readFrame()
+		switch fh.typ {
+		case http2FrameSettings:
+			if fh.flags&http2FlagAck == 0 {
+				hc.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+			}
+		case http2FrameWindowUpdate:
+		default:
+			return fh, payload
+		}
+	}
+}
+
+func (hc *http2TestClient) readResponse(id uint32) *http2TestResponse {
+	resp := &http2TestResponse{
+		header: make(map[string]string),
+	}
+	gotHeaders := false
+	for {
+		fh, payload := hc.readNextFrame()
+		if fh.streamID != id {
+			hc.t.Fatalf("unexpected frame type %d on stream %d. Expecting stream %d", fh.typ, fh.streamID, id)
+		}
+		switch fh.typ {
+		case http2FrameHeaders:
+			if gotHeaders {
+				hc.t.Fatalf("unexpected HEADERS frame")
+			}
+			gotHeaders = true
+			err := hc.dec.decode(payload, func(name, value []byte) error {
+				resp.header[string(name)] = string(value)
+				return nil
+			})
+			if err != nil {
+				hc.t.Fatalf("cannot decode headers: %s", err)
+			}
+			fmt.Sscanf(resp.header[":status"], "%d", &resp.statusCode)
+		case http2FrameData:
+			if !gotHeaders {
+				hc.t.Fatalf("unexpected DATA frame before HEADERS frame")
+			}
+			resp.body = append(resp.body, payload...)
+		default:
+			hc.t.Fatalf("unexpected frame type %d", fh.typ)
+		}
+		if fh.flags&http2FlagEndStream != 0 {
+			return resp
+		}
+	}
+}
+
+func (hc *http2TestClient) expectRSTStream(id, code uint32) {
+	fh, payload := hc.readNextFrame()
+	if fh.typ != http2FrameRSTStream {
+		hc.t.Fatalf("unexpected frame type %d. Expecting RST_STREAM", fh.typ)
+	}
+	if fh.streamID != id {
+		hc.t.Fatalf("unexpected stream id %d. Expecting %d", fh.streamID, id)
+	}
+	if c := binary.BigEndian.Uint32(payload); c != code {
+		hc.t.Fatalf("unexpected error code %d. Expecting %d", c, code)
+	}
+}
+
+func (hc *http2TestClient) expectGoAway(code uint32) {
+	fh, payload := hc.readNextFrame()
+	if fh.typ != http2FrameGoAway {
+		hc.t.Fatalf("unexpected frame type %d. Expecting GOAWAY", fh.typ)
+	}
+	if c := binary.BigEndian.Uint32(payload[4:]); c != code {
+		hc.t.Fatalf("unexpected error code %d. Expecting %d", c, code)
+	}
+}
+
+func TestHTTP2PriorKnowledge(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if !ctx.IsGet() {
+				t.Errorf("unexpected method %q. Expecting GET", ctx.Method())
+			}
+			if string(ctx.Host()) != "example.com" {
+				t.Errorf("unexpected host %q. Expecting %q", ctx.Host(), "example.com")
+			}
+			if string(ctx.Request.Header.Peek("X-Foo")) != "bar" {
+				t.Errorf("unexpected X-Foo header %q. Expecting %q", ctx.Request.Header.Peek("X-Foo"), "bar")
+			}
+			if string(ctx.Request.Header.Cookie("aaa")) != "bbb" {
+				t.Errorf("unexpected cookie %q. Expecting %q", ctx.Request.Header.Cookie("aaa"), "bbb")
+			}
+			ctx.Response.Header.Set("X-Path", string(ctx.Path()))
+			ctx.Response.Header.Set("Connection", "keep-alive")
+			ctx.SetContentType("text/html")
+			fmt.Fprintf(ctx, "hello, %s", ctx.QueryArgs().Peek("name"))
+		},
+	}
+	ln, ch := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	hc := newHTTP2TestClient(t, ln.Addr().String())
+	defer hc.c.Close()
+
+	for id := uint32(1); id < 10; id += 2 {
+		hc.writeHeaders(id, true, ":method", "GET", ":scheme", "http", ":path", "/foo/bar?name=h2",
+			":authority", "example.com", "x-foo", "bar", "cookie", "aaa=bbb")
+		resp := hc.readResponse(id)
+		if resp.statusCode != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.statusCode, StatusOK)
+		}
+		if string(resp.body) != "hello, h2" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.body, "hello, h2")
+		}
+		expectedHeaders := map[string]string{
+			"content-type":   "text/html",
+			"content-length": "9",
+			"x-path":         "/foo/bar",
+			"server":         string(defaultServerName),
+		}
+		for k, v := range expectedHeaders {
+			if resp.header[k] != v {
+				t.Fatalf("unexpected header %q value %q. Expecting %q", k, resp.header[k], v)
+			}
+		}
+		if _, ok := resp.header["connection"]; ok {
+			t.Fatalf("unexpected connection-specific header in HTTP/2 response")
+		}
+		if _, ok := resp.header["date"]; !ok {
+			t.Fatalf("missing date header")
+		}
+	}
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hc.expectGoAway(http2ErrCodeNo)
+	if err := <-ch; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestHTTP2RequestBody(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if !ctx.IsPost() {
+				t.Errorf("unexpected method %q. Expecting POST", ctx.Method())
+			}
+			if ctx.Request.Header.ContentLength() != len(ctx.PostBody()) {
+				t.Errorf("unexpected content-length %d. Expecting %d", ctx.Request.Header.ContentLength(), len(ctx.PostBody()))
+			}
+			ctx.Write(ctx.PostArgs().Peek("foo"))
+		},
+		MaxRequestBodySize: 1000,
+	}
+	ln, _ := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	hc := newHTTP2TestClient(t, ln.Addr().String())
+	defer hc.c.Close()
+
+	hc.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":path", "/",
+		"content-type", "application/x-www-form-urlencoded")
+	hc.writeFrame(http2FrameData, 0, 1, []byte("foo=ba"))
+	// padded DATA frame
+	hc.writeFrame(http2FrameData, http2FlagEndStream|http2FlagPadded, 1, []byte("\x03r&x=y\x00\x00\x00"))
+	resp := hc.readResponse(1)
+	if resp.statusCode != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.statusCode, StatusOK)
+	}
+	if string(resp.body) != "bar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.body, "bar")
+	}
+
+	// too big body
+	hc.writeHeaders(3, false, ":method", "POST", ":scheme", "http", ":path", "/")
+	hc.writeFrame(http2FrameData, 0, 3, bytes.Repeat([]byte("x"), 1001))
+	resp = hc.readResponse(3)
+	if resp.statusCode != StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.statusCode, StatusRequestEntityTooLarge)
+	}
+	hc.expectRSTStream(3, http2ErrCodeNo)
+}
+
+func TestHTTP2FlowControl(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/stream" {
+				ctx.SetBodyStream(bytes.NewReader(body), -1)
+				return
+			}
+			ctx.Write(body)
+		},
+	}
+	ln, _ := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	for _, path := range []string{"/", "/stream"} {
+		hc := newHTTP2TestClient(t, ln.Addr().String())
+
+		// Shrink the stream window, so the response is sent in small chunks.
+		settings := []byte{0, http2SettingInitialWindowSize, 0, 0, 0x10, 0}
+		hc.writeFrame(http2FrameSettings, 0, 0, settings)
+		hc.writeGet(1, path)
+
+		var result []byte
+		for {
+			fh, payload := hc.readNextFrame()
+			if fh.typ == http2FrameHeaders {
+				continue
+			}
+			if fh.typ != http2FrameData {
+				t.Fatalf("unexpected frame type %d", fh.typ)
+			}
+			if len(payload) > 0x1000 {
+				t.Fatalf("DATA frame exceeds flow-control window: %d bytes", len(payload))
+			}
+			result = append(result, payload...)
+			if fh.flags&http2FlagEndStream != 0 {
+				break
+			}
+			if len(payload) > 0 {
+				hc.writeWindowUpdate(1, uint32(len(payload)))
+				hc.writeWindowUpdate(0, uint32(len(payload)))
+			}
+		}
+		if !bytes.Equal(result, body) {
+			t.Fatalf("unexpected body received for %q: %d bytes. Expecting %d bytes", path, len(result), len(body))
+		}
+		hc.c.Close()
+	}
+}
+
+func TestHTTP2RequestBodyFlowControl(t *testing.T) {
+	readCh := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			<-readCh
+			body, err := ioutil.ReadAll(ctx.RequestBodyStream())
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			ctx.Write(body)
+		},
+		StreamRequestBody: true,
+	}
+	ln, _ := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	hc := newHTTP2TestClient(t, ln.Addr().String())
+	defer hc.c.Close()
+
+	body := bytes.Repeat([]byte("x"), 10000)
+	hc.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":path", "/")
+	hc.writeFrame(http2FrameData, 0, 1, body)
+
+	// The received data mustn't be returned to flow-control windows
+	// until the handler reads it.
+	hc.writeFrame(http2FramePing, 0, 0, []byte("12345678"))
+	for {
+		fh, _ := hc.readFrame()
+		if fh.typ == http2FrameWindowUpdate {
+			t.Fatalf("unexpected WINDOW_UPDATE frame on stream %d before reading the request body", fh.streamID)
+		}
+		if fh.typ == http2FramePing {
+			break
+		}
+		if fh.typ == http2FrameSettings && fh.flags&http2FlagAck == 0 {
+			hc.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+		}
+	}
+
+	close(readCh)
+	windows := make(map[uint32]int)
+	for windows[0] < len(body) || windows[1] < len(body) {
+		fh, payload := hc.readFrame()
+		if fh.typ != http2FrameWindowUpdate {
+			t.Fatalf("unexpected frame type %d. Expecting WINDOW_UPDATE", fh.typ)
+		}
+		windows[fh.streamID] += int(binary.BigEndian.Uint32(payload))
+	}
+	if windows[0] != len(body) || windows[1] != len(body) {
+		t.Fatalf("unexpected window updates %v. Expecting %d bytes for streams 0 and 1", windows, len(body))
+	}
+
+	hc.writeFrame(http2FrameData, http2FlagEndStream, 1, []byte("foo"))
+	resp := hc.readResponse(1)
+	if string(resp.body) != string(body)+"foo" {
+		t.Fatalf("unexpected body with length %d. Expecting length %d", len(resp.body), len(body)+3)
+	}
+}
+
+func TestHTTP2ReadTimeout(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			t.Errorf("unexpected handler call")
+		},
+		ReadTimeout: 100 * time.Millisecond,
+	}
+	ln, _ := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	hc := newHTTP2TestClient(t, ln.Addr().String())
+	defer hc.c.Close()
+
+	// The connection must be closed if the client doesn't send
+	// the request body in time.
+	hc.writeHeaders(1, false, ":method", "POST", ":scheme", "http", ":path", "/")
+	hc.c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(hc.br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestHTTP2ConcurrentStreams(t *testing.T) {
+	ch := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/wait":
+				<-ch
+			case "/release":
+				close(ch)
+			}
+			ctx.Write(ctx.Path())
+		},
+		Concurrency: 2,
+	}
+	ln, _ := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	hc := newHTTP2TestClient(t, ln.Addr().String())
+	defer hc.c.Close()
+
+	fh, payload := hc.readFrame()
+	if fh.typ != http2FrameSettings {
+		t.Fatalf("unexpected frame type %d. Expecting SETTINGS", fh.typ)
+	}
+	var maxStreams uint32
+	for b := payload; len(b) >= 6; b = b[6:] {
+		if binary.BigEndian.Uint16(b) == http2SettingMaxConcurrentStreams {
+			maxStreams = binary.BigEndian.Uint32(b[2:])
+		}
+	}
+	if maxStreams != 2 {
+		t.Fatalf("unexpected SETTINGS_MAX_CONCURRENT_STREAMS %d. Expecting 2", maxStreams)
+	}
+
+	hc.writeGet(1, "/wait")
+	// The stream 3 waits for the request body.
+	hc.writeHeaders(3, false, ":method", "POST", ":scheme", "http", ":path", "/release")
+	hc.writeGet(5, "/release")
+	hc.expectRSTStream(5, http2ErrCodeRefusedStream)
+
+	// Client resets the stream 3, so the stream 7 may be served
+	// after the stream 3 is closed.
+	hc.writeFrame(http2FrameRSTStream, 0, 3, []byte{0, 0, 0, 0x8})
+	for atomic.LoadUint32(&s.http2Streams) > 1 {
+		time.Sleep(time.Millisecond)
+	}
+	hc.writeGet(7, "/release")
+
+	resp := hc.readResponse(7)
+	if string(resp.body) != "/release" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.body, "/release")
+	}
+	resp = hc.readResponse(1)
+	if string(resp.body) != "/wait" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.body, "/wait")
+	}
+}
+
+func TestHTTP2PingAndErrors(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			t.Errorf("unexpected handler call")
+		},
+	}
+	ln, _ := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	hc := newHTTP2TestClient(t, ln.Addr().String())
+	defer hc.c.Close()
+
+	hc.writeFrame(http2FramePing, 0, 0, []byte("12345678"))
+	fh, payload := hc.readNextFrame()
+	if fh.typ != http2FramePing || fh.flags&http2FlagAck == 0 || string(payload) != "12345678" {
+		t.Fatalf("unexpected PING response: type %d, flags %d, payload %q", fh.typ, fh.flags, payload)
+	}
+
+	// uppercase header name
+	hc.writeHeaders(1, true, ":method", "GET", ":scheme", "http", ":path", "/", "X-Foo", "bar")
+	hc.expectRSTStream(1, http2ErrCodeProtocol)
+
+	// connection-specific header
+	hc.writeHeaders(3, true, ":method", "GET", ":scheme", "http", ":path", "/", "connection", "close")
+	hc.expectRSTStream(3, http2ErrCodeProtocol)
+
+	// missing :path
+	hc.writeHeaders(5, true, ":method", "GET", ":scheme", "http")
+	hc.expectRSTStream(5, http2ErrCodeProtocol)
+
+	// DATA on stream 0
+	hc.writeFrame(http2FrameData, 0, 0, []byte("foo"))
+	hc.expectGoAway(http2ErrCodeProtocol)
+}
+
+func TestHTTP2Upgrade(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if len(ctx.Request.Header.Peek("Upgrade")) > 0 {
+				t.Errorf("unexpected Upgrade header")
+			}
+			fmt.Fprintf(ctx, "%s %s %s", ctx.Method(), ctx.RequestURI(), ctx.PostBody())
+		},
+	}
+	ln, _ := startHTTP2TestServer(t, s)
+	defer ln.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial: %s", err)
+	}
+	defer c.Close()
+
+	settings := base64.RawURLEncoding.EncodeToString([]byte{0, http2SettingEnablePush, 0, 0, 0, 0})
+	req := "POST /foo HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: " + settings + "\r\nContent-Length: 3\r\n\r\nbar"
+	if _, err = c.Write([]byte(req)); err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+	hc := &http2TestClient{
+		t:  t,
+		c:  c,
+		br: bufio.NewReader(c),
+	}
+	hc.dec.setMaxTableSize(hpackDefaultTableSize)
+
+	var resp Response
+	if err = resp.Header.Read(hc.br); err != nil {
+		t.Fatalf("cannot read response: %s", err)
+	}
+	if resp.StatusCode() != StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+	}
+	if _, err = c.Write([]byte(http2Preface)); err != nil {
+		t.Fatalf("cannot write preface: %s", err)
+	}
+	hc.writeFrame(http2FrameSettings, 0, 0, nil)
+
+	r := hc.readResponse(1)
+	if string(r.body) != "POST /foo bar" {
+		t.Fatalf("unexpected body %q. Expecting %q", r.body, "POST /foo bar")
+	}
+
+	hc.writeGet(3, "/baz")
+	r = hc.readResponse(3)
+	if string(r.body) != "GET /baz " {
+		t.Fatalf("unexpected body %q. Expecting %q", r.body, "GET /baz ")
+	}
+}
+
+func TestHTTP2DisabledByDefault(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("foobar")
+		},
+	}
+	rw := &readWriter{}
+	rw.r.WriteString("GET / HTTP/1.1\r\nHost: aaa\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "foobar")
+}
+
+func TestHTTP2ServeHTTP1(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.Method())
+		},
+		EnableHTTP2: true,
+	}
+	rw := &readWriter{}
+	rw.r.WriteString("PUT / HTTP/1.1\r\nHost: aaa\r\nContent-Length: 0\r\n\r\n")
+	rw.r.WriteString("POST / HTTP/1.1\r\nHost: aaa\r\nContent-Length: 0\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "PUT")
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "POST")
+}
+
+func TestHTTP2TLS(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "path=%s", ctx.Path())
+		},
+		EnableHTTP2: true,
+	}
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load certificate: %s", err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	go s.Serve(ln)
+
+	c := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			ForceAttemptHTTP2: true,
+		},
+	}
+	defer c.CloseIdleConnections()
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(fmt.Sprintf("https://%s/foo%d", ln.Addr(), i))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("cannot read body: %s", err)
+		}
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("unexpected protocol %q. Expecting HTTP/2", resp.Proto)
+		}
+		expectedBody := fmt.Sprintf("path=/foo%d", i)
+		if string(body) != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+		}
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+			t.Fatalf("unexpected content-type %q", resp.Header.Get("Content-Type"))
+		}
+	}
+}
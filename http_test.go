@@ -3,7 +3,9 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"compress/zlib"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"strings"
@@ -406,6 +408,159 @@ func testSetResponseBodyStream(t *testing.T, body string, chunked bool) {
 	}
 }
 
+func TestResponseStreamBody(t *testing.T) {
+	body := createFixedBody(10001)
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	fmt.Fprintf(&w, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n%s", createChunkedBody(body))
+	fmt.Fprintf(&w, "HTTP/1.1 204 No Content\r\n\r\n")
+	fmt.Fprintf(&w, "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo")
+
+	br := bufio.NewReader(&w)
+	var resp Response
+	resp.StreamBody = true
+	for i := 0; i < 2; i++ {
+		if err := resp.Read(br); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(resp.Body()) > 0 {
+			t.Fatalf("unexpected non-empty body %q for streamed response", resp.Body())
+		}
+		b, err := ioutil.ReadAll(resp.BodyStream())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(b, body) {
+			t.Fatalf("unexpected body %q. Expecting %q", b, body)
+		}
+	}
+
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.BodyStream() != nil {
+		t.Fatalf("unexpected body stream for response without body")
+	}
+
+	resp.StreamBody = false
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.BodyStream() != nil {
+		t.Fatalf("unexpected body stream")
+	}
+	if string(resp.Body()) != "foo" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foo")
+	}
+}
+
+func TestSetRequestBodyStream(t *testing.T) {
+	testSetRequestBodyStream(t, "", false)
+
+	body := "foobar baz aaa bbb ccc"
+	testSetRequestBodyStream(t, body, false)
+
+	body = string(createFixedBody(10001))
+	testSetRequestBodyStream(t, body, false)
+}
+
+func TestSetRequestBodyStreamChunked(t *testing.T) {
+	testSetRequestBodyStream(t, "", true)
+
+	body := "foobar baz aaa bbb ccc"
+	testSetRequestBodyStream(t, body, true)
+
+	body = string(createFixedBody(10001))
+	testSetRequestBodyStream(t, body, true)
+}
+
+func testSetRequestBodyStream(t *testing.T, body string, chunked bool) {
+	var req Request
+	req.Header.SetHost("foobar.com")
+	req.Header.SetMethod("POST")
+
+	bodySize := len(body)
+	if chunked {
+		bodySize = -1
+	}
+	r := &testClosingReader{Reader: bytes.NewBufferString(body)}
+	req.SetBodyStream(r, bodySize)
+
+	var w bytes.Buffer
+	bw := bufio.NewWriter(&w)
+	if err := req.Write(bw); err != nil {
+		t.Fatalf("unexpected error when writing request: %s. body=%q", err, body)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unexpected error when flushing request: %s. body=%q", err, body)
+	}
+	if !r.closed {
+		t.Fatalf("body stream must be closed after writing the request")
+	}
+	if chunked != strings.Contains(w.String(), "Transfer-Encoding: chunked\r\n") {
+		t.Fatalf("unexpected request %q. Chunked body expected: %v", w.String(), chunked)
+	}
+
+	var req1 Request
+	br := bufio.NewReader(&w)
+	if err := req1.Read(br); err != nil {
+		t.Fatalf("unexpected error when reading request: %s. body=%q", err, body)
+	}
+	if string(req1.Body()) != body {
+		t.Fatalf("unexpected body %q. Expecting %q", req1.Body(), body)
+	}
+}
+
+func TestRequestSetBodyStreamWriter(t *testing.T) {
+	var req Request
+	req.Header.SetHost("foobar.com")
+	req.Header.SetMethod("PUT")
+	req.SetBodyStreamWriter(func(w *bufio.Writer) {
+		fmt.Fprintf(w, "foo")
+		w.Flush()
+		fmt.Fprintf(w, "barbaz")
+	})
+
+	s := req.String()
+	var req1 Request
+	if err := req1.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s. request=%q", err, s)
+	}
+	if string(req1.Body()) != "foobarbaz" {
+		t.Fatalf("unexpected body %q. Expecting %q", req1.Body(), "foobarbaz")
+	}
+}
+
+func TestRequestBodyStreamGet(t *testing.T) {
+	var req Request
+	req.Header.SetHost("foobar.com")
+	r := &testClosingReader{Reader: bytes.NewBufferString("foobar")}
+	req.SetBodyStream(r, -1)
+	if string(req.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", req.Body(), "foobar")
+	}
+	if !r.closed {
+		t.Fatalf("body stream must be closed after reading the whole body")
+	}
+
+	r = &testClosingReader{Reader: bytes.NewBufferString("foobar")}
+	req.SetBodyStream(r, -1)
+	req.Reset()
+	if !r.closed {
+		t.Fatalf("body stream must be closed on Reset")
+	}
+}
+
+type testClosingReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *testClosingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
 func TestRound2(t *testing.T) {
 	testRound2(t, 0, 0)
 	testRound2(t, 1, 1)
@@ -913,3 +1068,221 @@ func createChunkedBody(body []byte) []byte {
 	}
 	return append(b, []byte("0\r\n\r\n")...)
 }
+
+func TestBodyStreamReader(t *testing.T) {
+	testBodyStreamReader(t, 0)
+	testBodyStreamReader(t, 5)
+	testBodyStreamReader(t, 43488)
+	testBodyStreamReader(t, 3*1024*1024)
+}
+
+func testBodyStreamReader(t *testing.T, bodySize int) {
+	body := createFixedBody(bodySize)
+	expectedTrailer := "traler aaaa"
+
+	// fixed-size body
+	br := bufio.NewReader(bytes.NewBufferString(string(body) + expectedTrailer))
+	s := acquireBodyStreamReader(br, bodySize, 0)
+	b, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatalf("unexpected error for bodySize=%d: %s", bodySize, err)
+	}
+	if !bytes.Equal(b, body) {
+		t.Fatalf("unexpected body read for bodySize=%d: %q. Expecting %q", bodySize, b, body)
+	}
+	if !s.drain() {
+		t.Fatalf("cannot drain fully read body for bodySize=%d", bodySize)
+	}
+	releaseBodyStreamReader(s)
+	verifyTrailer(t, br, expectedTrailer)
+
+	// chunked body
+	br = bufio.NewReader(bytes.NewBufferString(string(createChunkedBody(body)) + expectedTrailer))
+	s = acquireBodyStreamReader(br, -1, 0)
+	b, err = ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatalf("unexpected error for chunked bodySize=%d: %s", bodySize, err)
+	}
+	if !bytes.Equal(b, body) {
+		t.Fatalf("unexpected chunked body read for bodySize=%d: %q. Expecting %q", bodySize, b, body)
+	}
+	releaseBodyStreamReader(s)
+	verifyTrailer(t, br, expectedTrailer)
+}
+
+func TestBodyStreamReaderDrain(t *testing.T) {
+	testBodyStreamReaderDrain(t, 100, 0, true)
+	testBodyStreamReaderDrain(t, 100, 50, true)
+	testBodyStreamReaderDrain(t, maxRequestBodyDrainSize, 0, true)
+	testBodyStreamReaderDrain(t, maxRequestBodyDrainSize+100, 100, true)
+	testBodyStreamReaderDrain(t, maxRequestBodyDrainSize+100, 0, false)
+}
+
+func testBodyStreamReaderDrain(t *testing.T, bodySize, readSize int, expectedResult bool) {
+	body := createFixedBody(bodySize)
+	expectedTrailer := "traler aaaa"
+
+	for _, contentLength := range []int{bodySize, -1} {
+		b := body
+		if contentLength == -1 {
+			b = createChunkedBody(body)
+		}
+		br := bufio.NewReader(bytes.NewBufferString(string(b) + expectedTrailer))
+		s := acquireBodyStreamReader(br, contentLength, 0)
+		if _, err := io.ReadFull(s, make([]byte, readSize)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if s.drain() != expectedResult {
+			t.Fatalf("unexpected drain result for bodySize=%d, readSize=%d, contentLength=%d. Expecting %v",
+				bodySize, readSize, contentLength, expectedResult)
+		}
+		if expectedResult {
+			verifyTrailer(t, br, expectedTrailer)
+		}
+		releaseBodyStreamReader(s)
+	}
+}
+
+func TestBodyStreamReaderBadChunk(t *testing.T) {
+	br := bufio.NewReader(bytes.NewBufferString("3\r\nfoobar\r\n0\r\n\r\n"))
+	s := acquireBodyStreamReader(br, -1, 0)
+	defer releaseBodyStreamReader(s)
+	if _, err := ioutil.ReadAll(s); err == nil {
+		t.Fatalf("expecting error for invalid chunk")
+	}
+	if s.drain() {
+		t.Fatalf("invalid body mustn't be drained")
+	}
+}
+
+func TestRequestReadConnect(t *testing.T) {
+	var req Request
+	br := bufio.NewReader(bytes.NewBufferString("CONNECT Example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nGET / HTTP/1.1\r\n"))
+	if err := req.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !req.Header.IsConnect() {
+		t.Fatalf("expecting CONNECT request")
+	}
+	if req.Header.ContentLength() != 0 {
+		t.Fatalf("unexpected content length %d. Expecting 0", req.Header.ContentLength())
+	}
+	if string(req.URI().Host()) != "example.com:443" {
+		t.Fatalf("unexpected host %q. Expecting %q", req.URI().Host(), "example.com:443")
+	}
+	if br.Buffered() != len("GET / HTTP/1.1\r\n") {
+		t.Fatalf("CONNECT request body mustn't be read")
+	}
+
+	req.Reset()
+	req.Header.SetMethod("CONNECT")
+	req.SetRequestURI("example.com:443")
+	s := req.String()
+	expectedPrefix := "CONNECT example.com:443 HTTP/1.1\r\n"
+	if !strings.HasPrefix(s, expectedPrefix) {
+		t.Fatalf("unexpected request %q. Expecting prefix %q", s, expectedPrefix)
+	}
+	if !strings.Contains(s, "\r\nHost: example.com:443\r\n") {
+		t.Fatalf("missing Host header in %q", s)
+	}
+	if strings.Contains(s, "Content-Type") || strings.Contains(s, "Content-Length") {
+		t.Fatalf("unexpected body headers in %q", s)
+	}
+}
+
+func TestRequestReadAbsoluteURI(t *testing.T) {
+	var req Request
+	br := bufio.NewReader(bytes.NewBufferString("GET HTTP://Example.com?foo=bar HTTP/1.1\r\nHost: foobar.com\r\n\r\n"))
+	if err := req.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	uri := req.URI()
+	if string(uri.Scheme()) != "http" {
+		t.Fatalf("unexpected scheme %q. Expecting %q", uri.Scheme(), "http")
+	}
+	if string(uri.Host()) != "example.com" {
+		t.Fatalf("unexpected host %q. Expecting %q", uri.Host(), "example.com")
+	}
+	if string(uri.Path()) != "/" {
+		t.Fatalf("unexpected path %q. Expecting %q", uri.Path(), "/")
+	}
+	if string(uri.QueryArgs().Peek("foo")) != "bar" {
+		t.Fatalf("unexpected query arg %q. Expecting %q", uri.QueryArgs().Peek("foo"), "bar")
+	}
+}
+
+func TestResponseDecompressBody(t *testing.T) {
+	var resp Response
+	resp.SetBodyString("foobar")
+	if err := resp.deflateBody(CompressBestSpeed); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := resp.decompressBody(0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobar")
+	}
+	if string(resp.DecompressedEncoding()) != "deflate" {
+		t.Fatalf("unexpected decompressed encoding %q. Expecting %q", resp.DecompressedEncoding(), "deflate")
+	}
+
+	// Invalid compressed body.
+	resp.Reset()
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.SetBodyString("foobar")
+	if err := resp.decompressBody(0); err == nil {
+		t.Fatalf("expecting error")
+	}
+}
+
+func TestResponseDecompressBodyZlib(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte("foobar"))
+	zw.Close()
+
+	// Deflate coding is zlib stream according to RFC 7230.
+	var resp Response
+	resp.Header.Set("Content-Encoding", "deflate")
+	resp.SetBody(buf.Bytes())
+	if err := resp.decompressBody(0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobar")
+	}
+
+	resp.Reset()
+	resp.Header.Set("Content-Encoding", "deflate")
+	resp.bodyStream = bytes.NewReader(buf.Bytes())
+	if err := resp.decompressBody(0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.BodyStream())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+}
+
+func TestResponseDecompressBodyStreamTooLarge(t *testing.T) {
+	var resp Response
+	resp.SetBody(createFixedBody(64 * 1024))
+	if err := resp.gzipBody(CompressBestSpeed); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.bodyStream = bytes.NewReader(resp.Body())
+	if err := resp.decompressBody(1024); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.BodyStream())
+	if err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %s", err, ErrBodyTooLarge)
+	}
+	if len(body) != 1024 {
+		t.Fatalf("unexpected body length %d. Expecting %d", len(body), 1024)
+	}
+}
@@ -0,0 +1,253 @@
+package fasthttp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalancingClient is the interface for clients, which may be passed
+// to LBClient.Clients.
+//
+// HostClient, PipelineClient and Client implement this interface.
+//
+// LBClient passes request deadline and context to clients implementing
+// DoDeadline(req, resp, deadline) and DoContext(ctx, req, resp) methods
+// such as HostClient, PipelineClient and Client. Requests to other
+// clients are abandoned when the deadline is exceeded or the context
+// is canceled.
+type BalancingClient interface {
+	Do(req *Request, resp *Response) error
+}
+
+// deadlineClient is implemented by BalancingClient supporting
+// request deadlines.
+type deadlineClient interface {
+	DoDeadline(req *Request, resp *Response, deadline time.Time) error
+}
+
+// contextClient is implemented by BalancingClient supporting
+// request contexts.
+type contextClient interface {
+	DoContext(ctx context.Context, req *Request, resp *Response) error
+}
+
+// LBClient balances requests among available LBClient.Clients.
+//
+// It has the following features:
+//
+//     * Balances load among available clients using 'least loaded' + 'round robin'
+//       hybrid technique.
+//     * Dynamically decreases load on unhealthy clients.
+//     * Retries idempotent requests on another client if the first client fails.
+//
+// It is forbidden copying LBClient instances. Create new instances instead.
+//
+// It is safe calling LBClient methods from concurrently running goroutines.
+type LBClient struct {
+	// Clients must contain non-zero clients list.
+	// Incoming requests are balanced among these clients.
+	Clients []BalancingClient
+
+	// HealthCheck is a callback called after each request.
+	//
+	// The request, response and the error returned by the client
+	// is passed to HealthCheck, so the callback may determine whether
+	// the client is healthy.
+	//
+	// Load on the current client is decreased if HealthCheck returns false.
+	//
+	// By default HealthCheck returns false if err != nil.
+	HealthCheck func(req *Request, resp *Response, err error) bool
+
+	// Timeout is the request timeout used when calling LBClient.Do.
+	//
+	// DefaultLBClientTimeout is used by default.
+	Timeout time.Duration
+
+	cs []*lbClient
+
+	once sync.Once
+}
+
+// DefaultLBClientTimeout is the default request timeout used by LBClient
+// when calling LBClient.Do.
+//
+// The timeout may be overridden via LBClient.Timeout.
+const DefaultLBClientTimeout = time.Second
+
+// DoTimeout calls DoTimeout on the least loaded client.
+//
+// Idempotent requests are retried on another client if the first client
+// turns out to be unhealthy and the timeout isn't exceeded yet.
+func (cc *LBClient) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return cc.doDeadline(nil, req, resp, time.Now().Add(timeout))
+}
+
+// DoDeadline calls DoDeadline on the least loaded client.
+//
+// Idempotent requests are retried on another client if the first client
+// turns out to be unhealthy and the deadline isn't exceeded yet.
+func (cc *LBClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	return cc.doDeadline(nil, req, resp, deadline)
+}
+
+// DoContext calls DoContext on the least loaded client.
+//
+// Idempotent requests are retried on another client if the first client
+// turns out to be unhealthy and the context isn't done yet.
+//
+// LBClient.Timeout isn't applied, so the request is limited
+// by the context only.
+func (cc *LBClient) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	deadline, _ := ctx.Deadline()
+	err := cc.doDeadline(ctx, req, resp, deadline)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Do calls DoTimeout on the least loaded client with LBClient.Timeout.
+func (cc *LBClient) Do(req *Request, resp *Response) error {
+	timeout := cc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLBClientTimeout
+	}
+	return cc.DoTimeout(req, resp, timeout)
+}
+
+// doDeadline performs the request until the given deadline
+// or until ctx is done if ctx isn't nil.
+func (cc *LBClient) doDeadline(ctx context.Context, req *Request, resp *Response, deadline time.Time) error {
+	idempotent := isIdempotent(req)
+	c := cc.get(nil)
+	healthy, err := c.doDeadline(ctx, req, resp, deadline)
+	if healthy || len(cc.cs) < 2 || !idempotent {
+		return err
+	}
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	if requestDeadlineError(nil, deadline, done) != nil {
+		return err
+	}
+	c = cc.get(c)
+	_, err = c.doDeadline(ctx, req, resp, deadline)
+	return err
+}
+
+func (cc *LBClient) init() {
+	if len(cc.Clients) == 0 {
+		panic("BUG: LBClient.Clients cannot be empty")
+	}
+	for _, c := range cc.Clients {
+		cc.cs = append(cc.cs, &lbClient{
+			c:           c,
+			healthCheck: cc.HealthCheck,
+		})
+	}
+}
+
+// get returns the least loaded client except the given one.
+func (cc *LBClient) get(except *lbClient) *lbClient {
+	cc.once.Do(cc.init)
+
+	var minC *lbClient
+	var minN int
+	var minT uint64
+	for _, c := range cc.cs {
+		if c == except {
+			continue
+		}
+		n := c.pendingRequests()
+		t := atomic.LoadUint64(&c.total)
+		if minC == nil || n < minN || (n == minN && t < minT) {
+			minC = c
+			minN = n
+			minT = t
+		}
+	}
+	return minC
+}
+
+type lbClient struct {
+	c           BalancingClient
+	healthCheck func(req *Request, resp *Response, err error) bool
+
+	pending int32
+	penalty uint32
+
+	// total amount of successfully served requests.
+	// It is used for round robin among clients with equal load.
+	total uint64
+}
+
+// doDeadline calls the underlying client and returns whether
+// the client is healthy according to the health check.
+//
+// The request is performed until the given deadline or until ctx
+// is done if ctx isn't nil.
+func (c *lbClient) doDeadline(ctx context.Context, req *Request, resp *Response, deadline time.Time) (bool, error) {
+	atomic.AddInt32(&c.pending, 1)
+	var err error
+	if cc, ok := c.c.(contextClient); ok && ctx != nil {
+		err = cc.DoContext(ctx, req, resp)
+	} else if dc, ok := c.c.(deadlineClient); ok && ctx == nil {
+		err = dc.DoDeadline(req, resp, deadline)
+	} else {
+		var done <-chan struct{}
+		if ctx != nil {
+			done = ctx.Done()
+		}
+		err = clientDoDeadline(req, resp, deadline, done, c.c)
+	}
+	atomic.AddInt32(&c.pending, -1)
+
+	healthy := c.isHealthy(req, resp, err)
+	if !healthy {
+		if c.incPenalty() {
+			// Penalize the client returning error, so the next requests
+			// are routed to another clients.
+			time.AfterFunc(penaltyDuration, c.decPenalty)
+		}
+	} else {
+		atomic.AddUint64(&c.total, 1)
+	}
+	return healthy, err
+}
+
+func (c *lbClient) pendingRequests() int {
+	n := atomic.LoadInt32(&c.pending)
+	m := atomic.LoadUint32(&c.penalty)
+	return int(n) + int(m)
+}
+
+func (c *lbClient) isHealthy(req *Request, resp *Response, err error) bool {
+	if c.healthCheck == nil {
+		return err == nil
+	}
+	return c.healthCheck(req, resp, err)
+}
+
+func (c *lbClient) incPenalty() bool {
+	m := atomic.AddUint32(&c.penalty, 1)
+	if m > maxPenalty {
+		c.decPenalty()
+		return false
+	}
+	return true
+}
+
+func (c *lbClient) decPenalty() {
+	atomic.AddUint32(&c.penalty, ^uint32(0))
+}
+
+const (
+	maxPenalty = 300
+
+	penaltyDuration = 3 * time.Second
+)
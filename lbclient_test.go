@@ -0,0 +1,243 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testBalancingClient struct {
+	calls uint32
+	err   error
+	delay time.Duration
+}
+
+func (c *testBalancingClient) Do(req *Request, resp *Response) error {
+	atomic.AddUint32(&c.calls, 1)
+	if c.delay > 0 {
+		time.Sleep(c.delay)
+	}
+	if c.err != nil {
+		return c.err
+	}
+	resp.SetBodyString("ok")
+	return nil
+}
+
+func TestLBClientEchoServers(t *testing.T) {
+	s1 := startEchoServer(t, "tcp", "127.0.0.1:56791")
+	defer s1.Stop()
+	s2 := startEchoServer(t, "tcp", "127.0.0.1:56792")
+	defer s2.Stop()
+
+	lbc := &LBClient{
+		Clients: []BalancingClient{
+			&HostClient{Addr: "127.0.0.1:56791"},
+			&PipelineClient{Addr: "127.0.0.1:56792"},
+		},
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var req Request
+			var resp Response
+			for j := 0; j < 10; j++ {
+				uri := fmt.Sprintf("http://foobar.com/%d/%d", i, j)
+				req.SetRequestURI(uri)
+				if err := lbc.Do(&req, &resp); err != nil {
+					errCh <- err
+					return
+				}
+				if string(resp.Body()) != uri {
+					errCh <- fmt.Errorf("unexpected body %q. Expecting %q", resp.Body(), uri)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestLBClientLeastLoaded(t *testing.T) {
+	slow := &testBalancingClient{delay: 50 * time.Millisecond}
+	fast := &testBalancingClient{}
+	lbc := &LBClient{
+		Clients: []BalancingClient{slow, fast},
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var req Request
+			var resp Response
+			for j := 0; j < 20; j++ {
+				if err := lbc.Do(&req, &resp); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	slowCalls := atomic.LoadUint32(&slow.calls)
+	fastCalls := atomic.LoadUint32(&fast.calls)
+	if slowCalls+fastCalls != 100 {
+		t.Fatalf("unexpected number of calls: %d. Expecting 100", slowCalls+fastCalls)
+	}
+	if slowCalls >= fastCalls {
+		t.Fatalf("the slow client must get less requests than the fast client. slow=%d, fast=%d", slowCalls, fastCalls)
+	}
+}
+
+func TestLBClientRetryIdempotent(t *testing.T) {
+	clientErr := errors.New("client error")
+	bad := &testBalancingClient{err: clientErr}
+	good := &testBalancingClient{}
+	lbc := &LBClient{
+		Clients: []BalancingClient{bad, good},
+	}
+
+	var req Request
+	var resp Response
+	for i := 0; i < 10; i++ {
+		if err := lbc.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error for idempotent request: %s", err)
+		}
+		if string(resp.Body()) != "ok" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+		}
+	}
+	if atomic.LoadUint32(&bad.calls) == 0 {
+		t.Fatalf("expecting at least a single call to the bad client")
+	}
+
+	// Non-idempotent requests mustn't be retried.
+	lbc = &LBClient{
+		Clients: []BalancingClient{bad, good},
+	}
+	req.Header.SetMethod("POST")
+	errors := 0
+	for i := 0; i < 10; i++ {
+		if err := lbc.Do(&req, &resp); err != nil {
+			if err != clientErr {
+				t.Fatalf("unexpected error: %s. Expecting %s", err, clientErr)
+			}
+			errors++
+		}
+	}
+	if errors == 0 {
+		t.Fatalf("expecting non-zero errors for non-idempotent requests")
+	}
+}
+
+func TestLBClientPenalty(t *testing.T) {
+	bad := &testBalancingClient{err: errors.New("client error")}
+	good := &testBalancingClient{}
+	lbc := &LBClient{
+		Clients: []BalancingClient{bad, good},
+	}
+
+	var req Request
+	req.Header.SetMethod("POST")
+	var resp Response
+	for i := 0; i < 100; i++ {
+		lbc.Do(&req, &resp)
+	}
+
+	// The bad client must be penalized after the first error.
+	badCalls := atomic.LoadUint32(&bad.calls)
+	if badCalls > 10 {
+		t.Fatalf("too many calls to the unhealthy client: %d", badCalls)
+	}
+}
+
+func TestLBClientHealthCheck(t *testing.T) {
+	c1 := &testBalancingClient{}
+	c2 := &testBalancingClient{}
+	lbc := &LBClient{
+		Clients: []BalancingClient{c1, c2},
+		HealthCheck: func(req *Request, resp *Response, err error) bool {
+			return string(req.Header.RequestURI()) != "/unhealthy"
+		},
+	}
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("/unhealthy")
+	if err := lbc.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The request must be retried on another client,
+	// since it is idempotent and the health check failed.
+	if atomic.LoadUint32(&c1.calls) != 1 || atomic.LoadUint32(&c2.calls) != 1 {
+		t.Fatalf("unexpected calls: c1=%d, c2=%d. Expecting 1 call per client", c1.calls, c2.calls)
+	}
+}
+
+func TestLBClientTimeout(t *testing.T) {
+	slow := &testBalancingClient{delay: 200 * time.Millisecond}
+	lbc := &LBClient{
+		Clients: []BalancingClient{slow},
+		Timeout: 20 * time.Millisecond,
+	}
+
+	var req Request
+	var resp Response
+	startTime := time.Now()
+	if err := lbc.Do(&req, &resp); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+	if d := time.Since(startTime); d > 150*time.Millisecond {
+		t.Fatalf("LBClient.Timeout must abort the request to Do-only client. The request took %s", d)
+	}
+}
+
+func TestLBClientDoContext(t *testing.T) {
+	addr, stop := startSlowTestServer(t)
+	defer stop()
+
+	slow := &testBalancingClient{delay: 300 * time.Millisecond}
+	for _, c := range []BalancingClient{slow, &HostClient{Addr: addr}} {
+		lbc := &LBClient{
+			Clients: []BalancingClient{c},
+		}
+		var req Request
+		var resp Response
+		req.SetRequestURI("http://example.com/slow")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		startTime := time.Now()
+		if err := lbc.DoContext(ctx, &req, &resp); err != context.Canceled {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+		}
+		if d := time.Since(startTime); d > 250*time.Millisecond {
+			t.Fatalf("too long request duration: %s", d)
+		}
+
+		if err := lbc.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err != ErrTimeout {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyFunc must return proxy url for the given request.
+//
+// The request is sent directly to the requested host if ProxyFunc
+// returns empty string.
+type ProxyFunc func(req *Request) string
+
+// ProxyFromEnvironment returns proxy url for the given request obtained
+// from HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+// (or their lowercase versions).
+//
+// HTTPS_PROXY is used for https requests, while HTTP_PROXY is used
+// for http requests. NO_PROXY may contain comma-separated list of hosts,
+// which must be requested directly:
+//
+//     * '*' disables proxy for all the hosts.
+//     * 'example.com' matches example.com and all its subdomains.
+//     * '.example.com' and '*.example.com' match only subdomains
+//       of example.com.
+//     * 'example.com:8080' matches only the given port.
+//     * '10.0.0.0/8' matches ip addresses from the given network.
+//
+// Environment variables are read only once on the first call.
+//
+// ProxyFromEnvironment may be used as Client.Proxy.
+func ProxyFromEnvironment(req *Request) string {
+	envProxyOnce.Do(func() {
+		envProxy = newProxyConfig(os.Getenv)
+	})
+	return envProxy.proxy(req)
+}
+
+var (
+	envProxyOnce sync.Once
+	envProxy     *proxyConfig
+)
+
+type proxyConfig struct {
+	httpProxy  string
+	httpsProxy string
+
+	noProxyAll bool
+	noProxy    []noProxyEntry
+}
+
+type noProxyEntry struct {
+	domain         string
+	port           string
+	subdomainsOnly bool
+	ipNet          *net.IPNet
+}
+
+func newProxyConfig(getenv func(key string) string) *proxyConfig {
+	pc := &proxyConfig{
+		httpProxy:  getenvAny(getenv, "HTTP_PROXY", "http_proxy"),
+		httpsProxy: getenvAny(getenv, "HTTPS_PROXY", "https_proxy"),
+	}
+	noProxy := getenvAny(getenv, "NO_PROXY", "no_proxy")
+	for _, s := range strings.Split(noProxy, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len(s) == 0 {
+			continue
+		}
+		if s == "*" {
+			pc.noProxyAll = true
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(s); err == nil {
+			pc.noProxy = append(pc.noProxy, noProxyEntry{
+				ipNet: ipNet,
+			})
+			continue
+		}
+
+		var e noProxyEntry
+		if host, port, err := net.SplitHostPort(s); err == nil {
+			s = host
+			e.port = port
+		}
+		if strings.HasPrefix(s, "*.") {
+			s = s[1:]
+		}
+		if len(s) > 0 && s[0] == '.' {
+			s = s[1:]
+			e.subdomainsOnly = true
+		}
+		if len(s) == 0 {
+			continue
+		}
+		e.domain = s
+		pc.noProxy = append(pc.noProxy, e)
+	}
+	return pc
+}
+
+func getenvAny(getenv func(key string) string, keys ...string) string {
+	for _, key := range keys {
+		if v := getenv(key); len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+func (pc *proxyConfig) proxy(req *Request) string {
+	uri := req.URI()
+	isTLS := bytes.Equal(uri.Scheme(), strHTTPS)
+	proxy := pc.httpProxy
+	if isTLS {
+		proxy = pc.httpsProxy
+	}
+	if len(proxy) == 0 {
+		return ""
+	}
+	if !pc.useProxy(addMissingPort(string(uri.Host()), isTLS)) {
+		return ""
+	}
+	return proxy
+}
+
+// useProxy returns false if the given addr matches NO_PROXY.
+func (pc *proxyConfig) useProxy(addr string) bool {
+	if pc.noProxyAll {
+		return false
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		port = ""
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for i := range pc.noProxy {
+		e := &pc.noProxy[i]
+		if e.ipNet != nil {
+			if ip != nil && e.ipNet.Contains(ip) {
+				return false
+			}
+			continue
+		}
+		if len(e.port) > 0 && e.port != port {
+			continue
+		}
+		if host == e.domain && !e.subdomainsOnly {
+			return false
+		}
+		n := len(host) - len(e.domain)
+		if n > 0 && host[n-1] == '.' && host[n:] == e.domain {
+			return false
+		}
+	}
+	return true
+}
+
+var errProxyHostRequired = errors.New("missing host in proxy url")
+
+// parseProxyURL returns proxy address and the value
+// for Proxy-Authorization header for the given proxy url.
+func parseProxyURL(proxy string) (string, []byte, error) {
+	if n := strings.Index(proxy, "://"); n >= 0 {
+		scheme := proxy[:n]
+		if !strings.EqualFold(scheme, "http") {
+			return "", nil, fmt.Errorf("unsupported proxy scheme %q. Only http proxies are supported", scheme)
+		}
+		proxy = proxy[n+len("://"):]
+	}
+	if n := strings.IndexByte(proxy, '/'); n >= 0 {
+		proxy = proxy[:n]
+	}
+
+	var auth []byte
+	if n := strings.LastIndexByte(proxy, '@'); n >= 0 {
+		userinfo := decodeArg(nil, []byte(proxy[:n]), false)
+		proxy = proxy[n+1:]
+		auth = append(auth, "Basic "...)
+		auth = append(auth, base64.StdEncoding.EncodeToString(userinfo)...)
+	}
+
+	if len(proxy) == 0 {
+		return "", nil, errProxyHostRequired
+	}
+	return addMissingPort(proxy, false), auth, nil
+}
+
+func (c *HostClient) getProxy() (string, []byte, error) {
+	c.proxyOnce.Do(func() {
+		c.proxyAddr, c.proxyAuth, c.proxyErr = parseProxyURL(c.Proxy)
+	})
+	return c.proxyAddr, c.proxyAuth, c.proxyErr
+}
+
+// proxyConnect establishes tunnel to addr via http proxy on the given conn
+// with CONNECT method.
+//
+// The tunnel must be established until the given deadline and until
+// done is closed.
+func (c *HostClient) proxyConnect(conn net.Conn, addr string, auth []byte, deadline time.Time, done <-chan struct{}) error {
+	if done != nil {
+		canceler := startConnCanceler(conn, done)
+		defer canceler.stop()
+	}
+
+	writeDeadline := connDeadline(c.WriteTimeout, deadline)
+	if !writeDeadline.IsZero() {
+		if err := conn.SetWriteDeadline(writeDeadline); err != nil {
+			return err
+		}
+	}
+	var b []byte
+	b = append(b, strConnect...)
+	b = append(b, ' ')
+	b = append(b, addr...)
+	b = append(b, ' ')
+	b = append(b, strHTTP11...)
+	b = append(b, strCRLF...)
+	b = appendHeaderLine(b, strHost, []byte(addr))
+	b = appendHeaderLine(b, strUserAgent, c.getClientName())
+	if len(auth) > 0 {
+		b = appendHeaderLine(b, strProxyAuthorization, auth)
+	}
+	b = append(b, strCRLF...)
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	readDeadline := connDeadline(c.ReadTimeout, deadline)
+	if !readDeadline.IsZero() {
+		if err := conn.SetReadDeadline(readDeadline); err != nil {
+			return err
+		}
+	}
+	br := c.acquireReader(conn)
+	// The proxy doesn't send response body for successful CONNECT.
+	var h ResponseHeader
+	err := h.Read(br)
+	buffered := br.Buffered()
+	c.releaseReader(br)
+	if err != nil {
+		return err
+	}
+	statusCode := h.StatusCode()
+	if statusCode < 200 || statusCode > 299 {
+		return fmt.Errorf("proxy refused connection to %q with status code %d", addr, statusCode)
+	}
+	if buffered > 0 {
+		return fmt.Errorf("unexpected data received from proxy after CONNECT response to %q", addr)
+	}
+	if !writeDeadline.IsZero() || !readDeadline.IsZero() {
+		// The deadlines are set for requests over the tunnel.
+		if err = conn.SetDeadline(zeroTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProxyRequest writes the given request to http proxy.
+//
+// The request is written with absolute request uri and Proxy-Authorization
+// header obtained from HostClient.Proxy.
+func (c *HostClient) writeProxyRequest(req *Request, bw *bufio.Writer) error {
+	h := &req.Header
+	uri := req.URI()
+	if len(h.Host()) == 0 {
+		host := uri.Host()
+		if len(host) == 0 {
+			return errRequestHostRequired
+		}
+		h.SetHostBytes(host)
+	}
+
+	requestURIOld := h.requestURI
+	var requestURI []byte
+	requestURI = append(requestURI, strHTTP...)
+	requestURI = append(requestURI, strColonSlashSlash...)
+	requestURI = append(requestURI, uri.Host()...)
+	requestURI = append(requestURI, uri.RequestURI()...)
+	h.requestURI = requestURI
+
+	_, auth, _ := c.getProxy()
+	setAuth := len(auth) > 0 && len(h.PeekBytes(strProxyAuthorization)) == 0
+	if setAuth {
+		h.SetBytesKV(strProxyAuthorization, auth)
+	}
+
+	err := req.Write(bw)
+
+	h.requestURI = requestURIOld
+	if setAuth {
+		h.DelBytes(strProxyAuthorization)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package fasthttp
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy determines whether and when HostClient retries requests.
+//
+// It is forbidden changing RetryPolicy fields after passing it
+// to HostClient or Client.
+type RetryPolicy struct {
+	// Maximum number of attempts per request including the first attempt.
+	//
+	// DefaultMaxRetryAttempts is used if not set.
+	MaxAttempts int
+
+	// RetryIf is called after each attempt except of the last one.
+	// The request is retried if RetryIf returns true.
+	//
+	// attempt is the number of the finished attempt starting from 1.
+	// err is the error returned by the attempt, while resp contains
+	// the response if err is nil. So RetryIf may retry requests
+	// on 502 Bad Gateway and 503 Service Unavailable responses.
+	//
+	// RetryIf may retry non-idempotent requests, which are known to be safe
+	// for retrying, for instance POST requests with Idempotency-Key header.
+	//
+	// Requests with body streams set via Request.SetBodyStream
+	// and Request.SetBodyStreamWriter are never retried, since the body
+	// may be already consumed.
+	//
+	// By default idempotent requests (GET, HEAD and PUT) are retried
+	// if the connection to the host has been closed before the response
+	// is read. This usually means the host closed the idle keep-alive
+	// connection.
+	RetryIf func(req *Request, resp *Response, attempt int, err error) bool
+
+	// Delay before the first retry. The delay is doubled
+	// on each subsequent retry until it reaches MaxBackoff.
+	//
+	// Random jitter is applied to each delay, so concurrently failed
+	// requests aren't retried simultaneously. The actual delay is
+	// in the range [delay/2 ... delay].
+	//
+	// Requests are retried without delays if Backoff isn't set.
+	Backoff time.Duration
+
+	// Maximum delay between retries.
+	//
+	// DefaultMaxRetryBackoff is used if not set.
+	MaxBackoff time.Duration
+}
+
+// DefaultMaxRetryAttempts is the default maximum number of attempts
+// per request used by RetryPolicy.
+const DefaultMaxRetryAttempts = 2
+
+// DefaultMaxRetryBackoff is the default maximum delay between retries
+// used by RetryPolicy.
+const DefaultMaxRetryBackoff = 10 * time.Second
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return DefaultMaxRetryAttempts
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) shouldRetry(req *Request, resp *Response, attempt int, connClosed bool, err error) bool {
+	if p.RetryIf == nil {
+		return err != nil && connClosed && isIdempotent(req)
+	}
+	return p.RetryIf(req, resp, attempt, err)
+}
+
+// backoff returns the delay before the retry following the given attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxRetryBackoff
+	}
+	d := p.Backoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+var defaultRetryPolicy RetryPolicy
+
+// doRetry performs the request via c, retrying it according to c.RetryPolicy.
+//
+// Retries are stopped if the delay before the next retry exceeds
+// the given deadline or done is closed.
+func (c *HostClient) doRetry(req *Request, resp *Response, deadline time.Time, done <-chan struct{}) error {
+	p := c.RetryPolicy
+	if p == nil {
+		p = &defaultRetryPolicy
+	}
+
+	// Check whether the request has body stream before sending it,
+	// since the body stream is released after sending the request.
+	canRetry := req.bodyStream == nil
+	if canRetry && p.RetryIf != nil && resp == nil {
+		// RetryIf must be able to inspect the response.
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+
+	maxAttempts := p.maxAttempts()
+	connClosed := false
+	for attempt := 1; ; attempt++ {
+		// Establish new connection for the retry if the previous
+		// connection has been closed, since idle connections
+		// to the host may be broken too.
+		var err error
+		connClosed, err = c.do(req, resp, connClosed, deadline, done)
+		if err != nil {
+			if err = requestDeadlineError(err, deadline, done); err == ErrTimeout || err == errRequestCanceled {
+				return err
+			}
+		}
+		if !canRetry || attempt >= maxAttempts || !p.shouldRetry(req, resp, attempt, connClosed, err) {
+			return err
+		}
+		if err == nil {
+			// Release the connection held by the response body stream.
+			resp.closeBodyStream()
+		}
+		if d := p.backoff(attempt); d > 0 {
+			if !deadline.IsZero() && -time.Since(deadline) < d {
+				return err
+			}
+			if done == nil {
+				time.Sleep(d)
+			} else {
+				select {
+				case <-time.After(d):
+				case <-done:
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package fasthttp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostClientRetryPolicyStatusCode(t *testing.T) {
+	var requests uint32
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			n := atomic.AddUint32(&requests, 1)
+			if n < 3 {
+				ctx.Error("try again", StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(ctx, "attempt %d, body=%s", n, ctx.PostBody())
+		},
+	})
+	defer stop()
+
+	var attempts []int
+	c := &HostClient{
+		Addr: addr,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 5,
+			RetryIf: func(req *Request, resp *Response, attempt int, err error) bool {
+				attempts = append(attempts, attempt)
+				if err != nil {
+					return false
+				}
+				return resp.StatusCode() == StatusBadGateway || resp.StatusCode() == StatusServiceUnavailable
+			},
+			Backoff: time.Millisecond,
+		},
+	}
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/")
+	req.Header.SetMethod("POST")
+	req.SetBodyString("foobar")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	if string(resp.Body()) != "attempt 3, body=foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "attempt 3, body=foobar")
+	}
+	if fmt.Sprintf("%v", attempts) != "[1 2 3]" {
+		t.Fatalf("unexpected attempts passed to RetryIf: %v. Expecting [1 2 3]", attempts)
+	}
+}
+
+func TestHostClientRetryPolicyMaxAttempts(t *testing.T) {
+	var requests uint32
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			atomic.AddUint32(&requests, 1)
+			ctx.Error("bad gateway", StatusBadGateway)
+		},
+	})
+	defer stop()
+
+	c := &HostClient{
+		Addr: addr,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 3,
+			RetryIf: func(req *Request, resp *Response, attempt int, err error) bool {
+				return err == nil && resp.StatusCode() == StatusBadGateway
+			},
+		},
+	}
+	statusCode, _, err := c.Get(nil, "http://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusBadGateway {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusBadGateway)
+	}
+	if n := atomic.LoadUint32(&requests); n != 3 {
+		t.Fatalf("unexpected number of requests: %d. Expecting 3", n)
+	}
+
+	// Nil response must be retried too.
+	atomic.StoreUint32(&requests, 0)
+	req := AcquireRequest()
+	req.SetRequestURI("http://example.com/")
+	if err = c.Do(req, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ReleaseRequest(req)
+	if n := atomic.LoadUint32(&requests); n != 3 {
+		t.Fatalf("unexpected number of requests: %d. Expecting 3", n)
+	}
+}
+
+func TestHostClientRetryPolicyBodyStream(t *testing.T) {
+	var requests uint32
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			atomic.AddUint32(&requests, 1)
+			ctx.Error("try again", StatusServiceUnavailable)
+		},
+	})
+	defer stop()
+
+	c := &HostClient{
+		Addr: addr,
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts: 3,
+			RetryIf: func(req *Request, resp *Response, attempt int, err error) bool {
+				return true
+			},
+		},
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/")
+	req.Header.SetMethod("PUT")
+	req.SetBodyStream(bytes.NewBufferString("foobar"), -1)
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusServiceUnavailable {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusServiceUnavailable)
+	}
+	if n := atomic.LoadUint32(&requests); n != 1 {
+		t.Fatalf("unexpected number of requests: %d. Expecting 1", n)
+	}
+}
+
+func TestHostClientRetryPolicyStreamBody(t *testing.T) {
+	var requests uint32
+	addr, stop := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			if atomic.AddUint32(&requests, 1) == 1 {
+				ctx.Error("try again", StatusServiceUnavailable)
+				return
+			}
+			ctx.SetBodyString("ok")
+		},
+	})
+	defer stop()
+
+	c := &HostClient{
+		Addr:     addr,
+		MaxConns: 1,
+		RetryPolicy: &RetryPolicy{
+			RetryIf: func(req *Request, resp *Response, attempt int, err error) bool {
+				return err == nil && resp.StatusCode() == StatusServiceUnavailable
+			},
+		},
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/")
+	resp.StreamBody = true
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadAll(resp.BodyStream())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", b, "ok")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	var p RetryPolicy
+	if d := p.backoff(1); d != 0 {
+		t.Fatalf("unexpected backoff %s. Expecting 0", d)
+	}
+
+	p.Backoff = 100 * time.Millisecond
+	p.MaxBackoff = time.Second
+	for i := 0; i < 100; i++ {
+		testRetryPolicyBackoff(t, &p, 1, 50*time.Millisecond, 100*time.Millisecond)
+		testRetryPolicyBackoff(t, &p, 2, 100*time.Millisecond, 200*time.Millisecond)
+		testRetryPolicyBackoff(t, &p, 4, 400*time.Millisecond, 800*time.Millisecond)
+		testRetryPolicyBackoff(t, &p, 5, 500*time.Millisecond, time.Second)
+		testRetryPolicyBackoff(t, &p, 100, 500*time.Millisecond, time.Second)
+	}
+}
+
+func testRetryPolicyBackoff(t *testing.T, p *RetryPolicy, attempt int, minDelay, maxDelay time.Duration) {
+	d := p.backoff(attempt)
+	if d < minDelay || d > maxDelay {
+		t.Fatalf("unexpected backoff %s for attempt %d. Expecting [%s ... %s]", d, attempt, minDelay, maxDelay)
+	}
+}
@@ -0,0 +1,423 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"time"
+)
+
+// ReverseProxy proxies incoming requests to the upstream server.
+//
+// The following features are supported:
+//
+//     * Hop-by-hop headers are removed from requests and responses.
+//     * Client ip is appended to 'X-Forwarded-For' and 'Forwarded'
+//       request headers.
+//     * 'Host' request header is rewritten to the upstream host,
+//       while 'Location' response header pointing to the upstream host
+//       is rewritten to the requested host.
+//     * Request and response bodies are streamed. Set
+//       Server.StreamRequestBody for streaming request bodies.
+//     * Upstream errors are converted to 502 Bad Gateway responses,
+//       while upstream timeouts are converted to 504 Gateway Timeout.
+//     * 'Connection: Upgrade' requests such as WebSocket are passed
+//       through to the upstream via RequestCtx.Hijack.
+//
+// It is forbidden copying ReverseProxy instances. Create new instances
+// instead.
+//
+// It is safe calling ReverseProxy methods from concurrently running
+// goroutines.
+type ReverseProxy struct {
+	// Client for sending requests to the upstream server.
+	//
+	// This field is mandatory.
+	Client *HostClient
+
+	// Host header sent to the upstream server.
+	//
+	// Client.Addr is used if not set.
+	Host string
+
+	// Maximum duration for waiting for upstream response header.
+	//
+	// Upstream response body is streamed to the client without
+	// the timeout. See HostClient.ReadTimeout for limiting
+	// the duration of the full response reading.
+	//
+	// By default the timeout is unlimited.
+	Timeout time.Duration
+}
+
+// hopHeaders contains hop-by-hop headers, which mustn't be proxied.
+//
+// See https://tools.ietf.org/html/rfc7230#section-6.1 .
+var hopHeaders = [][]byte{
+	strConnection,
+	[]byte("Proxy-Connection"),
+	strKeepAliveCamelCase,
+	[]byte("Proxy-Authenticate"),
+	strProxyAuthorization,
+	[]byte("Te"),
+	[]byte("Trailer"),
+	strTransferEncoding,
+	strUpgrade,
+}
+
+var (
+	strXForwardedFor = []byte("X-Forwarded-For")
+	strForwarded     = []byte("Forwarded")
+)
+
+// NewRequestHandler returns request handler proxying requests
+// to the upstream server.
+func (p *ReverseProxy) NewRequestHandler() RequestHandler {
+	if p.Client == nil {
+		panic("BUG: ReverseProxy.Client must be set")
+	}
+	return p.handleRequest
+}
+
+func (p *ReverseProxy) handleRequest(ctx *RequestCtx) {
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+
+	ctx.Request.Header.CopyTo(&req.Header)
+	upgrade := ctx.Request.Header.PeekBytes(strUpgrade)
+	isUpgrade := len(upgrade) > 0 && hasHeaderToken(ctx.Request.Header.PeekBytes(strConnection), strUpgrade)
+	p.prepareRequest(ctx, req)
+
+	if isUpgrade {
+		req.Header.SetBytesKV(strConnection, strUpgrade)
+		req.Header.SetBytesKV(strUpgrade, upgrade)
+		p.proxyUpgrade(ctx, req)
+		return
+	}
+
+	if !doProxyRequest(ctx, req, p.Client, p.Timeout, p.Client.Addr) {
+		return
+	}
+	if location := ctx.Response.Header.PeekBytes(strLocation); len(location) > 0 {
+		p.rewriteLocation(ctx, location)
+	}
+}
+
+type proxyClient interface {
+	Do(req *Request, resp *Response) error
+	DoTimeout(req *Request, resp *Response, timeout time.Duration) error
+}
+
+// doProxyRequest sends req with the body from ctx to the upstream via c
+// and copies the upstream response to ctx.
+//
+// Error response is written to ctx and false is returned on error.
+func doProxyRequest(ctx *RequestCtx, req *Request, c proxyClient, timeout time.Duration, upstream string) bool {
+	streamRequestBody := ctx.Request.bodyStream != nil
+	if streamRequestBody {
+		contentLength := ctx.Request.Header.ContentLength()
+		if contentLength < 0 {
+			contentLength = -1
+		}
+		req.SetBodyStream(ctx.RequestBodyStream(), contentLength)
+	} else {
+		req.SetBody(ctx.Request.Body())
+	}
+
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	resp.StreamBody = true
+	resp.SkipBody = req.Header.IsHead()
+
+	var err error
+	if timeout > 0 {
+		err = c.DoTimeout(req, resp, timeout)
+	} else {
+		err = c.Do(req, resp)
+	}
+	if err != nil {
+		if streamRequestBody && err == ErrTimeout {
+			// The request body may be partially read,
+			// so the connection mustn't be re-used.
+			ctx.Logger().Printf("timeout when proxying the request to %q", upstream)
+			ctx.Error(StatusMessage(StatusGatewayTimeout), StatusGatewayTimeout)
+			ctx.SetConnectionClose()
+			return false
+		}
+		handleProxyError(ctx, upstream, err)
+		return false
+	}
+
+	copyProxyResponse(ctx, resp)
+	if bodyStream := resp.bodyStream; bodyStream != nil {
+		resp.bodyStream = nil
+		contentLength := resp.Header.ContentLength()
+		if contentLength < 0 {
+			contentLength = -1
+		}
+		ctx.Response.SetBodyStream(bodyStream, contentLength)
+	}
+	return true
+}
+
+func (p *ReverseProxy) prepareRequest(ctx *RequestCtx, req *Request) {
+	h := &req.Header
+	removeHopHeaders(h.PeekBytes(strConnection), h.DelBytes)
+	h.connectionClose = false
+
+	host := p.Host
+	if len(host) == 0 {
+		host = p.Client.Addr
+	}
+	h.SetHost(host)
+
+	ip := ctx.RemoteIP().String()
+
+	var b []byte
+	if v := h.PeekBytes(strXForwardedFor); len(v) > 0 {
+		b = append(b, v...)
+		b = append(b, ", "...)
+	}
+	b = append(b, ip...)
+	h.SetBytesKV(strXForwardedFor, b)
+
+	b = b[:0]
+	if v := h.PeekBytes(strForwarded); len(v) > 0 {
+		b = append(b, v...)
+		b = append(b, ", "...)
+	}
+	b = append(b, "for="...)
+	if strings.IndexByte(ip, ':') >= 0 {
+		// IPv6 addresses must be quoted and enclosed in square brackets.
+		b = append(b, `"[`...)
+		b = append(b, ip...)
+		b = append(b, `]"`...)
+	} else {
+		b = append(b, ip...)
+	}
+	if requestHost := ctx.Host(); len(requestHost) > 0 {
+		b = append(b, ";host="...)
+		b = appendForwardedValue(b, requestHost)
+	}
+	b = append(b, ";proto="...)
+	if ctx.IsTLS() {
+		b = append(b, strHTTPS...)
+	} else {
+		b = append(b, strHTTP...)
+	}
+	h.SetBytesKV(strForwarded, b)
+}
+
+// appendForwardedValue appends the given Forwarded header parameter value
+// to dst. The value is quoted if it isn't a valid token, for instance
+// if it contains port after the host.
+func appendForwardedValue(dst, v []byte) []byte {
+	isToken := len(v) > 0
+	for _, c := range v {
+		if !isTokenChar(c) {
+			isToken = false
+			break
+		}
+	}
+	if isToken {
+		return append(dst, v...)
+	}
+
+	dst = append(dst, '"')
+	for _, c := range v {
+		if c == '"' || c == '\\' {
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, c)
+	}
+	return append(dst, '"')
+}
+
+// isTokenChar returns true if c may be used in the token
+// according to RFC 7230.
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// removeHopHeaders removes hop-by-hop headers with the given del function,
+// including headers listed in the given Connection header value.
+func removeHopHeaders(connection []byte, del func(key []byte)) {
+	// connection may point to the header being deleted.
+	connection = append([]byte(nil), connection...)
+	for len(connection) > 0 {
+		n := bytes.IndexByte(connection, ',')
+		if n < 0 {
+			n = len(connection)
+		}
+		if key := bytes.TrimSpace(connection[:n]); len(key) > 0 {
+			del(key)
+		}
+		if n == len(connection) {
+			break
+		}
+		connection = connection[n+1:]
+	}
+	for _, key := range hopHeaders {
+		del(key)
+	}
+}
+
+// hasHeaderToken returns true if comma-separated header value v
+// contains the given token.
+func hasHeaderToken(v, token []byte) bool {
+	for len(v) > 0 {
+		n := bytes.IndexByte(v, ',')
+		if n < 0 {
+			n = len(v)
+		}
+		if bytes.EqualFold(bytes.TrimSpace(v[:n]), token) {
+			return true
+		}
+		if n == len(v) {
+			break
+		}
+		v = v[n+1:]
+	}
+	return false
+}
+
+// copyProxyResponse copies upstream response header to ctx
+// except of hop-by-hop headers.
+func copyProxyResponse(ctx *RequestCtx, resp *Response) {
+	h := &ctx.Response.Header
+	resp.Header.CopyTo(h)
+	if resp.Header.StatusCode() != StatusSwitchingProtocols {
+		removeHopHeaders(h.PeekBytes(strConnection), h.DelBytes)
+	}
+	h.connectionClose = false
+}
+
+// rewriteLocation rewrites absolute Location pointing to the upstream host,
+// so it points to the requested host.
+func (p *ReverseProxy) rewriteLocation(ctx *RequestCtx, location []byte) {
+	if !bytes.HasPrefix(location, strHTTP) {
+		return
+	}
+	n := bytes.Index(location, strColonSlashSlash)
+	if n < 0 {
+		return
+	}
+	scheme := location[:n]
+	if !bytes.Equal(scheme, strHTTP) && !bytes.Equal(scheme, strHTTPS) {
+		return
+	}
+	// The authority ends at the path, query or fragment.
+	hostEnd := bytes.IndexAny(location[n+len(strColonSlashSlash):], "/?#")
+	if hostEnd < 0 {
+		hostEnd = len(location)
+	} else {
+		hostEnd += n + len(strColonSlashSlash)
+	}
+	host := location[n+len(strColonSlashSlash) : hostEnd]
+	if !p.isUpstreamHost(host, bytes.Equal(scheme, strHTTPS)) {
+		return
+	}
+
+	var b []byte
+	if ctx.IsTLS() {
+		b = append(b, strHTTPS...)
+	} else {
+		b = append(b, strHTTP...)
+	}
+	b = append(b, strColonSlashSlash...)
+	b = append(b, ctx.Host()...)
+	b = append(b, location[hostEnd:]...)
+	ctx.Response.Header.SetBytesKV(strLocation, b)
+}
+
+func (p *ReverseProxy) isUpstreamHost(host []byte, isTLS bool) bool {
+	host = bytes.ToLower(host)
+	addr := addMissingPort(string(host), isTLS)
+	if addr == addMissingPort(p.Client.Addr, isTLS) {
+		return true
+	}
+	return len(p.Host) > 0 && addr == addMissingPort(p.Host, isTLS)
+}
+
+// handleProxyError writes error response for the given upstream error to ctx.
+func handleProxyError(ctx *RequestCtx, upstream string, err error) {
+	ctx.Logger().Printf("error when proxying the request to %q: %s", upstream, err)
+	if isTimeoutError(err) {
+		ctx.Error(StatusMessage(StatusGatewayTimeout), StatusGatewayTimeout)
+		return
+	}
+	ctx.Error(StatusMessage(StatusBadGateway), StatusBadGateway)
+}
+
+func isTimeoutError(err error) bool {
+	if err == ErrTimeout {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// proxyUpgrade passes 'Connection: Upgrade' request to the upstream
+// over a dedicated connection. The connection is hijacked
+// if the upstream switches protocols.
+func (p *ReverseProxy) proxyUpgrade(ctx *RequestCtx, req *Request) {
+	var deadline time.Time
+	if p.Timeout > 0 {
+		deadline = time.Now().Add(p.Timeout)
+	}
+	conn, err := p.Client.dialHost(deadline, nil, req.Trace)
+	if err != nil {
+		handleProxyError(ctx, p.Client.Addr, err)
+		return
+	}
+	if p.Timeout > 0 {
+		if err = conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			handleProxyError(ctx, p.Client.Addr, err)
+			return
+		}
+	}
+
+	bw := bufio.NewWriter(conn)
+	if err = req.Write(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		handleProxyError(ctx, p.Client.Addr, err)
+		return
+	}
+
+	br := bufio.NewReader(conn)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	if err = resp.ReadLimitBody(br, p.Client.MaxResponseBodySize); err != nil {
+		conn.Close()
+		handleProxyError(ctx, p.Client.Addr, err)
+		return
+	}
+	copyProxyResponse(ctx, resp)
+	if location := ctx.Response.Header.PeekBytes(strLocation); len(location) > 0 {
+		p.rewriteLocation(ctx, location)
+	}
+	if resp.StatusCode() != StatusSwitchingProtocols {
+		conn.Close()
+		ctx.Response.SetBody(resp.Body())
+		return
+	}
+	if err = conn.SetDeadline(zeroTime); err != nil {
+		conn.Close()
+		handleProxyError(ctx, p.Client.Addr, err)
+		return
+	}
+
+	ctx.Hijack(func(c net.Conn) {
+		// br may contain data sent by the upstream
+		// right after the response header.
+		pipeConns(c, conn, br)
+	})
+}
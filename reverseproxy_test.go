@@ -0,0 +1,375 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseProxy(t *testing.T) {
+	var upstreamAddr string
+	upstreamAddr, stopUpstream := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			h := &ctx.Request.Header
+			fmt.Fprintf(ctx, "uri=%s, host=%s, xff=%s, forwarded=%s, foo=%s, hop=%s, body=%s",
+				h.RequestURI(), h.Host(), h.Peek("X-Forwarded-For"), h.Peek("Forwarded"),
+				h.Peek("X-Foo"), h.Peek("X-Hop"), ctx.PostBody())
+			ctx.Response.Header.Set("Connection", "X-Resp-Hop")
+			ctx.Response.Header.Set("X-Resp-Hop", "xxx")
+			ctx.Response.Header.Set("X-Resp", "yyy")
+			if string(ctx.Path()) == "/redirect" {
+				ctx.Response.Header.Set("Location", "http://"+upstreamAddr+"/foo?bar")
+				ctx.SetStatusCode(StatusFound)
+			}
+		},
+	})
+	defer stopUpstream()
+
+	p := &ReverseProxy{
+		Client: &HostClient{
+			Addr: upstreamAddr,
+		},
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	c := &HostClient{
+		Addr: proxyAddr,
+	}
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/foo/bar?baz=1")
+	req.Header.SetMethod("POST")
+	req.Header.Set("X-Foo", "foo")
+	req.Header.Set("X-Hop", "hop")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.SetBodyString("request body")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	expectedBody := fmt.Sprintf("uri=/foo/bar?baz=1, host=%s, xff=10.0.0.1, 127.0.0.1, "+
+		"forwarded=for=127.0.0.1;host=example.com;proto=http, foo=foo, hop=, body=request body", upstreamAddr)
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if v := resp.Header.Peek("X-Resp-Hop"); len(v) > 0 {
+		t.Fatalf("unexpected hop-by-hop header in response: %q", v)
+	}
+	if v := resp.Header.Peek("X-Resp"); string(v) != "yyy" {
+		t.Fatalf("unexpected header value %q. Expecting %q", v, "yyy")
+	}
+
+	req.Reset()
+	resp.Reset()
+	req.SetRequestURI("http://example.com/redirect")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusFound {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusFound)
+	}
+	location := resp.Header.Peek("Location")
+	if string(location) != "http://example.com/foo?bar" {
+		t.Fatalf("unexpected location %q. Expecting %q", location, "http://example.com/foo?bar")
+	}
+
+	req.Reset()
+	resp.Reset()
+	req.SetRequestURI("http://example.com/head")
+	req.Header.SetMethod("HEAD")
+	if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+}
+
+func TestReverseProxyStreamBody(t *testing.T) {
+	upstreamAddr, stopUpstream := startTestServer(t, &Server{
+		StreamRequestBody: true,
+		Handler: func(ctx *RequestCtx) {
+			n, err := io.Copy(io.MultiWriter(), ctx.RequestBodyStream())
+			if err != nil {
+				ctx.Error(err.Error(), StatusInternalServerError)
+				return
+			}
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				for i := 0; i < 1000; i++ {
+					fmt.Fprintf(w, "line %d of request with %d bytes\n", i, n)
+				}
+			})
+		},
+	})
+	defer stopUpstream()
+
+	p := &ReverseProxy{
+		Client: &HostClient{
+			Addr: upstreamAddr,
+		},
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		StreamRequestBody:  true,
+		MaxRequestBodySize: 1024,
+		Handler:            p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	c := &HostClient{
+		Addr: proxyAddr,
+	}
+
+	body := bytes.Repeat([]byte("x"), 100*1024)
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/upload")
+	req.Header.SetMethod("POST")
+	req.SetBodyStream(bytes.NewReader(body), -1)
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d. Body: %q", resp.StatusCode(), StatusOK, resp.Body())
+	}
+	var expectedBody bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&expectedBody, "line %d of request with %d bytes\n", i, len(body))
+	}
+	if !bytes.Equal(resp.Body(), expectedBody.Bytes()) {
+		t.Fatalf("unexpected body with length %d. Expecting body with length %d", len(resp.Body()), expectedBody.Len())
+	}
+}
+
+func TestReverseProxyStreamBodyTimeout(t *testing.T) {
+	upstreamAddr, stopUpstream := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(200 * time.Millisecond)
+		},
+	})
+	defer stopUpstream()
+
+	p := &ReverseProxy{
+		Client: &HostClient{
+			Addr: upstreamAddr,
+		},
+		Timeout: 50 * time.Millisecond,
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		StreamRequestBody: true,
+		Handler:           p.NewRequestHandler(),
+		Logger:            &customLogger{},
+	})
+	defer stopProxy()
+
+	c := &HostClient{
+		Addr: proxyAddr,
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/upload")
+	req.Header.SetMethod("POST")
+	req.SetBodyString("foobar")
+	if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusGatewayTimeout {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusGatewayTimeout)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting 'Connection: close' response header")
+	}
+}
+
+func TestReverseProxyErrors(t *testing.T) {
+	upstreamAddr, stopUpstream := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(200 * time.Millisecond)
+		},
+	})
+	defer stopUpstream()
+
+	p := &ReverseProxy{
+		Client: &HostClient{
+			Addr: upstreamAddr,
+		},
+		Timeout: 50 * time.Millisecond,
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+		Logger:  &customLogger{},
+	})
+	defer stopProxy()
+	testReverseProxyStatusCode(t, proxyAddr, StatusGatewayTimeout)
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+	p = &ReverseProxy{
+		Client: &HostClient{
+			Addr: closedAddr,
+		},
+	}
+	proxyAddr, stopProxy = startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+		Logger:  &customLogger{},
+	})
+	defer stopProxy()
+	testReverseProxyStatusCode(t, proxyAddr, StatusBadGateway)
+}
+
+func testReverseProxyStatusCode(t *testing.T, proxyAddr string, expectedStatusCode int) {
+	c := &HostClient{
+		Addr: proxyAddr,
+	}
+	statusCode, _, err := c.Get(nil, "http://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, expectedStatusCode)
+	}
+}
+
+func TestReverseProxyUpgrade(t *testing.T) {
+	upstreamAddr, stopUpstream := startTestServer(t, &Server{
+		Handler: func(ctx *RequestCtx) {
+			if !hasHeaderToken(ctx.Request.Header.Peek("Connection"), strUpgrade) ||
+				string(ctx.Request.Header.Peek("Upgrade")) != "echo" {
+				ctx.Error("expecting upgrade", StatusBadRequest)
+				return
+			}
+			ctx.SetStatusCode(StatusSwitchingProtocols)
+			ctx.Response.Header.Set("Connection", "Upgrade")
+			ctx.Response.Header.Set("Upgrade", "echo")
+			ctx.Hijack(func(c net.Conn) {
+				io.Copy(c, c)
+			})
+		},
+	})
+	defer stopUpstream()
+
+	p := &ReverseProxy{
+		Client: &HostClient{
+			Addr: upstreamAddr,
+		},
+		Timeout: time.Second,
+	}
+	proxyAddr, stopProxy := startTestServer(t, &Server{
+		Handler: p.NewRequestHandler(),
+	})
+	defer stopProxy()
+
+	conn, err := net.Dial("tcp4", proxyAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("GET /echo HTTP/1.1\r\nHost: example.com\r\n" +
+		"Connection: keep-alive, Upgrade\r\nUpgrade: echo\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(conn)
+	var resp Response
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+	}
+	if v := resp.Header.Peek("Upgrade"); string(v) != "echo" {
+		t.Fatalf("unexpected Upgrade header %q. Expecting %q", v, "echo")
+	}
+
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("hello %d\n", i)
+		if _, err = conn.Write([]byte(msg)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if line != msg {
+			t.Fatalf("unexpected message %q. Expecting %q", line, msg)
+		}
+	}
+}
+
+func TestRemoveHopHeaders(t *testing.T) {
+	var h RequestHeader
+	h.Set("Connection", "X-Foo, keep-alive , X-Bar")
+	h.Set("X-Foo", "foo")
+	h.Set("X-Bar", "bar")
+	h.Set("X-Baz", "baz")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Te", "trailers")
+	h.Set("Upgrade", "websocket")
+	removeHopHeaders(h.Peek("Connection"), h.DelBytes)
+
+	s := h.String()
+	for _, key := range []string{"Connection", "X-Foo", "X-Bar", "Keep-Alive", "Te", "Upgrade"} {
+		if strings.Contains(s, key+":") {
+			t.Fatalf("unexpected header %q in %q", key, s)
+		}
+	}
+	if v := h.Peek("X-Baz"); string(v) != "baz" {
+		t.Fatalf("unexpected header value %q. Expecting %q", v, "baz")
+	}
+}
+
+func TestAppendForwardedValue(t *testing.T) {
+	testAppendForwardedValue(t, "example.com", "example.com")
+	testAppendForwardedValue(t, "example.com:8080", `"example.com:8080"`)
+	testAppendForwardedValue(t, "[::1]:8080", `"[::1]:8080"`)
+	testAppendForwardedValue(t, `foo"bar\`, `"foo\"bar\\"`)
+	testAppendForwardedValue(t, "", `""`)
+}
+
+func testAppendForwardedValue(t *testing.T, v, expectedResult string) {
+	result := appendForwardedValue(nil, []byte(v))
+	if string(result) != expectedResult {
+		t.Fatalf("unexpected result for %q: %q. Expecting %q", v, result, expectedResult)
+	}
+}
+
+func TestReverseProxyRewriteLocation(t *testing.T) {
+	testReverseProxyRewriteLocation(t, "http://upstream/foo?bar", "http://example.com/foo?bar")
+	testReverseProxyRewriteLocation(t, "http://upstream:80", "http://example.com")
+	testReverseProxyRewriteLocation(t, "http://upstream?x", "http://example.com?x")
+	testReverseProxyRewriteLocation(t, "http://upstream#x", "http://example.com#x")
+	testReverseProxyRewriteLocation(t, "http://UPSTREAM/", "http://example.com/")
+	testReverseProxyRewriteLocation(t, "http://upstream.example.com?x", "http://upstream.example.com?x")
+	testReverseProxyRewriteLocation(t, "https://upstream/foo", "http://example.com/foo")
+	testReverseProxyRewriteLocation(t, "https://upstream:8443/foo", "https://upstream:8443/foo")
+	testReverseProxyRewriteLocation(t, "/foo", "/foo")
+}
+
+func testReverseProxyRewriteLocation(t *testing.T, location, expectedLocation string) {
+	p := &ReverseProxy{
+		Client: &HostClient{
+			Addr: "upstream",
+		},
+	}
+	var ctx RequestCtx
+	ctx.Request.Header.SetHost("example.com")
+	ctx.Response.Header.Set("Location", location)
+	p.rewriteLocation(&ctx, ctx.Response.Header.Peek("Location"))
+	if v := ctx.Response.Header.Peek("Location"); string(v) != expectedLocation {
+		t.Fatalf("unexpected location for %q: %q. Expecting %q", location, v, expectedLocation)
+	}
+}
@@ -187,15 +187,57 @@ type Server struct {
 	// Server accepts all the requests by default.
 	GetOnly bool
 
+	// Passes request body to Handler as a stream if set to true.
+	//
+	// Handler reads request body via RequestCtx.RequestBodyStream
+	// directly from the connection, so arbitrary large request bodies
+	// may be processed without buffering them in memory.
+	// MaxRequestBodySize doesn't limit the streamed body size.
+	// It limits only the body size read into memory
+	// by RequestCtx.PostBody, RequestCtx.PostArgs and
+	// RequestCtx.MultipartForm.
+	//
+	// The unread part of request body is drained after Handler returns.
+	// The connection is closed instead if too many unread bytes remain.
+	//
+	// Request body is read into memory before calling Handler by default.
+	StreamRequestBody bool
+
 	// Logger, which is used by RequestCtx.Logger().
 	//
 	// By default standard logger from log package is used.
 	Logger Logger
 
+	// Enables HTTP/2 support if set to true.
+	//
+	// HTTP/2 is negotiated via ALPN on TLS connections served
+	// by ListenAndServeTLS. Plaintext connections are switched to HTTP/2
+	// either by 'Upgrade: h2c' request or by HTTP/2 connection preface
+	// (prior knowledge). Requests from HTTP/2 streams are passed
+	// to the same Handler as HTTP/1 requests.
+	//
+	// The total number of concurrent HTTP/2 streams served by the server
+	// is limited by Concurrency. ReadTimeout limits waiting for the next
+	// frame while the connection is idle or request bodies are received.
+	// The client may send request body data only as fast as it is read,
+	// see StreamRequestBody.
+	//
+	// HTTP/2 support is disabled by default.
+	EnableHTTP2 bool
+
 	concurrency      uint32
+	http2Streams     uint32
 	perIPConnCounter perIPConnCounter
 	serverName       atomic.Value
 
+	// shutdown stuff.
+	stop      int32
+	mu        sync.Mutex
+	ln        []net.Listener
+	conns     map[*trackedConn]struct{}
+	openConns int
+	doneCh    chan struct{}
+
 	ctxPool        sync.Pool
 	readerPool     sync.Pool
 	writerPool     sync.Pool
@@ -323,6 +365,7 @@ type HijackHandler func(c net.Conn)
 //
 //     * 'Connection: close' header exists in either request or response.
 //     * Unexpected error during response writing to the connection.
+//     * The request is served over HTTP/2.
 //
 // The server stops processing requests from hijacked connections.
 // Server limits such as Concurrency, ReadTimeout, WriteTimeout, etc.
@@ -333,7 +376,8 @@ type HijackHandler func(c net.Conn)
 // Arbitrary 'Connection: Upgrade' protocols may be implemented
 // with HijackHandler. For instance,
 //
-//     * WebSocket ( https://en.wikipedia.org/wiki/WebSocket )
+//     * WebSocket ( https://en.wikipedia.org/wiki/WebSocket ).
+//       See github.com/valyala/fasthttp/websocket package.
 //     * HTTP/2.0 ( https://en.wikipedia.org/wiki/HTTP/2 )
 //
 func (ctx *RequestCtx) Hijack(handler HijackHandler) {
@@ -639,6 +683,7 @@ func (ctx *RequestCtx) SuccessString(contentType, body string) {
 //    * StatusFound (302)
 //    * StatusSeeOther (303)
 //    * StatusTemporaryRedirect (307)
+//    * StatusPermanentRedirect (308)
 //
 // All other statusCode values are replaced by StatusFound (302).
 //
@@ -659,6 +704,7 @@ func (ctx *RequestCtx) Redirect(uri string, statusCode int) {
 //    * StatusFound (302)
 //    * StatusSeeOther (303)
 //    * StatusTemporaryRedirect (307)
+//    * StatusPermanentRedirect (308)
 //
 // All other statusCode values are replaced by StatusFound (302).
 //
@@ -678,13 +724,22 @@ func (ctx *RequestCtx) redirect(uri []byte, statusCode int) {
 }
 
 func getRedirectStatusCode(statusCode int) int {
-	if statusCode == StatusMovedPermanently || statusCode == StatusFound ||
-		statusCode == StatusSeeOther || statusCode == StatusTemporaryRedirect {
+	if isRedirectStatusCode(statusCode) {
 		return statusCode
 	}
 	return StatusFound
 }
 
+func isRedirectStatusCode(statusCode int) bool {
+	switch statusCode {
+	case StatusMovedPermanently, StatusFound, StatusSeeOther,
+		StatusTemporaryRedirect, StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
 // SetBody sets response body to the given value.
 func (ctx *RequestCtx) SetBody(body []byte) {
 	ctx.Response.SetBody(body)
@@ -702,9 +757,15 @@ func (ctx *RequestCtx) ResetBody() {
 
 // SendFile sends local file contents from the given path as response body.
 //
+// 'Range' and 'If-Range' request headers are honored, so only
+// the requested byte ranges of the file are sent in '206 Partial Content'
+// response. '416 Requested Range Not Satisfiable' response is set
+// if the requested byte ranges are outside the file.
+//
 // Note that SendFile doesn't set Content-Type for the response body,
-// so set it yourself with SetContentType() before returning
-// from RequestHandler.
+// so set it yourself with SetContentType(). Content-Type is sent
+// in each part of multipart/byteranges response to multiple byte ranges
+// request.
 func (ctx *RequestCtx) SendFile(path string) error {
 	ifModStr := ctx.Request.Header.peek(strIfModifiedSince)
 	if len(ifModStr) > 0 {
@@ -719,7 +780,45 @@ func (ctx *RequestCtx) SendFile(path string) error {
 			}
 		}
 	}
-	return ctx.Response.SendFile(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size64 := fileInfo.Size()
+	size := int(size64)
+	if int64(size) != size64 {
+		size = -1
+	}
+
+	hdr := &ctx.Response.Header
+	hdr.SetLastModified(fileInfo.ModTime())
+	if size >= 0 {
+		byteRanges, err := ctx.requestedByteRanges(size, nil, fileInfo.ModTime())
+		if err != nil {
+			f.Close()
+			ctx.byteRangeNotSatisfiable(size)
+			return nil
+		}
+		hdr.SetCanonical(strAcceptRanges, strBytes)
+		if len(byteRanges) > 0 {
+			r := &fileRangeReader{
+				f: f,
+			}
+			if err = ctx.setByteRangesBody(byteRanges, r, f, size); err != nil {
+				f.Close()
+				return err
+			}
+			return nil
+		}
+	}
+	ctx.Response.SetBodyStream(f, size)
+	return nil
 }
 
 // IfModifiedSince returns true if lastModified exceeds 'If-Modified-Since'
@@ -771,6 +870,14 @@ func (ctx *RequestCtx) PostBody() []byte {
 	return ctx.Request.Body()
 }
 
+// RequestBodyStream returns a reader for the request body.
+//
+// The body is read directly from the connection if Server.StreamRequestBody
+// is set. The returned reader is valid until RequestHandler return.
+func (ctx *RequestCtx) RequestBodyStream() io.Reader {
+	return ctx.Request.BodyStream()
+}
+
 // SetBodyStream sets response body stream and, optionally body size.
 //
 // bodyStream.Close() is called after finishing reading all body data
@@ -899,6 +1006,9 @@ func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 	}
+	if s.EnableHTTP2 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
 	ln, err := tls.Listen("tcp", addr, tlsConfig)
 	if err != nil {
 		return err
@@ -912,15 +1022,23 @@ const DefaultConcurrency = 256 * 1024
 // Serve serves incoming connections from the given listener.
 //
 // Serve blocks until the given listener returns permanent error.
+// Serve returns nil after Shutdown call.
 func (s *Server) Serve(ln net.Listener) error {
 	var lastOverflowErrorTime time.Time
 	var lastPerIPErrorTime time.Time
 	var c net.Conn
 	var err error
 
+	if !s.registerListener(ln) {
+		// The server is shut down.
+		ln.Close()
+		return nil
+	}
+	defer s.unregisterListener(ln)
+
 	maxWorkersCount := s.getConcurrency()
 	wp := &workerPool{
-		WorkerFunc:      s.serveConn,
+		WorkerFunc:      s.serveAcceptedConn,
 		MaxWorkersCount: maxWorkersCount,
 		Logger:          s.logger(),
 	}
@@ -929,12 +1047,16 @@ func (s *Server) Serve(ln net.Listener) error {
 	for {
 		if c, err = acceptConn(s, ln, &lastPerIPErrorTime); err != nil {
 			wp.Stop()
-			if err == io.EOF {
+			if err == io.EOF || atomic.LoadInt32(&s.stop) == 1 {
 				return nil
 			}
 			return err
 		}
+		// The connection is counted before the handoff to wp,
+		// so ShutdownDeadline waits for connections queued in wp.
+		s.incOpenConns()
 		if !wp.Serve(c) {
+			s.decOpenConns()
 			c.Close()
 			if time.Since(lastOverflowErrorTime) > time.Minute {
 				s.logger().Printf("The incoming connection cannot be served, because %d concurrent connections are served. "+
@@ -950,6 +1072,9 @@ func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.
 	for {
 		c, err := ln.Accept()
 		if err != nil {
+			if atomic.LoadInt32(&s.stop) == 1 {
+				return nil, io.EOF
+			}
 			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
 				s.logger().Printf("Temporary error when accepting new connections: %s", netErr)
 				time.Sleep(time.Second)
@@ -994,6 +1119,170 @@ func wrapPerIPConn(s *Server, c net.Conn) net.Conn {
 	return acquirePerIPConn(c, ip, &s.perIPConnCounter)
 }
 
+// Shutdown gracefully shuts down the server without interrupting
+// requests being served.
+//
+// Shutdown closes all the listeners passed to Serve, so Serve returns nil.
+// Then it closes idle keep-alive connections and waits until all
+// the connections being served exit. Connections serving requests
+// at the moment send 'Connection: close' header in the response
+// and are closed after the response is sent.
+//
+// Hijacked connections aren't tracked by Shutdown.
+//
+// The server cannot be started again after Shutdown call.
+func (s *Server) Shutdown() error {
+	return s.ShutdownDeadline(zeroTime)
+}
+
+// ShutdownDeadline works like Shutdown, but waits for active connections
+// until the given deadline.
+//
+// ErrTimeout is returned if active connections didn't exit until
+// the deadline. Zero deadline means waiting without time limit.
+func (s *Server) ShutdownDeadline(deadline time.Time) error {
+	s.mu.Lock()
+	atomic.StoreInt32(&s.stop, 1)
+	for _, ln := range s.ln {
+		ln.Close()
+	}
+	s.ln = nil
+
+	// Interrupt reading requests from idle connections.
+	// They'll notice s.stop and exit.
+	for tc := range s.conns {
+		tc.lock.Lock()
+		if tc.idle {
+			tc.c.SetReadDeadline(shutdownTime)
+		}
+		tc.lock.Unlock()
+	}
+
+	if s.openConns == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.doneCh == nil {
+		s.doneCh = make(chan struct{})
+	}
+	doneCh := s.doneCh
+	s.mu.Unlock()
+
+	if deadline.IsZero() {
+		<-doneCh
+		return nil
+	}
+
+	timeout := -time.Since(deadline)
+	if timeout <= 0 {
+		return ErrTimeout
+	}
+	tc := time.NewTimer(timeout)
+	defer stopTimer(tc)
+	select {
+	case <-doneCh:
+		return nil
+	case <-tc.C:
+		return ErrTimeout
+	}
+}
+
+// shutdownTime is used as read deadline for interrupting idle connections.
+var shutdownTime = time.Unix(1, 0)
+
+func (s *Server) registerListener(ln net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if atomic.LoadInt32(&s.stop) == 1 {
+		return false
+	}
+	s.ln = append(s.ln, ln)
+	return true
+}
+
+func (s *Server) unregisterListener(ln net.Listener) {
+	s.mu.Lock()
+	for i, x := range s.ln {
+		if x == ln {
+			s.ln = append(s.ln[:i], s.ln[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) incOpenConns() {
+	s.mu.Lock()
+	s.openConns++
+	s.mu.Unlock()
+}
+
+func (s *Server) decOpenConns() {
+	s.mu.Lock()
+	s.openConns--
+	if s.openConns == 0 && s.doneCh != nil {
+		close(s.doneCh)
+		s.doneCh = nil
+	}
+	s.mu.Unlock()
+}
+
+// trackedConn is the connection tracked by Shutdown.
+type trackedConn struct {
+	c net.Conn
+
+	// lock is per-connection, so switching between idle and active
+	// states doesn't contend with other connections.
+	lock sync.Mutex
+	idle bool
+}
+
+// trackConn registers c for Shutdown until untrackConn is called.
+func (s *Server) trackConn(c net.Conn) *trackedConn {
+	tc := &trackedConn{
+		c: c,
+	}
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[*trackedConn]struct{})
+	}
+	s.conns[tc] = struct{}{}
+	s.mu.Unlock()
+	return tc
+}
+
+func (s *Server) untrackConn(tc *trackedConn) {
+	s.mu.Lock()
+	delete(s.conns, tc)
+	s.mu.Unlock()
+}
+
+// setConnIdle marks tc as waiting for the next request.
+//
+// Returns false if the server is shutting down, so the connection
+// must be closed.
+func (s *Server) setConnIdle(tc *trackedConn) bool {
+	tc.lock.Lock()
+	tc.idle = true
+	tc.lock.Unlock()
+
+	// Shutdown sets s.stop before checking tc.idle, so either
+	// s.stop is noticed here or Shutdown interrupts the connection.
+	return atomic.LoadInt32(&s.stop) == 0
+}
+
+// setConnActive marks tc as serving the request.
+//
+// Returns true if the server started shutting down while tc was idle.
+// Read deadline set by Shutdown may be safely restored then, since
+// Shutdown doesn't touch active connections.
+func (s *Server) setConnActive(tc *trackedConn) bool {
+	tc.lock.Lock()
+	tc.idle = false
+	tc.lock.Unlock()
+	return atomic.LoadInt32(&s.stop) == 1
+}
+
 var defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
 
 func (s *Server) logger() Logger {
@@ -1043,7 +1332,9 @@ func (s *Server) ServeConn(c net.Conn) error {
 		return ErrConcurrencyLimit
 	}
 
+	s.incOpenConns()
 	err := s.serveConn(c)
+	s.decOpenConns()
 
 	atomic.AddUint32(&s.concurrency, ^uint32(0))
 
@@ -1068,6 +1359,12 @@ func (s *Server) getConcurrency() int {
 	return n
 }
 
+// serveAcceptedConn serves the connection counted by Serve.
+func (s *Server) serveAcceptedConn(c net.Conn) error {
+	defer s.decOpenConns()
+	return s.serveConn(c)
+}
+
 func (s *Server) serveConn(c net.Conn) error {
 	currentTime := time.Now()
 	connTime := currentTime
@@ -1081,6 +1378,19 @@ func (s *Server) serveConn(c net.Conn) error {
 	var connectionClose bool
 	var timeoutResponse *Response
 	var hijackHandler HijackHandler
+	var bodyStream *bodyStreamReader
+	var readDeadline time.Time
+	var idle bool
+
+	tc := s.trackConn(c)
+	defer s.untrackConn(tc)
+
+	if s.EnableHTTP2 && isHTTP2TLSConn(c, s.ReadTimeout) {
+		err = s.serveHTTP2(ctx, nil, nil, nil)
+		s.releaseCtx(ctx)
+		return err
+	}
+
 	for {
 		ctx.id++
 		connRequestNum++
@@ -1098,22 +1408,58 @@ func (s *Server) serveConn(c net.Conn) error {
 					readTimeout = connTimeout
 				}
 			}
-			if err = c.SetReadDeadline(currentTime.Add(readTimeout)); err != nil {
+			readDeadline = currentTime.Add(readTimeout)
+			if err = c.SetReadDeadline(readDeadline); err != nil {
 				break
 			}
 		}
 
+		// The connection is idle if there are no pipelined requests
+		// in the read buffer.
+		idle = br == nil
+		if idle && !s.setConnIdle(tc) {
+			// The server is shutting down.
+			break
+		}
+
 		if !(s.ReduceMemoryUsage || ctx.lastReadDuration > time.Second) || br != nil {
 			if br == nil {
 				br = acquireReader(ctx)
 			}
+			if idle {
+				// Wait for the first byte of the request.
+				if _, err = br.Peek(1); err != nil {
+					// treat all errors on the first byte read as EOF
+					err = io.EOF
+				}
+			}
 		} else {
 			br, err = acquireByteReader(&ctx)
 		}
 
+		if idle && s.setConnActive(tc) {
+			if err != nil {
+				// Shutdown interrupted waiting for the request.
+				err = io.EOF
+			} else {
+				// The request arrived while shutting down. Restore
+				// read deadline, which could be reset by Shutdown.
+				err = c.SetReadDeadline(readDeadline)
+			}
+		}
+
+		if err == nil && connRequestNum == 1 && s.EnableHTTP2 && hasHTTP2Preface(br) {
+			// HTTP/2 with prior knowledge.
+			err = s.serveHTTP2(ctx, br, nil, nil)
+			br = nil
+			break
+		}
+
 		if err == nil {
-			err = ctx.Request.readLimitBody(br, s.MaxRequestBodySize, s.GetOnly)
-			if br.Buffered() == 0 || err != nil {
+			err = ctx.Request.readLimitBody(br, s.MaxRequestBodySize, s.GetOnly, s.StreamRequestBody)
+			// Request body is read from br by the handler
+			// if StreamRequestBody is set.
+			if (br.Buffered() == 0 && !s.StreamRequestBody) || err != nil {
 				releaseReader(s, br)
 				br = nil
 			}
@@ -1145,15 +1491,41 @@ func (s *Server) serveConn(c net.Conn) error {
 			}
 
 			// Read request body.
-			if br == nil {
-				br = acquireReader(ctx)
+			if !s.StreamRequestBody {
+				if br == nil {
+					br = acquireReader(ctx)
+				}
+				err = ctx.Request.ContinueReadBody(br, s.MaxRequestBodySize)
+				if br.Buffered() == 0 || err != nil {
+					releaseReader(s, br)
+					br = nil
+				}
+				if err != nil {
+					break
+				}
 			}
-			err = ctx.Request.ContinueReadBody(br, s.MaxRequestBodySize)
-			if br.Buffered() == 0 || err != nil {
-				releaseReader(s, br)
+		}
+
+		if s.StreamRequestBody {
+			bodyStream = acquireBodyStreamReader(br, ctx.Request.Header.ContentLength(), s.MaxRequestBodySize)
+			ctx.Request.bodyStream = bodyStream
+		}
+
+		if s.EnableHTTP2 {
+			if settings, ok := http2UpgradeSettings(&ctx.Request.Header); ok {
+				if bodyStream != nil {
+					// The upgrade request body is passed to HTTP/2 stream.
+					ctx.Request.Body()
+					if !bodyStream.drain() {
+						err = bodyStream.err
+						break
+					}
+					releaseBodyStreamReader(bodyStream)
+					bodyStream = nil
+				}
+				err = s.upgradeHTTP2(ctx, br, bw, settings)
 				br = nil
-			}
-			if err != nil {
+				bw = nil
 				break
 			}
 		}
@@ -1173,17 +1545,41 @@ func (s *Server) serveConn(c net.Conn) error {
 		ctx.Request.RemoveMultipartFormFiles()
 
 		timeoutResponse = ctx.timeoutResponse
+		if bodyStream != nil {
+			if timeoutResponse != nil {
+				// The handler may still read request body from br,
+				// so neither br nor bodyStream may be re-used.
+				// Close the connection.
+				br = nil
+			} else {
+				if !bodyStream.drain() {
+					ctx.SetConnectionClose()
+				}
+				ctx.Request.bodyStream = nil
+				releaseBodyStreamReader(bodyStream)
+				if br.Buffered() == 0 {
+					releaseReader(s, br)
+					br = nil
+				}
+			}
+			bodyStream = nil
+		}
 		if timeoutResponse != nil {
 			ctx = s.acquireCtx(c)
 			timeoutResponse.CopyTo(&ctx.Response)
-			if br != nil {
-				// Close connection, since br may be attached to the old ctx via ctx.fbr.
+			if br != nil || s.StreamRequestBody {
+				// Close connection, since br may be attached to the old ctx via ctx.fbr
+				// or request body may be still read by the handler.
 				ctx.SetConnectionClose()
 			}
 		}
 		if s.MaxRequestsPerConn > 0 && connRequestNum >= uint64(s.MaxRequestsPerConn) {
 			ctx.SetConnectionClose()
 		}
+		if atomic.LoadInt32(&s.stop) == 1 {
+			// The server is shutting down.
+			ctx.SetConnectionClose()
+		}
 
 		if s.WriteTimeout > 0 || s.MaxKeepaliveDuration > 0 {
 			writeTimeout := s.WriteTimeout
@@ -1325,6 +1721,14 @@ func (c hijackConn) Close() error {
 	return nil
 }
 
+func (c hijackConn) CloseWrite() error {
+	cw, ok := c.Conn.(closeWriter)
+	if !ok {
+		return errCloseWriteUnsupported
+	}
+	return cw.CloseWrite()
+}
+
 // LastTimeoutErrorResponse returns the last timeout response set
 // via TimeoutError* call.
 //
@@ -1342,7 +1746,9 @@ func writeResponse(ctx *RequestCtx, w *bufio.Writer) error {
 	if len(serverOld) == 0 {
 		h.server = ctx.s.getServerName()
 	}
+	h.connectTunnel = ctx.Request.Header.IsConnect()
 	err := ctx.Response.Write(w)
+	h.connectTunnel = false
 	if len(serverOld) == 0 {
 		h.server = serverOld
 	}
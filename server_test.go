@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -53,6 +56,127 @@ func TestServerExpect100Continue(t *testing.T) {
 	verifyResponse(t, br, StatusOK, string(defaultContentType), "foobar")
 }
 
+func TestServerStreamRequestBody(t *testing.T) {
+	s := &Server{
+		StreamRequestBody: true,
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/stream":
+				n, err := io.Copy(ioutil.Discard, ctx.RequestBodyStream())
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				fmt.Fprintf(ctx, "read %d bytes", n)
+			case "/args":
+				fmt.Fprintf(ctx, "foo=%s", ctx.PostArgs().Peek("foo"))
+			default:
+				// Do not read request body. It must be drained by the server.
+				ctx.WriteString("skipped")
+			}
+		},
+	}
+
+	body := createFixedBody(3 * maxRequestBodyDrainSize)
+	rw := &readWriter{}
+	rw.r.WriteString("POST /stream HTTP/1.1\r\nHost: google.com\r\nTransfer-Encoding: chunked\r\n\r\n")
+	rw.r.Write(createChunkedBody(body))
+	rw.r.WriteString("POST /skip HTTP/1.1\r\nHost: google.com\r\nContent-Length: 5\r\n\r\n12345")
+	rw.r.WriteString("POST /args HTTP/1.1\r\nHost: google.com\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 7\r\n\r\nfoo=bar")
+	rw.r.WriteString("GET /skip HTTP/1.1\r\nHost: google.com\r\n\r\n")
+	fmt.Fprintf(&rw.r, "POST /skip HTTP/1.1\r\nHost: google.com\r\nContent-Length: %d\r\n\r\n", len(body))
+	rw.r.Write(body)
+	rw.r.WriteString("GET /skip HTTP/1.1\r\nHost: google.com\r\n\r\n")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), fmt.Sprintf("read %d bytes", len(body)))
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "skipped")
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "foo=bar")
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "skipped")
+
+	// Too big unread body mustn't be drained. The connection must be closed.
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting 'Connection: close' response header")
+	}
+	if string(resp.Body()) != "skipped" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "skipped")
+	}
+	if br.Buffered() > 0 {
+		t.Fatalf("unexpected responses after closing the connection")
+	}
+}
+
+func TestServerStreamRequestBodyMaxRequestBodySize(t *testing.T) {
+	s := &Server{
+		StreamRequestBody:  true,
+		MaxRequestBodySize: 10,
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/stream" {
+				b, err := ioutil.ReadAll(ctx.RequestBodyStream())
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				ctx.Write(b)
+				return
+			}
+			if len(ctx.PostBody()) != 0 {
+				t.Fatalf("unexpected body %q. Expecting empty body", ctx.PostBody())
+			}
+			ctx.WriteString("too large")
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("POST /stream HTTP/1.1\r\nHost: google.com\r\nContent-Length: 15\r\n\r\n123456789012345")
+	rw.r.WriteString("POST /body HTTP/1.1\r\nHost: google.com\r\nContent-Length: 15\r\n\r\n123456789012345")
+	rw.r.WriteString("GET /foo HTTP/1.1\r\nHost: google.com\r\n\r\n")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "123456789012345")
+
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting 'Connection: close' response header")
+	}
+	if string(resp.Body()) != "too large" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "too large")
+	}
+}
+
 func TestCompressHandler(t *testing.T) {
 	expectedBody := "foo/bar/baz"
 	h := CompressHandler(func(ctx *RequestCtx) {
@@ -386,6 +510,132 @@ func TestRequestCtxSendFile(t *testing.T) {
 	}
 }
 
+func TestRequestCtxSendFileByteRange(t *testing.T) {
+	filePath := "./server_test.go"
+	body, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+
+	resp := testRequestCtxSendFileByteRange(t, filePath, "bytes=10-19")
+	if resp.StatusCode() != StatusPartialContent {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+	}
+	expectedContentRange := fmt.Sprintf("bytes 10-19/%d", len(body))
+	if string(resp.Header.Peek("Content-Range")) != expectedContentRange {
+		t.Fatalf("unexpected Content-Range %q. Expecting %q", resp.Header.Peek("Content-Range"), expectedContentRange)
+	}
+	if !bytes.Equal(resp.Body(), body[10:20]) {
+		t.Fatalf("unexpected response body: %q. Expecting %q", resp.Body(), body[10:20])
+	}
+
+	resp = testRequestCtxSendFileByteRange(t, filePath, "bytes=0-4,-5")
+	if resp.StatusCode() != StatusPartialContent {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+	}
+	if !strings.HasPrefix(string(resp.Header.ContentType()), "multipart/byteranges; boundary=") {
+		t.Fatalf("unexpected Content-Type %q", resp.Header.ContentType())
+	}
+	if !bytes.Contains(resp.Body(), body[:5]) || !bytes.Contains(resp.Body(), body[len(body)-5:]) {
+		t.Fatalf("unexpected response body: %q", resp.Body())
+	}
+
+	resp = testRequestCtxSendFileByteRange(t, filePath, fmt.Sprintf("bytes=%d-", len(body)))
+	if resp.StatusCode() != StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusRequestedRangeNotSatisfiable)
+	}
+
+	// Invalid Range header is ignored.
+	resp = testRequestCtxSendFileByteRange(t, filePath, "foobar")
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	if !bytes.Equal(resp.Body(), body) {
+		t.Fatalf("unexpected response body with length %d. Expecting length %d", len(resp.Body()), len(body))
+	}
+	if string(resp.Header.Peek("Accept-Ranges")) != "bytes" {
+		t.Fatalf("unexpected Accept-Ranges %q. Expecting %q", resp.Header.Peek("Accept-Ranges"), "bytes")
+	}
+}
+
+func TestRequestCtxSendFileByteRangesContentType(t *testing.T) {
+	filePath := "./server_test.go"
+	body, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+
+	var ctx RequestCtx
+	var req Request
+	req.Header.Set("Range", "bytes=0-4,-5")
+	ctx.Init(&req, nil, defaultLogger)
+
+	if err := ctx.SendFile(filePath); err != nil {
+		t.Fatalf("error in SendFile: %s", err)
+	}
+	// Content-Type set after SendFile must be used for each part.
+	ctx.SetContentType("text/x-go")
+
+	var resp Response
+	s := ctx.Response.String()
+	if err := resp.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusPartialContent {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+	}
+	contentType := string(resp.Header.ContentType())
+	if !strings.HasPrefix(contentType, "multipart/byteranges; boundary=") {
+		t.Fatalf("unexpected Content-Type %q", contentType)
+	}
+	mr := multipart.NewReader(bytes.NewReader(resp.Body()), contentType[len("multipart/byteranges; boundary="):])
+	for _, expectedBody := range [][]byte{body[:5], body[len(body)-5:]} {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if p.Header.Get("Content-Type") != "text/x-go" {
+			t.Fatalf("unexpected part Content-Type %q. Expecting %q", p.Header.Get("Content-Type"), "text/x-go")
+		}
+		b, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(b, expectedBody) {
+			t.Fatalf("unexpected part body %q. Expecting %q", b, expectedBody)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+}
+
+func testRequestCtxSendFileByteRange(t *testing.T, filePath, byteRange string) *Response {
+	var ctx RequestCtx
+	var req Request
+	req.Header.Set("Range", byteRange)
+	ctx.Init(&req, nil, defaultLogger)
+
+	if err := ctx.SendFile(filePath); err != nil {
+		t.Fatalf("error in SendFile: %s", err)
+	}
+
+	w := &bytes.Buffer{}
+	bw := bufio.NewWriter(w)
+	if err := ctx.Response.Write(bw); err != nil {
+		t.Fatalf("error when writing response: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("error when flushing response: %s", err)
+	}
+
+	var resp Response
+	if err := resp.Read(bufio.NewReader(w)); err != nil {
+		t.Fatalf("error when reading response: %s", err)
+	}
+	return &resp
+}
+
 func TestRequestCtxHijack(t *testing.T) {
 	hijackStartCh := make(chan struct{})
 	hijackStopCh := make(chan struct{})
@@ -826,6 +1076,232 @@ func TestServerConnectionClose(t *testing.T) {
 	}
 }
 
+func TestServerShutdown(t *testing.T) {
+	handlerStartCh := make(chan struct{})
+	handlerStopCh := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			close(handlerStartCh)
+			<-handlerStopCh
+			ctx.Success("aaa/bbb", []byte("done"))
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- s.Serve(ln)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial: %s", err)
+	}
+	defer c.Close()
+	idleConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial: %s", err)
+	}
+	defer idleConn.Close()
+
+	if _, err = c.Write([]byte("GET /foo HTTP/1.1\r\nHost: google.com\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error when writing request: %s", err)
+	}
+	select {
+	case <-handlerStartCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown()
+	}()
+
+	select {
+	case err = <-serveCh:
+		if err != nil {
+			t.Fatalf("unexpected error returned from Serve: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	select {
+	case err = <-shutdownCh:
+		t.Fatalf("Shutdown mustn't return while the request is served. err=%v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(handlerStopCh)
+	select {
+	case err = <-shutdownCh:
+		if err != nil {
+			t.Fatalf("unexpected error returned from Shutdown: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(c)
+	var resp Response
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("unexpected error when reading response: %s", err)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("Response must have 'connection: close' header")
+	}
+	if string(resp.Body()) != "done" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "done")
+	}
+
+	// The idle connection must be closed by Shutdown.
+	idleConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := idleConn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("unexpected result when reading from idle connection: (%d, %v). Expecting (0, EOF)", n, err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	handlerStartCh := make(chan struct{})
+	handlerStopCh := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			close(handlerStartCh)
+			<-handlerStopCh
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET /foo HTTP/1.1\r\nHost: google.com\r\n\r\n")
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- s.ServeConn(rw)
+	}()
+	select {
+	case <-handlerStartCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if err := s.ShutdownDeadline(time.Now().Add(20 * time.Millisecond)); err != ErrTimeout {
+		t.Fatalf("unexpected error returned from ShutdownDeadline: %v. Expecting %v", err, ErrTimeout)
+	}
+	close(handlerStopCh)
+	if err := s.ShutdownDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error returned from ShutdownDeadline: %s", err)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("unexpected error returned from ServeConn: %s", err)
+	}
+
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error when reading response: %s", err)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("Response must have 'connection: close' header")
+	}
+}
+
+func TestServerShutdownAcceptedConn(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {},
+	}
+	ln := &countingListener{
+		s:       s,
+		connsCh: make(chan net.Conn, 1),
+		countCh: make(chan int, 1),
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	ln.connsCh <- serverConn
+
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- s.Serve(ln)
+	}()
+
+	// The connection must be counted as soon as it is handed off
+	// to the worker pool, even if it isn't served yet.
+	select {
+	case n := <-ln.countCh:
+		if n != 1 {
+			t.Fatalf("unexpected number of open connections: %d. Expecting 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if err := s.ShutdownDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error returned from ShutdownDeadline: %s", err)
+	}
+	s.mu.Lock()
+	n := s.openConns
+	s.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("unexpected number of open connections after shutdown: %d. Expecting 0", n)
+	}
+	select {
+	case err := <-serveCh:
+		if err != nil {
+			t.Fatalf("unexpected error returned from Serve: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+// countingListener returns connections from connsCh and reports
+// the number of open server connections on the subsequent Accept call.
+type countingListener struct {
+	s       *Server
+	connsCh chan net.Conn
+	countCh chan int
+
+	lock   sync.Mutex
+	closed bool
+}
+
+func (ln *countingListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.connsCh:
+		return c, nil
+	default:
+	}
+	ln.s.mu.Lock()
+	n := ln.s.openConns
+	ln.s.mu.Unlock()
+	select {
+	case ln.countCh <- n:
+	default:
+	}
+
+	for {
+		ln.lock.Lock()
+		closed := ln.closed
+		ln.lock.Unlock()
+		if closed {
+			return nil, io.EOF
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (ln *countingListener) Close() error {
+	ln.lock.Lock()
+	ln.closed = true
+	ln.lock.Unlock()
+	return nil
+}
+
+func (ln *countingListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
 func TestServerRequestNumAndTime(t *testing.T) {
 	n := uint64(0)
 	var connT time.Time
@@ -899,6 +1375,25 @@ func TestServerEmptyResponse(t *testing.T) {
 	verifyResponse(t, br, 200, string(defaultContentType), "")
 }
 
+// startTestServer starts s on a random local port.
+//
+// It returns the server address and the function stopping the server.
+func startTestServer(t *testing.T, s *Server) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	return ln.Addr().String(), func() {
+		ln.Close()
+		<-ch
+	}
+}
+
 type customLogger struct {
 	out string
 }
@@ -182,6 +182,66 @@ func BenchmarkServerTimeoutError(b *testing.B) {
 	verifyRequestsServed(b, ch)
 }
 
+func BenchmarkServerServeConnKeepAlive(b *testing.B) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Success("text/plain", fakeResponse)
+		},
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		// Each request is read separately, so the connection becomes
+		// idle before each request.
+		c := &fakeKeepAliveConn{
+			pb:      pb,
+			request: []byte(getRequest),
+		}
+		if err := s.ServeConn(c); err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
+// fakeKeepAliveConn returns a single request per Read call
+// until pb.Next returns false.
+type fakeKeepAliveConn struct {
+	net.TCPConn
+	pb      *testing.PB
+	request []byte
+	pos     int
+}
+
+func (c *fakeKeepAliveConn) Read(b []byte) (int, error) {
+	if c.pos == 0 && !c.pb.Next() {
+		return 0, io.EOF
+	}
+	n := copy(b, c.request[c.pos:])
+	c.pos += n
+	if c.pos == len(c.request) {
+		c.pos = 0
+	}
+	return n, nil
+}
+
+func (c *fakeKeepAliveConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *fakeKeepAliveConn) RemoteAddr() net.Addr {
+	return &fakeAddr
+}
+
+func (c *fakeKeepAliveConn) Close() error {
+	return nil
+}
+
+func (c *fakeKeepAliveConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *fakeKeepAliveConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
 type fakeServerConn struct {
 	net.TCPConn
 	ln            *fakeListener
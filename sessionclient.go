@@ -0,0 +1,339 @@
+package fasthttp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// SessionClient performs requests on behalf of a single client session.
+//
+// It has the following features:
+//
+//     * Stores cookies from 'Set-Cookie' response headers in a per-session
+//       cookie jar respecting cookie Domain, Path and Expires attributes.
+//     * Sends matching cookies from the jar with subsequent requests.
+//     * Sets 'Referer' request header to the previously fetched url,
+//       including intermediate urls while following redirects.
+//
+// It is forbidden copying SessionClient instances. Create new instances
+// instead.
+//
+// It is safe calling SessionClient methods from concurrently running
+// goroutines.
+type SessionClient struct {
+	// Client used for performing requests.
+	//
+	// Default client is used if not set.
+	Client *Client
+
+	mLock   sync.Mutex
+	cookies []sessionCookie
+	referer []byte
+}
+
+type sessionCookie struct {
+	key      []byte
+	value    []byte
+	domain   []byte
+	path     []byte
+	expire   time.Time
+	hostOnly bool
+}
+
+// Get appends url contents to dst and returns it as body.
+//
+// Redirects are followed. Cookies set by intermediate responses
+// are stored in the session.
+//
+// New body buffer is allocated if dst is nil.
+func (sc *SessionClient) Get(dst []byte, url string) (statusCode int, body []byte, err error) {
+	req := AcquireRequest()
+
+	statusCode, body, err = doRequestFollowRedirects(req, dst, url, sessionRedirectDoer{sc})
+
+	ReleaseRequest(req)
+	return statusCode, body, err
+}
+
+// Post sends POST request to the given url with the given POST arguments.
+//
+// Redirects are followed. Cookies set by intermediate responses
+// are stored in the session.
+//
+// Response body is appended to dst, which is returned as body.
+//
+// New body buffer is allocated if dst is nil.
+//
+// Empty POST body is sent if postArgs is nil.
+func (sc *SessionClient) Post(dst []byte, url string, postArgs *Args) (statusCode int, body []byte, err error) {
+	req := AcquireRequest()
+	req.Header.SetMethodBytes(strPost)
+	req.Header.SetContentTypeBytes(strPostArgsContentType)
+	if postArgs != nil {
+		postArgs.WriteTo(req.BodyWriter())
+	}
+
+	statusCode, body, err = doRequestFollowRedirects(req, dst, url, sessionRedirectDoer{sc})
+
+	ReleaseRequest(req)
+	return statusCode, body, err
+}
+
+// DoTimeout performs the given request with session cookies and referer
+// and waits for response during the given timeout duration.
+//
+// ErrTimeout is returned if the response wasn't returned during
+// the given timeout.
+func (sc *SessionClient) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return sc.DoDeadline(req, resp, time.Now().Add(timeout))
+}
+
+// DoDeadline performs the given request with session cookies and referer
+// and waits for response until the given deadline.
+//
+// See Client.DoDeadline for details.
+func (sc *SessionClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	return sc.doDeadline(req, resp, deadline, nil)
+}
+
+// DoContext performs the given request with session cookies and referer
+// and waits for response until the given context is canceled
+// or its' deadline is exceeded.
+//
+// See Client.DoContext for details.
+func (sc *SessionClient) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	deadline, _ := ctx.Deadline()
+	err := sc.doDeadline(req, resp, deadline, ctx.Done())
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Do performs the given request with session cookies and referer
+// and fills the given response.
+//
+// Cookies from the session jar matching the request url are added
+// to the request. 'Referer' header is set to the previously fetched url
+// unless the request already contains it.
+//
+// Cookies from the response are stored in the session jar.
+//
+// Redirects aren't followed. Use Get or Post for following redirects.
+func (sc *SessionClient) Do(req *Request, resp *Response) error {
+	return sc.doDeadline(req, resp, zeroTime, nil)
+}
+
+func (sc *SessionClient) doDeadline(req *Request, resp *Response, deadline time.Time, done <-chan struct{}) error {
+	uri := req.URI()
+	host := cookieHost(uri.Host())
+	path := uri.Path()
+	isTLS := bytes.Equal(uri.Scheme(), strHTTPS)
+
+	sc.mLock.Lock()
+	now := time.Now()
+	for i := range sc.cookies {
+		c := &sc.cookies[i]
+		if c.matches(host, path, now) {
+			req.Header.SetCookieBytesKV(c.key, c.value)
+		}
+	}
+	if len(req.Header.Referer()) == 0 && len(sc.referer) > 0 {
+		// Do not leak https urls to plain http hosts.
+		if isTLS || !bytes.HasPrefix(sc.referer, strHTTPS) {
+			req.Header.SetRefererBytes(sc.referer)
+		}
+	}
+	sc.mLock.Unlock()
+
+	c := sc.Client
+	if c == nil {
+		c = &defaultClient
+	}
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	if err := c.doDeadline(req, resp, deadline, done); err != nil {
+		return err
+	}
+
+	sc.mLock.Lock()
+	sc.referer = uri.AppendBytes(sc.referer[:0])
+	var cookie Cookie
+	now = time.Now()
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		if err := cookie.ParseBytes(value); err == nil {
+			sc.storeCookie(&cookie, host, path, now)
+		}
+	})
+	sc.mLock.Unlock()
+	return nil
+}
+
+// Reset removes all the cookies and the referer from the session.
+func (sc *SessionClient) Reset() {
+	sc.mLock.Lock()
+	sc.cookies = sc.cookies[:0]
+	sc.referer = sc.referer[:0]
+	sc.mLock.Unlock()
+}
+
+// sessionRedirectDoer performs requests issued by doRequestFollowRedirects.
+//
+// The request is reused between redirects, so cookies and referer
+// for the previous url are removed before each request.
+type sessionRedirectDoer struct {
+	sc *SessionClient
+}
+
+func (d sessionRedirectDoer) Do(req *Request, resp *Response) error {
+	h := &req.Header
+	h.DelAllCookies()
+	h.DelBytes(strReferer)
+	return d.sc.Do(req, resp)
+}
+
+func (sc *SessionClient) storeCookie(cookie *Cookie, host, path []byte, now time.Time) {
+	if len(cookie.Key()) == 0 {
+		return
+	}
+
+	hostOnly := true
+	domain := host
+	if d := cookie.Domain(); len(d) > 0 {
+		if d[0] == '.' {
+			d = d[1:]
+		}
+		lowercaseBytes(d)
+		if !bytes.Equal(d, host) {
+			if !domainMatches(host, d) || !isSharedCookieDomain(host, d) {
+				// The server may set cookies only for its own domain
+				// and parent domains.
+				return
+			}
+			domain = d
+			hostOnly = false
+		} else if isSharedCookieDomain(host, d) {
+			hostOnly = false
+		}
+	}
+
+	cookiePath := cookie.Path()
+	if len(cookiePath) == 0 || cookiePath[0] != '/' {
+		cookiePath = defaultCookiePath(path)
+	}
+
+	// Remove the existing cookie with the same key, domain and path.
+	cs := sc.cookies
+	for i := range cs {
+		c := &cs[i]
+		if bytes.Equal(c.key, cookie.Key()) && bytes.Equal(c.domain, domain) && bytes.Equal(c.path, cookiePath) {
+			n := len(cs) - 1
+			cs[i], cs[n] = cs[n], cs[i]
+			cs = cs[:n]
+			break
+		}
+	}
+	sc.cookies = cs
+
+	expire := cookie.Expire()
+	if !expire.IsZero() && !expire.After(now) {
+		// Expired cookie deletes the stored cookie.
+		return
+	}
+
+	var c *sessionCookie
+	if cap(cs) > len(cs) {
+		cs = cs[:len(cs)+1]
+		c = &cs[len(cs)-1]
+	} else {
+		cs = append(cs, sessionCookie{})
+		c = &cs[len(cs)-1]
+	}
+	c.key = append(c.key[:0], cookie.Key()...)
+	c.value = append(c.value[:0], cookie.Value()...)
+	c.domain = append(c.domain[:0], domain...)
+	c.path = append(c.path[:0], cookiePath...)
+	c.expire = expire
+	c.hostOnly = hostOnly
+	sc.cookies = cs
+}
+
+func (c *sessionCookie) matches(host, path []byte, now time.Time) bool {
+	if !c.expire.IsZero() && !c.expire.After(now) {
+		return false
+	}
+	if c.hostOnly {
+		if !bytes.Equal(host, c.domain) {
+			return false
+		}
+	} else if !domainMatches(host, c.domain) {
+		return false
+	}
+	return pathMatches(path, c.path)
+}
+
+// cookieHost returns host without port.
+func cookieHost(host []byte) []byte {
+	n := bytes.LastIndexByte(host, ':')
+	if n >= 0 && bytes.IndexByte(host[n:], ']') < 0 {
+		host = host[:n]
+	}
+	return host
+}
+
+// domainMatches returns true if host equals to domain
+// or is a subdomain of domain.
+func domainMatches(host, domain []byte) bool {
+	if len(domain) == 0 {
+		return false
+	}
+	if !bytes.HasSuffix(host, domain) {
+		return false
+	}
+	n := len(host) - len(domain)
+	return n == 0 || host[n-1] == '.'
+}
+
+// isSharedCookieDomain returns true if the cookie with the given domain
+// set by the given host may be sent to subdomains of the domain.
+//
+// Single-label domains such as "com" and IP addresses cannot be shared,
+// otherwise the host could set cookies for unrelated hosts.
+func isSharedCookieDomain(host, domain []byte) bool {
+	if bytes.IndexByte(domain, '.') < 0 {
+		return false
+	}
+	return !isIPHost(host) && !isIPHost(domain)
+}
+
+// isIPHost returns true if host is IPv4 or IPv6 address.
+func isIPHost(host []byte) bool {
+	if len(host) > 0 && host[0] == '[' {
+		return true
+	}
+	return net.ParseIP(string(host)) != nil
+}
+
+// pathMatches returns true if the request path is within the cookie path.
+func pathMatches(path, cookiePath []byte) bool {
+	if !bytes.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return len(path) == len(cookiePath) ||
+		cookiePath[len(cookiePath)-1] == '/' ||
+		path[len(cookiePath)] == '/'
+}
+
+// defaultCookiePath returns the directory of the request path.
+func defaultCookiePath(path []byte) []byte {
+	n := bytes.LastIndexByte(path, '/')
+	if n <= 0 {
+		return strSlash
+	}
+	return path[:n]
+}
@@ -0,0 +1,262 @@
+package fasthttp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionClientCookiesAndReferer(t *testing.T) {
+	addr := "127.0.0.1:56793"
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot listen %q: %s", addr, err)
+	}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Response.Header.SetBytesV("X-Referer", ctx.Request.Header.Referer())
+			ctx.Response.Header.SetBytesV("X-Cookie", ctx.Request.Header.Peek("Cookie"))
+			switch string(ctx.Path()) {
+			case "/login":
+				var c Cookie
+				c.SetKey("session")
+				c.SetValue("12345")
+				c.SetDomain("example.com")
+				ctx.Response.Header.SetCookie(&c)
+
+				c.Reset()
+				c.SetKey("admin")
+				c.SetValue("yes")
+				c.SetPath("/admin")
+				ctx.Response.Header.SetCookie(&c)
+
+				c.Reset()
+				c.SetKey("foreign")
+				c.SetValue("bar")
+				c.SetDomain("foobar.com")
+				ctx.Response.Header.SetCookie(&c)
+
+				ctx.Redirect("/home", StatusFound)
+			case "/logout":
+				var c Cookie
+				c.SetKey("session")
+				c.SetDomain("example.com")
+				c.SetExpire(CookieExpireDelete)
+				ctx.Response.Header.SetCookie(&c)
+			}
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	sc := &SessionClient{
+		Client: &Client{
+			Dial: func(string) (net.Conn, error) {
+				return Dial(addr)
+			},
+		},
+	}
+
+	var resp Response
+	if err := sc.Do(newTestSessionRequest("http://www.example.com/login"), &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusFound {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusFound)
+	}
+
+	testSessionClientDo(t, sc, &resp, "http://www.example.com/home",
+		"http://www.example.com/login", "session=12345")
+	testSessionClientDo(t, sc, &resp, "http://example.com/admin/users",
+		"http://www.example.com/home", "session=12345")
+	testSessionClientDo(t, sc, &resp, "http://www.example.com/admin/users",
+		"http://example.com/admin/users", "session=12345; admin=yes")
+	testSessionClientDo(t, sc, &resp, "http://www.example.com/administrator",
+		"http://www.example.com/admin/users", "session=12345")
+	testSessionClientDo(t, sc, &resp, "http://foobar.com/",
+		"http://www.example.com/administrator", "")
+
+	// Delete the session cookie.
+	testSessionClientDo(t, sc, &resp, "http://www.example.com/logout",
+		"http://foobar.com/", "session=12345")
+	testSessionClientDo(t, sc, &resp, "http://www.example.com/admin",
+		"http://www.example.com/logout", "admin=yes")
+
+	sc.Reset()
+	testSessionClientDo(t, sc, &resp, "http://www.example.com/admin", "", "")
+
+	// Get must follow redirects and store cookies from redirect responses.
+	statusCode, body, err := sc.Get(nil, "http://www.example.com/login")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+	}
+	if len(body) != 0 {
+		t.Fatalf("unexpected body %q. Expecting empty body", body)
+	}
+	testSessionClientDo(t, sc, &resp, "http://www.example.com/admin",
+		"http://www.example.com/home", "session=12345; admin=yes")
+}
+
+func testSessionClientDo(t *testing.T, sc *SessionClient, resp *Response, url, expectedReferer, expectedCookie string) {
+	req := newTestSessionRequest(url)
+	if err := sc.DoTimeout(req, resp, time.Second); err != nil {
+		t.Fatalf("unexpected error when requesting %q: %s", url, err)
+	}
+	if resp.StatusCode() != StatusOK {
+		return
+	}
+	referer := resp.Header.Peek("X-Referer")
+	if string(referer) != expectedReferer {
+		t.Fatalf("unexpected referer %q for %q. Expecting %q", referer, url, expectedReferer)
+	}
+	cookie := resp.Header.Peek("X-Cookie")
+	if string(cookie) != expectedCookie {
+		t.Fatalf("unexpected cookie %q for %q. Expecting %q", cookie, url, expectedCookie)
+	}
+}
+
+func newTestSessionRequest(url string) *Request {
+	var req Request
+	req.SetRequestURI(url)
+	return &req
+}
+
+func TestSessionClientDoContext(t *testing.T) {
+	addr, stop := startSlowTestServer(t)
+	defer stop()
+
+	var sc SessionClient
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://" + addr + "/slow")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	startTime := time.Now()
+	if err := sc.DoContext(ctx, &req, &resp); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+	if d := time.Since(startTime); d > 250*time.Millisecond {
+		t.Fatalf("too long request duration: %s", d)
+	}
+
+	if err := sc.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+
+	req.SetRequestURI("http://" + addr + "/fast")
+	if err := sc.DoTimeout(&req, &resp, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+}
+
+func TestSessionClientExpiredCookie(t *testing.T) {
+	var sc SessionClient
+	var c Cookie
+	c.SetKey("foo")
+	c.SetValue("bar")
+	now := time.Now()
+	sc.storeCookie(&c, []byte("example.com"), []byte("/"), now)
+	if len(sc.cookies) != 1 {
+		t.Fatalf("unexpected number of cookies: %d. Expecting 1", len(sc.cookies))
+	}
+	if sc.cookies[0].matches([]byte("www.example.com"), []byte("/"), now) {
+		t.Fatalf("host-only cookie mustn't match subdomains")
+	}
+
+	c.SetExpire(now.Add(time.Hour))
+	sc.storeCookie(&c, []byte("example.com"), []byte("/"), now)
+	if len(sc.cookies) != 1 {
+		t.Fatalf("unexpected number of cookies: %d. Expecting 1", len(sc.cookies))
+	}
+	if !sc.cookies[0].matches([]byte("example.com"), []byte("/foo"), now) {
+		t.Fatalf("cookie must match")
+	}
+	if sc.cookies[0].matches([]byte("example.com"), []byte("/foo"), now.Add(2*time.Hour)) {
+		t.Fatalf("expired cookie mustn't match")
+	}
+}
+
+func TestSessionClientCookieDomain(t *testing.T) {
+	testSessionClientCookieDomain(t, "example.com", "example.com", "www.example.com", true)
+	testSessionClientCookieDomain(t, "www.example.com", ".example.com", "foo.example.com", true)
+	testSessionClientCookieDomain(t, "www.example.com", "EXAMPLE.com", "example.com", true)
+
+	// Single-label and IP address domains mustn't be shared.
+	testSessionClientCookieDomain(t, "example.com", "com", "", false)
+	testSessionClientCookieDomain(t, "example.com", ".com", "", false)
+	testSessionClientCookieDomain(t, "1.2.3.4", "2.3.4", "", false)
+	testSessionClientCookieDomain(t, "1.2.3.4", "1.2.3.4", "5.1.2.3.4", false)
+	testSessionClientCookieDomain(t, "localhost", "localhost", "foo.localhost", false)
+	testSessionClientCookieDomain(t, "[::1]", "[::1]", "foo.[::1]", false)
+}
+
+func testSessionClientCookieDomain(t *testing.T, host, domain, subdomain string, expectedShared bool) {
+	now := time.Now()
+	var sc SessionClient
+	var c Cookie
+	c.SetKey("foo")
+	c.SetValue("bar")
+	c.SetDomain(domain)
+	sc.storeCookie(&c, []byte(host), []byte("/"), now)
+	if len(subdomain) == 0 {
+		if len(sc.cookies) != 0 {
+			t.Fatalf("cookie with domain %q set by %q must be rejected", domain, host)
+		}
+		return
+	}
+	if len(sc.cookies) != 1 {
+		t.Fatalf("cookie with domain %q set by %q must be stored", domain, host)
+	}
+	cookie := &sc.cookies[0]
+	if !cookie.matches([]byte(host), []byte("/"), now) {
+		t.Fatalf("cookie with domain %q must match host %q", domain, host)
+	}
+	if cookie.matches([]byte(subdomain), []byte("/"), now) != expectedShared {
+		t.Fatalf("unexpected result for cookie with domain %q set by %q matching %q. Expecting %v", domain, host, subdomain, expectedShared)
+	}
+}
+
+func TestDomainMatches(t *testing.T) {
+	testDomainMatches(t, "example.com", "example.com", true)
+	testDomainMatches(t, "www.example.com", "example.com", true)
+	testDomainMatches(t, "wwwexample.com", "example.com", false)
+	testDomainMatches(t, "example.com", "www.example.com", false)
+	testDomainMatches(t, "example.com", "", false)
+}
+
+func testDomainMatches(t *testing.T, host, domain string, expectedResult bool) {
+	if domainMatches([]byte(host), []byte(domain)) != expectedResult {
+		t.Fatalf("unexpected result for domainMatches(%q, %q). Expecting %v", host, domain, expectedResult)
+	}
+}
+
+func TestPathMatches(t *testing.T) {
+	testPathMatches(t, "/", "/", true)
+	testPathMatches(t, "/foo", "/", true)
+	testPathMatches(t, "/foo", "/foo", true)
+	testPathMatches(t, "/foo/bar", "/foo", true)
+	testPathMatches(t, "/foo/bar", "/foo/", true)
+	testPathMatches(t, "/foobar", "/foo", false)
+	testPathMatches(t, "/", "/foo", false)
+}
+
+func testPathMatches(t *testing.T, path, cookiePath string, expectedResult bool) {
+	if pathMatches([]byte(path), []byte(cookiePath)) != expectedResult {
+		t.Fatalf("unexpected result for pathMatches(%q, %q). Expecting %v", path, cookiePath, expectedResult)
+	}
+}
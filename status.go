@@ -25,6 +25,7 @@ const (
 	StatusNotModified       = 304
 	StatusUseProxy          = 305
 	StatusTemporaryRedirect = 307
+	StatusPermanentRedirect = 308
 
 	StatusBadRequest                   = 400
 	StatusUnauthorized                 = 401
@@ -45,6 +46,7 @@ const (
 	StatusRequestedRangeNotSatisfiable = 416
 	StatusExpectationFailed            = 417
 	StatusTeapot                       = 418
+	StatusUpgradeRequired              = 426
 	StatusPreconditionRequired         = 428
 	StatusTooManyRequests              = 429
 	StatusRequestHeaderFieldsTooLarge  = 431
@@ -80,6 +82,7 @@ var (
 		StatusNotModified:       "Not Modified",
 		StatusUseProxy:          "Use Proxy",
 		StatusTemporaryRedirect: "Temporary Redirect",
+		StatusPermanentRedirect: "Permanent Redirect",
 
 		StatusBadRequest:                   "Bad Request",
 		StatusUnauthorized:                 "Unauthorized",
@@ -100,6 +103,7 @@ var (
 		StatusRequestedRangeNotSatisfiable: "Requested Range Not Satisfiable",
 		StatusExpectationFailed:            "Expectation Failed",
 		StatusTeapot:                       "Teapot",
+		StatusUpgradeRequired:              "Upgrade Required",
 
 		StatusInternalServerError:     "Internal Server Error",
 		StatusNotImplemented:          "Not Implemented",
@@ -21,28 +21,41 @@ var (
 
 	strResponseContinue = []byte("HTTP/1.1 100 Continue\r\n\r\n")
 
-	strGet  = []byte("GET")
-	strHead = []byte("HEAD")
-	strPost = []byte("POST")
-	strPut  = []byte("PUT")
-
-	strExpect           = []byte("Expect")
-	strConnection       = []byte("Connection")
-	strContentLength    = []byte("Content-Length")
-	strContentType      = []byte("Content-Type")
-	strDate             = []byte("Date")
-	strHost             = []byte("Host")
-	strReferer          = []byte("Referer")
-	strServer           = []byte("Server")
-	strTransferEncoding = []byte("Transfer-Encoding")
-	strContentEncoding  = []byte("Content-Encoding")
-	strAcceptEncoding   = []byte("Accept-Encoding")
-	strUserAgent        = []byte("User-Agent")
-	strCookie           = []byte("Cookie")
-	strSetCookie        = []byte("Set-Cookie")
-	strLocation         = []byte("Location")
-	strIfModifiedSince  = []byte("If-Modified-Since")
-	strLastModified     = []byte("Last-Modified")
+	strResponseSwitchingProtocolsH2C = []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+
+	strGet     = []byte("GET")
+	strHead    = []byte("HEAD")
+	strPost    = []byte("POST")
+	strPut     = []byte("PUT")
+	strConnect = []byte("CONNECT")
+
+	strExpect             = []byte("Expect")
+	strConnection         = []byte("Connection")
+	strContentLength      = []byte("Content-Length")
+	strContentType        = []byte("Content-Type")
+	strDate               = []byte("Date")
+	strHost               = []byte("Host")
+	strReferer            = []byte("Referer")
+	strServer             = []byte("Server")
+	strTransferEncoding   = []byte("Transfer-Encoding")
+	strContentEncoding    = []byte("Content-Encoding")
+	strAcceptEncoding     = []byte("Accept-Encoding")
+	strUserAgent          = []byte("User-Agent")
+	strCookie             = []byte("Cookie")
+	strSetCookie          = []byte("Set-Cookie")
+	strLocation           = []byte("Location")
+	strIfModifiedSince    = []byte("If-Modified-Since")
+	strLastModified       = []byte("Last-Modified")
+	strProxyAuthorization = []byte("Proxy-Authorization")
+	strAuthorization      = []byte("Authorization")
+	strRange              = []byte("Range")
+	strIfRange            = []byte("If-Range")
+	strAcceptRanges       = []byte("Accept-Ranges")
+	strContentRange       = []byte("Content-Range")
+	strETag               = []byte("ETag")
+	strIfMatch            = []byte("If-Match")
+	strIfNoneMatch        = []byte("If-None-Match")
+	strIfUnmodifiedSince  = []byte("If-Unmodified-Since")
 
 	strCookieExpires = []byte("expires")
 	strCookieDomain  = []byte("domain")
@@ -51,6 +64,7 @@ var (
 	strClose               = []byte("close")
 	strGzip                = []byte("gzip")
 	strDeflate             = []byte("deflate")
+	strGzipDeflate         = []byte("gzip, deflate")
 	strKeepAlive           = []byte("keep-alive")
 	strKeepAliveCamelCase  = []byte("Keep-Alive")
 	strUpgrade             = []byte("Upgrade")
@@ -60,4 +74,10 @@ var (
 	strPostArgsContentType = []byte("application/x-www-form-urlencoded")
 	strMultipartFormData   = []byte("multipart/form-data")
 	strBoundary            = []byte("boundary")
+	strBytes               = []byte("bytes")
+	strH2C                 = []byte("h2c")
+
+	strH2Status = []byte(":status")
+	strH2Date   = []byte("date")
+	strH2Server = []byte("server")
 )
@@ -1,6 +1,10 @@
 package fasthttp
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"sync"
@@ -9,8 +13,11 @@ import (
 )
 
 var (
-	dial          = (&tcpDialer{}).NewDial()
-	dialDualStack = (&tcpDialer{DualStack: true}).NewDial()
+	defaultDialer          = &tcpDialer{}
+	defaultDualStackDialer = &tcpDialer{DualStack: true}
+
+	dial          = defaultDialer.NewDial()
+	dialDualStack = defaultDualStackDialer.NewDial()
 )
 
 // Dial dials the given TCP addr using tcp4.
@@ -47,6 +54,208 @@ func DialDualStack(addr string) (net.Conn, error) {
 	return dialDualStack(addr)
 }
 
+// NewSOCKS5Dial returns DialFunc, which dials the given TCP addr
+// via SOCKS5 proxy located at proxyAddr.
+//
+// Username/password authentication is used if username is non-empty.
+// Otherwise the proxy must support connections without authentication.
+//
+// Host names are resolved by the proxy.
+//
+// Connecting to the proxy and SOCKS5 handshake must complete
+// during the given timeout. The timeout is unlimited if it is zero.
+//
+// The returned DialFunc may be passed to Client.Dial or HostClient.Dial.
+// The addr passed to the function must contain port. Example addr values:
+//
+//     * foobar.baz:443
+//     * foo.bar:80
+//     * aaa.com:8080
+func NewSOCKS5Dial(proxyAddr, username, password string, timeout time.Duration) DialFunc {
+	return func(addr string) (net.Conn, error) {
+		host, port, err := parseSOCKS5Addr(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if timeout > 0 {
+			if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		if err = socks5Handshake(conn, host, port, username, password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if timeout > 0 {
+			if err = conn.SetDeadline(zeroTime); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+}
+
+const (
+	socks5Version = 5
+
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+
+	// Username/password authentication version. See RFC 1929.
+	socks5AuthPasswordVersion = 1
+
+	socks5CmdConnect = 1
+
+	socks5AddrIPv4   = 1
+	socks5AddrDomain = 3
+	socks5AddrIPv6   = 4
+)
+
+var (
+	errSOCKS5BadVersion = errors.New("unexpected SOCKS5 protocol version in proxy response")
+	errSOCKS5NoAuth     = errors.New("SOCKS5 proxy doesn't support the requested authentication methods")
+	errSOCKS5AuthFailed = errors.New("SOCKS5 proxy rejected username and password")
+
+	errSOCKS5BadAuthVersion = errors.New("unexpected SOCKS5 username/password authentication version in proxy response")
+)
+
+var socks5Errors = []string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func parseSOCKS5Addr(addr string) (string, int, error) {
+	host, portS, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portS)
+	if err != nil || port <= 0 || port > 0xffff {
+		return "", 0, fmt.Errorf("invalid port in addr %q", addr)
+	}
+	if len(host) > 255 {
+		return "", 0, fmt.Errorf("too long host name in addr %q", addr)
+	}
+	return host, port, nil
+}
+
+func socks5Handshake(conn net.Conn, host string, port int, username, password string) error {
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("too long SOCKS5 username or password")
+	}
+
+	var err error
+	b := make([]byte, 0, 6+len(host))
+	b = append(b, socks5Version, 1, socks5AuthNone)
+	if len(username) > 0 {
+		b = append(b[:1], 2, socks5AuthNone, socks5AuthPassword)
+	}
+	if _, err = conn.Write(b); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(conn, b[:2]); err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return errSOCKS5BadVersion
+	}
+	switch b[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if len(username) == 0 {
+			return errSOCKS5NoAuth
+		}
+		// See RFC 1929.
+		b = append(b[:0], socks5AuthPasswordVersion, byte(len(username)))
+		b = append(b, username...)
+		b = append(b, byte(len(password)))
+		b = append(b, password...)
+		if _, err = conn.Write(b); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, b[:2]); err != nil {
+			return err
+		}
+		if b[0] != socks5AuthPasswordVersion {
+			return errSOCKS5BadAuthVersion
+		}
+		if b[1] != 0 {
+			return errSOCKS5AuthFailed
+		}
+	default:
+		return errSOCKS5NoAuth
+	}
+
+	b = append(b[:0], socks5Version, socks5CmdConnect, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5AddrIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5AddrIPv6)
+			b = append(b, ip...)
+		}
+	} else {
+		// Let the proxy resolve the host name.
+		b = append(b, socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	if _, err = conn.Write(b); err != nil {
+		return err
+	}
+
+	// Read reply header: VER, REP, RSV, ATYP.
+	if _, err = io.ReadFull(conn, b[:4]); err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return errSOCKS5BadVersion
+	}
+	if rep := int(b[1]); rep != 0 {
+		msg := "unknown error"
+		if rep < len(socks5Errors) {
+			msg = socks5Errors[rep]
+		}
+		return fmt.Errorf("SOCKS5 proxy cannot connect to %q: %s", net.JoinHostPort(host, strconv.Itoa(port)), msg)
+	}
+
+	// Skip the bound address and port.
+	var n int
+	switch b[3] {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		if _, err = io.ReadFull(conn, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return fmt.Errorf("unexpected address type %d in SOCKS5 proxy response", b[3])
+	}
+	n += 2
+	if cap(b) < n {
+		b = make([]byte, n)
+	}
+	_, err = io.ReadFull(conn, b[:n])
+	return err
+}
+
 type tcpDialer struct {
 	DualStack bool
 
@@ -63,16 +272,33 @@ func (d *tcpDialer) NewDial() DialFunc {
 	go d.tcpAddrsClean()
 
 	return func(addr string) (net.Conn, error) {
-		tcpAddr, err := d.getTCPAddr(addr)
-		if err != nil {
-			return nil, err
-		}
-		network := "tcp4"
-		if d.DualStack {
-			network = "tcp"
-		}
-		return net.DialTCP(network, nil, tcpAddr)
+		return d.dial(context.Background(), addr, nil)
+	}
+}
+
+// dial dials addr until ctx is done.
+func (d *tcpDialer) dial(ctx context.Context, addr string, trace *ClientTrace) (net.Conn, error) {
+	tcpAddr, err := d.getTCPAddr(ctx, addr, trace)
+	if err != nil {
+		return nil, err
+	}
+	network := "tcp4"
+	if d.DualStack {
+		network = "tcp"
+	}
+	var dialer net.Dialer
+	tcpAddrStr := tcpAddr.String()
+	if trace == nil {
+		return dialer.DialContext(ctx, network, tcpAddrStr)
+	}
+	trace.connectStart(tcpAddrStr)
+	conn, err := dialer.DialContext(ctx, network, tcpAddrStr)
+	trace.connectDone(tcpAddrStr, err)
+	if err != nil {
+		// Do not return non-nil net.Conn interface holding nil *net.TCPConn.
+		return nil, err
 	}
+	return conn, nil
 }
 
 type tcpAddrEntry struct {
@@ -101,7 +327,7 @@ func (d *tcpDialer) tcpAddrsClean() {
 	}
 }
 
-func (d *tcpDialer) getTCPAddr(addr string) (*net.TCPAddr, error) {
+func (d *tcpDialer) getTCPAddr(ctx context.Context, addr string, trace *ClientTrace) (*net.TCPAddr, error) {
 	d.tcpAddrsLock.Lock()
 	e := d.tcpAddrsMap[addr]
 	if e != nil && !e.pending && time.Since(e.resolveTime) > tcpAddrsCacheDuration {
@@ -111,7 +337,7 @@ func (d *tcpDialer) getTCPAddr(addr string) (*net.TCPAddr, error) {
 	d.tcpAddrsLock.Unlock()
 
 	if e == nil {
-		tcpAddrs, err := resolveTCPAddrs(addr, d.DualStack)
+		tcpAddrs, err := resolveTCPAddrs(ctx, addr, d.DualStack, trace)
 		if err != nil {
 			d.tcpAddrsLock.Lock()
 			e = d.tcpAddrsMap[addr]
@@ -141,7 +367,7 @@ func (d *tcpDialer) getTCPAddr(addr string) (*net.TCPAddr, error) {
 	return tcpAddr, nil
 }
 
-func resolveTCPAddrs(addr string, dualStack bool) ([]net.TCPAddr, error) {
+func resolveTCPAddrs(ctx context.Context, addr string, dualStack bool, trace *ClientTrace) ([]net.TCPAddr, error) {
 	host, portS, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -151,22 +377,26 @@ func resolveTCPAddrs(addr string, dualStack bool) ([]net.TCPAddr, error) {
 		return nil, err
 	}
 
-	ips, err := net.LookupIP(host)
+	trace.dnsStart(host)
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
 	if err != nil {
+		trace.dnsDone(nil, err)
 		return nil, err
 	}
 
 	n := len(ips)
 	addrs := make([]net.TCPAddr, 0, n)
 	for i := 0; i < n; i++ {
-		ip := ips[i]
-		if !dualStack && ip.To4() == nil {
+		ip := &ips[i]
+		if !dualStack && ip.IP.To4() == nil {
 			continue
 		}
 		addrs = append(addrs, net.TCPAddr{
-			IP:   ip,
+			IP:   ip.IP,
 			Port: port,
+			Zone: ip.Zone,
 		})
 	}
+	trace.dnsDone(addrs, nil)
 	return addrs, nil
 }
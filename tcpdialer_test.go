@@ -0,0 +1,267 @@
+package fasthttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSOCKS5DialNoAuth(t *testing.T) {
+	testSOCKS5Dial(t, "", "", "example.com:80")
+}
+
+func TestSOCKS5DialPasswordAuth(t *testing.T) {
+	testSOCKS5Dial(t, "foo", "bar", "example.com:80")
+}
+
+func TestSOCKS5DialIPAddr(t *testing.T) {
+	testSOCKS5Dial(t, "", "", "10.1.2.3:8080")
+	testSOCKS5Dial(t, "", "", "[2001:db8::1]:443")
+}
+
+func testSOCKS5Dial(t *testing.T, username, password, addr string) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "host=%s", ctx.Host())
+		},
+	}
+	ln, ch := startTestSOCKS5Server(t, s, username, password, addr)
+	defer func() {
+		ln.Close()
+		if err := <-ch; err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}()
+
+	c := &HostClient{
+		Addr: addr,
+		Dial: NewSOCKS5Dial(ln.Addr().String(), username, password, time.Second),
+	}
+	statusCode, body, err := c.Get(nil, "http://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+	}
+	if string(body) != "host=example.com" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "host=example.com")
+	}
+}
+
+func TestSOCKS5DialAuthFailed(t *testing.T) {
+	ln, ch := startTestSOCKS5Server(t, nil, "foo", "bar", "example.com:80")
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	dial := NewSOCKS5Dial(ln.Addr().String(), "foo", "baz", time.Second)
+	conn, err := dial("example.com:80")
+	if err != errSOCKS5AuthFailed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errSOCKS5AuthFailed)
+	}
+	if conn != nil {
+		t.Fatalf("unexpected non-nil conn")
+	}
+
+	dial = NewSOCKS5Dial(ln.Addr().String(), "", "", time.Second)
+	if _, err = dial("example.com:80"); err != errSOCKS5NoAuth {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errSOCKS5NoAuth)
+	}
+}
+
+func TestSOCKS5DialBadAuthVersion(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Greeting: version, 2 methods, no auth, password.
+		if _, err = io.ReadFull(conn, make([]byte, 4)); err != nil {
+			return
+		}
+		conn.Write([]byte{5, socks5AuthPassword})
+		// Auth request: version, "foo", "bar".
+		if _, err = io.ReadFull(conn, make([]byte, 9)); err != nil {
+			return
+		}
+		// Successful status with the SOCKS5 version instead of the RFC 1929 one.
+		conn.Write([]byte{5, 0})
+	}()
+
+	dial := NewSOCKS5Dial(ln.Addr().String(), "foo", "bar", time.Second)
+	if _, err := dial("example.com:80"); err != errSOCKS5BadAuthVersion {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errSOCKS5BadAuthVersion)
+	}
+}
+
+func TestSOCKS5DialConnectFailed(t *testing.T) {
+	ln, ch := startTestSOCKS5Server(t, nil, "", "", "example.com:80")
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	dial := NewSOCKS5Dial(ln.Addr().String(), "", "", time.Second)
+	if _, err := dial("foobar.com:80"); err == nil {
+		t.Fatalf("expecting error")
+	}
+	if _, err := dial("foobar.com"); err == nil {
+		t.Fatalf("expecting error for addr without port")
+	}
+}
+
+func TestSOCKS5DialTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept the connection and never respond.
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}()
+
+	dial := NewSOCKS5Dial(ln.Addr().String(), "", "", 50*time.Millisecond)
+	if _, err := dial("example.com:80"); err == nil {
+		t.Fatalf("expecting timeout error")
+	}
+}
+
+// startTestSOCKS5Server starts SOCKS5 proxy stand-in, which accepts
+// connections only to expectedAddr and serves them with s.
+func startTestSOCKS5Server(t *testing.T, s *Server, username, password, expectedAddr string) (net.Listener, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	ch := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				ch <- nil
+				return
+			}
+			ok, err := serveTestSOCKS5Handshake(conn, username, password, expectedAddr)
+			if err != nil {
+				conn.Close()
+				ch <- err
+				return
+			}
+			if !ok {
+				conn.Close()
+				continue
+			}
+			go s.ServeConn(conn)
+		}
+	}()
+	return ln, ch
+}
+
+func serveTestSOCKS5Handshake(conn net.Conn, username, password, expectedAddr string) (bool, error) {
+	b := make([]byte, 512)
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return false, err
+	}
+	if b[0] != 5 {
+		return false, fmt.Errorf("unexpected version %d", b[0])
+	}
+	methods := b[2 : 2+b[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return false, err
+	}
+	method := byte(socks5AuthNone)
+	if len(username) > 0 {
+		method = socks5AuthPassword
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		conn.Write([]byte{5, socks5AuthNoAcceptable})
+		return false, nil
+	}
+	if _, err := conn.Write([]byte{5, method}); err != nil {
+		return false, err
+	}
+
+	if method == socks5AuthPassword {
+		if _, err := io.ReadFull(conn, b[:2]); err != nil {
+			return false, err
+		}
+		u := make([]byte, b[1])
+		if _, err := io.ReadFull(conn, u); err != nil {
+			return false, err
+		}
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return false, err
+		}
+		p := make([]byte, b[0])
+		if _, err := io.ReadFull(conn, p); err != nil {
+			return false, err
+		}
+		if string(u) != username || string(p) != password {
+			conn.Write([]byte{1, 1})
+			return false, nil
+		}
+		if _, err := conn.Write([]byte{1, 0}); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := io.ReadFull(conn, b[:4]); err != nil {
+		return false, err
+	}
+	if b[1] != socks5CmdConnect {
+		return false, fmt.Errorf("unexpected command %d", b[1])
+	}
+	var host string
+	switch b[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		n := net.IPv4len
+		if b[3] == socks5AddrIPv6 {
+			n = net.IPv6len
+		}
+		if _, err := io.ReadFull(conn, b[:n]); err != nil {
+			return false, err
+		}
+		host = net.IP(b[:n]).String()
+	case socks5AddrDomain:
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return false, err
+		}
+		n := int(b[0])
+		if _, err := io.ReadFull(conn, b[:n]); err != nil {
+			return false, err
+		}
+		host = string(b[:n])
+	default:
+		return false, fmt.Errorf("unexpected address type %d", b[3])
+	}
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return false, err
+	}
+	port := int(b[0])<<8 | int(b[1])
+	addr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	if addr != expectedAddr {
+		// Host unreachable.
+		conn.Write([]byte{5, 4, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return false, nil
+	}
+	_, err := conn.Write([]byte{5, 0, 0, socks5AddrDomain, 5, 'p', 'r', 'o', 'x', 'y', 0x1f, 0x90})
+	return true, err
+}
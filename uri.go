@@ -311,10 +311,15 @@ func (x *URI) RequestURI() []byte {
 //
 //     * Absolute, i.e. http://foobar.com/aaa/bb?cc . In this case the original
 //       uri is replaced by newURI.
+//     * Missing scheme, i.e. //foobar.com/aaa/bb?cc . In this case
+//       the original scheme is preserved.
 //     * Missing host, i.e. /aaa/bb?cc . In this case only RequestURI part
 //       of the original uri is replaced.
 //     * Relative path, i.e.  xx?yy=abc . In this case the original RequestURI
 //       is updated according to the new relative path.
+//     * Query string and/or hash only, i.e. ?yy=abc#qwe or #qwe .
+//
+// See https://tools.ietf.org/html/rfc3986#section-5.2 for details.
 func (x *URI) Update(newURI string) {
 	x.fullURI = append(x.fullURI[:0], newURI...)
 	x.UpdateBytes(x.fullURI)
@@ -326,10 +331,15 @@ func (x *URI) Update(newURI string) {
 //
 //     * Absolute, i.e. http://foobar.com/aaa/bb?cc . In this case the original
 //       uri is replaced by newURI.
+//     * Missing scheme, i.e. //foobar.com/aaa/bb?cc . In this case
+//       the original scheme is preserved.
 //     * Missing host, i.e. /aaa/bb?cc . In this case only RequestURI part
 //       of the original uri is replaced.
 //     * Relative path, i.e.  xx?yy=abc . In this case the original RequestURI
 //       is updated according to the new relative path.
+//     * Query string and/or hash only, i.e. ?yy=abc#qwe or #qwe .
+//
+// See https://tools.ietf.org/html/rfc3986#section-5.2 for details.
 func (x *URI) UpdateBytes(newURI []byte) {
 	x.requestURI = x.updateBytes(newURI, x.requestURI)
 }
@@ -338,6 +348,14 @@ func (x *URI) updateBytes(newURI, buf []byte) []byte {
 	if len(newURI) == 0 {
 		return buf
 	}
+	if bytes.HasPrefix(newURI, strSlashSlash) {
+		// uri without scheme
+		buf = append(buf[:0], x.Scheme()...)
+		buf = append(buf, ':')
+		buf = append(buf, newURI...)
+		x.Parse(nil, buf)
+		return buf
+	}
 	if newURI[0] == '/' {
 		// uri without host
 		buf = x.appendSchemeHost(buf[:0])
@@ -346,22 +364,33 @@ func (x *URI) updateBytes(newURI, buf []byte) []byte {
 		return buf
 	}
 
-	n := bytes.Index(newURI, strColonSlashSlash)
-	if n >= 0 {
-		// absolute uri
+	if isAbsoluteURI(newURI) {
 		x.Parse(nil, newURI)
 		return buf
 	}
 
 	// relative path
-	if newURI[0] == '?' {
+	switch newURI[0] {
+	case '?':
 		// query string only update
-		x.SetQueryStringBytes(newURI[1:])
+		queryString := newURI[1:]
+		n := bytes.IndexByte(queryString, '#')
+		if n >= 0 {
+			x.SetHashBytes(queryString[n+1:])
+			queryString = queryString[:n]
+		} else {
+			x.SetHashBytes(nil)
+		}
+		x.SetQueryStringBytes(queryString)
+		return buf
+	case '#':
+		// hash only update
+		x.SetHashBytes(newURI[1:])
 		return buf
 	}
 
 	path := x.Path()
-	n = bytes.LastIndexByte(path, '/')
+	n := bytes.LastIndexByte(path, '/')
 	if n < 0 {
 		panic("BUG: path must contain at least one slash")
 	}
@@ -403,6 +432,13 @@ func (x *URI) String() string {
 	return string(x.FullURI())
 }
 
+// isAbsoluteURI returns true if uri contains scheme and host,
+// i.e. http://example.com/foo .
+func isAbsoluteURI(uri []byte) bool {
+	n := bytes.Index(uri, strColonSlashSlash)
+	return n > 0 && bytes.IndexAny(uri[:n], "/?#") < 0
+}
+
 func splitHostUri(host, uri []byte) ([]byte, []byte, []byte) {
 	n := bytes.Index(uri, strColonSlashSlash)
 	if n < 0 {
@@ -414,7 +450,7 @@ func splitHostUri(host, uri []byte) ([]byte, []byte, []byte) {
 	}
 	n += len(strColonSlashSlash)
 	uri = uri[n:]
-	n = bytes.IndexByte(uri, '/')
+	n = bytes.IndexAny(uri, "/?#")
 	if n < 0 {
 		return scheme, uri, strSlash
 	}
@@ -35,6 +35,18 @@ func TestURIUpdate(t *testing.T) {
 	testURIUpdate(t, "http://xx/a/b/c/d", "../qwe/p?zx=34", "http://xx/a/b/qwe/p?zx=34")
 	testURIUpdate(t, "https://qqq/aaa.html?foo=bar", "?baz=434&aaa", "https://qqq/aaa.html?baz=434&aaa")
 	testURIUpdate(t, "http://foo.bar/baz", "~a/%20b=c,тест?йцу=ке", "http://foo.bar/~a/%20b=c,%D1%82%D0%B5%D1%81%D1%82?йцу=ке")
+	testURIUpdate(t, "http://foo.bar/baz/xxx", "aa?url=http://x.com/y", "http://foo.bar/baz/aa?url=http://x.com/y")
+	testURIUpdate(t, "http://a/b/c/d;p?q", "./g", "http://a/b/c/g")
+	testURIUpdate(t, "http://a/b/c/d;p?q", "g/", "http://a/b/c/g/")
+	testURIUpdate(t, "http://a/b/c/d;p?q", "../../g", "http://a/g")
+
+	// uri without scheme
+	testURIUpdate(t, "https://foo.bar/baz?aaa=22#aaa", "//aa.com/bb?cc", "https://aa.com/bb?cc")
+
+	// query string and hash
+	testURIUpdate(t, "http://foo.bar/baz?aaa=22#aaa", "?bb=33#cc", "http://foo.bar/baz?bb=33#cc")
+	testURIUpdate(t, "http://foo.bar/baz?aaa=22#aaa", "?bb=33", "http://foo.bar/baz?bb=33")
+	testURIUpdate(t, "http://foo.bar/baz?aaa=22#aaa", "#cc", "http://foo.bar/baz?aaa=22#cc")
 }
 
 func testURIUpdate(t *testing.T, base, update, result string) {
@@ -194,6 +206,8 @@ func TestURIParse(t *testing.T) {
 	// no slash after hostname in uri
 	testURIParse(t, &u, "aaa.com", "http://google.com",
 		"http://google.com/", "google.com", "/", "/", "", "")
+	testURIParse(t, &u, "aaa.com", "http://google.com?foo=bar#baz",
+		"http://google.com/?foo=bar#baz", "google.com", "/", "", "foo=bar", "baz")
 
 	// uppercase hostname in uri
 	testURIParse(t, &u, "abc.com", "http://GoGLE.com/aaa",
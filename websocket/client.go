@@ -0,0 +1,283 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Dial establishes WebSocket connection to the given url.
+//
+// The url must have ws:// or wss:// scheme.
+func Dial(url string) (*Conn, error) {
+	return defaultDialer.DialURL(url)
+}
+
+var defaultDialer Dialer
+
+var errUnsupportedScheme = errors.New("websocket: unsupported url scheme. Expecting ws or wss")
+
+// Dialer establishes client-side WebSocket connections.
+//
+// It is safe to call Dialer methods from concurrently running goroutines.
+type Dialer struct {
+	// Callback for establishing new TCP connections.
+	//
+	// fasthttp.Dial is used by default.
+	Dial fasthttp.DialFunc
+
+	// Attempt to connect to both ipv4 and ipv6 host addresses
+	// if set to true.
+	//
+	// This option is used only if default TCP dialer is used,
+	// i.e. if Dial is blank.
+	DialDualStack bool
+
+	// Optional TLS config for wss:// connections.
+	TLSConfig *tls.Config
+
+	// Maximum duration for establishing the connection and completing
+	// the handshake.
+	//
+	// By default the handshake duration is unlimited.
+	HandshakeTimeout time.Duration
+
+	// Subprotocols lists the subprotocols requested from the server.
+	Subprotocols []string
+
+	// EnableCompression enables permessage-deflate extension negotiation.
+	EnableCompression bool
+
+	// Per-connection buffer size for reading frames.
+	//
+	// Default buffer size is used if not set.
+	ReadBufferSize int
+
+	// Per-connection buffer size for writing frames.
+	//
+	// This also limits the size of fragments written via Conn.NextWriter.
+	//
+	// Default buffer size is used if not set.
+	WriteBufferSize int
+
+	// Maximum incoming message size in bytes.
+	//
+	// DefaultMaxMessageSize is used if not set.
+	MaxMessageSize int
+}
+
+// DialURL establishes WebSocket connection to the given url.
+//
+// The url must have ws:// or wss:// scheme.
+func (d *Dialer) DialURL(url string) (*Conn, error) {
+	req := fasthttp.AcquireRequest()
+	req.SetRequestURI(url)
+	c, err := d.DialRequest(req, nil)
+	fasthttp.ReleaseRequest(req)
+	return c, err
+}
+
+// DialRequest establishes WebSocket connection to req.URI()
+// using the given handshake request.
+//
+// The request uri must have ws:// or wss:// scheme. Arbitrary headers
+// such as 'Origin', 'Authorization' or cookies may be set in req.
+// WebSocket handshake headers are added to req by DialRequest.
+//
+// The handshake response is stored in resp if it isn't nil.
+// ErrBadHandshake is returned if the server rejects the handshake.
+// resp contains the server response in this case.
+func (d *Dialer) DialRequest(req *fasthttp.Request, resp *fasthttp.Response) (*Conn, error) {
+	uri := req.URI()
+	var isTLS bool
+	switch string(uri.Scheme()) {
+	case "ws":
+		isTLS = false
+	case "wss":
+		isTLS = true
+	default:
+		return nil, errUnsupportedScheme
+	}
+
+	var deadline time.Time
+	if d.HandshakeTimeout > 0 {
+		deadline = time.Now().Add(d.HandshakeTimeout)
+	}
+	c, err := d.dial(string(uri.Host()), isTLS)
+	if err != nil {
+		return nil, err
+	}
+	if !deadline.IsZero() {
+		c.SetDeadline(deadline)
+	}
+
+	if resp == nil {
+		resp = fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+	}
+	conn, err := d.handshake(c, req, resp)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !deadline.IsZero() {
+		c.SetDeadline(time.Time{})
+	}
+	return conn, nil
+}
+
+func (d *Dialer) dial(addr string, isTLS bool) (net.Conn, error) {
+	dial := d.Dial
+	if dial == nil {
+		if d.DialDualStack {
+			dial = fasthttp.DialDualStack
+		} else {
+			dial = fasthttp.Dial
+		}
+		addr = addMissingPort(addr, isTLS)
+	}
+	c, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		panic("BUG: DialFunc returned (nil, nil)")
+	}
+	if isTLS {
+		tlsConfig := d.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = defaultTLSConfig
+		}
+		c = tls.Client(c, tlsConfig)
+	}
+	return c, nil
+}
+
+var defaultTLSConfig = &tls.Config{
+	InsecureSkipVerify: true,
+}
+
+func (d *Dialer) handshake(c net.Conn, req *fasthttp.Request, resp *fasthttp.Response) (*Conn, error) {
+	var keyBuf [16]byte
+	if _, err := rand.Read(keyBuf[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBuf[:])
+
+	h := &req.Header
+	h.SetMethod("GET")
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "websocket")
+	h.Set("Sec-WebSocket-Version", "13")
+	h.Set("Sec-WebSocket-Key", key)
+	if len(d.Subprotocols) > 0 {
+		h.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		h.Set("Sec-WebSocket-Extensions", compressionExtension)
+	}
+
+	readBufferSize := d.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	writeBufferSize := d.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	conn := newConn(c, bufio.NewReaderSize(c, readBufferSize), false, writeBufferSize)
+	if d.MaxMessageSize > 0 {
+		conn.maxMessageSize = d.MaxMessageSize
+	}
+
+	if err := req.Write(conn.bw); err != nil {
+		return nil, err
+	}
+	if err := conn.bw.Flush(); err != nil {
+		return nil, err
+	}
+	// The response body isn't read for '101 Switching Protocols' response,
+	// so the frames sent by the server right after the handshake
+	// remain in conn.br.
+	if err := resp.Read(conn.br); err != nil {
+		return nil, err
+	}
+
+	rh := &resp.Header
+	if rh.StatusCode() != fasthttp.StatusSwitchingProtocols ||
+		!headerContainsToken(rh.Peek("Connection"), "upgrade") ||
+		!headerContainsToken(rh.Peek("Upgrade"), "websocket") ||
+		!bytes.Equal(rh.Peek("Sec-WebSocket-Accept"), appendAcceptKey(nil, []byte(key))) {
+		return nil, ErrBadHandshake
+	}
+
+	subprotocol := string(rh.Peek("Sec-WebSocket-Protocol"))
+	if len(subprotocol) > 0 && !isStringInSlice(subprotocol, d.Subprotocols) {
+		return nil, fmt.Errorf("websocket: the server selected unexpected subprotocol %q", subprotocol)
+	}
+	conn.subprotocol = subprotocol
+
+	extensions := rh.Peek("Sec-WebSocket-Extensions")
+	if len(extensions) > 0 {
+		if !d.EnableCompression || !isValidCompressionResponse(extensions) {
+			return nil, fmt.Errorf("websocket: the server selected unexpected extensions %q", extensions)
+		}
+		conn.compress = true
+	}
+	return conn, nil
+}
+
+// isValidCompressionResponse returns true if the server accepted
+// permessage-deflate extension with parameters supported by the client.
+func isValidCompressionResponse(extensions []byte) bool {
+	name, params := nextHeaderToken(extensions, ';')
+	if string(name) != "permessage-deflate" || bytes.IndexByte(params, ',') >= 0 {
+		return false
+	}
+	for len(params) > 0 {
+		var param []byte
+		param, params = nextHeaderToken(params, ';')
+		if n := bytes.IndexByte(param, '='); n >= 0 {
+			param = bytes.TrimSpace(param[:n])
+		}
+		switch string(param) {
+		case "server_no_context_takeover", "client_no_context_takeover", "server_max_window_bits":
+			// The client decompresses messages with the maximum window size,
+			// so it accepts any server window.
+		default:
+			// client_max_window_bits hasn't been offered by the client.
+			return false
+		}
+	}
+	return true
+}
+
+func isStringInSlice(s string, a []string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func addMissingPort(addr string, isTLS bool) string {
+	n := strings.Index(addr, ":")
+	if n >= 0 {
+		return addr
+	}
+	port := 80
+	if isTLS {
+		port = 443
+	}
+	return fmt.Sprintf("%s:%d", addr, port)
+}
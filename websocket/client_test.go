@@ -0,0 +1,209 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDial(t *testing.T) {
+	addr, stop := startEchoServer(t, &Upgrader{})
+	defer stop()
+
+	c, err := Dial("ws://" + addr + "/foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testClientConn(t, c)
+}
+
+func TestDialerCompression(t *testing.T) {
+	addr, stop := startEchoServer(t, &Upgrader{
+		EnableCompression: true,
+	})
+	defer stop()
+
+	d := &Dialer{
+		EnableCompression: true,
+	}
+	c, err := d.DialURL("ws://" + addr + "/foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !c.Compression() {
+		t.Fatalf("expecting negotiated compression")
+	}
+	testClientConn(t, c)
+}
+
+func TestDialerTLS(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../ssl-cert-snakeoil.pem", "../ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load certificate: %s", err)
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	tlsLn := tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	u := &Upgrader{}
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			u.Upgrade(ctx, func(c *Conn) {
+				c.WriteMessage(TextMessage, []byte("hello"))
+			})
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(tlsLn)
+		close(ch)
+	}()
+	defer func() {
+		tlsLn.Close()
+		<-ch
+	}()
+
+	c, err := Dial("wss://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	_, b, err := c.ReadMessage(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected message %q. Expecting %q", b, "hello")
+	}
+}
+
+func TestDialerCustomDial(t *testing.T) {
+	addr, stop := startEchoServer(t, &Upgrader{
+		Subprotocols: []string{"foo", "bar"},
+	})
+	defer stop()
+
+	var dialAddr string
+	d := &Dialer{
+		Dial: func(a string) (net.Conn, error) {
+			dialAddr = a
+			return fasthttp.Dial(addr)
+		},
+		Subprotocols:     []string{"bar"},
+		HandshakeTimeout: time.Second,
+	}
+	c, err := d.DialURL("ws://example.com/foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dialAddr != "example.com" {
+		t.Fatalf("unexpected dial address %q. Expecting %q", dialAddr, "example.com")
+	}
+	if c.Subprotocol() != "bar" {
+		t.Fatalf("unexpected subprotocol %q. Expecting %q", c.Subprotocol(), "bar")
+	}
+	testClientConn(t, c)
+}
+
+func TestDialerBadHandshake(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.Error("forbidden", fasthttp.StatusForbidden)
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	defer func() {
+		ln.Close()
+		<-ch
+	}()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("ws://" + ln.Addr().String() + "/foo")
+	req.Header.Set("Origin", "http://example.com")
+	var resp fasthttp.Response
+	var d Dialer
+	if _, err = d.DialRequest(req, &resp); err != ErrBadHandshake {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBadHandshake)
+	}
+	if resp.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusForbidden)
+	}
+	if string(resp.Body()) != "forbidden" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "forbidden")
+	}
+
+	if _, err = Dial("http://" + ln.Addr().String() + "/foo"); err != errUnsupportedScheme {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errUnsupportedScheme)
+	}
+}
+
+func TestIsValidCompressionResponse(t *testing.T) {
+	testIsValidCompressionResponse(t, "permessage-deflate", true)
+	testIsValidCompressionResponse(t, compressionExtension, true)
+	testIsValidCompressionResponse(t, "permessage-deflate; server_max_window_bits=10", true)
+	testIsValidCompressionResponse(t, "permessage-deflate; client_max_window_bits=10", false)
+	testIsValidCompressionResponse(t, "permessage-deflate, permessage-deflate", false)
+	testIsValidCompressionResponse(t, "foobar", false)
+}
+
+func testIsValidCompressionResponse(t *testing.T, extensions string, expectedResult bool) {
+	if isValidCompressionResponse([]byte(extensions)) != expectedResult {
+		t.Fatalf("unexpected result for isValidCompressionResponse(%q). Expecting %v", extensions, expectedResult)
+	}
+}
+
+func testClientConn(t *testing.T, c *Conn) {
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		s := strings.Repeat(fmt.Sprintf("message %d ", i), i*1000)
+		w, err := c.NextWriter(BinaryMessage)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for j := 0; j < 3; j++ {
+			if _, err = w.Write([]byte(s)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		mt, b, err := c.ReadMessage(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if mt != BinaryMessage {
+			t.Fatalf("unexpected message type %d. Expecting %d", mt, BinaryMessage)
+		}
+		if string(b) != s+s+s {
+			t.Fatalf("unexpected message with length %d. Expecting length %d", len(b), 3*len(s))
+		}
+	}
+
+	if err := c.WriteClose(CloseNormalClosure, "done"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, _, err := c.ReadMessage(nil)
+	if ce, ok := err.(*CloseError); !ok || ce.Code != CloseNormalClosure {
+		t.Fatalf("unexpected error: %v. Expecting close error with code %d", err, CloseNormalClosure)
+	}
+}
@@ -0,0 +1,815 @@
+package websocket
+
+import (
+	"bufio"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Message types.
+//
+// See https://tools.ietf.org/html/rfc6455#section-11.8 .
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes.
+//
+// See https://tools.ietf.org/html/rfc6455#section-7.4.1 .
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+const (
+	continuationFrame = 0
+
+	finalBit = 0x80
+	rsv1Bit  = 0x40
+	rsv2Bit  = 0x20
+	rsv3Bit  = 0x10
+	maskBit  = 0x80
+
+	maxFrameHeaderSize         = 2 + 8 + 4
+	maxControlFramePayloadSize = 125
+)
+
+var (
+	// ErrCloseSent is returned when writing to the connection
+	// after the close frame has been sent.
+	ErrCloseSent = errors.New("websocket: close frame has been already sent")
+
+	// ErrReadLimit is returned when the incoming message exceeds
+	// the maximum message size.
+	ErrReadLimit = errors.New("websocket: message exceeds the maximum size")
+
+	errInvalidMessageType   = errors.New("websocket: invalid message type")
+	errWriterClosed         = errors.New("websocket: message writer is already closed")
+	errReservedBits         = errors.New("websocket: unexpected reserved bits")
+	errUnknownOpcode        = errors.New("websocket: unknown opcode")
+	errUnexpectedMask       = errors.New("websocket: unexpected masked frame")
+	errMissingMask          = errors.New("websocket: missing frame mask")
+	errInvalidControlFrame  = errors.New("websocket: invalid control frame")
+	errInvalidLength        = errors.New("websocket: invalid frame length")
+	errUnexpectedContinue   = errors.New("websocket: unexpected continuation frame")
+	errUnexpectedDataFrame  = errors.New("websocket: unexpected data frame inside fragmented message")
+	errInvalidUTF8          = errors.New("websocket: invalid utf8 in text message")
+	errInvalidCloseCode     = errors.New("websocket: invalid close code")
+	errInvalidCloseFrame    = errors.New("websocket: invalid close frame payload")
+	errInvalidCompressedMsg = errors.New("websocket: invalid compressed message")
+)
+
+// CloseError is returned from Conn.ReadMessage when the close frame
+// is received from the peer.
+type CloseError struct {
+	// Code is the close code sent by the peer.
+	//
+	// CloseNoStatusReceived is used if the peer sent no close code.
+	Code int
+
+	// Text is the close reason sent by the peer.
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: connection closed by peer with code %d: %q", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection.
+//
+// Conn is returned by Upgrader.Upgrade, Dial and Dialer.
+//
+// Only a single goroutine may read from Conn at a time. Only a single
+// goroutine may write data messages to Conn at a time. Control messages
+// (ping, pong and close) may be written concurrently with data messages.
+type Conn struct {
+	c  net.Conn
+	br *bufio.Reader
+
+	isServer         bool
+	subprotocol      string
+	compress         bool
+	compressionLevel int
+	maxMessageSize   int
+
+	// The following fields are accessed only by the reading goroutine.
+	readErr     error
+	rhdr        [maxFrameHeaderSize]byte
+	rbuf        []byte
+	cbuf        []byte
+	pingHandler func(data []byte) error
+	pongHandler func(data []byte) error
+
+	// The following fields are protected by wmu.
+	wmu       sync.Mutex
+	bw        *bufio.Writer
+	whdr      [maxFrameHeaderSize]byte
+	wbuf      []byte
+	zbuf      []byte
+	closeSent bool
+}
+
+func newConn(c net.Conn, br *bufio.Reader, isServer bool, writeBufferSize int) *Conn {
+	return &Conn{
+		c:                c,
+		br:               br,
+		bw:               bufio.NewWriterSize(c, writeBufferSize),
+		isServer:         isServer,
+		compressionLevel: fasthttp.CompressDefaultCompression,
+		maxMessageSize:   DefaultMaxMessageSize,
+	}
+}
+
+// Subprotocol returns the subprotocol negotiated during the handshake.
+//
+// Empty string is returned if no subprotocol has been negotiated.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compression returns true if permessage-deflate extension
+// has been negotiated during the handshake.
+func (c *Conn) Compression() bool {
+	return c.compress
+}
+
+// SetCompressionLevel sets compression level for outgoing messages.
+//
+// The level has effect only if permessage-deflate extension
+// has been negotiated. Supported levels are:
+//
+//     * fasthttp.CompressNoCompression
+//     * fasthttp.CompressBestSpeed
+//     * fasthttp.CompressBestCompression
+//     * fasthttp.CompressDefaultCompression
+func (c *Conn) SetCompressionLevel(level int) {
+	c.wmu.Lock()
+	c.compressionLevel = level
+	c.wmu.Unlock()
+}
+
+// LocalAddr returns local address for the connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.c.LocalAddr()
+}
+
+// RemoteAddr returns remote address for the connection.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.c.RemoteAddr()
+}
+
+// SetReadDeadline sets read deadline on the underlying connection.
+//
+// ReadMessage returns timeout error after the deadline is reached.
+// The connection becomes unusable for reading after that.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.c.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets write deadline on the underlying connection.
+//
+// Write calls return timeout error after the deadline is reached.
+// The connection becomes unusable for writing after that.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.c.SetWriteDeadline(t)
+}
+
+// Close closes the underlying connection without sending the close frame.
+//
+// Use WriteClose for initiating the close handshake.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
+
+// SetPingHandler sets the handler for ping messages received from the peer.
+//
+// The handler is called from ReadMessage. By default pong message
+// with the same payload is sent in response to ping message.
+//
+// data passed to the handler is valid only until the handler returns.
+// Non-nil error returned by the handler is returned from ReadMessage.
+func (c *Conn) SetPingHandler(h func(data []byte) error) {
+	c.pingHandler = h
+}
+
+// SetPongHandler sets the handler for pong messages received from the peer.
+//
+// The handler is called from ReadMessage. By default pong messages
+// are ignored.
+//
+// data passed to the handler is valid only until the handler returns.
+// Non-nil error returned by the handler is returned from ReadMessage.
+func (c *Conn) SetPongHandler(h func(data []byte) error) {
+	c.pongHandler = h
+}
+
+// ReadMessage reads the next data message from the connection,
+// appends its payload to dst and returns the message type
+// (TextMessage or BinaryMessage) and the resulting dst.
+//
+// Fragmented messages are reassembled and compressed messages
+// are decompressed. Control messages received while reading
+// are passed to ping and pong handlers.
+//
+// *CloseError is returned after the close frame is received from the peer.
+// The close frame is automatically echoed back to the peer
+// if it hasn't been sent yet.
+//
+// The connection becomes unusable for reading after the first error.
+func (c *Conn) ReadMessage(dst []byte) (int, []byte, error) {
+	if c.readErr != nil {
+		return 0, dst, c.readErr
+	}
+	messageType, dst, err := c.readMessage(dst)
+	if err != nil {
+		c.readErr = err
+	}
+	return messageType, dst, err
+}
+
+func (c *Conn) readMessage(dst []byte) (int, []byte, error) {
+	start := len(dst)
+	messageType := 0
+	compressed := false
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, dst, err
+		}
+		if h.opcode >= CloseMessage {
+			if err = c.handleControlFrame(&h); err != nil {
+				return 0, dst, err
+			}
+			continue
+		}
+
+		if h.opcode == continuationFrame {
+			if messageType == 0 {
+				return 0, dst, c.fail(CloseProtocolError, errUnexpectedContinue)
+			}
+		} else {
+			if messageType != 0 {
+				return 0, dst, c.fail(CloseProtocolError, errUnexpectedDataFrame)
+			}
+			messageType = int(h.opcode)
+			compressed = h.rsv1
+			c.rbuf = c.rbuf[:0]
+		}
+
+		n := len(dst) - start
+		if compressed {
+			n = len(c.rbuf)
+		}
+		if uint64(n)+h.length > uint64(c.maxMessageSize) {
+			return 0, dst, c.fail(CloseMessageTooBig, ErrReadLimit)
+		}
+		if compressed {
+			c.rbuf, err = c.readPayload(c.rbuf, &h)
+		} else {
+			dst, err = c.readPayload(dst, &h)
+		}
+		if err != nil {
+			return 0, dst, err
+		}
+		if !h.fin {
+			continue
+		}
+
+		if compressed {
+			c.rbuf = append(c.rbuf, deflateTail...)
+			dst, err = appendInflateBytes(dst, c.rbuf, start+c.maxMessageSize)
+			if err != nil {
+				if err == ErrReadLimit {
+					return 0, dst, c.fail(CloseMessageTooBig, err)
+				}
+				return 0, dst, c.fail(CloseInvalidFramePayloadData, errInvalidCompressedMsg)
+			}
+		}
+		if messageType == TextMessage && !utf8.Valid(dst[start:]) {
+			return 0, dst, c.fail(CloseInvalidFramePayloadData, errInvalidUTF8)
+		}
+		return messageType, dst, nil
+	}
+}
+
+type frameHeader struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	masked  bool
+	maskKey [4]byte
+	length  uint64
+}
+
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var h frameHeader
+	b := c.rhdr[:2]
+	if _, err := io.ReadFull(c.br, b); err != nil {
+		return h, err
+	}
+	h.fin = b[0]&finalBit != 0
+	h.rsv1 = b[0]&rsv1Bit != 0
+	h.opcode = b[0] & 0x0f
+	h.masked = b[1]&maskBit != 0
+	h.length = uint64(b[1] & 0x7f)
+
+	switch h.opcode {
+	case continuationFrame, TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return h, c.fail(CloseProtocolError, errUnknownOpcode)
+	}
+	if b[0]&(rsv2Bit|rsv3Bit) != 0 {
+		return h, c.fail(CloseProtocolError, errReservedBits)
+	}
+	if h.rsv1 && (!c.compress || h.opcode == continuationFrame || h.opcode >= CloseMessage) {
+		// RSV1 is allowed only in the first frame of compressed message.
+		return h, c.fail(CloseProtocolError, errReservedBits)
+	}
+	if h.masked != c.isServer {
+		// Clients must mask frames, while servers mustn't.
+		if h.masked {
+			return h, c.fail(CloseProtocolError, errUnexpectedMask)
+		}
+		return h, c.fail(CloseProtocolError, errMissingMask)
+	}
+
+	switch h.length {
+	case 126:
+		b = c.rhdr[:2]
+		if _, err := io.ReadFull(c.br, b); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		h.length = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b = c.rhdr[:8]
+		if _, err := io.ReadFull(c.br, b); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		h.length = binary.BigEndian.Uint64(b)
+		if h.length>>63 != 0 {
+			return h, c.fail(CloseProtocolError, errInvalidLength)
+		}
+	}
+	if h.masked {
+		if _, err := io.ReadFull(c.br, h.maskKey[:]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+	}
+	return h, nil
+}
+
+// readPayload appends frame payload to dst.
+func (c *Conn) readPayload(dst []byte, h *frameHeader) ([]byte, error) {
+	start := len(dst)
+	n := h.length
+	for n > 0 {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		m := cap(dst) - len(dst)
+		if uint64(m) > n {
+			m = int(n)
+		}
+		k, err := io.ReadFull(c.br, dst[len(dst):len(dst)+m])
+		dst = dst[:len(dst)+k]
+		if err != nil {
+			return dst, unexpectedEOF(err)
+		}
+		n -= uint64(k)
+	}
+	if h.masked {
+		maskBytes(h.maskKey, dst[start:])
+	}
+	return dst, nil
+}
+
+func (c *Conn) handleControlFrame(h *frameHeader) error {
+	if !h.fin || h.length > maxControlFramePayloadSize {
+		return c.fail(CloseProtocolError, errInvalidControlFrame)
+	}
+	var err error
+	if c.cbuf, err = c.readPayload(c.cbuf[:0], h); err != nil {
+		return err
+	}
+	data := c.cbuf
+
+	switch h.opcode {
+	case PingMessage:
+		if c.pingHandler != nil {
+			return c.pingHandler(data)
+		}
+		if err = c.WritePong(data); err == ErrCloseSent {
+			err = nil
+		}
+		return err
+	case PongMessage:
+		if c.pongHandler != nil {
+			return c.pongHandler(data)
+		}
+		return nil
+	default:
+		return c.handleClose(data)
+	}
+}
+
+func (c *Conn) handleClose(data []byte) error {
+	code := CloseNoStatusReceived
+	text := ""
+	if len(data) == 1 {
+		return c.fail(CloseProtocolError, errInvalidCloseFrame)
+	}
+	if len(data) >= 2 {
+		code = int(binary.BigEndian.Uint16(data))
+		if !isValidCloseCode(code) {
+			return c.fail(CloseProtocolError, errInvalidCloseCode)
+		}
+		if !utf8.Valid(data[2:]) {
+			return c.fail(CloseInvalidFramePayloadData, errInvalidUTF8)
+		}
+		text = string(data[2:])
+	}
+
+	// Echo the close frame back to the peer.
+	var err error
+	if code == CloseNoStatusReceived {
+		err = c.writeControl(CloseMessage, nil)
+	} else {
+		err = c.WriteClose(code, "")
+	}
+	if err != nil && err != ErrCloseSent {
+		return err
+	}
+	return &CloseError{
+		Code: code,
+		Text: text,
+	}
+}
+
+// fail sends close frame with the given code to the peer
+// and returns err.
+func (c *Conn) fail(code int, err error) error {
+	c.WriteClose(code, err.Error())
+	return err
+}
+
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage writes message with the given type and payload p
+// to the connection.
+//
+// The message is compressed if permessage-deflate extension
+// has been negotiated. Use NextWriter for writing fragmented messages.
+func (c *Conn) WriteMessage(messageType int, p []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+		c.wmu.Lock()
+		err := c.writeDataFrame(byte(messageType), true, p)
+		c.wmu.Unlock()
+		return err
+	case CloseMessage, PingMessage, PongMessage:
+		return c.writeControl(messageType, p)
+	default:
+		return errInvalidMessageType
+	}
+}
+
+// WritePing sends ping message with the given payload to the peer.
+//
+// The payload mustn't exceed 125 bytes.
+func (c *Conn) WritePing(data []byte) error {
+	return c.writeControl(PingMessage, data)
+}
+
+// WritePong sends pong message with the given payload to the peer.
+//
+// The payload mustn't exceed 125 bytes.
+func (c *Conn) WritePong(data []byte) error {
+	return c.writeControl(PongMessage, data)
+}
+
+// WriteClose initiates the close handshake by sending close frame
+// with the given code and reason to the peer.
+//
+// The reason is truncated to 123 bytes. ReadMessage returns *CloseError
+// after the peer responds with close frame.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if len(reason) > maxControlFramePayloadSize-2 {
+		reason = reason[:maxControlFramePayloadSize-2]
+	}
+	var b [maxControlFramePayloadSize]byte
+	binary.BigEndian.PutUint16(b[:], uint16(code))
+	n := copy(b[2:], reason)
+	return c.writeControl(CloseMessage, b[:2+n])
+}
+
+func (c *Conn) writeControl(messageType int, data []byte) error {
+	if len(data) > maxControlFramePayloadSize {
+		return errInvalidControlFrame
+	}
+	c.wmu.Lock()
+	err := c.writeFrame(byte(messageType), false, true, data)
+	if messageType == CloseMessage {
+		c.closeSent = true
+	}
+	c.wmu.Unlock()
+	return err
+}
+
+// NextWriter returns a writer for the next message with the given type.
+//
+// Data written to the returned writer is sent to the peer in fragments
+// of up to write buffer size. The message is finished when the writer
+// is closed. Compressed messages are sent in a single frame
+// after the writer is closed.
+//
+// The previous writer must be closed before calling NextWriter.
+func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, errInvalidMessageType
+	}
+	return &messageWriter{
+		c:      c,
+		opcode: byte(messageType),
+	}, nil
+}
+
+type messageWriter struct {
+	c      *Conn
+	opcode byte
+	buf    []byte
+	err    error
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	if !w.c.compress && len(w.buf) >= w.c.bw.Size() {
+		w.flush(false)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.flush(true)
+	if w.err == nil {
+		w.err = errWriterClosed
+		return nil
+	}
+	return w.err
+}
+
+func (w *messageWriter) flush(fin bool) {
+	c := w.c
+	c.wmu.Lock()
+	w.err = c.writeDataFrame(w.opcode, fin, w.buf)
+	c.wmu.Unlock()
+	w.opcode = continuationFrame
+	w.buf = w.buf[:0]
+}
+
+// writeDataFrame must be called under wmu lock.
+func (c *Conn) writeDataFrame(opcode byte, fin bool, p []byte) error {
+	if !c.compress || opcode == continuationFrame {
+		return c.writeFrame(opcode, false, fin, p)
+	}
+	c.zbuf = appendDeflateBytes(c.zbuf[:0], p, c.compressionLevel)
+	return c.writeFrame(opcode, true, fin, c.zbuf)
+}
+
+// writeFrame must be called under wmu lock.
+func (c *Conn) writeFrame(opcode byte, rsv1, fin bool, p []byte) error {
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	b := c.whdr[:1]
+	b[0] = opcode
+	if fin {
+		b[0] |= finalBit
+	}
+	if rsv1 {
+		b[0] |= rsv1Bit
+	}
+	var mask byte
+	if !c.isServer {
+		mask = maskBit
+	}
+	n := len(p)
+	switch {
+	case n <= 125:
+		b = append(b, mask|byte(n))
+	case n <= 0xffff:
+		b = append(b, mask|126, byte(n>>8), byte(n))
+	default:
+		b = append(b, mask|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[len(b)-8:], uint64(n))
+	}
+	if !c.isServer {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		b = append(b, key[:]...)
+		c.wbuf = append(c.wbuf[:0], p...)
+		maskBytes(key, c.wbuf)
+		p = c.wbuf
+	}
+
+	if _, err := c.bw.Write(b); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(p); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// deflateTail is appended to compressed message before decompression.
+//
+// The first four bytes complete the empty stored block stripped
+// by the sender, while the remaining bytes terminate the stream
+// for senders, which don't set BFINAL bit.
+// See https://tools.ietf.org/html/rfc7692#section-7.2.2 .
+var deflateTail = []byte("\x00\x00\xff\xff\x01\x00\x00\xff\xff")
+
+// appendDeflateBytes appends permessage-deflate compressed p to dst.
+func appendDeflateBytes(dst, p []byte, level int) []byte {
+	start := len(dst)
+	w := &byteSliceWriter{dst}
+	zw := acquireFlateWriter(w, level)
+	zw.Write(p)
+	releaseFlateWriter(zw)
+	dst = w.b
+	// Strip the trailing empty stored block.
+	// See https://tools.ietf.org/html/rfc7692#section-7.2.1 .
+	if n := len(dst) - start; n >= 4 && string(dst[len(dst)-4:]) == "\x00\x00\xff\xff" {
+		dst = dst[:len(dst)-4]
+	}
+	return dst
+}
+
+// appendInflateBytes appends decompressed src to dst.
+//
+// ErrReadLimit is returned if the resulting dst exceeds maxLen.
+func appendInflateBytes(dst, src []byte, maxLen int) ([]byte, error) {
+	r := &byteSliceReader{b: src}
+	zr := acquireFlateReader(r)
+	defer flateReaderPool.Put(zr)
+
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := zr.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if len(dst) > maxLen {
+			return dst, ErrReadLimit
+		}
+		if err == io.EOF {
+			return dst, nil
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+}
+
+func acquireFlateReader(r io.Reader) io.ReadCloser {
+	v := flateReaderPool.Get()
+	if v == nil {
+		return flate.NewReader(r)
+	}
+	zr := v.(io.ReadCloser)
+	zr.(flate.Resetter).Reset(r, nil)
+	return zr
+}
+
+var flateReaderPool sync.Pool
+
+func acquireFlateWriter(w io.Writer, level int) *flateWriter {
+	p := flateWriterPoolMap[level]
+	if p == nil {
+		panic(fmt.Sprintf("BUG: unexpected compression level passed: %d. See compress/flate for supported levels", level))
+	}
+
+	v := p.Get()
+	if v == nil {
+		zw, err := flate.NewWriter(w, level)
+		if err != nil {
+			panic(fmt.Sprintf("BUG: unexpected error in flate.NewWriter(%d): %s", level, err))
+		}
+		return &flateWriter{
+			Writer: zw,
+			p:      p,
+		}
+	}
+	zw := v.(*flateWriter)
+	zw.Reset(w)
+	return zw
+}
+
+func releaseFlateWriter(zw *flateWriter) {
+	zw.Close()
+	zw.p.Put(zw)
+}
+
+type flateWriter struct {
+	*flate.Writer
+	p *sync.Pool
+}
+
+var flateWriterPoolMap = func() map[int]*sync.Pool {
+	// Initialize pools for all the compression levels defined
+	// in https://golang.org/pkg/compress/flate/#pkg-constants .
+	m := make(map[int]*sync.Pool, 11)
+	m[-1] = &sync.Pool{}
+	for i := 0; i < 10; i++ {
+		m[i] = &sync.Pool{}
+	}
+	return m
+}()
+
+type byteSliceWriter struct {
+	b []byte
+}
+
+func (w *byteSliceWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+type byteSliceReader struct {
+	b []byte
+}
+
+func (r *byteSliceReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+func (r *byteSliceReader) ReadByte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c, nil
+}
@@ -0,0 +1,335 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConnPair(compress bool) (*Conn, *Conn) {
+	c1, c2 := net.Pipe()
+	server := newConn(c1, bufio.NewReader(c1), true, 4096)
+	client := newConn(c2, bufio.NewReader(c2), false, 4096)
+	server.compress = compress
+	client.compress = compress
+	return server, client
+}
+
+func TestConnReadWriteMessage(t *testing.T) {
+	testConnReadWriteMessage(t, false)
+}
+
+func TestConnReadWriteMessageCompressed(t *testing.T) {
+	testConnReadWriteMessage(t, true)
+}
+
+func testConnReadWriteMessage(t *testing.T, compress bool) {
+	server, client := newTestConnPair(compress)
+	defer server.Close()
+	defer client.Close()
+
+	messages := []string{"", "foobar", strings.Repeat("x", 200), strings.Repeat("abcdef", 20000)}
+	for _, s := range messages {
+		testConnSendMessage(t, client, server, TextMessage, s)
+		testConnSendMessage(t, server, client, BinaryMessage, s)
+	}
+}
+
+func testConnSendMessage(t *testing.T, from, to *Conn, messageType int, s string) {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- from.WriteMessage(messageType, []byte(s))
+	}()
+	mt, b, err := to.ReadMessage([]byte("prefix"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if mt != messageType {
+		t.Fatalf("unexpected message type %d. Expecting %d", mt, messageType)
+	}
+	if string(b) != "prefix"+s {
+		t.Fatalf("unexpected message %q. Expecting %q", b, "prefix"+s)
+	}
+	if err = <-ch; err != nil {
+		t.Fatalf("unexpected error when writing message: %s", err)
+	}
+}
+
+func TestConnFragmentedMessage(t *testing.T) {
+	testConnFragmentedMessage(t, false)
+	testConnFragmentedMessage(t, true)
+}
+
+func testConnFragmentedMessage(t *testing.T, compress bool) {
+	server, client := newTestConnPair(compress)
+	defer server.Close()
+	defer client.Close()
+
+	chunk := strings.Repeat("0123456789", 300)
+	ch := make(chan error, 1)
+	go func() {
+		w, err := client.NextWriter(BinaryMessage)
+		if err != nil {
+			ch <- err
+			return
+		}
+		for i := 0; i < 5; i++ {
+			if _, err = w.Write([]byte(chunk)); err != nil {
+				ch <- err
+				return
+			}
+		}
+		ch <- w.Close()
+	}()
+
+	mt, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if mt != BinaryMessage {
+		t.Fatalf("unexpected message type %d. Expecting %d", mt, BinaryMessage)
+	}
+	if string(b) != strings.Repeat(chunk, 5) {
+		t.Fatalf("unexpected message with length %d. Expecting length %d", len(b), 5*len(chunk))
+	}
+	if err = <-ch; err != nil {
+		t.Fatalf("unexpected error when writing message: %s", err)
+	}
+}
+
+func TestConnPingPong(t *testing.T) {
+	server, client := newTestConnPair(false)
+	defer server.Close()
+	defer client.Close()
+
+	pongCh := make(chan string, 1)
+	client.SetPongHandler(func(data []byte) error {
+		pongCh <- string(data)
+		return nil
+	})
+	go func() {
+		// The client must read in order to receive the pong.
+		client.ReadMessage(nil)
+	}()
+	go func() {
+		client.WritePing([]byte("foobar"))
+		client.WriteMessage(TextMessage, []byte("baz"))
+	}()
+
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != "baz" {
+		t.Fatalf("unexpected message %q. Expecting %q", b, "baz")
+	}
+	select {
+	case s := <-pongCh:
+		if s != "foobar" {
+			t.Fatalf("unexpected pong payload %q. Expecting %q", s, "foobar")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if err = client.WritePing(make([]byte, 126)); err != errInvalidControlFrame {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errInvalidControlFrame)
+	}
+}
+
+func TestConnCloseHandshake(t *testing.T) {
+	server, client := newTestConnPair(false)
+	defer server.Close()
+	defer client.Close()
+
+	ch := make(chan error, 1)
+	go func() {
+		if err := client.WriteClose(CloseGoingAway, "bye"); err != nil {
+			ch <- err
+			return
+		}
+		_, _, err := client.ReadMessage(nil)
+		ch <- err
+	}()
+
+	_, _, serverErr := server.ReadMessage(nil)
+	ce, ok := serverErr.(*CloseError)
+	if !ok {
+		t.Fatalf("unexpected error: %v. Expecting *CloseError", serverErr)
+	}
+	if ce.Code != CloseGoingAway || ce.Text != "bye" {
+		t.Fatalf("unexpected close error %+v. Expecting code %d and text %q", ce, CloseGoingAway, "bye")
+	}
+
+	err := <-ch
+	ce, ok = err.(*CloseError)
+	if !ok {
+		t.Fatalf("unexpected error: %v. Expecting *CloseError", err)
+	}
+	if ce.Code != CloseGoingAway {
+		t.Fatalf("unexpected close code %d. Expecting %d", ce.Code, CloseGoingAway)
+	}
+
+	if err = client.WriteMessage(TextMessage, []byte("foo")); err != ErrCloseSent {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrCloseSent)
+	}
+	if _, _, err = server.ReadMessage(nil); err != serverErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, serverErr)
+	}
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	server, client := newTestConnPair(false)
+	defer server.Close()
+	defer client.Close()
+
+	server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err := server.ReadMessage(nil)
+	if err == nil {
+		t.Fatalf("expecting error")
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("unexpected error: %v. Expecting timeout error", err)
+	}
+}
+
+type fakeConn struct {
+	net.Conn
+	r bytes.Buffer
+	w bytes.Buffer
+}
+
+func (c *fakeConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+func appendTestFrame(dst []byte, b0 byte, masked bool, payload string) []byte {
+	dst = append(dst, b0)
+	var mask byte
+	if masked {
+		mask = maskBit
+	}
+	n := len(payload)
+	switch {
+	case n <= 125:
+		dst = append(dst, mask|byte(n))
+	case n <= 0xffff:
+		dst = append(dst, mask|126, byte(n>>8), byte(n))
+	default:
+		dst = append(dst, mask|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(dst[len(dst)-8:], uint64(n))
+	}
+	p := []byte(payload)
+	if masked {
+		key := [4]byte{1, 2, 3, 4}
+		dst = append(dst, key[:]...)
+		maskBytes(key, p)
+	}
+	return append(dst, p...)
+}
+
+func TestConnReadInvalidFrames(t *testing.T) {
+	// unmasked client frame
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|TextMessage, false, "foo"), errMissingMask, CloseProtocolError)
+
+	// unknown opcode
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|3, true, "foo"), errUnknownOpcode, CloseProtocolError)
+
+	// reserved bits without compression
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|rsv1Bit|TextMessage, true, "foo"), errReservedBits, CloseProtocolError)
+
+	// continuation without the first frame
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|continuationFrame, true, "foo"), errUnexpectedContinue, CloseProtocolError)
+
+	// data frame inside fragmented message
+	b := appendTestFrame(nil, TextMessage, true, "foo")
+	b = appendTestFrame(b, finalBit|TextMessage, true, "bar")
+	testConnReadInvalidFrame(t, b, errUnexpectedDataFrame, CloseProtocolError)
+
+	// fragmented control frame
+	testConnReadInvalidFrame(t, appendTestFrame(nil, PingMessage, true, "foo"), errInvalidControlFrame, CloseProtocolError)
+
+	// too long control frame
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|PingMessage, true, strings.Repeat("x", 126)), errInvalidControlFrame, CloseProtocolError)
+
+	// invalid utf8
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|TextMessage, true, "foo\xff"), errInvalidUTF8, CloseInvalidFramePayloadData)
+
+	// invalid close code
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|CloseMessage, true, "\x03\xed"), errInvalidCloseCode, CloseProtocolError)
+
+	// too big message
+	testConnReadInvalidFrame(t, appendTestFrame(nil, finalBit|BinaryMessage, true, strings.Repeat("x", 101)), ErrReadLimit, CloseMessageTooBig)
+}
+
+func testConnReadInvalidFrame(t *testing.T, frames []byte, expectedErr error, expectedCode int) {
+	c := &fakeConn{}
+	c.r.Write(frames)
+	conn := newConn(c, bufio.NewReader(c), true, 4096)
+	conn.maxMessageSize = 100
+
+	_, _, err := conn.ReadMessage(nil)
+	if err != expectedErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+	}
+	if _, _, err = conn.ReadMessage(nil); err != expectedErr {
+		t.Fatalf("unexpected error on the second read: %v. Expecting %v", err, expectedErr)
+	}
+
+	b := c.w.Bytes()
+	if len(b) < 4 || b[0] != finalBit|CloseMessage {
+		t.Fatalf("expecting close frame. Got %q", b)
+	}
+	code := int(binary.BigEndian.Uint16(b[2:]))
+	if code != expectedCode {
+		t.Fatalf("unexpected close code %d. Expecting %d", code, expectedCode)
+	}
+}
+
+func TestConnReadCompressedMessage(t *testing.T) {
+	// "Hello" compressed with sync flush from RFC 7692 section 7.2.3.1.
+	testConnReadCompressedMessage(t, "\xf2\x48\xcd\xc9\xc9\x07\x00", "Hello")
+
+	// "Hello" compressed with BFINAL bit from RFC 7692 section 7.2.3.3.
+	testConnReadCompressedMessage(t, "\xf3\x48\xcd\xc9\xc9\x07\x00\x00", "Hello")
+}
+
+func testConnReadCompressedMessage(t *testing.T, payload, expectedMessage string) {
+	c := &fakeConn{}
+	c.r.Write(appendTestFrame(nil, finalBit|rsv1Bit|TextMessage, true, payload))
+	conn := newConn(c, bufio.NewReader(c), true, 4096)
+	conn.compress = true
+
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != expectedMessage {
+		t.Fatalf("unexpected message %q. Expecting %q", b, expectedMessage)
+	}
+	if _, _, err = conn.ReadMessage(nil); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+}
+
+func TestConnReadCompressedMessageTooBig(t *testing.T) {
+	payload := appendDeflateBytes(nil, bytes.Repeat([]byte("x"), 1000), 9)
+	c := &fakeConn{}
+	c.r.Write(appendTestFrame(nil, finalBit|rsv1Bit|BinaryMessage, true, string(payload)))
+	conn := newConn(c, bufio.NewReader(c), true, 4096)
+	conn.compress = true
+	conn.maxMessageSize = 100
+
+	if _, _, err := conn.ReadMessage(nil); err != ErrReadLimit {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrReadLimit)
+	}
+}
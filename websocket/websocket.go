@@ -0,0 +1,288 @@
+// Package websocket implements WebSocket protocol on top of fasthttp.
+//
+// See https://tools.ietf.org/html/rfc6455 for protocol details.
+//
+// Server-side connections are established via Upgrader.Upgrade,
+// which hijacks the connection from fasthttp.RequestCtx.
+// Client-side connections are established via Dial and Dialer.
+//
+// Permessage-deflate extension ( https://tools.ietf.org/html/rfc7692 )
+// is supported without context takeover.
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultMaxMessageSize is the maximum incoming message size used
+// if Upgrader.MaxMessageSize isn't set.
+const DefaultMaxMessageSize = 4 * 1024 * 1024
+
+const (
+	defaultReadBufferSize  = 4096
+	defaultWriteBufferSize = 4096
+)
+
+// ErrBadHandshake is returned when the WebSocket handshake fails.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Handler must process WebSocket connection c.
+//
+// The connection c is automatically closed after returning from Handler.
+type Handler func(c *Conn)
+
+// Upgrader upgrades HTTP requests to WebSocket connections.
+//
+// It is safe to call Upgrader methods from concurrently running goroutines.
+type Upgrader struct {
+	// Subprotocols lists the supported subprotocols in the order
+	// of preference.
+	//
+	// The first subprotocol from the list requested by the client
+	// is selected. No subprotocol is selected if the list is empty.
+	Subprotocols []string
+
+	// CheckOrigin must return true if the request origin is acceptable.
+	//
+	// By default requests with 'Origin' header, whose host doesn't match
+	// 'Host' header, are rejected.
+	CheckOrigin func(ctx *fasthttp.RequestCtx) bool
+
+	// EnableCompression enables permessage-deflate extension negotiation.
+	EnableCompression bool
+
+	// Per-connection buffer size for reading frames.
+	//
+	// Default buffer size is used if not set.
+	ReadBufferSize int
+
+	// Per-connection buffer size for writing frames.
+	//
+	// This also limits the size of fragments written via Conn.NextWriter.
+	//
+	// Default buffer size is used if not set.
+	WriteBufferSize int
+
+	// Maximum incoming message size in bytes.
+	//
+	// The connection is closed with CloseMessageTooBig code
+	// if the message exceeds this limit.
+	//
+	// DefaultMaxMessageSize is used if not set.
+	MaxMessageSize int
+}
+
+// Upgrade upgrades the request from ctx to WebSocket connection.
+//
+// The handler is called with the established connection after returning
+// from fasthttp.RequestHandler. See fasthttp.RequestCtx.Hijack
+// for details.
+//
+// ErrBadHandshake is returned and the corresponding error response
+// is set in ctx if the request isn't a valid WebSocket handshake.
+func (u *Upgrader) Upgrade(ctx *fasthttp.RequestCtx, handler Handler) error {
+	h := &ctx.Request.Header
+	if !ctx.IsGet() {
+		ctx.Response.Header.Set("Allow", "GET")
+		ctx.Error("websocket: the request method must be GET", fasthttp.StatusMethodNotAllowed)
+		return ErrBadHandshake
+	}
+	if !headerContainsToken(h.Peek("Connection"), "upgrade") || !headerContainsToken(h.Peek("Upgrade"), "websocket") {
+		ctx.Error("websocket: missing 'Connection: Upgrade' or 'Upgrade: websocket' header", fasthttp.StatusBadRequest)
+		return ErrBadHandshake
+	}
+	if string(h.Peek("Sec-WebSocket-Version")) != "13" {
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13")
+		ctx.Error("websocket: unsupported protocol version", fasthttp.StatusUpgradeRequired)
+		return ErrBadHandshake
+	}
+	key := h.Peek("Sec-WebSocket-Key")
+	if !isValidChallengeKey(key) {
+		ctx.Error("websocket: invalid 'Sec-WebSocket-Key' header", fasthttp.StatusBadRequest)
+		return ErrBadHandshake
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(ctx) {
+		ctx.Error("websocket: origin not allowed", fasthttp.StatusForbidden)
+		return ErrBadHandshake
+	}
+
+	subprotocol := selectSubprotocol(h.Peek("Sec-WebSocket-Protocol"), u.Subprotocols)
+	compress := u.EnableCompression && acceptCompression(h.Peek("Sec-WebSocket-Extensions"))
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set("Upgrade", "websocket")
+	ctx.Response.Header.Set("Connection", "Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept", string(appendAcceptKey(nil, key)))
+	if len(subprotocol) > 0 {
+		ctx.Response.Header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if compress {
+		ctx.Response.Header.Set("Sec-WebSocket-Extensions", compressionExtension)
+	}
+
+	readBufferSize := u.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	writeBufferSize := u.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	maxMessageSize := u.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	ctx.Hijack(func(c net.Conn) {
+		conn := newConn(c, bufio.NewReaderSize(c, readBufferSize), true, writeBufferSize)
+		conn.subprotocol = subprotocol
+		conn.compress = compress
+		conn.maxMessageSize = maxMessageSize
+		handler(conn)
+	})
+	return nil
+}
+
+// IsWebSocketUpgrade returns true if the request from ctx
+// is a WebSocket handshake request.
+func IsWebSocketUpgrade(ctx *fasthttp.RequestCtx) bool {
+	h := &ctx.Request.Header
+	return ctx.IsGet() && headerContainsToken(h.Peek("Connection"), "upgrade") &&
+		headerContainsToken(h.Peek("Upgrade"), "websocket")
+}
+
+func checkSameOrigin(ctx *fasthttp.RequestCtx) bool {
+	origin := ctx.Request.Header.Peek("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	n := bytes.Index(origin, strSchemeSep)
+	if n < 0 {
+		return false
+	}
+	host := origin[n+len(strSchemeSep):]
+	if n = bytes.IndexByte(host, '/'); n >= 0 {
+		host = host[:n]
+	}
+	return bytes.EqualFold(host, ctx.Host())
+}
+
+func selectSubprotocol(requested []byte, supported []string) string {
+	for _, s := range supported {
+		if headerContainsToken(requested, s) {
+			return s
+		}
+	}
+	return ""
+}
+
+// compressionExtension is the permessage-deflate extension offer
+// sent by the client and the response sent by the server.
+//
+// Context takeover is disabled in both directions, since messages
+// are compressed and decompressed independently with pooled
+// flate writers and readers.
+const compressionExtension = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// acceptCompression returns true if the 'Sec-WebSocket-Extensions'
+// header contains acceptable permessage-deflate offer.
+func acceptCompression(extensions []byte) bool {
+	for len(extensions) > 0 {
+		var offer []byte
+		offer, extensions = nextHeaderToken(extensions, ',')
+		name, params := nextHeaderToken(offer, ';')
+		if string(name) != "permessage-deflate" {
+			continue
+		}
+		if acceptCompressionParams(params) {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptCompressionParams(params []byte) bool {
+	for len(params) > 0 {
+		var param []byte
+		param, params = nextHeaderToken(params, ';')
+		key, value := param, []byte(nil)
+		if n := bytes.IndexByte(param, '='); n >= 0 {
+			key = bytes.TrimSpace(param[:n])
+			value = bytes.Trim(bytes.TrimSpace(param[n+1:]), `"`)
+		}
+		switch string(key) {
+		case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+		case "server_max_window_bits":
+			// The server always uses the maximum window size.
+			if string(value) != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// headerContainsToken returns true if comma-separated header value v
+// contains the given token. Tokens are compared case-insensitively.
+func headerContainsToken(v []byte, token string) bool {
+	for len(v) > 0 {
+		var t []byte
+		t, v = nextHeaderToken(v, ',')
+		if len(t) == len(token) && bytes.EqualFold(t, []byte(token)) {
+			return true
+		}
+	}
+	return false
+}
+
+func nextHeaderToken(v []byte, sep byte) ([]byte, []byte) {
+	n := bytes.IndexByte(v, sep)
+	if n < 0 {
+		return bytes.TrimSpace(v), nil
+	}
+	return bytes.TrimSpace(v[:n]), v[n+1:]
+}
+
+func isValidChallengeKey(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	var buf [32]byte
+	if base64.StdEncoding.DecodedLen(len(key)) > len(buf) {
+		return false
+	}
+	n, err := base64.StdEncoding.Decode(buf[:], key)
+	return err == nil && n == 16
+}
+
+// appendAcceptKey appends 'Sec-WebSocket-Accept' header value
+// for the given challenge key to dst.
+func appendAcceptKey(dst, key []byte) []byte {
+	h := sha1.New()
+	h.Write(key)
+	h.Write(strKeyGUID)
+	var sum [sha1.Size]byte
+	s := h.Sum(sum[:0])
+
+	n := len(dst)
+	dst = append(dst, make([]byte, base64.StdEncoding.EncodedLen(len(s)))...)
+	base64.StdEncoding.Encode(dst[n:], s)
+	return dst
+}
+
+var (
+	strSchemeSep = []byte("://")
+	strKeyGUID   = []byte("258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
+)
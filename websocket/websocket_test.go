@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAppendAcceptKey(t *testing.T) {
+	// See https://tools.ietf.org/html/rfc6455#section-1.3 .
+	result := string(appendAcceptKey(nil, []byte("dGhlIHNhbXBsZSBub25jZQ==")))
+	expectedResult := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+	if result != expectedResult {
+		t.Fatalf("unexpected accept key %q. Expecting %q", result, expectedResult)
+	}
+}
+
+func TestHeaderContainsToken(t *testing.T) {
+	testHeaderContainsToken(t, "Upgrade", "upgrade", true)
+	testHeaderContainsToken(t, "keep-alive, Upgrade", "upgrade", true)
+	testHeaderContainsToken(t, " foo ,bar , baz", "bar", true)
+	testHeaderContainsToken(t, "upgrades", "upgrade", false)
+	testHeaderContainsToken(t, "", "upgrade", false)
+}
+
+func testHeaderContainsToken(t *testing.T, v, token string, expectedResult bool) {
+	if headerContainsToken([]byte(v), token) != expectedResult {
+		t.Fatalf("unexpected result for headerContainsToken(%q, %q). Expecting %v", v, token, expectedResult)
+	}
+}
+
+func TestAcceptCompression(t *testing.T) {
+	testAcceptCompression(t, "permessage-deflate", true)
+	testAcceptCompression(t, "permessage-deflate; client_max_window_bits", true)
+	testAcceptCompression(t, "permessage-deflate; server_no_context_takeover; client_no_context_takeover", true)
+	testAcceptCompression(t, "permessage-deflate; server_max_window_bits=15", true)
+	testAcceptCompression(t, "permessage-deflate; server_max_window_bits=10, permessage-deflate", true)
+	testAcceptCompression(t, "x-webkit-deflate-frame", false)
+	testAcceptCompression(t, "permessage-deflate; server_max_window_bits=10", false)
+	testAcceptCompression(t, "permessage-deflate; foo=bar", false)
+	testAcceptCompression(t, "", false)
+}
+
+func testAcceptCompression(t *testing.T, extensions string, expectedResult bool) {
+	if acceptCompression([]byte(extensions)) != expectedResult {
+		t.Fatalf("unexpected result for acceptCompression(%q). Expecting %v", extensions, expectedResult)
+	}
+}
+
+func startEchoServer(t *testing.T, u *Upgrader) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			u.Upgrade(ctx, func(c *Conn) {
+				for {
+					mt, b, err := c.ReadMessage(nil)
+					if err != nil {
+						return
+					}
+					if err = c.WriteMessage(mt, b); err != nil {
+						return
+					}
+				}
+			})
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(ch)
+	}()
+	return ln.Addr().String(), func() {
+		ln.Close()
+		<-ch
+	}
+}
+
+func doHandshake(t *testing.T, addr, headers string) (net.Conn, *bufio.Reader, *fasthttp.ResponseHeader) {
+	c, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatalf("cannot dial %q: %s", addr, err)
+	}
+	req := fmt.Sprintf("GET /foo HTTP/1.1\r\nHost: %s\r\n%s\r\n", addr, headers)
+	if _, err = c.Write([]byte(req)); err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+	br := bufio.NewReader(c)
+	var h fasthttp.ResponseHeader
+	if err = h.Read(br); err != nil {
+		t.Fatalf("cannot read response: %s", err)
+	}
+	return c, br, &h
+}
+
+const testHandshakeHeaders = "Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+	"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+
+func TestUpgrade(t *testing.T) {
+	testUpgrade(t, false)
+	testUpgrade(t, true)
+}
+
+func testUpgrade(t *testing.T, compress bool) {
+	u := &Upgrader{
+		Subprotocols:      []string{"foo", "bar"},
+		EnableCompression: compress,
+	}
+	addr, stop := startEchoServer(t, u)
+	defer stop()
+
+	headers := testHandshakeHeaders + "Sec-WebSocket-Protocol: baz, bar, foo\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n" +
+		"Origin: http://" + addr + "\r\n"
+	c, br, h := doHandshake(t, addr, headers)
+	defer c.Close()
+
+	if h.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d. Expecting %d", h.StatusCode(), fasthttp.StatusSwitchingProtocols)
+	}
+	if string(h.Peek("Sec-WebSocket-Accept")) != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected Sec-WebSocket-Accept %q", h.Peek("Sec-WebSocket-Accept"))
+	}
+	if string(h.Peek("Upgrade")) != "websocket" {
+		t.Fatalf("unexpected Upgrade header %q. Expecting %q", h.Peek("Upgrade"), "websocket")
+	}
+	if string(h.Peek("Sec-WebSocket-Protocol")) != "foo" {
+		t.Fatalf("unexpected subprotocol %q. Expecting %q", h.Peek("Sec-WebSocket-Protocol"), "foo")
+	}
+	extensions := string(h.Peek("Sec-WebSocket-Extensions"))
+	if compress && extensions != compressionExtension {
+		t.Fatalf("unexpected extensions %q. Expecting %q", extensions, compressionExtension)
+	}
+	if !compress && extensions != "" {
+		t.Fatalf("unexpected extensions %q. Expecting empty extensions", extensions)
+	}
+
+	conn := newConn(c, br, false, 4096)
+	conn.compress = compress
+	for i := 0; i < 3; i++ {
+		s := strings.Repeat(fmt.Sprintf("message %d ", i), i*1000)
+		if err := conn.WriteMessage(TextMessage, []byte(s)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		mt, b, err := conn.ReadMessage(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if mt != TextMessage {
+			t.Fatalf("unexpected message type %d. Expecting %d", mt, TextMessage)
+		}
+		if string(b) != s {
+			t.Fatalf("unexpected message %q. Expecting %q", b, s)
+		}
+	}
+
+	if err := conn.WriteClose(CloseNormalClosure, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, _, err := conn.ReadMessage(nil)
+	if ce, ok := err.(*CloseError); !ok || ce.Code != CloseNormalClosure {
+		t.Fatalf("unexpected error: %v. Expecting close error with code %d", err, CloseNormalClosure)
+	}
+}
+
+func TestUpgradeError(t *testing.T) {
+	u := &Upgrader{}
+	addr, stop := startEchoServer(t, u)
+	defer stop()
+
+	// missing upgrade headers
+	testUpgradeStatusCode(t, addr, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n",
+		fasthttp.StatusBadRequest)
+
+	// unsupported version
+	testUpgradeStatusCode(t, addr, strings.Replace(testHandshakeHeaders, "Version: 13", "Version: 8", 1),
+		fasthttp.StatusUpgradeRequired)
+
+	// invalid key
+	testUpgradeStatusCode(t, addr, strings.Replace(testHandshakeHeaders, "dGhlIHNhbXBsZSBub25jZQ==", "Zm9vYmFy", 1),
+		fasthttp.StatusBadRequest)
+
+	// cross-origin request
+	testUpgradeStatusCode(t, addr, testHandshakeHeaders+"Origin: http://example.com\r\n", fasthttp.StatusForbidden)
+}
+
+func testUpgradeStatusCode(t *testing.T, addr, headers string, expectedStatusCode int) {
+	c, _, h := doHandshake(t, addr, headers)
+	defer c.Close()
+	if h.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d. headers=%q", h.StatusCode(), expectedStatusCode, headers)
+	}
+}
+
+func TestUpgradeCheckOrigin(t *testing.T) {
+	u := &Upgrader{
+		CheckOrigin: func(ctx *fasthttp.RequestCtx) bool {
+			return string(ctx.Request.Header.Peek("Origin")) == "http://example.com"
+		},
+	}
+	addr, stop := startEchoServer(t, u)
+	defer stop()
+
+	testUpgradeStatusCode(t, addr, testHandshakeHeaders+"Origin: http://example.com\r\n", fasthttp.StatusSwitchingProtocols)
+	testUpgradeStatusCode(t, addr, testHandshakeHeaders+"Origin: http://"+addr+"\r\n", fasthttp.StatusForbidden)
+}